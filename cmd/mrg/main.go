@@ -6,9 +6,21 @@
 //
 // It can be used to convert back and forth between a call MRO file and a json
 // representation thereof.
+//
+// With --validate, the invocation json is checked against the JSON Schema for
+// the called pipeline or stage before generating the call, and any errors are
+// reported with the JSON Pointer to the offending value.  The schema is
+// generated from the mro source, or loaded from the file given with --schema,
+// as generated by mro schema.
+//
+// With --format, the invocation may instead be given as a YAML or TOML
+// document with the same keys as the json.  Argument values in those formats
+// are converted according to the types of the called pipeline or stage's
+// parameters.
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -18,6 +30,7 @@ import (
 
 	"github.com/martian-lang/docopt.go"
 	"github.com/martian-lang/martian/martian/core"
+	"github.com/martian-lang/martian/martian/syntax"
 	"github.com/martian-lang/martian/martian/util"
 )
 
@@ -27,12 +40,18 @@ func main() {
 	doc := `Martian Invocation Generator.
 
 Usage:
-    mrg
+    mrg [--format=<fmt>] [--validate] [--schema=<file>]
     mrg --reverse
     mrg -h | --help | --version
 
 Options:
     --reverse       Generate invocation data from mro source.
+    --format=<fmt>  The format of the invocation data: json, yaml, or toml.
+                    [default: json]
+    --validate      Validate the invocation json against the schema for
+                    the called pipeline or stage.
+    --schema=<file> Validate against the JSON Schema in the given file,
+                    rather than the schema generated from the mro source.
     -h --help       Show this message.
     --version       Show version.`
 	martianVersion := util.GetVersion()
@@ -70,11 +89,36 @@ Options:
 		}
 	}
 
-	// Read and parse JSON from stdin.
-	dec := json.NewDecoder(os.Stdin)
+	// Read and parse the invocation from stdin.
+	src, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		panic(err)
+	}
+	schemaFile, _ := opts["--schema"].(string)
+	doValidate := schemaFile != "" || opts["--validate"].(bool)
+	if format := core.DocumentFormat(opts["--format"].(string)); format != core.JsonDocument {
+		callSrc, err := documentCallSource(src, format,
+			doValidate, schemaFile, mroPaths)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Print(callSrc)
+		os.Exit(0)
+	}
+	dec := json.NewDecoder(bytes.NewReader(src))
 	dec.UseNumber()
 	var input core.InvocationData
 	if err := dec.Decode(&input); err == nil {
+		if doValidate {
+			if err := validate(src, input.Call, input.Include,
+				schemaFile, mroPaths); err != nil {
+				os.Stderr.WriteString("Invalid invocation:\n")
+				os.Stderr.WriteString(err.Error())
+				os.Stderr.WriteString("\n")
+				os.Exit(1)
+			}
+		}
 		src, bldErr := input.BuildCallSource(mroPaths)
 
 		if bldErr == nil {
@@ -87,3 +131,59 @@ Options:
 	}
 	os.Exit(1)
 }
+
+// documentCallSource generates call source from a YAML or TOML invocation
+// document.  If validation is requested, the schema is checked against the
+// json form of the arguments after they were converted to the parameter
+// types.
+func documentCallSource(src []byte, format core.DocumentFormat,
+	doValidate bool, schemaFile string, mroPaths []string) (string, error) {
+	doc, err := core.ParseInvocationDocument(src, "stdin", format)
+	if err != nil {
+		return "", err
+	}
+	ast, err := doc.BuildCallAst(mroPaths)
+	if err != nil {
+		return "", err
+	}
+	if doValidate {
+		data, err := core.BuildDataForAst(ast)
+		if err != nil {
+			return "", err
+		}
+		b, err := json.Marshal(data)
+		if err != nil {
+			return "", err
+		}
+		if err := validate(b, doc.Call, doc.Include,
+			schemaFile, mroPaths); err != nil {
+			return "", fmt.Errorf("Invalid invocation:\n%w", err)
+		}
+	}
+	return ast.Format(), nil
+}
+
+// validate checks the invocation json against the schema in the given file,
+// or if no file is given the schema for the invocation's callable.
+func validate(src []byte, call, include string,
+	schemaFile string, mroPaths []string) error {
+	var schema *syntax.JsonSchema
+	if schemaFile != "" {
+		b, err := ioutil.ReadFile(schemaFile)
+		if err != nil {
+			return err
+		}
+		schema = new(syntax.JsonSchema)
+		if err := json.Unmarshal(b, schema); err != nil {
+			return fmt.Errorf("parsing %s: %w", schemaFile, err)
+		}
+	} else {
+		callable, lookup, err := core.CompileCallableFrom(
+			call, include, mroPaths)
+		if err != nil {
+			return err
+		}
+		schema = syntax.CallableJsonSchema(callable, lookup)
+	}
+	return schema.Validate(src)
+}
@@ -17,6 +17,7 @@ import (
 
 	"github.com/martian-lang/martian/martian/syntax"
 	"github.com/martian-lang/martian/martian/syntax/graph"
+	"github.com/martian-lang/martian/martian/syntax/lint"
 	"github.com/martian-lang/martian/martian/util"
 
 	"github.com/martian-lang/docopt.go"
@@ -62,6 +63,12 @@ Options:
     --strict        Strict syntax validation
     --no-check-src  Do not check that stage source paths exist.
     --dot           Render the top-level pipeline to graphviz dot format.
+    --lint          Run lint rules over the compiled files.
+    --lint-config=<file>
+                    Json file configuring lint rules.
+    --lint-format=<fmt>
+                    Format for lint findings: text, json, or sarif.
+                    [default: text]
 
     -h --help       Show this message.
     --version       Show version.`
@@ -92,6 +99,26 @@ Options:
 	mkjson := opts["--json"].(bool)
 	callgraph := opts["--graph"].(bool)
 	mkdot := opts["--dot"].(bool)
+	runLint := opts["--lint"].(bool)
+	var lintConfig *lint.Config
+	lintFormat, _ := opts["--lint-format"].(string)
+	if runLint {
+		if fname, ok := opts["--lint-config"].(string); ok && fname != "" {
+			var err error
+			lintConfig, err = lint.LoadConfig(fname)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "Error loading lint config:", err.Error())
+				os.Exit(1)
+			}
+		}
+		switch lintFormat {
+		case "text", "json", "sarif":
+		default:
+			fmt.Fprintln(os.Stderr, "Unknown lint format", lintFormat)
+			os.Exit(1)
+		}
+	}
+	var lintAsts []*syntax.Ast
 
 	count := 0
 	wasErr := false
@@ -137,6 +164,7 @@ Options:
 			}
 		}
 		count += num
+		lintAsts = asts
 	} else {
 		// Compile just the specified MRO files.
 		var asts []*syntax.Ast
@@ -160,7 +188,7 @@ Options:
 					}
 				}
 
-				if mkjson || callgraph {
+				if mkjson || callgraph || runLint {
 					asts = append(asts, ast)
 				}
 				if c := getBestCall(ast); c != nil {
@@ -182,9 +210,15 @@ Options:
 		if callgraph {
 			wasErr = printCallGraphs(asts) || wasErr
 		}
+		lintAsts = asts
 	}
 	fmt.Fprintln(os.Stderr, "Successfully compiled", count, "mro files.")
 
+	if runLint {
+		wasErr = printLint(lintAsts, lintConfig, lintFormat,
+			martianVersion) || wasErr
+	}
+
 	if wasErr {
 		os.Exit(1)
 	}
@@ -222,6 +256,28 @@ func printCallGraphs(asts []*syntax.Ast) bool {
 	return wasErr
 }
 
+// printLint runs the lint rules and prints the findings.  Returns true if
+// any findings had error severity.
+func printLint(asts []*syntax.Ast, config *lint.Config,
+	format, version string) bool {
+	findings := lint.Lint(asts, config)
+	cwd, _ := os.Getwd()
+	var err error
+	switch format {
+	case "json":
+		err = lint.WriteJson(os.Stdout, findings, cwd)
+	case "sarif":
+		err = lint.WriteSarif(os.Stdout, findings, cwd, version)
+	default:
+		err = lint.WriteText(os.Stdout, findings, cwd)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error writing lint findings:", err.Error())
+		return true
+	}
+	return lint.HasErrors(findings)
+}
+
 // If the AST has a call, return it.  Otherwise, return the last
 // callable defined in the top-level file.
 func getBestCall(ast *syntax.Ast) *syntax.CallStm {
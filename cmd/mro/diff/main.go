@@ -0,0 +1,97 @@
+// Copyright (c) 2020 10X Genomics, Inc. All rights reserved.
+
+// Package diff implements the command line interface for reporting changes
+// to the callable API between two versions of a pipeline definition.
+package diff
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/martian-lang/martian/martian/syntax"
+	"github.com/martian-lang/martian/martian/util"
+)
+
+func Main(argv []string) {
+	util.SetPrintLogger(os.Stderr)
+	syntax.SetEnforcementLevel(syntax.EnforceError)
+
+	var flags flag.FlagSet
+	flags.Init("mro diff", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(),
+			"Usage: mro diff [options] <old.mro> <new.mro>")
+		fmt.Fprintln(flags.Output())
+		fmt.Fprintln(flags.Output(),
+			"Exits with status 1 if any changes are breaking.")
+		fmt.Fprintln(flags.Output())
+		flags.PrintDefaults()
+	}
+
+	var asJson bool
+	flags.BoolVar(&asJson, "json", false,
+		"Render the list of changes as json.")
+	var oldMroPath, newMroPath string
+	flags.StringVar(&oldMroPath, "old-mropath", "",
+		"The `MROPATH` to use for resolving includes in the old file.  "+
+			"Defaults to $MROPATH.")
+	flags.StringVar(&newMroPath, "new-mropath", "",
+		"The `MROPATH` to use for resolving includes in the new file.  "+
+			"Defaults to $MROPATH.")
+	if err := flags.Parse(argv); err != nil {
+		panic(err)
+	}
+	if flags.NArg() != 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	cwd, _ := os.Getwd()
+	mroPaths := util.ParseMroPath(cwd)
+	if value := os.Getenv("MROPATH"); len(value) > 0 {
+		mroPaths = util.ParseMroPath(value)
+	}
+	oldPaths, newPaths := mroPaths, mroPaths
+	if oldMroPath != "" {
+		oldPaths = util.ParseMroPath(oldMroPath)
+	}
+	if newMroPath != "" {
+		newPaths = util.ParseMroPath(newMroPath)
+	}
+
+	oldAst := compile(flags.Arg(0), oldPaths)
+	newAst := compile(flags.Arg(1), newPaths)
+	changes := oldAst.ApiDiff(newAst)
+	if asJson {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		if changes == nil {
+			changes = syntax.ApiChanges{}
+		}
+		if err := enc.Encode(changes); err != nil {
+			fmt.Fprintln(os.Stderr, "Error rendering json:", err.Error())
+			os.Exit(4)
+		}
+	} else {
+		for i := range changes {
+			fmt.Println(changes[i].String())
+		}
+	}
+	if changes.HasBreaking() {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+func compile(fname string, mroPaths []string) *syntax.Ast {
+	var parser syntax.Parser
+	_, _, ast, err := parser.Compile(fname, mroPaths, false)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, fname+":", err.Error())
+		os.Exit(3)
+	}
+	return ast
+}
@@ -110,6 +110,24 @@ func Main(argv []string) {
 	flags.Var(stringListValue{set: &renameOutput}, "rename-output",
 		"Rename the given stage or pipeline outputs.  "+
 			"Comma-separated list of `STAGE.oldname=newName`.")
+	var extract, extractAs string
+	var inline refactoring.StringSet
+	flags.StringVar(&extract, "extract", "",
+		"Move calls into a new pipeline, which is called in their place.  "+
+			"Specified as `PIPELINE:CALL1,CALL2`.  Requires -extract-as.")
+	flags.StringVar(&extractAs, "extract-as", "",
+		"The `NAME` for the pipeline created by -extract.")
+	flags.Var(stringListValue{set: &inline}, "inline",
+		"Replace calls to pipelines with the calls those pipelines make.  "+
+			"Comma-separated list of `CALL` or PIPELINE.CALL.")
+	var move, moveTo string
+	flags.StringVar(&move, "move", "",
+		"Move the declaration of a stage, pipeline, or struct `NAME` "+
+			"to the file given by -to, and fix includes in every file in "+
+			"MROPATH which needs them.  Files are modified in place.  "+
+			"If no files are given, MROPATH is searched for the declaration.")
+	flags.StringVar(&moveTo, "to", "",
+		"The destination `file.mro` for -move.")
 	version := flags.Bool("v", false, "Print the version and exit.")
 	if err := flags.Parse(argv); err != nil {
 		panic(err)
@@ -119,7 +137,7 @@ func Main(argv []string) {
 		os.Exit(0)
 	}
 
-	if flags.NArg() < 1 {
+	if flags.NArg() < 1 && move == "" {
 		flags.Usage()
 		os.Exit(1)
 	}
@@ -131,6 +149,14 @@ func Main(argv []string) {
 	}
 
 	var parser syntax.Parser
+	if move != "" {
+		if moveTo == "" {
+			fmt.Fprintln(flags.Output(), "-move requires -to")
+			flags.Usage()
+			os.Exit(4)
+		}
+		os.Exit(moveDeclaration(move, moveTo, flags.Args(), mroPaths, &parser))
+	}
 	fileBytes, compiledAsts := loadFiles(flags.Args(), mroPaths, &parser)
 
 	if !noRemoveUnusedOuts {
@@ -141,6 +167,8 @@ func Main(argv []string) {
 	conf.Rename = validateRename(rename, &flags)
 	conf.RenameInParam = validateParamRename(renameInput, &flags)
 	conf.RenameOutParam = validateParamRename(renameOutput, &flags)
+	conf.Extract = validateExtract(extract, extractAs, &flags)
+	conf.Inline = validateInline(inline)
 
 	edit, err := refactoring.Refactor(compiledAsts, conf)
 	if err != nil {
@@ -327,6 +355,54 @@ func validateParamRename(params refactoring.StringSet, flags *flag.FlagSet) []re
 	return result
 }
 
+func validateExtract(extract, name string, flags *flag.FlagSet) []refactoring.Extraction {
+	if extract == "" {
+		if name != "" {
+			fmt.Fprintln(flags.Output(),
+				"-extract-as requires -extract")
+			flags.Usage()
+			os.Exit(4)
+		}
+		return nil
+	}
+	i := strings.IndexByte(extract, ':')
+	if i < 1 || i == len(extract)-1 {
+		fmt.Fprintln(flags.Output(),
+			"Extracted calls must be specified as PIPELINE:CALL1,CALL2")
+		flags.Usage()
+		os.Exit(4)
+	}
+	if name == "" {
+		fmt.Fprintln(flags.Output(),
+			"-extract requires -extract-as")
+		flags.Usage()
+		os.Exit(4)
+	}
+	return []refactoring.Extraction{{
+		Pipeline: extract[:i],
+		Calls:    strings.Split(extract[i+1:], ","),
+		NewName:  name,
+	}}
+}
+
+func validateInline(calls refactoring.StringSet) []refactoring.PipelineCall {
+	if len(calls) == 0 {
+		return nil
+	}
+	result := make([]refactoring.PipelineCall, 0, len(calls))
+	for call := range calls {
+		if i := strings.IndexByte(call, '.'); i >= 0 {
+			result = append(result, refactoring.PipelineCall{
+				Pipeline: call[:i],
+				Call:     call[i+1:],
+			})
+		} else {
+			result = append(result, refactoring.PipelineCall{Call: call})
+		}
+	}
+	return result
+}
+
 func editFile(data []byte, filename string, mroPaths []string,
 	edit refactoring.Edit, rewrite bool, parser *syntax.Parser) {
 	ast, err := parser.UncheckedParse(data, filename)
@@ -345,35 +421,53 @@ func editFile(data []byte, filename string, mroPaths []string,
 	}
 	fmt.Fprintln(os.Stderr, count, "edits to", filename)
 	if rewrite && filename != "-" {
-		dir, base := filepath.Split(filename)
-		if dir == "" {
-			dir = "."
-		}
-		f, err := ioutil.TempFile(dir, base)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error opening temporary file for %s: %s\n",
-				filename, err.Error())
-			return
-		}
-		defer func() {
-			f.Close()
-			os.Remove(f.Name())
-		}()
-		if _, err := f.WriteString(ast.Format()); err != nil {
-			fmt.Fprintf(os.Stderr, "Error writing updated source for %s: %s\n",
-				filename, err.Error())
-			return
-		}
-		if err := f.Close(); err != nil {
-			fmt.Fprintf(os.Stderr, "Error closing updated source file for %s: %s\n",
-				filename, err.Error())
-			return
-		}
-		if err := os.Rename(f.Name(), filename); err != nil && !os.IsNotExist(err) {
-			fmt.Fprintf(os.Stderr, "Error renaming updated source file for %s: %s\n",
-				filename, err.Error())
-		}
+		writeFile(filename, ast.Format())
 	} else {
 		fmt.Println(ast.Format())
 	}
 }
+
+// writeFile replaces the content of the given file, by way of a temporary
+// file in the same directory.  It returns false if there was an error, after
+// printing it to standard error.
+func writeFile(filename, content string) bool {
+	dir, base := filepath.Split(filename)
+	if dir == "" {
+		dir = "."
+	}
+	f, err := ioutil.TempFile(dir, base)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error opening temporary file for %s: %s\n",
+			filename, err.Error())
+		return false
+	}
+	defer func() {
+		f.Close()
+		os.Remove(f.Name())
+	}()
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(filename); err == nil {
+		mode = info.Mode().Perm()
+	}
+	if err := f.Chmod(mode); err != nil {
+		fmt.Fprintf(os.Stderr, "Error setting permissions for %s: %s\n",
+			filename, err.Error())
+		return false
+	}
+	if _, err := f.WriteString(content); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing updated source for %s: %s\n",
+			filename, err.Error())
+		return false
+	}
+	if err := f.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error closing updated source file for %s: %s\n",
+			filename, err.Error())
+		return false
+	}
+	if err := os.Rename(f.Name(), filename); err != nil && !os.IsNotExist(err) {
+		fmt.Fprintf(os.Stderr, "Error renaming updated source file for %s: %s\n",
+			filename, err.Error())
+		return false
+	}
+	return true
+}
@@ -0,0 +1,361 @@
+// Copyright (c) 2020 10X Genomics, Inc. All rights reserved.
+
+package edit
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/martian-lang/martian/martian/syntax"
+	"github.com/martian-lang/martian/martian/syntax/refactoring"
+)
+
+type parsedFile struct {
+	path string
+	ast  *syntax.Ast
+}
+
+// findMroFiles returns the absolute paths of all mro files in or below the
+// given directories.
+func findMroFiles(dirs []string) []string {
+	seen := make(map[string]struct{})
+	var result []string
+	for _, dir := range dirs {
+		_ = filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+			if err != nil {
+				return nil
+			}
+			if info.IsDir() || filepath.Ext(p) != ".mro" {
+				return nil
+			}
+			if abs, err := filepath.Abs(p); err == nil {
+				p = abs
+			}
+			if _, ok := seen[p]; !ok {
+				seen[p] = struct{}{}
+				result = append(result, p)
+			}
+			return nil
+		})
+	}
+	sort.Strings(result)
+	return result
+}
+
+func declares(ast *syntax.Ast, name string) bool {
+	for _, c := range ast.Callables.List {
+		if c.GetId() == name {
+			return true
+		}
+	}
+	for _, st := range ast.StructTypes {
+		if st.Id == name {
+			return true
+		}
+	}
+	return false
+}
+
+// includeValue returns the string with which a file should be included from
+// another file.
+func includeValue(from, target string, mroPaths []string) string {
+	if filepath.Dir(from) == filepath.Dir(target) {
+		return filepath.Base(target)
+	}
+	if p, _, err := syntax.IncludeFilePath(target, mroPaths); err == nil {
+		return p
+	}
+	if rel, err := filepath.Rel(filepath.Dir(from), target); err == nil {
+		return rel
+	}
+	return target
+}
+
+func hasInclude(ast *syntax.Ast, value string) bool {
+	for _, inc := range ast.Includes {
+		if inc.Value == value {
+			return true
+		}
+	}
+	return false
+}
+
+// moveDeclaration moves a declaration to the given destination file, and fixes
+// includes in the files which are affected.  It returns the exit code.
+func moveDeclaration(name, dest string, fnames, mroPaths []string,
+	parser *syntax.Parser) int {
+	dest, _ = filepath.Abs(dest)
+	paths := findMroFiles(mroPaths)
+	candidates := paths
+	if len(fnames) > 0 {
+		candidates = make([]string, 0, len(fnames))
+		for _, fname := range fnames {
+			fname, _ = filepath.Abs(fname)
+			candidates = append(candidates, fname)
+		}
+		paths = append(paths, candidates...)
+	}
+	files := make(map[string]*syntax.Ast, len(paths))
+	original := make(map[string][]byte, len(paths))
+	var parsed []parsedFile
+	for _, p := range paths {
+		if _, ok := files[p]; ok {
+			continue
+		}
+		b, err := ioutil.ReadFile(p)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading from %s: %s\n", p, err.Error())
+			return 3
+		}
+		ast, err := parser.UncheckedParse(b, p)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error parsing %s: %s\n", p, err.Error())
+			return 3
+		}
+		files[p] = ast
+		original[p] = b
+		parsed = append(parsed, parsedFile{path: p, ast: ast})
+	}
+
+	var source string
+	for _, p := range candidates {
+		if declares(files[p], name) {
+			if source != "" {
+				fmt.Fprintf(os.Stderr,
+					"%s is declared in both %s and %s.  "+
+						"Specify the file to move it from.\n",
+					name, source, p)
+				return 4
+			}
+			source = p
+		}
+	}
+	if source == "" {
+		fmt.Fprintln(os.Stderr, "Could not find a declaration for", name)
+		return 4
+	}
+	if source == dest {
+		fmt.Fprintln(os.Stderr, name, "is already declared in", dest)
+		return 4
+	}
+	destAst := files[dest]
+	if destAst == nil {
+		if b, err := ioutil.ReadFile(dest); os.IsNotExist(err) {
+			destAst = syntax.NewAst(nil, nil, &syntax.SourceFile{
+				FileName: dest,
+				FullPath: dest,
+			})
+		} else if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading from %s: %s\n",
+				dest, err.Error())
+			return 3
+		} else if destAst, err = parser.UncheckedParse(b, dest); err != nil {
+			fmt.Fprintf(os.Stderr, "Error parsing %s: %s\n", dest, err.Error())
+			return 3
+		} else {
+			original[dest] = b
+		}
+		parsed = append(parsed, parsedFile{path: dest, ast: destAst})
+	}
+
+	moved, err := refactoring.MoveDeclaration(name, files[source], destAst)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 10
+	}
+	names := make([]string, 0, len(moved))
+	for n := range moved {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	fmt.Fprintf(os.Stderr, "Moving %v from %s to %s\n", names, source, dest)
+
+	files[dest] = destAst
+	graph := includeGraph{files: files, mroPaths: mroPaths}
+
+	// Add an include of the destination file to every file which needs one,
+	// and then let FixIncludes clean up any which are no longer needed.
+	changed := []string{dest, source}
+	for _, f := range parsed {
+		if f.path == dest || !refactoring.ReferencesAny(f.ast, moved) {
+			continue
+		}
+		if f.path != source {
+			changed = append(changed, f.path)
+		}
+		inc := includeValue(f.path, dest, mroPaths)
+		if !hasInclude(f.ast, inc) {
+			f.ast.Includes = append(f.ast.Includes, &syntax.Include{
+				Value: inc,
+			})
+		}
+	}
+	// The destination needs those of the source's includes which declare
+	// something the moved declarations use, and the source itself if they
+	// use something which was not moved.
+	for _, inc := range files[source].Includes {
+		p := graph.resolve(source, inc.Value)
+		if p == "" || p == dest ||
+			!refactoring.ReferencesAny(destAst, graph.visible(p)) {
+			continue
+		}
+		if v := includeValue(dest, p, mroPaths); !hasInclude(destAst, v) {
+			destAst.Includes = append(destAst.Includes, &syntax.Include{
+				Value: v,
+			})
+		}
+	}
+	if refactoring.ReferencesAny(destAst, graph.declared(source)) {
+		if graph.reaches(source, dest) {
+			fmt.Fprintf(os.Stderr,
+				"Moving %s would require %s and %s to include each other.\n",
+				name, source, dest)
+			return 10
+		}
+		if v := includeValue(dest, source, mroPaths); !hasInclude(destAst, v) {
+			destAst.Includes = append(destAst.Includes, &syntax.Include{
+				Value: v,
+			})
+		}
+	}
+	for _, p := range changed {
+		if graph.reaches(p, p) {
+			fmt.Fprintf(os.Stderr,
+				"Moving %s would make %s include itself.\n", name, p)
+			return 10
+		}
+	}
+	// Write the destination first, so that the moved declarations are never
+	// lost, and put every file back the way it was if a write fails.
+	restore := func() {
+		for _, p := range changed {
+			if b, ok := original[p]; ok {
+				writeFile(p, string(b))
+			} else {
+				os.Remove(p)
+			}
+		}
+		fmt.Fprintln(os.Stderr, "Restored the original files.")
+	}
+	if !writeFile(dest, destAst.Format()) {
+		return 11
+	}
+	for _, p := range changed[1:] {
+		if !writeFile(p, files[p].Format()) {
+			restore()
+			return 11
+		}
+	}
+	result := 0
+	for _, p := range changed {
+		src, err := parser.FormatFile(p, true, mroPaths)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error fixing includes in %s: %s\n",
+				p, err.Error())
+			result = 12
+			continue
+		}
+		if !writeFile(p, src) {
+			restore()
+			return 11
+		}
+		fmt.Fprintln(os.Stderr, "Updated", p)
+	}
+	return result
+}
+
+// includeGraph resolves the includes between parsed files.
+type includeGraph struct {
+	files    map[string]*syntax.Ast
+	mroPaths []string
+}
+
+// resolve returns the absolute path of the file included from the given file
+// with the given value, or an empty string if it could not be found.
+func (g *includeGraph) resolve(from, value string) string {
+	dirs := append(g.mroPaths[:len(g.mroPaths):len(g.mroPaths)],
+		filepath.Dir(from))
+	for _, dir := range dirs {
+		p, err := filepath.Abs(filepath.Join(dir, value))
+		if err != nil {
+			continue
+		}
+		if _, ok := g.files[p]; ok {
+			return p
+		}
+	}
+	return ""
+}
+
+// includes returns the resolved includes of the given file.
+func (g *includeGraph) includes(p string) []string {
+	ast := g.files[p]
+	if ast == nil {
+		return nil
+	}
+	result := make([]string, 0, len(ast.Includes))
+	for _, inc := range ast.Includes {
+		if ip := g.resolve(p, inc.Value); ip != "" {
+			result = append(result, ip)
+		}
+	}
+	return result
+}
+
+// declared returns the names declared in the given file.
+func (g *includeGraph) declared(p string) refactoring.StringSet {
+	names := make(refactoring.StringSet)
+	if ast := g.files[p]; ast != nil {
+		for _, st := range ast.StructTypes {
+			names.Add(st.Id)
+		}
+		for _, c := range ast.Callables.List {
+			names.Add(c.GetId())
+		}
+	}
+	return names
+}
+
+// visible returns the names declared in the given file, or in any file it
+// includes directly or indirectly.
+func (g *includeGraph) visible(p string) refactoring.StringSet {
+	names := make(refactoring.StringSet)
+	seen := make(map[string]struct{})
+	var visit func(string)
+	visit = func(p string) {
+		if _, ok := seen[p]; ok {
+			return
+		}
+		seen[p] = struct{}{}
+		for n := range g.declared(p) {
+			names.Add(n)
+		}
+		for _, ip := range g.includes(p) {
+			visit(ip)
+		}
+	}
+	visit(p)
+	return names
+}
+
+// reaches returns true if from includes to, directly or indirectly.
+func (g *includeGraph) reaches(from, to string) bool {
+	seen := make(map[string]struct{})
+	stack := g.includes(from)
+	for len(stack) > 0 {
+		p := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if p == to {
+			return true
+		}
+		if _, ok := seen[p]; ok {
+			continue
+		}
+		seen[p] = struct{}{}
+		stack = append(stack, g.includes(p)...)
+	}
+	return false
+}
@@ -0,0 +1,153 @@
+// Copyright (c) 2020 10X Genomics, Inc. All rights reserved.
+
+package edit
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/martian-lang/martian/martian/syntax"
+)
+
+const moveTestStruct = `struct S(
+    int x,
+)
+`
+
+const moveTestSource = `@include "c.mro"
+
+stage FOO(
+    in  S   s,
+    out int y,
+    src py  "foo",
+)
+
+pipeline BAR(
+    in  S   s,
+    out int y,
+)
+{
+    call FOO(
+        s = self.s,
+    )
+
+    return (
+        y = FOO.y,
+    )
+}
+`
+
+const moveTestUser = `@include "a.mro"
+
+pipeline BAZ(
+    in  S   s,
+    out int y,
+)
+{
+    call FOO(
+        s = self.s,
+    )
+
+    return (
+        y = FOO.y,
+    )
+}
+`
+
+func writeMoveTestFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name),
+			[]byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// runMove runs moveDeclaration, failing the test if it does not finish.
+func runMove(t *testing.T, name, dest string, mroPaths []string) int {
+	t.Helper()
+	result := make(chan int, 1)
+	go func() {
+		var parser syntax.Parser
+		result <- moveDeclaration(name, dest, nil, mroPaths, &parser)
+	}()
+	select {
+	case r := <-result:
+		return r
+	case <-time.After(time.Minute):
+		t.Fatal("moveDeclaration did not finish")
+		return -1
+	}
+}
+
+func readIncludes(t *testing.T, fn string) map[string]bool {
+	t.Helper()
+	b, err := os.ReadFile(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var parser syntax.Parser
+	ast, err := parser.UncheckedParse(b, fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := make(map[string]bool, len(ast.Includes))
+	for _, inc := range ast.Includes {
+		result[inc.Value] = true
+	}
+	return result
+}
+
+func TestMoveDeclarationIncludes(t *testing.T) {
+	dir := writeMoveTestFiles(t, map[string]string{
+		"a.mro": moveTestSource,
+		"c.mro": moveTestStruct,
+		"d.mro": moveTestUser,
+	})
+	mroPaths := []string{dir}
+	if r := runMove(t, "FOO", filepath.Join(dir, "b.mro"), mroPaths); r != 0 {
+		t.Fatalf("exit code %d", r)
+	}
+	if inc := readIncludes(t, filepath.Join(dir, "b.mro")); !inc["c.mro"] {
+		t.Error("destination does not include the struct it needs")
+	} else if inc["a.mro"] {
+		t.Error("destination includes the source, which includes it")
+	}
+	if inc := readIncludes(t, filepath.Join(dir, "a.mro")); !inc["b.mro"] {
+		t.Error("source does not include the destination")
+	}
+	if inc := readIncludes(t, filepath.Join(dir, "d.mro")); !inc["b.mro"] {
+		t.Error("user of the moved stage does not include the destination")
+	}
+	for _, fn := range []string{"a.mro", "b.mro", "d.mro"} {
+		var parser syntax.Parser
+		if _, _, _, err := parser.Compile(filepath.Join(dir, fn),
+			mroPaths, false); err != nil {
+			t.Errorf("%s: %v", fn, err)
+		}
+	}
+}
+
+func TestMoveDeclarationCycle(t *testing.T) {
+	// The destination already includes the source, which still calls the
+	// moved stage.
+	dir := writeMoveTestFiles(t, map[string]string{
+		"a.mro": moveTestSource,
+		"b.mro": "@include \"a.mro\"\n\nstruct T(\n    int z,\n)\n",
+		"c.mro": moveTestStruct,
+	})
+	if r := runMove(t, "FOO", filepath.Join(dir, "b.mro"),
+		[]string{dir}); r != 10 {
+		t.Errorf("expected the move to be refused, got exit code %d", r)
+	}
+	if b, err := os.ReadFile(filepath.Join(dir, "a.mro")); err != nil {
+		t.Fatal(err)
+	} else if string(b) != moveTestSource {
+		t.Error("source was modified")
+	}
+}
@@ -5,10 +5,13 @@
 package graph
 
 import (
+	"bufio"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/martian-lang/martian/martian/syntax"
@@ -25,6 +28,8 @@ func Main(argv []string) {
 	flags.Usage = func() {
 		fmt.Fprintln(flags.Output(),
 			"Usage: mro graph [options] <file1.mro>]")
+		fmt.Fprintln(flags.Output(),
+			"       mro graph -impacted-by=PATHS [-json] [<file1.mro>...]")
 		fmt.Fprintln(flags.Output())
 		flags.PrintDefaults()
 	}
@@ -40,10 +45,28 @@ func Main(argv []string) {
 	flags.StringVar(&stageOutput, "trace-output", "",
 		"List any input parameters to any stages which resolve "+
 			"to the given `STAGE.output`")
+	var impactedBy string
+	flags.StringVar(&impactedBy, "impacted-by", "",
+		"List the stages and pipelines which depend on any of the given "+
+			"comma-separated `PATHS`, which may be mro files, stage code, "+
+			"or directories.  If set to -, the paths are read from "+
+			"standard input, one per line.  If no mro files are given, "+
+			"all mro files in MROPATH are checked.")
 	if err := flags.Parse(argv); err != nil {
 		panic(err)
 	}
 
+	if impactedBy != "" {
+		if asDot || stageInput != "" || stageOutput != "" {
+			fmt.Fprintln(flags.Output(),
+				"Impact analysis can only be combined with -json.")
+			flags.Usage()
+			os.Exit(1)
+		}
+		listImpacted(parseImpactPaths(impactedBy), flags.Args(), asJson)
+		os.Exit(0)
+	}
+
 	cg, lookup := getGraph(flags.Arg(0))
 	if stageInput != "" || stageOutput != "" {
 		if asJson || asDot {
@@ -85,12 +108,17 @@ func Main(argv []string) {
 	os.Exit(0)
 }
 
-func getGraph(fname string) (syntax.CallGraphNode, *syntax.TypeLookup) {
+func getMroPaths() []string {
 	cwd, _ := os.Getwd()
 	mroPaths := util.ParseMroPath(cwd)
 	if value := os.Getenv("MROPATH"); len(value) > 0 {
 		mroPaths = util.ParseMroPath(value)
 	}
+	return mroPaths
+}
+
+func getGraph(fname string) (syntax.CallGraphNode, *syntax.TypeLookup) {
+	mroPaths := getMroPaths()
 	_, _, ast, err := syntax.Compile(fname, mroPaths, false)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err.Error())
@@ -303,3 +331,91 @@ func renderJson(pcg *syntax.CallGraphPipeline) {
 		os.Exit(4)
 	}
 }
+
+func parseImpactPaths(arg string) []string {
+	if arg != "-" {
+		return strings.Split(arg, ",")
+	}
+	var paths []string
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			paths = append(paths, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		fmt.Fprintln(os.Stderr, "Error reading paths:", err.Error())
+		os.Exit(1)
+	}
+	return paths
+}
+
+type impactedCallable struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	File string `json:"file"`
+	Line int    `json:"line"`
+}
+
+// listImpacted prints the callables defined in or included by any of the
+// given files which depend on any of the given paths.
+func listImpacted(paths, fnames []string, asJson bool) {
+	mroPaths := getMroPaths()
+	if len(fnames) == 0 {
+		for _, mroPath := range mroPaths {
+			names, _ := util.Readdirnames(mroPath)
+			for _, f := range names {
+				if strings.HasSuffix(f, ".mro") && !strings.HasPrefix(f, "_") {
+					fnames = append(fnames, filepath.Join(mroPath, f))
+				}
+			}
+		}
+	}
+	var parser syntax.Parser
+	seen := make(map[string]struct{})
+	var impacted []impactedCallable
+	failed := false
+	for _, fname := range fnames {
+		_, _, ast, err := parser.Compile(fname, mroPaths, false)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, fname+":", err.Error())
+			failed = true
+			continue
+		}
+		for _, c := range ast.ImpactedCallables(paths, mroPaths) {
+			if _, ok := seen[c.GetId()]; ok {
+				continue
+			}
+			seen[c.GetId()] = struct{}{}
+			p, _, _ := syntax.IncludeFilePath(c.File().FullPath, mroPaths)
+			impacted = append(impacted, impactedCallable{
+				Name: c.GetId(),
+				Type: c.Type(),
+				File: p,
+				Line: c.Line(),
+			})
+		}
+	}
+	sort.Slice(impacted, func(i, j int) bool {
+		return impacted[i].Name < impacted[j].Name
+	})
+	if asJson {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		if impacted == nil {
+			impacted = []impactedCallable{}
+		}
+		if err := enc.Encode(impacted); err != nil {
+			fmt.Fprintln(os.Stderr, "Error rendering json:", err.Error())
+			os.Exit(4)
+		}
+	} else {
+		for _, c := range impacted {
+			fmt.Printf("%-8s %s (%s:%d)\n", c.Type, c.Name, c.File, c.Line)
+		}
+	}
+	if failed {
+		os.Exit(3)
+	}
+}
@@ -11,13 +11,15 @@ import (
 	"runtime/trace"
 
 	"github.com/martian-lang/martian/cmd/mro/check"
+	"github.com/martian-lang/martian/cmd/mro/diff"
 	"github.com/martian-lang/martian/cmd/mro/edit"
 	"github.com/martian-lang/martian/cmd/mro/format"
 	"github.com/martian-lang/martian/cmd/mro/graph"
+	"github.com/martian-lang/martian/cmd/mro/schema"
 	"github.com/martian-lang/martian/martian/util"
 )
 
-const usage = "Usage: mro [help] [check | edit | format | graph] ..."
+const usage = "Usage: mro [help] [check | diff | edit | format | graph | schema] ..."
 
 func main() {
 	if len(os.Args) < 2 {
@@ -44,6 +46,9 @@ func main() {
 	check:
 		Perform static analysis tasks.
 
+	diff:
+		Report changes to stage and pipeline APIs between two files.
+
 	edit:
 		Perform various refactoring tasks.
 
@@ -53,6 +58,9 @@ func main() {
 	graph:
 		Render a call graph, or query information about it.
 
+	schema:
+		Generate a JSON Schema for the args and outs of a pipeline or stage.
+
 	version:
 		Print the version and exit.`)
 		} else {
@@ -71,12 +79,16 @@ func delegateMain(argv []string) {
 	switch argv[0] {
 	case "check":
 		check.Main(argv[1:])
+	case "diff":
+		diff.Main(argv[1:])
 	case "edit":
 		edit.Main(argv[1:])
 	case "format":
 		format.Main(argv[1:])
 	case "graph":
 		graph.Main(argv[1:])
+	case "schema":
+		schema.Main(argv[1:])
 	case "-cpuprofile":
 		cpuProfile(argv[1], argv[2:])
 	case "-memprofile":
@@ -0,0 +1,80 @@
+// Copyright (c) 2020 10X Genomics, Inc. All rights reserved.
+
+// Package schema implements the command line interface for generating a
+// JSON Schema describing the inputs and outputs of a pipeline or stage.
+package schema
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/martian-lang/martian/martian/core"
+	"github.com/martian-lang/martian/martian/syntax"
+	"github.com/martian-lang/martian/martian/util"
+)
+
+func Main(argv []string) {
+	util.SetPrintLogger(os.Stderr)
+	syntax.SetEnforcementLevel(syntax.EnforceError)
+
+	var flags flag.FlagSet
+	flags.Init("mro schema", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(),
+			"Usage: mro schema [options] <CALLABLE>")
+		fmt.Fprintln(flags.Output())
+		fmt.Fprintln(flags.Output(),
+			"Generates a JSON Schema (draft 2020-12) for the args and outs "+
+				"of a pipeline or stage.")
+		fmt.Fprintln(flags.Output())
+		flags.PrintDefaults()
+	}
+
+	var mroFile, outFile string
+	flags.StringVar(&mroFile, "mro", "",
+		"The `FILE` which declares the callable.  "+
+			"By default, all files in the MROPATH are searched.")
+	flags.StringVar(&outFile, "o", "",
+		"Write the schema to `FILE` instead of standard output.")
+	if err := flags.Parse(argv); err != nil {
+		panic(err)
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	cwd, _ := os.Getwd()
+	mroPaths := util.ParseMroPath(cwd)
+	if value := os.Getenv("MROPATH"); len(value) > 0 {
+		mroPaths = util.ParseMroPath(value)
+	}
+	if mroFile != "" {
+		mroPaths = append(mroPaths, cwd)
+	}
+
+	callable, lookup, err := core.CompileCallableFrom(
+		flags.Arg(0), mroFile, mroPaths)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	b, err := json.MarshalIndent(
+		syntax.CallableJsonSchema(callable, lookup), "", "  ")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error rendering json:", err.Error())
+		os.Exit(1)
+	}
+	b = append(b, '\n')
+	if outFile == "" {
+		_, err = os.Stdout.Write(b)
+	} else {
+		err = os.WriteFile(outFile, b, 0644)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+}
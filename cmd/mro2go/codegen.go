@@ -100,13 +100,15 @@ func needJsonImport(ss []*syntax.StructType, cs []syntax.Callable, onlyIns bool)
 }
 
 func makeCallableGoRaw(ast *syntax.Ast, pkg, mroName string, stageNames []string,
-	pipeline, onlyIns bool, seenStructs map[string]struct{}) string {
+	pipeline, onlyIns, invocations bool,
+	seenStructs map[string]struct{}) string {
 	var buffer bytes.Buffer
 	buffer.WriteString("// Code generated by mro2go ")
 	buffer.WriteString(mroName)
 	buffer.WriteString("; DO NOT EDIT.\n\n")
 
 	callables := getCallables(ast, mroName, stageNames, pipeline)
+	invocations = invocations && pipeline && len(callables) > 0
 
 	var structs []*syntax.StructType
 	if pkg != "" {
@@ -119,14 +121,14 @@ func makeCallableGoRaw(ast *syntax.Ast, pkg, mroName string, stageNames []string
 				structs = getStructs(ast, c, onlyIns, structs, seenStructs)
 			}
 		}
-		if split, chunkOuts := anySplit(callables); split {
+		if split, chunkOuts := anySplit(callables); split || invocations {
 			buffer.WriteString(`
 import (
 `)
 			if chunkOuts {
 				buffer.WriteString("\t\"bytes\"\n")
 				buffer.WriteString("\t\"encoding/json\"\n")
-			} else if needJsonImport(structs, callables, onlyIns) {
+			} else if invocations || needJsonImport(structs, callables, onlyIns) {
 				buffer.WriteString("\t\"encoding/json\"\n")
 			}
 			buffer.WriteString(`
@@ -148,9 +150,17 @@ import (
 	}
 	for _, s := range structs {
 		writeStruct(&buffer, &ast.TypeTable, s)
+		if invocations && !onlyIns &&
+			anyFiles(&ast.TypeTable, structMembers(s)) {
+			writeResolvePaths(&buffer, &ast.TypeTable,
+				GoName(s.Id), structMembers(s))
+		}
 	}
 	for _, c := range callables {
 		writeStageStructs(&buffer, &ast.TypeTable, c, onlyIns)
+		if invocations {
+			writeInvocation(&buffer, &ast.TypeTable, c, onlyIns)
+		}
 	}
 	return buffer.String()
 }
@@ -5,6 +5,7 @@
 //go:generate m2g -input-only -pipeline SUM_SQUARE_PIPELINE -o split_pipeline_test.go testdata/pipeline_stages.mro
 //go:generate m2g -pipeline OUTER -o struct_pipeline_test.go testdata/struct_pipeline.mro
 //go:generate m2g -o split_test.go testdata/pipeline_stages.mro
+//go:generate m2g -invocations -o invocation_pipeline_test.go testdata/invocation_pipeline.mro
 
 package main
 
@@ -13,6 +14,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"os"
 	"path"
 	"reflect"
 	"strings"
@@ -60,7 +62,7 @@ func TestMroToGo(t *testing.T) {
 	if err := MroToGo(&dest,
 		mrosrc, "testdata/pipeline_stages.mro", nil,
 		nil,
-		"main", "split_test.go", false, false,
+		"main", "split_test.go", false, false, false,
 		make(map[string]struct{})); err != nil {
 		t.Fatal(err)
 	}
@@ -82,7 +84,7 @@ func TestPipelineMroToGo(t *testing.T) {
 	if err := MroToGo(&dest,
 		mrosrc, "testdata/pipeline_stages.mro", []string{"SUM_SQUARE_PIPELINE"},
 		nil,
-		"main", "split_pipeline_test.go", true, true, nil); err != nil {
+		"main", "split_pipeline_test.go", true, true, false, nil); err != nil {
 		t.Fatal(err)
 	}
 	goSrc := dest.String()
@@ -103,7 +105,7 @@ func TestStructPipelineMroToGo(t *testing.T) {
 	if err := MroToGo(&dest,
 		mrosrc, "testdata/struct_pipeline.mro", []string{"OUTER"},
 		nil,
-		"main", "struct_pipeline_test.go", true, false,
+		"main", "struct_pipeline_test.go", true, false, false,
 		make(map[string]struct{})); err != nil {
 		t.Fatal(err)
 	}
@@ -115,6 +117,95 @@ func TestStructPipelineMroToGo(t *testing.T) {
 	}
 }
 
+// Test that the go output for generating pipeline invocations matches
+// what's expected.
+func TestInvocationMroToGo(t *testing.T) {
+	mrosrc, err := ioutil.ReadFile(path.Join("testdata", "invocation_pipeline.mro"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dest bytes.Buffer
+	if err := MroToGo(&dest,
+		mrosrc, "testdata/invocation_pipeline.mro", nil,
+		nil,
+		"main", "invocation_pipeline_test.go", true, false, true,
+		make(map[string]struct{})); err != nil {
+		t.Fatal(err)
+	}
+	goSrc := dest.String()
+	if expectedSrc, err := ioutil.ReadFile("invocation_pipeline_test.go"); err != nil {
+		t.Fatal(err)
+	} else if string(expectedSrc) != goSrc {
+		t.Errorf("Expected:\n%s\n\nGot:\n%s", expectedSrc, goSrc)
+	}
+}
+
+func TestCallSource(t *testing.T) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	args := SummarizerArgs{Count: 3, Name: "foo"}
+	src, err := args.CallSource([]string{cwd})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const expected = `@include "testdata/invocation_pipeline.mro"
+
+call SUMMARIZER(
+    count = 3,
+    name  = "foo",
+)
+`
+	if src != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, src)
+	}
+}
+
+func TestReadOuts(t *testing.T) {
+	psPath := t.TempDir()
+	forkPath := path.Join(psPath, "SUMMARIZER", "fork0")
+	if err := os.MkdirAll(forkPath, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path.Join(forkPath, "_outs"), []byte(`{
+		"summary": {
+			"text": "/old/pipestance/outs/summary/text.txt",
+			"count": 2,
+			"extras": ["summary/extras/a", null]
+		},
+		"texts": {"main": "/old/pipestance/outs/texts/main.txt"},
+		"text_sets": [{"main": "text_sets/0/main.txt"}],
+		"count": 3
+	}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	outs, err := ReadSummarizerOuts(psPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outsPath := path.Join(psPath, "outs")
+	if !reflect.DeepEqual(outs, &SummarizerOuts{
+		Summary: &Summary{
+			Text:  path.Join(outsPath, "summary", "text.txt"),
+			Count: 2,
+			Extras: []string{
+				path.Join(outsPath, "summary", "extras", "a"),
+				"",
+			},
+		},
+		Texts: map[string]string{
+			"main": path.Join(outsPath, "texts", "main.txt"),
+		},
+		TextSets: []map[string]string{{
+			"main": path.Join(outsPath, "text_sets", "0", "main.txt"),
+		}},
+		Count: 3,
+	}) {
+		t.Errorf("Incorrect outputs %#v", outs)
+	}
+}
+
 func serialize(t *testing.T, obj interface{}, expected string) {
 	t.Helper()
 	if b, err := json.MarshalIndent(obj, "\t", "\t"); err != nil {
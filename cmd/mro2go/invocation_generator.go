@@ -0,0 +1,176 @@
+//
+// Copyright (c) 2020 10X Genomics, Inc. All rights reserved.
+//
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+
+	"github.com/martian-lang/martian/martian/syntax"
+)
+
+// hasFiles returns true if values of the given type contain file paths.
+func hasFiles(lookup *syntax.TypeLookup, tname string) bool {
+	switch tname {
+	case syntax.KindInt, syntax.KindBool, syntax.KindFloat,
+		syntax.KindString, syntax.KindMap:
+		return false
+	case syntax.KindFile, syntax.KindPath:
+		return true
+	}
+	if s, ok := lookup.Get(syntax.TypeId{
+		Tname: tname}).(*syntax.StructType); ok {
+		for _, m := range s.Members {
+			if hasFiles(lookup, m.Tname.Tname) {
+				return true
+			}
+		}
+		return false
+	}
+	// User-defined file type.
+	return true
+}
+
+func anyFiles(lookup *syntax.TypeLookup, params []syntax.StructMemberLike) bool {
+	for _, param := range params {
+		if hasFiles(lookup, param.GetTname().Tname) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeResolveValue writes code to resolve the file paths in the value of
+// the given expression, which has the given type.
+func writeResolveValue(buffer *bytes.Buffer, lookup *syntax.TypeLookup,
+	expr string, tid syntax.TypeId, depth int) {
+	indent := bytes.Repeat([]byte{'\t'}, depth)
+	// An array of maps is map<T>[], so arrays are the outermost dimension.
+	if tid.ArrayDim > 0 {
+		i := "i" + strconv.Itoa(depth)
+		fmt.Fprintf(buffer, "%sfor %s := range %s {\n", indent, i, expr)
+		writeResolveValue(buffer, lookup, expr+"["+i+"]", syntax.TypeId{
+			Tname:    tid.Tname,
+			ArrayDim: tid.ArrayDim - 1,
+			MapDim:   tid.MapDim,
+		}, depth+1)
+		fmt.Fprintf(buffer, "%s}\n", indent)
+	} else if tid.MapDim > 0 {
+		k := "k" + strconv.Itoa(depth)
+		fmt.Fprintf(buffer, "%sfor %s := range %s {\n", indent, k, expr)
+		writeResolveValue(buffer, lookup, expr+"["+k+"]", syntax.TypeId{
+			Tname:    tid.Tname,
+			ArrayDim: tid.MapDim - 1,
+		}, depth+1)
+		fmt.Fprintf(buffer, "%s}\n", indent)
+	} else if _, ok := lookup.Get(syntax.TypeId{
+		Tname: tid.Tname}).(*syntax.StructType); ok {
+		fmt.Fprintf(buffer, "%sif %s != nil {\n%s\t%s.resolvePaths(resolve)\n%s}\n",
+			indent, expr, indent, expr, indent)
+	} else {
+		fmt.Fprintf(buffer, "%s%s = resolve(%s)\n", indent, expr, expr)
+	}
+}
+
+// writeResolvePaths writes a resolvePaths method for the given go type,
+// which applies a function to all of the file paths in the object.
+func writeResolvePaths(buffer *bytes.Buffer, lookup *syntax.TypeLookup,
+	typeName string, params []syntax.StructMemberLike) {
+	fmt.Fprintf(buffer, `
+// resolvePaths replaces each file path in the object with the result of
+// the given function.
+func (v *%s) resolvePaths(resolve func(string) string) {
+`, typeName)
+	for _, param := range params {
+		if hasFiles(lookup, param.GetTname().Tname) {
+			writeResolveValue(buffer, lookup,
+				"v."+GoName(param.GetId()), param.GetTname(), 1)
+		}
+	}
+	buffer.WriteString("}\n\n")
+}
+
+func structMembers(s *syntax.StructType) []syntax.StructMemberLike {
+	params := make([]syntax.StructMemberLike, len(s.Members))
+	for i, m := range s.Members {
+		params[i] = m
+	}
+	return params
+}
+
+func outParams(c syntax.Callable) []syntax.StructMemberLike {
+	outs := c.GetOutParams().List
+	params := make([]syntax.StructMemberLike, len(outs))
+	for i, p := range outs {
+		params[i] = p
+	}
+	return params
+}
+
+// writeInvocation writes methods to build an invocation from the args for
+// a pipeline and a function to read the outs of a completed pipestance.
+func writeInvocation(buffer *bytes.Buffer, lookup *syntax.TypeLookup,
+	pipeline syntax.Callable, onlyIns bool) {
+	prefix := GoName(pipeline.GetId())
+	fmt.Fprintf(buffer, `
+// Invocation returns the invocation data for a call to the %[1]s %[2]s
+// with these arguments.
+func (args *%[3]sArgs) Invocation() (*core.InvocationData, error) {
+	b, err := json.Marshal(args)
+	if err != nil {
+		return nil, err
+	}
+	var argMap core.LazyArgumentMap
+	if err := json.Unmarshal(b, &argMap); err != nil {
+		return nil, err
+	}
+	return &core.InvocationData{
+		Call:    args.CallName(),
+		Args:    argMap,
+		Include: args.MroFileName(),
+	}, nil
+}
+
+// CallSource returns the mro source for a call to the %[1]s %[2]s with
+// these arguments, suitable for passing to mrp.
+func (args *%[3]sArgs) CallSource(mroPaths []string) (string, error) {
+	invocation, err := args.Invocation()
+	if err != nil {
+		return "", err
+	}
+	ast, err := invocation.BuildCallAst(mroPaths)
+	if err != nil {
+		return "", err
+	}
+	return ast.Format(), nil
+}
+`, pipeline.GetId(), pipeline.Type(), prefix)
+	if onlyIns {
+		buffer.WriteRune('\n')
+		return
+	}
+	files := anyFiles(lookup, outParams(pipeline))
+	fmt.Fprintf(buffer, `
+// Read%[3]sOuts loads the outputs of a completed pipestance for the %[1]s
+// %[2]s.  File paths are resolved to the pipestance's outs directory.
+func Read%[3]sOuts(pipestancePath string) (*%[3]sOuts, error) {
+	var outs %[3]sOuts
+	if err := core.ReadPipestanceOuts(pipestancePath,
+		%[4]q, &outs); err != nil {
+		return nil, err
+	}
+`, pipeline.GetId(), pipeline.Type(), prefix, pipeline.GetId())
+	if files {
+		buffer.WriteString(
+			"\touts.resolvePaths(core.OutsPathResolver(pipestancePath))\n")
+	}
+	buffer.WriteString("\treturn &outs, nil\n}\n")
+	if files {
+		writeResolvePaths(buffer, lookup, prefix+"Outs", outParams(pipeline))
+	} else {
+		buffer.WriteRune('\n')
+	}
+}
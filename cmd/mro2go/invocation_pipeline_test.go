@@ -0,0 +1,114 @@
+// Code generated by mro2go testdata/invocation_pipeline.mro; DO NOT EDIT.
+
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/martian-lang/martian/martian/core"
+)
+
+// A structure to encode and decode the SUMMARY struct.
+type Summary struct {
+	// txt file
+	Text   string   `json:"text"`
+	Count  int      `json:"count"`
+	Extras []string `json:"extras"`
+}
+
+// resolvePaths replaces each file path in the object with the result of
+// the given function.
+func (v *Summary) resolvePaths(resolve func(string) string) {
+	v.Text = resolve(v.Text)
+	for i1 := range v.Extras {
+		v.Extras[i1] = resolve(v.Extras[i1])
+	}
+}
+
+//
+// SUMMARIZER
+//
+
+// A structure to encode and decode args to the SUMMARIZER pipeline.
+type SummarizerArgs struct {
+	Count int    `json:"count"`
+	Name  string `json:"name"`
+}
+
+// CallName returns the name of this pipeline as defined in the .mro file.
+func (*SummarizerArgs) CallName() string {
+	return "SUMMARIZER"
+}
+
+// MroFileName returns the name of the .mro file which defines this pipeline.
+func (*SummarizerArgs) MroFileName() string {
+	return "testdata/invocation_pipeline.mro"
+}
+
+// A structure to encode and decode outs from the SUMMARIZER pipeline.
+type SummarizerOuts struct {
+	Summary  *Summary            `json:"summary"`
+	Texts    map[string]string   `json:"texts"`
+	TextSets []map[string]string `json:"text_sets"`
+	Count    int                 `json:"count"`
+}
+
+// Invocation returns the invocation data for a call to the SUMMARIZER pipeline
+// with these arguments.
+func (args *SummarizerArgs) Invocation() (*core.InvocationData, error) {
+	b, err := json.Marshal(args)
+	if err != nil {
+		return nil, err
+	}
+	var argMap core.LazyArgumentMap
+	if err := json.Unmarshal(b, &argMap); err != nil {
+		return nil, err
+	}
+	return &core.InvocationData{
+		Call:    args.CallName(),
+		Args:    argMap,
+		Include: args.MroFileName(),
+	}, nil
+}
+
+// CallSource returns the mro source for a call to the SUMMARIZER pipeline with
+// these arguments, suitable for passing to mrp.
+func (args *SummarizerArgs) CallSource(mroPaths []string) (string, error) {
+	invocation, err := args.Invocation()
+	if err != nil {
+		return "", err
+	}
+	ast, err := invocation.BuildCallAst(mroPaths)
+	if err != nil {
+		return "", err
+	}
+	return ast.Format(), nil
+}
+
+// ReadSummarizerOuts loads the outputs of a completed pipestance for the SUMMARIZER
+// pipeline.  File paths are resolved to the pipestance's outs directory.
+func ReadSummarizerOuts(pipestancePath string) (*SummarizerOuts, error) {
+	var outs SummarizerOuts
+	if err := core.ReadPipestanceOuts(pipestancePath,
+		"SUMMARIZER", &outs); err != nil {
+		return nil, err
+	}
+	outs.resolvePaths(core.OutsPathResolver(pipestancePath))
+	return &outs, nil
+}
+
+// resolvePaths replaces each file path in the object with the result of
+// the given function.
+func (v *SummarizerOuts) resolvePaths(resolve func(string) string) {
+	if v.Summary != nil {
+		v.Summary.resolvePaths(resolve)
+	}
+	for k1 := range v.Texts {
+		v.Texts[k1] = resolve(v.Texts[k1])
+	}
+	for i1 := range v.TextSets {
+		for k2 := range v.TextSets[i1] {
+			v.TextSets[i1][k2] = resolve(v.TextSets[i1][k2])
+		}
+	}
+}
@@ -49,6 +49,14 @@ A stage without a split will look like the following simple example:
 Stages with splits will be more complex and should use the corresponding
 datastructures.
 
+If '-invocations' is specified, structs are generated for pipelines, as with
+'-pipeline'.  In addition, the Args struct for each pipeline gets Invocation
+and CallSource methods, which build a *core.InvocationData or the mro source
+for a call to the pipeline with those arguments, and a Read<pipelineName>Outs
+function is generated which loads the outs of a completed pipestance for the
+pipeline into the Outs struct, with file paths resolved to the pipestance's
+outs directory.
+
 Leading underscores are stripped from the stage.  The stage name is converted
 to camelCase unless '-public' is specified on the command line, in which case
 it is converted to PascalCase.
@@ -206,6 +214,10 @@ func main() {
 		"Write the go source to standard out.")
 	onlyIns := flags.Bool("input-only", false,
 		"If set, only create structs for inputs.")
+	invocations := flags.Bool("invocations", false,
+		"Generate pipeline structs, along with methods to build "+
+			"invocations from the args and functions to read the outs "+
+			"of completed pipestances.")
 	if err := flags.Parse(os.Args[1:]); err != nil {
 		// ExitOnError should mean that it never returns an error.
 		panic(err)
@@ -214,10 +226,10 @@ func main() {
 		flags.Usage()
 		os.Exit(1)
 	}
-	if *pipelineNames != "" {
+	if *pipelineNames != "" || *invocations {
 		if *stageNames != "" {
 			fmt.Fprintf(os.Stderr,
-				"-stage and -pipeline are incompatible.")
+				"-stage is incompatible with -pipeline and -invocations.")
 			os.Exit(1)
 		}
 		*stageNames = *pipelineNames
@@ -298,7 +310,8 @@ func main() {
 			}
 		}
 		processFile(f, mrofile, thisPackage, stageNamesList,
-			mroPaths, *pipelineNames != "", *onlyIns, seenStructs)
+			mroPaths, *pipelineNames != "" || *invocations, *onlyIns,
+			*invocations, seenStructs)
 		if *outDir != "" {
 			if err := f.Close(); err != nil {
 				fmt.Fprintf(os.Stderr,
@@ -318,7 +331,7 @@ func maybeSplitList(s string) []string {
 }
 
 func processFile(dest *os.File, mrofile, packageName string, stageNames []string,
-	mroPaths []string, pipeline, onlyIns bool,
+	mroPaths []string, pipeline, onlyIns, invocations bool,
 	seenStructs map[string]struct{}) {
 	if dest == nil {
 		thisOut := path.Base(strings.TrimSuffix(mrofile, ".mro")) + ".go"
@@ -350,7 +363,8 @@ func processFile(dest *os.File, mrofile, packageName string, stageNames []string
 		os.Exit(1)
 	} else if err := MroToGo(dest, src,
 		mrofile, stageNames, mroPaths,
-		packageName, dest.Name(), pipeline, onlyIns, seenStructs); err != nil {
+		packageName, dest.Name(), pipeline, onlyIns, invocations,
+		seenStructs); err != nil {
 		fmt.Fprintf(os.Stderr, "Error generating go source for %s\n%s\n",
 			mrofile, err.Error())
 		os.Exit(1)
@@ -378,7 +392,7 @@ func readSrc(mrofile string, mroPaths []string) ([]byte, string, error) {
 
 func MroToGo(dest io.Writer,
 	src []byte, mrofile string, stageNames, mroPaths []string,
-	pkg, outName string, pipeline, onlyIns bool,
+	pkg, outName string, pipeline, onlyIns, invocations bool,
 	seenStructs map[string]struct{}) error {
 	canonicalPath, _, err := syntax.IncludeFilePath(mrofile, mroPaths)
 	if err != nil {
@@ -389,7 +403,7 @@ func MroToGo(dest io.Writer,
 	} else {
 		return gofmt(dest,
 			makeCallableGoRaw(ast, pkg, canonicalPath, stageNames,
-				pipeline, onlyIns, seenStructs), outName)
+				pipeline, onlyIns, invocations, seenStructs), outName)
 	}
 }
 
@@ -0,0 +1,412 @@
+//
+// Copyright (c) 2020 10X Genomics, Inc. All rights reserved.
+//
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"strings"
+	"unicode"
+
+	"github.com/martian-lang/martian/martian/syntax"
+)
+
+// Python reserved words, which cannot be used as attribute names.
+var pyKeywords = map[string]struct{}{
+	"False": {}, "None": {}, "True": {}, "and": {}, "as": {}, "assert": {},
+	"async": {}, "await": {}, "break": {}, "class": {}, "continue": {},
+	"def": {}, "del": {}, "elif": {}, "else": {}, "except": {},
+	"finally": {}, "for": {}, "from": {}, "global": {}, "if": {},
+	"import": {}, "in": {}, "is": {}, "lambda": {}, "nonlocal": {},
+	"not": {}, "or": {}, "pass": {}, "raise": {}, "return": {}, "try": {},
+	"while": {}, "with": {}, "yield": {},
+}
+
+func isKeyword(id string) bool {
+	_, ok := pyKeywords[id]
+	return ok
+}
+
+// Convert mro stage and struct names into python class names.
+func PyName(name string) string {
+	parts := strings.Split(name, "_")
+	var result strings.Builder
+	for _, p := range parts {
+		for i, r := range p {
+			if i == 0 {
+				result.WriteRune(unicode.ToUpper(r))
+			} else if unicode.IsUpper(r) {
+				result.WriteString(strings.ToLower(p[i:]))
+				break
+			} else {
+				result.WriteString(p[i:])
+				break
+			}
+		}
+	}
+	return result.String()
+}
+
+// pyType returns the python type annotation for a value of the given type.
+func pyType(lookup *syntax.TypeLookup, tid syntax.TypeId) string {
+	var t string
+	switch tid.Tname {
+	case syntax.KindInt:
+		t = "int"
+	case syntax.KindFloat:
+		t = "float"
+	case syntax.KindBool:
+		t = "bool"
+	case syntax.KindMap:
+		t = "Dict[str, Any]"
+	case syntax.KindString, syntax.KindFile, syntax.KindPath:
+		t = "str"
+	default:
+		if _, ok := lookup.Get(syntax.TypeId{
+			Tname: tid.Tname}).(*syntax.StructType); ok {
+			t = PyName(tid.Tname)
+		} else {
+			// User-defined file type.
+			t = "str"
+		}
+	}
+	if tid.MapDim > 0 {
+		for i := tid.MapDim; i > 1; i-- {
+			t = "List[" + t + "]"
+		}
+		t = "Dict[str, " + t + "]"
+	}
+	for i := tid.ArrayDim; i > 0; i-- {
+		t = "List[" + t + "]"
+	}
+	return t
+}
+
+// paramComments returns the comment lines describing a parameter.
+func paramComments(param syntax.StructMemberLike) []string {
+	var lines []string
+	if h := param.GetHelp(); h != "" {
+		lines = append(lines, h)
+	}
+	if o := param.GetOutName(); o != "" {
+		lines = append(lines, o)
+	}
+	var comments []string
+	switch p := param.(type) {
+	case *syntax.InParam:
+		comments = p.Node.Comments
+	case *syntax.OutParam:
+		comments = p.Node.Comments
+	case *syntax.StructMember:
+		comments = p.Node.Comments
+	}
+	for _, c := range comments {
+		lines = append(lines, strings.TrimSpace(strings.TrimPrefix(c, "#")))
+	}
+	if param.IsFile() == syntax.KindIsFile {
+		switch t := param.GetTname().Tname; t {
+		case syntax.KindFile:
+			lines = append(lines, "file")
+		case syntax.KindPath:
+			lines = append(lines, "path")
+		default:
+			lines = append(lines, t+" file")
+		}
+		if param.GetArrayDim() > 0 {
+			lines[len(lines)-1] += "s"
+		}
+	}
+	return lines
+}
+
+func writeFields(buffer *bytes.Buffer, lookup *syntax.TypeLookup,
+	params []syntax.StructMemberLike) {
+	for i, param := range params {
+		comments := paramComments(param)
+		if i == 0 || len(comments) > 0 {
+			buffer.WriteRune('\n')
+		}
+		for _, line := range comments {
+			buffer.WriteString("    # ")
+			buffer.WriteString(line)
+			buffer.WriteRune('\n')
+		}
+		if isKeyword(param.GetId()) {
+			fmt.Fprintf(buffer,
+				"    # %s cannot be declared, because it is a python keyword.\n",
+				param.GetId())
+		} else {
+			fmt.Fprintf(buffer, "    %s: Optional[%s]\n",
+				param.GetId(), pyType(lookup, param.GetTname()))
+		}
+	}
+}
+
+// writeDataclass writes a dataclass declaration, used for the attributes of
+// a martian.Record object.
+func writeDataclass(buffer *bytes.Buffer, lookup *syntax.TypeLookup,
+	name, doc string, bases []string, params []syntax.StructMemberLike) {
+	buffer.WriteString("\n\n@dataclass\nclass ")
+	buffer.WriteString(name)
+	if len(bases) > 0 {
+		buffer.WriteRune('(')
+		buffer.WriteString(strings.Join(bases, ", "))
+		buffer.WriteRune(')')
+	}
+	fmt.Fprintf(buffer, ":\n    \"\"\"%s\"\"\"\n", doc)
+	writeFields(buffer, lookup, params)
+}
+
+func anyKeyword(params []syntax.StructMemberLike) bool {
+	for _, param := range params {
+		if isKeyword(param.GetId()) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeTypedDict writes a TypedDict declaration, for values which stage code
+// sees as a dict.
+func writeTypedDict(buffer *bytes.Buffer, lookup *syntax.TypeLookup,
+	name, doc string, params []syntax.StructMemberLike, resources bool) {
+	if !resources && !anyKeyword(params) {
+		fmt.Fprintf(buffer, "\n\nclass %s(TypedDict):\n    \"\"\"%s\"\"\"\n",
+			name, doc)
+		writeFields(buffer, lookup, params)
+		return
+	}
+	// Keys which are not valid identifiers require the functional syntax.
+	fmt.Fprintf(buffer, "\n\n# %s\n%s = TypedDict(\n    %q,\n    {\n",
+		doc, name, name)
+	for _, param := range params {
+		fmt.Fprintf(buffer, "        %q: Optional[%s],\n",
+			param.GetId(), pyType(lookup, param.GetTname()))
+	}
+	if resources {
+		buffer.WriteString(`        "__threads": float,
+        "__mem_gb": float,
+        "__vmem_gb": float,
+        "__special": str,
+    },
+    total=False,
+)
+`)
+	} else {
+		buffer.WriteString("    },\n)\n")
+	}
+}
+
+func structMembers(s *syntax.StructType) []syntax.StructMemberLike {
+	params := make([]syntax.StructMemberLike, len(s.Members))
+	for i, m := range s.Members {
+		params[i] = m
+	}
+	return params
+}
+
+func inParams(params *syntax.InParams) []syntax.StructMemberLike {
+	if params == nil {
+		return nil
+	}
+	result := make([]syntax.StructMemberLike, len(params.List))
+	for i, p := range params.List {
+		result[i] = p
+	}
+	return result
+}
+
+func outParams(params *syntax.OutParams) []syntax.StructMemberLike {
+	if params == nil {
+		return nil
+	}
+	result := make([]syntax.StructMemberLike, len(params.List))
+	for i, p := range params.List {
+		result[i] = p
+	}
+	return result
+}
+
+// getStructs adds the struct types used by the given parameters to the list,
+// after any struct types they depend on.
+func getStructs(lookup *syntax.TypeLookup, params []syntax.StructMemberLike,
+	structs []*syntax.StructType,
+	structSet map[string]struct{}) []*syntax.StructType {
+	for _, param := range params {
+		t := lookup.Get(syntax.TypeId{Tname: param.GetTname().Tname})
+		if s, ok := t.(*syntax.StructType); ok {
+			if _, ok := structSet[s.Id]; !ok {
+				structSet[s.Id] = struct{}{}
+				structs = getStructs(lookup, structMembers(s),
+					structs, structSet)
+				structs = append(structs, s)
+			}
+		}
+	}
+	return structs
+}
+
+func getStages(ast *syntax.Ast, fname string, stageNames []string) []*syntax.Stage {
+	stages := make([]*syntax.Stage, 0, len(ast.Stages))
+	for _, stage := range ast.Stages {
+		if path.Base(stage.Node.Loc.File.FullPath) != path.Base(fname) {
+			continue
+		}
+		if len(stageNames) == 0 {
+			stages = append(stages, stage)
+			continue
+		}
+		for _, n := range stageNames {
+			if n == stage.Id {
+				stages = append(stages, stage)
+				break
+			}
+		}
+	}
+	return stages
+}
+
+// makeTypesPy generates a python module declaring types for the given stages
+// and the struct types they use.
+func makeTypesPy(lookup *syntax.TypeLookup, mroName string,
+	stages []*syntax.Stage) string {
+	var buffer bytes.Buffer
+	fmt.Fprintf(&buffer, `# Code generated by mro2py %s; DO NOT EDIT.
+
+"""Types for the args and outs of stages declared in %s."""
+
+from dataclasses import dataclass
+from typing import Any, Dict, List, Optional, TypedDict  # pylint: disable=unused-import
+`, mroName, path.Base(mroName))
+
+	var structs []*syntax.StructType
+	structSet := make(map[string]struct{})
+	anySplit := false
+	for _, stage := range stages {
+		structs = getStructs(lookup, inParams(stage.InParams),
+			structs, structSet)
+		structs = getStructs(lookup, outParams(stage.OutParams),
+			structs, structSet)
+		if stage.Split {
+			anySplit = true
+			structs = getStructs(lookup, inParams(stage.ChunkIns),
+				structs, structSet)
+			structs = getStructs(lookup, outParams(stage.ChunkOuts),
+				structs, structSet)
+		}
+	}
+	if anySplit {
+		writeTypedDict(&buffer, lookup, "JobResources",
+			"Resources for a chunk or join.", nil, true)
+	}
+	for _, s := range structs {
+		doc := fmt.Sprintf("The %s struct.", s.Id)
+		if len(s.Node.Comments) > 0 {
+			doc = strings.TrimSpace(strings.TrimPrefix(s.Node.Comments[0], "#"))
+		}
+		writeTypedDict(&buffer, lookup, PyName(s.Id), doc,
+			structMembers(s), false)
+	}
+	for _, stage := range stages {
+		writeStageTypes(&buffer, lookup, stage)
+	}
+	return buffer.String()
+}
+
+func writeStageTypes(buffer *bytes.Buffer, lookup *syntax.TypeLookup,
+	stage *syntax.Stage) {
+	prefix := PyName(stage.Id)
+	fmt.Fprintf(buffer, "\n\n#\n# %s\n#\n", stage.Id)
+	writeDataclass(buffer, lookup, prefix+"Args",
+		fmt.Sprintf("Args to the %s stage.", stage.Id),
+		nil, inParams(stage.InParams))
+	writeDataclass(buffer, lookup, prefix+"Outs",
+		fmt.Sprintf("Outs from the %s stage.", stage.Id),
+		nil, outParams(stage.OutParams))
+	if !stage.Split {
+		return
+	}
+	chunkIns := inParams(stage.ChunkIns)
+	writeTypedDict(buffer, lookup, prefix+"ChunkDef",
+		fmt.Sprintf("A chunk definition returned by the %s split.", stage.Id),
+		chunkIns, true)
+	fmt.Fprintf(buffer, `
+
+class %[1]sStageDefs(TypedDict, total=False):
+    """The chunk and join definitions returned by the %[2]s split."""
+
+    chunks: List[%[1]sChunkDef]
+    join: JobResources
+`, prefix, stage.Id)
+	writeDataclass(buffer, lookup, prefix+"ChunkIns",
+		fmt.Sprintf("Chunk-specific args to %s chunks.", stage.Id),
+		nil, chunkIns)
+	writeDataclass(buffer, lookup, prefix+"ChunkArgs",
+		fmt.Sprintf("Args to %s chunks.", stage.Id),
+		[]string{prefix + "Args", prefix + "ChunkIns"}, nil)
+	writeDataclass(buffer, lookup, prefix+"ChunkOuts",
+		fmt.Sprintf("Outs from %s chunks.", stage.Id),
+		[]string{prefix + "Outs"}, outParams(stage.ChunkOuts))
+}
+
+// makeStubPy generates a skeleton stage code module for the given stage,
+// which imports its types from the given module.
+func makeStubPy(stage *syntax.Stage, typesModule string) string {
+	prefix := PyName(stage.Id)
+	var buffer bytes.Buffer
+	fmt.Fprintf(&buffer, `"""Stage code for %s."""
+
+`, stage.Id)
+	names := []string{prefix + "Args", prefix + "Outs"}
+	if stage.Split {
+		buffer.WriteString("from typing import List\n\n")
+		names = append(names,
+			prefix+"ChunkArgs", prefix+"ChunkIns",
+			prefix+"ChunkOuts", prefix+"StageDefs")
+	}
+	buffer.WriteString("import martian  # pylint: disable=unused-import\n\n")
+	fmt.Fprintf(&buffer, "from %s import (  # pylint: disable=unused-import\n",
+		typesModule)
+	for _, n := range names {
+		fmt.Fprintf(&buffer, "    %s,\n", n)
+	}
+	buffer.WriteString(")\n")
+	src := (&syntax.Ast{
+		Callables: &syntax.Callables{List: []syntax.Callable{stage}},
+	}).Format()
+	fmt.Fprintf(&buffer, "\n__MRO__ = \"\"\"\n%s\"\"\"\n", src)
+	if stage.Split {
+		fmt.Fprintf(&buffer, `
+
+def split(args: %[1]sArgs) -> %[1]sStageDefs:
+    """Returns the chunk definitions for %[2]s."""
+    raise NotImplementedError()
+
+
+def main(args: %[1]sChunkArgs, outs: %[1]sChunkOuts) -> None:
+    """Runs a chunk of %[2]s."""
+    raise NotImplementedError()
+
+
+def join(
+    args: %[1]sArgs,
+    outs: %[1]sOuts,
+    chunk_defs: List[%[1]sChunkIns],
+    chunk_outs: List[%[1]sChunkOuts],
+) -> None:
+    """Combines the outputs of the chunks of %[2]s."""
+    raise NotImplementedError()
+`, prefix, stage.Id)
+	} else {
+		fmt.Fprintf(&buffer, `
+
+def main(args: %[1]sArgs, outs: %[1]sOuts) -> None:
+    """Runs %[2]s."""
+    raise NotImplementedError()
+`, prefix, stage.Id)
+	}
+	return buffer.String()
+}
@@ -0,0 +1,64 @@
+// Copyright (c) 2020 10X Genomics, Inc. All rights reserved.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"testing"
+
+	"github.com/martian-lang/martian/martian/syntax"
+)
+
+func ExamplePyName() {
+	for _, n := range []string{
+		"STAGE_NAME",
+		"_STAGE_NAME",
+		"_StageName",
+		"param_name",
+	} {
+		fmt.Println(n, "->", PyName(n))
+	}
+	// Output:
+	// STAGE_NAME -> StageName
+	// _STAGE_NAME -> StageName
+	// _StageName -> StageName
+	// param_name -> ParamName
+}
+
+func parseTestMro(t *testing.T) *syntax.Ast {
+	t.Helper()
+	ast, err := parseMro("testdata/stages.mro", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ast
+}
+
+func checkOutput(t *testing.T, expectedFile, src string) {
+	t.Helper()
+	if expected, err := ioutil.ReadFile(expectedFile); err != nil {
+		t.Fatal(err)
+	} else if string(expected) != src {
+		t.Errorf("Expected:\n%s\n\nGot:\n%s", expected, src)
+	}
+}
+
+// Test that the python output matches what is expected.
+func TestMakeTypesPy(t *testing.T) {
+	ast := parseTestMro(t)
+	checkOutput(t, "testdata/stages_types.py",
+		makeTypesPy(&ast.TypeTable, "testdata/stages.mro",
+			getStages(ast, "testdata/stages.mro", nil)))
+}
+
+// Test that the skeleton stage code matches what is expected.
+func TestMakeStubPy(t *testing.T) {
+	ast := parseTestMro(t)
+	stages := getStages(ast, "testdata/stages.mro", []string{"SUM_SQUARES"})
+	if len(stages) != 1 {
+		t.Fatalf("expected 1 stage, got %d", len(stages))
+	}
+	checkOutput(t, "testdata/sum_squares_init.py",
+		makeStubPy(stages[0], "._types"))
+}
@@ -0,0 +1,205 @@
+//
+// Copyright (c) 2020 10X Genomics, Inc. All rights reserved.
+//
+
+/*
+Generates python type declarations for the stages declared in the given mro
+source, for use by stage code with type checkers such as mypy.
+
+MRO files are parsed given the current mropath.  If a specific set of stages
+is not specified, then code is generated for all stages declared in the file.
+
+For each stage, dataclasses are declared for the attributes of the args and
+outs objects passed to the stage code, named <stageName>Args and
+<stageName>Outs.  For stages which split, in addition
+
+  - <stageName>ChunkDef is a TypedDict for the chunk definitions returned by
+    split, and <stageName>StageDefs is a TypedDict for the return value of
+    split.
+  - <stageName>ChunkIns declares the chunk-specific args, as seen by join in
+    chunk_defs.
+  - <stageName>ChunkArgs declares the args seen by main, which combine the
+    stage args with the chunk-specific args.
+  - <stageName>ChunkOuts declares the outs seen by main, and by join in
+    chunk_outs.
+
+Struct types used by the stages are declared as TypedDicts.  Since any value
+may be null, all values are declared as Optional.
+
+Stage names are converted from SNAKE_CASE to PascalCase.
+
+If -stubs is given, then for each py stage a _types.py module with the
+declarations for that stage is written into the stage code directory, which
+is the stage's src path relative to the given directory.  If the directory
+does not already have an __init__.py, a skeleton is written with split, main,
+and join functions which use those types.
+
+Given the input pipeline.mro:
+
+	stage SUM_SQUARES(
+	    in  float[] values,
+	    out float   sum,
+	    src py      "stages/sum_squares",
+	) split (
+	    in  float   value,
+	    out float   square,
+	)
+
+A user would run
+
+	$ mro2py -stubs . pipeline.mro
+
+to generate stages/sum_squares/_types.py, and a skeleton
+stages/sum_squares/__init__.py with
+
+	def split(args: SumSquaresArgs) -> SumSquaresStageDefs:
+	    ...
+
+	def main(args: SumSquaresChunkArgs, outs: SumSquaresChunkOuts) -> None:
+	    ...
+
+	def join(
+	    args: SumSquaresArgs,
+	    outs: SumSquaresOuts,
+	    chunk_defs: List[SumSquaresChunkIns],
+	    chunk_outs: List[SumSquaresChunkOuts],
+	) -> None:
+	    ...
+*/
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/martian-lang/martian/martian/syntax"
+	"github.com/martian-lang/martian/martian/util"
+)
+
+func main() {
+	flags := flag.NewFlagSet("", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr,
+			"Usage: %s [options] <source.mro>\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	outfile := flags.String("output", "",
+		"The destination file name.  The default is "+
+			"<basename of source>_types.py")
+	flags.StringVar(outfile, "o", "",
+		"The destination file name.  The default is "+
+			"<basename of source>_types.py")
+	stageNames := flags.String("stage", "",
+		"Only generate code for the given stages (comma-separated list).")
+	stdout := flags.Bool("stdout", false,
+		"Write the python source to standard out.")
+	stubs := flags.String("stubs", "",
+		"Write types and skeleton stage code into the stage code "+
+			"directories for py stages, relative to the given directory.")
+	if err := flags.Parse(os.Args[1:]); err != nil {
+		// ExitOnError should mean that it never returns an error.
+		panic(err)
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(1)
+	}
+	if *stubs != "" && (*outfile != "" || *stdout) {
+		fmt.Fprintln(os.Stderr,
+			"-stubs is incompatible with -output and -stdout.")
+		os.Exit(1)
+	}
+	// Require strict enforcement of mro language, as mro2go does.
+	syntax.SetEnforcementLevel(syntax.EnforceError)
+	cwd, err := os.Getwd()
+	if err != nil {
+		fmt.Fprintf(os.Stderr,
+			"Could not get working directory: %v\n",
+			err)
+	}
+	mroPaths := append(util.ParseMroPath(os.Getenv("MROPATH")), cwd)
+	mrofile := flags.Arg(0)
+	ast, err := parseMro(mrofile, mroPaths)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error parsing %s\n%s\n",
+			mrofile, err.Error())
+		os.Exit(1)
+	}
+	var names []string
+	if *stageNames != "" {
+		names = strings.Split(*stageNames, ",")
+	}
+	stages := getStages(ast, mrofile, names)
+	if *stubs != "" {
+		if err := writeStubs(&ast.TypeTable, mrofile, stages, *stubs); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	src := makeTypesPy(&ast.TypeTable, mrofile, stages)
+	if *stdout {
+		fmt.Print(src)
+		return
+	}
+	if *outfile == "" {
+		bn := filepath.Base(mrofile)
+		*outfile = strings.TrimSuffix(bn, filepath.Ext(bn)) + "_types.py"
+	}
+	if err := ioutil.WriteFile(*outfile, []byte(src), 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing %s: %v\n", *outfile, err)
+		os.Exit(1)
+	}
+}
+
+func parseMro(mrofile string, mroPaths []string) (*syntax.Ast, error) {
+	src, err := ioutil.ReadFile(mrofile)
+	if os.IsNotExist(err) {
+		if p, found := util.SearchPaths(mrofile, mroPaths); found {
+			src, err = ioutil.ReadFile(p)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	_, _, ast, err := syntax.ParseSourceBytes(src, mrofile, mroPaths, false)
+	return ast, err
+}
+
+// writeStubs writes the types for each py stage into its stage code
+// directory, along with a skeleton __init__.py if one does not exist.
+func writeStubs(lookup *syntax.TypeLookup, mrofile string,
+	stages []*syntax.Stage, dir string) error {
+	for _, stage := range stages {
+		if stage.Src == nil || stage.Src.Type != syntax.PythonStage {
+			continue
+		}
+		stageDir := filepath.Join(dir, filepath.FromSlash(stage.Src.Path))
+		if err := os.MkdirAll(stageDir, 0755); err != nil {
+			return err
+		}
+		typesFile := filepath.Join(stageDir, "_types.py")
+		if err := ioutil.WriteFile(typesFile,
+			[]byte(makeTypesPy(lookup, mrofile,
+				[]*syntax.Stage{stage})), 0644); err != nil {
+			return err
+		}
+		fmt.Println("Wrote", typesFile)
+		initFile := filepath.Join(stageDir, "__init__.py")
+		if _, err := os.Stat(initFile); err == nil {
+			continue
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+		if err := ioutil.WriteFile(initFile,
+			[]byte(makeStubPy(stage, "._types")), 0644); err != nil {
+			return err
+		}
+		fmt.Println("Wrote", initFile)
+	}
+	return nil
+}
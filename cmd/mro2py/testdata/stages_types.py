@@ -0,0 +1,124 @@
+# Code generated by mro2py testdata/stages.mro; DO NOT EDIT.
+
+"""Types for the args and outs of stages declared in stages.mro."""
+
+from dataclasses import dataclass
+from typing import Any, Dict, List, Optional, TypedDict  # pylint: disable=unused-import
+
+
+# Resources for a chunk or join.
+JobResources = TypedDict(
+    "JobResources",
+    {
+        "__threads": float,
+        "__mem_gb": float,
+        "__vmem_gb": float,
+        "__special": str,
+    },
+    total=False,
+)
+
+
+class Stats(TypedDict):
+    """Summary statistics."""
+
+    count: Optional[int]
+    mean: Optional[float]
+
+    # txt file
+    report: Optional[str]
+
+
+class Results(TypedDict):
+    """The RESULTS struct."""
+
+    stats: Optional[Stats]
+    by_sample: Optional[Dict[str, List[Stats]]]
+
+
+#
+# SUM_SQUARES
+#
+
+
+@dataclass
+class SumSquaresArgs:
+    """Args to the SUM_SQUARES stage."""
+
+    # The values to sum over
+    # These values are squared and then summed over.
+    values: Optional[List[float]]
+    options: Optional[Dict[str, Any]]
+
+
+@dataclass
+class SumSquaresOuts:
+    """Outs from the SUM_SQUARES stage."""
+
+    # The sum of the squares of the values
+    sum: Optional[float]
+    results: Optional[Results]
+
+
+# A chunk definition returned by the SUM_SQUARES split.
+SumSquaresChunkDef = TypedDict(
+    "SumSquaresChunkDef",
+    {
+        "value": Optional[float],
+        "from": Optional[bool],
+        "__threads": float,
+        "__mem_gb": float,
+        "__vmem_gb": float,
+        "__special": str,
+    },
+    total=False,
+)
+
+
+class SumSquaresStageDefs(TypedDict, total=False):
+    """The chunk and join definitions returned by the SUM_SQUARES split."""
+
+    chunks: List[SumSquaresChunkDef]
+    join: JobResources
+
+
+@dataclass
+class SumSquaresChunkIns:
+    """Chunk-specific args to SUM_SQUARES chunks."""
+
+    value: Optional[float]
+    # from cannot be declared, because it is a python keyword.
+
+
+@dataclass
+class SumSquaresChunkArgs(SumSquaresArgs, SumSquaresChunkIns):
+    """Args to SUM_SQUARES chunks."""
+
+
+@dataclass
+class SumSquaresChunkOuts(SumSquaresOuts):
+    """Outs from SUM_SQUARES chunks."""
+
+    square: Optional[float]
+    logs: Optional[List[str]]
+
+
+#
+# REPORT
+#
+
+
+@dataclass
+class ReportArgs:
+    """Args to the REPORT stage."""
+
+    values: Optional[List[float]]
+    stats: Optional[Stats]
+
+
+@dataclass
+class ReportOuts:
+    """Outs from the REPORT stage."""
+
+    # txt file
+    report: Optional[str]
@@ -0,0 +1,51 @@
+"""Stage code for SUM_SQUARES."""
+
+from typing import List
+
+import martian  # pylint: disable=unused-import
+
+from ._types import (  # pylint: disable=unused-import
+    SumSquaresArgs,
+    SumSquaresOuts,
+    SumSquaresChunkArgs,
+    SumSquaresChunkIns,
+    SumSquaresChunkOuts,
+    SumSquaresStageDefs,
+)
+
+__MRO__ = """
+# Computes the sum of the squares of each value in a separate chunk.
+stage SUM_SQUARES(
+    # These values are squared and then summed over.
+    in  float[] values   "The values to sum over",
+    in  map     options,
+    out float   sum      "The sum of the squares of the values",
+    out RESULTS results,
+    src py      "stages/sum_squares",
+) split (
+    in  float   value,
+    in  bool    from,
+    out float   square,
+    out txt[]   logs,
+)
+"""
+
+
+def split(args: SumSquaresArgs) -> SumSquaresStageDefs:
+    """Returns the chunk definitions for SUM_SQUARES."""
+    raise NotImplementedError()
+
+
+def main(args: SumSquaresChunkArgs, outs: SumSquaresChunkOuts) -> None:
+    """Runs a chunk of SUM_SQUARES."""
+    raise NotImplementedError()
+
+
+def join(
+    args: SumSquaresArgs,
+    outs: SumSquaresOuts,
+    chunk_defs: List[SumSquaresChunkIns],
+    chunk_outs: List[SumSquaresChunkOuts],
+) -> None:
+    """Combines the outputs of the chunks of SUM_SQUARES."""
+    raise NotImplementedError()
@@ -40,6 +40,9 @@ type mrpConfiguration struct {
 	requireAuth    bool
 	noExit         bool
 	cert           *tls.Config
+
+	// If set, print the job script for this stage and exit.
+	renderJobscript string
 }
 
 func parseMroFlags(opts map[string]interface{}, doc string, martianOptions []string, martianArguments []string) {
@@ -94,6 +97,9 @@ func configure() mrpConfiguration {
 	// Parse commandline.
 	doc := `Martian Pipeline Runner.
 
+The call may be given as mro source, or as a YAML (.yaml or .yml) or TOML
+(.toml) invocation document with the same keys as the json accepted by mrg.
+
 Usage:
     mrp <call.mro> <pipestance_name> [options]
     mrp -h | --help | --version
@@ -103,6 +109,12 @@ Options:
                             local (default)
                             A cluster job mode listed such as sge, lsf, or slurm
                             A file <jobmode>.template
+                            simcluster, to run jobs on a simulated cluster
+    --simcluster=JSON   JSON file configuring the simulated cluster for the
+                        simcluster jobmode.
+    --render-jobscript=STAGE
+                        Print the job script which would be submitted for
+                        the given stage in a cluster jobmode, and exit.
     --localcores=NUM    Set max cores the pipeline may request at one time.
                             Only applies to local jobs.
     --localmem=NUM      Set max GB the pipeline may request at one time.
@@ -119,9 +131,24 @@ Options:
                             Only applies in cluster jobmodes.
     --limit-loadavg     Avoid scheduling jobs when the system loadavg is high.
                             Only applies to local jobs.
+    --min-free-disk=GB  Pause job submission while the pipestance filesystem
+                        has less than this much space available.
+    --disk-forecast=PATH
+                        Hold back stages whose output, according to the
+                        _perf file or pipestance directory of a previous run
+                        at PATH, would not fit in the available space, until
+                        VDR frees enough space.
 
     --vdrmode=MODE      Enables Volatile Data Removal. Valid options:
-                            post, rolling (default), strict, or disable
+                            post, rolling (default), strict, archive,
+                            report, or disable.  In report mode, nothing is
+                            removed, but _vdrreport records what strict mode
+                            would have removed.
+    --vdr-archive=PATH  In archive vdrmode, move volatile files to PATH
+                        instead of deleting them.  They can be put back with
+                        mrps restore.
+    --vdr-archive-tar   In archive vdrmode, pack the volatile files for each
+                        stage into a tarball instead of moving them.
 
     --nopreflight       Skips preflight stages.
     --strict=MODE       Determines how mrp reports cases where it needs to fall
@@ -143,6 +170,10 @@ Options:
     --noexit            Keep UI running after pipestance completes or fails.
     --onfinish=EXEC     Run this when pipeline finishes, success or fail.
     --zip               Zip metadata files after pipestance completes.
+    --publish=URI       After the pipestance completes successfully, upload
+                        the files in outs and their manifest to URI, which
+                        may be an absolute path, or a file, http(s), s3 or
+                        gs URI.
     --tags=TAGS         Tag pipestance with comma-separated key:value pairs.
 
     --profile=MODE      Enables stage performance profiling.  Configurable.
@@ -157,9 +188,18 @@ Options:
     --retry-wait=SECS   Wait SECS seconds after a failure before attempting
                         automatic retry.  Defaults to 1 second.
     --overrides=JSON    JSON file supplying custom run conditions per stage.
+    --chaos=CONFIG      JSON file configuring the rates at which to inject
+                        faults, for testing retry and recovery logic.
     --psdir=PATH        The path to the pipestance directory.  The default is
                         to use <pipestance_name>.
     --never-local       Ignore 'local' modifiers on non-preflight stages.
+    --replay-from=PATH  Copy the outputs of the stages listed in the
+                        replay-stages option from the pipestance in PATH,
+                        instead of running them.
+    --replay-stages=LIST
+                        Comma-separated list of stages to replay.  A stage
+                        may be given by its call name, or by a suffix of its
+                        fully-qualified name, e.g. SUBPIPELINE.STAGE.
 
     -h --help           Show this message.
     --version           Show version.`
@@ -254,6 +294,29 @@ Options:
 		config.JobMode = value.(string)
 	}
 	util.LogInfo("options", "--jobmode=%s", config.JobMode)
+	if value := opts["--simcluster"]; value != nil {
+		if config.JobMode != "simcluster" {
+			util.PrintInfo("options",
+				"--simcluster requires --jobmode=simcluster.")
+			os.Exit(1)
+		}
+		var err error
+		config.SimCluster, err = core.ReadSimClusterConfig(value.(string))
+		if err != nil {
+			util.PrintError(err, "startup",
+				"Failed to parse simulated cluster config file")
+			os.Exit(1)
+		}
+		util.LogInfo("options", "--simcluster=%s", value.(string))
+	}
+	if value := opts["--render-jobscript"]; value != nil {
+		if config.JobMode == "local" {
+			util.PrintInfo("options",
+				"--render-jobscript requires a cluster jobmode.")
+			os.Exit(1)
+		}
+		c.renderJobscript = value.(string)
+	}
 
 	if value := opts["--never-local"]; value != nil {
 		if nl, ok := value.(bool); ok && nl {
@@ -370,6 +433,20 @@ Options:
 		}
 	}
 
+	// Parse fault injection configuration.
+	if v := opts["--chaos"]; v != nil {
+		var err error
+		config.Chaos, err = core.ReadChaosConfig(v.(string))
+		if err != nil {
+			util.PrintError(err, "startup", "Failed to parse chaos config file")
+			os.Exit(1)
+		}
+		util.LogInfo("options", "--chaos=%s", v.(string))
+		util.PrintInfo("options",
+			"WARNING: Chaos mode is enabled. Faults will be injected with seed %d.",
+			config.Chaos.Seed)
+	}
+
 	// Compute stackVars flag.
 	config.StackVars = opts["--stackvars"].(bool)
 	util.LogInfo("options", "--stackvars=%v", config.StackVars)
@@ -377,8 +454,39 @@ Options:
 	config.Zip = opts["--zip"].(bool)
 	util.LogInfo("options", "--zip=%v", config.Zip)
 
+	if value := opts["--publish"]; value != nil {
+		if _, err := core.ParsePublishUri(value.(string)); err != nil {
+			util.PrintError(err, "options",
+				"Invalid --publish destination \"%s\"", value.(string))
+			os.Exit(1)
+		}
+		config.PublishUri = value.(string)
+		util.LogInfo("options", "--publish=%s", config.PublishUri)
+	}
+
 	config.LimitLoadavg = opts["--limit-loadavg"].(bool)
 	util.LogInfo("options", "--limit-loadavg=%v", config.LimitLoadavg)
+	if value := opts["--min-free-disk"]; value != nil {
+		if gb, err := strconv.ParseFloat(value.(string), 64); err == nil && gb >= 0 {
+			config.MinFreeDisk = uint64(gb * 1024 * 1024 * 1024)
+			util.LogInfo("options", "--min-free-disk=%s", value.(string))
+		} else {
+			util.PrintInfo("options",
+				"Could not parse --min-free-disk value \"%s\"", value.(string))
+			os.Exit(1)
+		}
+	}
+	if value := opts["--disk-forecast"]; value != nil {
+		forecast, err := core.ReadDiskForecast(value.(string))
+		if err != nil {
+			util.PrintError(err, "options",
+				"Could not read --disk-forecast %s", value.(string))
+			os.Exit(1)
+		}
+		config.DiskForecast = forecast
+		util.LogInfo("options", "--disk-forecast=%s (%d stages)",
+			value.(string), len(forecast))
+	}
 
 	c.noExit = opts["--noexit"].(bool)
 	util.LogInfo("options", "--noexit=%v", c.noExit)
@@ -399,6 +507,49 @@ Options:
 			}
 		}
 	}
+	if value := opts["--replay-from"]; value != nil {
+		if p, ok := value.(string); ok && p != "" {
+			if filepath.IsAbs(p) {
+				config.ReplayFrom = path.Clean(p)
+			} else {
+				config.ReplayFrom = path.Join(cwd, p)
+			}
+			util.LogInfo("options", "--replay-from=%s", config.ReplayFrom)
+		}
+	}
+	if value := opts["--replay-stages"]; value != nil {
+		for _, stage := range strings.Split(value.(string), ",") {
+			if stage = strings.TrimSpace(stage); stage != "" {
+				config.ReplayStages = append(config.ReplayStages, stage)
+			}
+		}
+		util.LogInfo("options", "--replay-stages=%s",
+			strings.Join(config.ReplayStages, ","))
+	}
+	if value := opts["--vdr-archive"]; value != nil {
+		if p, ok := value.(string); ok && p != "" {
+			if filepath.IsAbs(p) {
+				config.VdrArchiveRoot = path.Clean(p)
+			} else {
+				config.VdrArchiveRoot = path.Join(cwd, p)
+			}
+			util.LogInfo("options", "--vdr-archive=%s", config.VdrArchiveRoot)
+		}
+	}
+	if config.VdrArchiveTar = opts["--vdr-archive-tar"].(bool); config.VdrArchiveTar {
+		util.LogInfo("options", "--vdr-archive-tar")
+	}
+	if config.VdrMode == core.VdrArchive && config.VdrArchiveRoot == "" {
+		util.PrintInfo("options", "--vdrmode=archive requires --vdr-archive.")
+		os.Exit(1)
+	}
+	if config.ReplayFrom != "" && len(config.ReplayStages) == 0 {
+		util.PrintInfo("options", "--replay-from provided, but no --replay-stages.")
+		os.Exit(1)
+	} else if config.ReplayFrom == "" && len(config.ReplayStages) > 0 {
+		util.PrintInfo("options", "--replay-stages provided, but no --replay-from.")
+		os.Exit(1)
+	}
 	config.Monitor = opts["--monitor"].(bool)
 	c.readOnly = opts["--inspect"].(bool)
 	config.Debug = opts["--debug"].(bool)
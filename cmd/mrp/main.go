@@ -182,6 +182,24 @@ func (self *pipestanceHolder) HandleSignal(os.Signal) {
 	}
 }
 
+// invocationSource returns the mro source for the invocation file, converting
+// YAML and TOML invocation documents into an mro call.
+func invocationSource(fname string, data []byte, mroPaths []string) (string, error) {
+	switch format := core.DocumentFormatForPath(fname); format {
+	case core.YamlDocument, core.TomlDocument:
+		doc, err := core.ParseInvocationDocument(data, fname, format)
+		if err != nil {
+			return "", err
+		}
+		ast, err := doc.BuildCallAst(mroPaths)
+		if err != nil {
+			return "", err
+		}
+		return ast.Format(), nil
+	}
+	return string(data), nil
+}
+
 func (pipestanceBox *pipestanceHolder) Configure(c *mrpConfiguration, invocationSrc string) (
 	bool, *core.Runtime) {
 	//=========================================================================
@@ -396,7 +414,17 @@ func main() {
 	//=========================================================================
 	data, err := ioutil.ReadFile(c.invocationPath)
 	util.DieIf(err)
-	invocationSrc := string(data)
+	invocationSrc, err := invocationSource(c.invocationPath, data, c.mroPaths)
+	util.DieIf(err)
+
+	if c.renderJobscript != "" {
+		script, err := c.config.NewRuntime().RenderJobScript(invocationSrc,
+			c.invocationPath, c.psid, c.pipestancePath, c.mroPaths,
+			c.renderJobscript)
+		util.DieIf(err)
+		fmt.Print(script)
+		os.Exit(0)
+	}
 
 	// Attempt to reattach to the pipestance.
 	var pipestanceBox pipestanceHolder
@@ -179,8 +179,18 @@ func cleanupCompleted(pipestance *core.Pipestance, pipestanceBox *pipestanceHold
 		util.LogInfo("runtime", "VDR disabled. No files killed.")
 	} else {
 		killReport := pipestance.VDRKill()
-		util.LogInfo("runtime", "VDR killed %d files, %s.",
-			killReport.Count, humanize.Bytes(killReport.Size))
+		if vdrMode == core.VdrReport {
+			report := pipestance.VDRReport()
+			util.LogInfo("runtime",
+				"VDR would have removed %d files, %s, reducing peak "+
+					"storage use from %s to %s.",
+				report.Count, humanize.Bytes(report.Size),
+				humanize.Bytes(uint64(report.MaxBytes)),
+				humanize.Bytes(uint64(report.ProjectedMaxBytes)))
+		} else {
+			util.LogInfo("runtime", "VDR killed %d files, %s.",
+				killReport.Count, humanize.Bytes(killReport.Size))
+		}
 	}
 	trace.WithRegion(ctx, "PostProcess", pipestance.PostProcess)
 	pipestance.Unlock()
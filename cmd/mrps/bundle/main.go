@@ -0,0 +1,93 @@
+// Copyright (c) 2020 10X Genomics, Inc. All rights reserved.
+
+// Package bundle implements the command line interface for packaging a
+// pipestance into a portable bundle, and unpacking one.
+package bundle
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/martian-lang/martian/martian/core"
+	"github.com/martian-lang/martian/martian/util"
+)
+
+func Main(argv []string) {
+	util.SetPrintLogger(os.Stderr)
+
+	var flags flag.FlagSet
+	flags.Init("mrps bundle", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(),
+			"Usage: mrps bundle [options] <pipestance> <bundle.tar.gz>\n"+
+				"       mrps bundle -import [options] <bundle.tar.gz> <destination>")
+		fmt.Fprintln(flags.Output())
+		fmt.Fprintln(flags.Output(),
+			"Packages a pipestance into a single gzipped tarball with a\n"+
+				"manifest, for archiving or attaching to a support ticket.  With\n"+
+				"-import, unpacks a bundle into a new pipestance directory and\n"+
+				"rewrites its paths, so that it can be browsed with\n"+
+				"mrp --inspect.")
+		fmt.Fprintln(flags.Output())
+		flags.PrintDefaults()
+	}
+	var importMode, noVerify bool
+	var scopeFlag string
+	flags.BoolVar(&importMode, "import", false,
+		"Unpack a bundle rather than creating one.")
+	flags.StringVar(&scopeFlag, "scope", string(core.BundleMetadata),
+		"The files to include: metadata, outs (metadata and outs), or all.")
+	flags.BoolVar(&noVerify, "noverify", false,
+		"When importing, do not check that the pipestance can be loaded.")
+	if err := flags.Parse(argv); err != nil {
+		panic(err)
+	}
+	if flags.NArg() != 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	if importMode {
+		importBundle(flags.Arg(0), flags.Arg(1), noVerify)
+		return
+	}
+	scope, err := core.ParseBundleScope(scopeFlag)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	manifest, err := core.ExportBundle(flags.Arg(0), flags.Arg(1), scope)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error creating bundle:", err)
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "Bundled %d files from %s into %s.\n",
+		len(manifest.Files), manifest.Psid, flags.Arg(1))
+}
+
+func importBundle(bundle, dst string, noVerify bool) {
+	manifest, result, err := core.ImportBundle(bundle, dst)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error importing bundle:", err)
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr,
+		"Imported %d files from %s to %s: rewrote %d files and %d symlinks.\n",
+		len(manifest.Files), manifest.Psid, dst, result.Files, result.Symlinks)
+	if noVerify {
+		return
+	}
+	if _, err := os.Stat(path.Join(dst, core.FinalState.FileName())); err != nil {
+		// Only pipestances which finished can be checked.
+		return
+	}
+	opts := core.DefaultRuntimeOptions()
+	rt := opts.NewRuntime()
+	if err := rt.VerifyRelocatedPipestance(dst, context.Background()); err != nil {
+		fmt.Fprintln(os.Stderr, "Imported pipestance could not be loaded:", err)
+		os.Exit(1)
+	}
+	fmt.Fprintln(os.Stderr, "Verified that the pipestance loads at", dst)
+}
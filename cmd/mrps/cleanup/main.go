@@ -0,0 +1,110 @@
+// Copyright (c) 2020 10X Genomics, Inc. All rights reserved.
+
+// Package cleanup implements the command line interface for removing the
+// intermediate files from completed pipestances.
+package cleanup
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/dustin/go-humanize"
+	"github.com/martian-lang/martian/martian/core"
+	"github.com/martian-lang/martian/martian/util"
+)
+
+func Main(argv []string) {
+	util.SetPrintLogger(os.Stderr)
+
+	var flags flag.FlagSet
+	flags.Init("mrps cleanup", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(),
+			"Usage: mrps cleanup [options] <pipestance>...")
+		fmt.Fprintln(flags.Output())
+		fmt.Fprintln(flags.Output(),
+			"Removes the files of each completed pipestance which are not\n"+
+				"reachable from its top-level outs or kept by a retain\n"+
+				"declaration, along with its performance report, log and stage\n"+
+				"metadata.  Pipestances which mrp may still be running in are\n"+
+				"refused.")
+		fmt.Fprintln(flags.Output())
+		flags.PrintDefaults()
+	}
+	var opts core.CleanupOptions
+	var asJson, verbose bool
+	flags.BoolVar(&opts.DryRun, "dry-run", false,
+		"Report what would be removed without removing anything.")
+	flags.BoolVar(&opts.KeepPerf, "keep-perf", false,
+		"Keep the pipestance performance report, "+core.Perf.FileName()+".")
+	flags.BoolVar(&opts.KeepLog, "keep-log", false,
+		"Keep the pipestance log, "+core.LogFile.FileName()+".")
+	flags.BoolVar(&opts.KeepMetadata, "keep-metadata", false,
+		"Keep the stage metadata, including "+core.MetadataZip.FileName()+".")
+	flags.BoolVar(&asJson, "json", false, "Print the reports as json.")
+	flags.BoolVar(&verbose, "v", false, "List every path which is removed.")
+	if err := flags.Parse(argv); err != nil {
+		panic(err)
+	}
+	if flags.NArg() < 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	rtOpts := core.DefaultRuntimeOptions()
+	rt := rtOpts.NewRuntime()
+	verb := "Removed"
+	if opts.DryRun {
+		verb = "Would remove"
+	}
+	failed := false
+	var total uint64
+	reports := make(map[string]*core.CleanupReport, flags.NArg())
+	for _, psdir := range flags.Args() {
+		report, err := rt.CleanupPipestance(psdir, opts, context.Background())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error cleaning up %s: %v\n", psdir, err)
+			failed = true
+			continue
+		}
+		for _, e := range report.Errors {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", psdir, e)
+			failed = true
+		}
+		total += report.Size
+		if asJson {
+			reports[psdir] = report
+			continue
+		}
+		if verbose {
+			for _, stage := range report.Stages {
+				for _, p := range stage.Paths {
+					fmt.Println(p)
+				}
+			}
+			for _, p := range report.Metadata {
+				fmt.Println(p)
+			}
+		}
+		fmt.Fprintf(os.Stderr,
+			"%s: %s %d files (%s) from %d stages and %d metadata files (%s).\n",
+			psdir, verb, report.Count-report.MetadataCount,
+			humanize.Bytes(report.Size-report.MetadataSize), len(report.Stages),
+			report.MetadataCount, humanize.Bytes(report.MetadataSize))
+	}
+	if asJson {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(reports); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			failed = true
+		}
+	} else if flags.NArg() > 1 {
+		fmt.Fprintf(os.Stderr, "%s %s in total.\n", verb, humanize.Bytes(total))
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,83 @@
+// Command mrps serves as a front-end for various tools for managing
+// pipestance directories.
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/martian-lang/martian/cmd/mrps/bundle"
+	"github.com/martian-lang/martian/cmd/mrps/cleanup"
+	"github.com/martian-lang/martian/cmd/mrps/provenance"
+	"github.com/martian-lang/martian/cmd/mrps/relocate"
+	"github.com/martian-lang/martian/cmd/mrps/restore"
+	"github.com/martian-lang/martian/cmd/mrps/verify"
+	"github.com/martian-lang/martian/martian/util"
+)
+
+const usage = "Usage: mrps [help] [bundle] [cleanup] [provenance] [relocate] [restore] [verify] ..."
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(1)
+	}
+	switch os.Args[1] {
+	case "help":
+		if len(os.Args) == 2 {
+			fmt.Fprintln(os.Stderr, usage+`
+
+	bundle:
+		Package a pipestance into a portable bundle, or unpack one
+		with -import.
+
+	cleanup:
+		Remove the files of a completed pipestance which are not
+		reachable from its outs.
+
+	provenance:
+		Export the provenance of a completed pipestance as an RO-Crate.
+
+	relocate:
+		Move a pipestance and rewrite the paths embedded in its metadata.
+
+	restore:
+		Restore volatile files archived by --vdrmode=archive.
+
+	verify:
+		Check the files in a pipestance's outs directory against the
+		checksums recorded in its manifest.
+
+	version:
+		Print the version and exit.`)
+		} else {
+			delegateMain(append([]string{os.Args[2], "--help"}, os.Args[3:]...))
+		}
+		os.Exit(0)
+	case "version", "--version":
+		fmt.Println(util.GetVersion())
+		os.Exit(0)
+	}
+	delegateMain(os.Args[1:])
+	os.Exit(0)
+}
+
+func delegateMain(argv []string) {
+	switch argv[0] {
+	case "bundle":
+		bundle.Main(argv[1:])
+	case "cleanup":
+		cleanup.Main(argv[1:])
+	case "provenance":
+		provenance.Main(argv[1:])
+	case "relocate":
+		relocate.Main(argv[1:])
+	case "restore":
+		restore.Main(argv[1:])
+	case "verify":
+		verify.Main(argv[1:])
+	default:
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright (c) 2020 10X Genomics, Inc. All rights reserved.
+
+// Package provenance implements the command line interface for exporting
+// the provenance of a completed pipestance as an RO-Crate.
+package provenance
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/martian-lang/martian/martian/core"
+	"github.com/martian-lang/martian/martian/util"
+)
+
+func Main(argv []string) {
+	util.SetPrintLogger(os.Stderr)
+
+	var flags flag.FlagSet
+	flags.Init("mrps provenance", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(),
+			"Usage: mrps provenance [options] <pipestance>")
+		fmt.Fprintln(flags.Output())
+		fmt.Fprintln(flags.Output(),
+			"Writes an RO-Crate metadata file describing each stage which ran\n"+
+				"in a completed pipestance, and the files each stage used and\n"+
+				"generated.  By default the file is written to\n"+
+				"<pipestance>/"+core.RoCrateMetadataFile+", making the pipestance\n"+
+				"directory the root of the crate.")
+		fmt.Fprintln(flags.Output())
+		flags.PrintDefaults()
+	}
+	var out string
+	flags.StringVar(&out, "o", "",
+		"Write the metadata to this file instead, or - for standard output.")
+	if err := flags.Parse(argv); err != nil {
+		panic(err)
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	psdir := flags.Arg(0)
+	crate, err := core.ExportProvenance(psdir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error reading pipestance:", err)
+		os.Exit(1)
+	}
+	b, err := json.MarshalIndent(crate, "", "  ")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	b = append(b, '\n')
+	switch out {
+	case "-":
+		_, err = os.Stdout.Write(b)
+	case "":
+		out = path.Join(psdir, core.RoCrateMetadataFile)
+		fallthrough
+	default:
+		err = os.WriteFile(out, b, 0644)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error writing provenance:", err)
+		os.Exit(1)
+	}
+}
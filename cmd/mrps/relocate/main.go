@@ -0,0 +1,69 @@
+// Copyright (c) 2020 10X Genomics, Inc. All rights reserved.
+
+// Package relocate implements the command line interface for moving a
+// pipestance directory and rewriting the absolute paths embedded in it.
+package relocate
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/martian-lang/martian/martian/core"
+	"github.com/martian-lang/martian/martian/util"
+)
+
+func Main(argv []string) {
+	util.SetPrintLogger(os.Stderr)
+
+	var flags flag.FlagSet
+	flags.Init("mrps relocate", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(),
+			"Usage: mrps relocate [options] <pipestance> [<destination>]")
+		fmt.Fprintln(flags.Output())
+		fmt.Fprintln(flags.Output(),
+			"Moves a finished or failed pipestance to a new directory, and\n"+
+				"rewrites the paths under the old directory in its metadata,\n"+
+				"journal and symlinks.  If the destination is omitted, fixes up\n"+
+				"a pipestance which was already moved to its current location.")
+		fmt.Fprintln(flags.Output())
+		flags.PrintDefaults()
+	}
+	var keepSrc, noVerify bool
+	flags.BoolVar(&keepSrc, "copy", false,
+		"Copy the pipestance rather than moving it.")
+	flags.BoolVar(&noVerify, "noverify", false,
+		"Do not check that the pipestance can be loaded afterwards.")
+	if err := flags.Parse(argv); err != nil {
+		panic(err)
+	}
+	if flags.NArg() < 1 || flags.NArg() > 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	src := flags.Arg(0)
+	dst := src
+	if flags.NArg() > 1 {
+		dst = flags.Arg(1)
+	}
+	result, err := core.RelocatePipestance(src, dst, keepSrc)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error relocating pipestance:", err)
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr,
+		"Relocated %s to %s: rewrote %d files and %d symlinks.\n",
+		result.Psid, dst, result.Files, result.Symlinks)
+	if noVerify {
+		return
+	}
+	opts := core.DefaultRuntimeOptions()
+	rt := opts.NewRuntime()
+	if err := rt.VerifyRelocatedPipestance(dst, context.Background()); err != nil {
+		fmt.Fprintln(os.Stderr, "Relocated pipestance could not be loaded:", err)
+		os.Exit(1)
+	}
+	fmt.Fprintln(os.Stderr, "Verified that the pipestance loads at", dst)
+}
@@ -0,0 +1,63 @@
+// Copyright (c) 2020 10X Genomics, Inc. All rights reserved.
+
+// Package restore implements the command line interface for restoring
+// volatile files which were archived rather than deleted by mrp's archive
+// VDR mode.
+package restore
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/martian-lang/martian/martian/core"
+	"github.com/martian-lang/martian/martian/util"
+)
+
+func Main(argv []string) {
+	util.SetPrintLogger(os.Stderr)
+
+	var flags flag.FlagSet
+	flags.Init("mrps restore", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(),
+			"Usage: mrps restore [options] <pipestance> <stage>...")
+		fmt.Fprintln(flags.Output())
+		fmt.Fprintln(flags.Output(),
+			"Copies the volatile files of the given stages back into the\n"+
+				"pipestance from the archive, so that downstream stages can be\n"+
+				"rerun.  A stage may be given by its call name, or by a suffix\n"+
+				"of its fully-qualified name, e.g. SUBPIPELINE.STAGE.")
+		fmt.Fprintln(flags.Output())
+		flags.PrintDefaults()
+	}
+	var verbose bool
+	flags.BoolVar(&verbose, "v", false, "Print each restored path.")
+	if err := flags.Parse(argv); err != nil {
+		panic(err)
+	}
+	if flags.NArg() < 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	psdir := flags.Arg(0)
+	failed := false
+	for _, stage := range flags.Args()[1:] {
+		restored, err := core.RestoreVolatile(psdir, stage)
+		if verbose {
+			for _, p := range restored {
+				fmt.Println(p)
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error restoring %s: %v\n", stage, err)
+			failed = true
+		} else {
+			fmt.Fprintf(os.Stderr, "Restored %d paths for %s.\n",
+				len(restored), stage)
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
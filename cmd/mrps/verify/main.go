@@ -0,0 +1,81 @@
+// Copyright (c) 2020 10X Genomics, Inc. All rights reserved.
+
+// Package verify implements the command line interface for checking the
+// files in a pipestance's outs directory against the checksums which mrp
+// recorded in its manifest.
+package verify
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/martian-lang/martian/martian/core"
+	"github.com/martian-lang/martian/martian/util"
+)
+
+func Main(argv []string) {
+	util.SetPrintLogger(os.Stderr)
+
+	var flags flag.FlagSet
+	flags.Init("mrps verify", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(),
+			"Usage: mrps verify [options] <pipestance>...")
+		fmt.Fprintln(flags.Output())
+		fmt.Fprintln(flags.Output(),
+			"Re-hashes the files in the outs directory of each pipestance and\n"+
+				"reports any which are missing or were modified since mrp wrote\n"+
+				"outs/"+core.OutsManifestFile+".  Exits with status 1 if any\n"+
+				"problems were found.")
+		fmt.Fprintln(flags.Output())
+		flags.PrintDefaults()
+	}
+	var asJson bool
+	flags.BoolVar(&asJson, "json", false, "Print the results as json.")
+	if err := flags.Parse(argv); err != nil {
+		panic(err)
+	}
+	if flags.NArg() < 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	failed := false
+	results := make(map[string]*core.OutsVerification, flags.NArg())
+	for _, psdir := range flags.Args() {
+		result, err := core.VerifyOutsManifest(psdir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error verifying %s: %v\n", psdir, err)
+			failed = true
+			continue
+		}
+		if !result.OK() {
+			failed = true
+		}
+		if asJson {
+			results[psdir] = result
+			continue
+		}
+		for _, p := range result.Missing {
+			fmt.Printf("%s: missing: %s\n", psdir, p)
+		}
+		for _, p := range result.Modified {
+			fmt.Printf("%s: modified: %s\n", psdir, p)
+		}
+		fmt.Fprintf(os.Stderr,
+			"%s: checked %d files, %d missing, %d modified.\n",
+			psdir, result.Checked, len(result.Missing), len(result.Modified))
+	}
+	if asJson {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(results); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
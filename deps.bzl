@@ -61,6 +61,22 @@ def martian_dependencies(
         importpath = "github.com/martian-lang/docopt.go",
     )
 
+    maybe(
+        go_repository,
+        name = "com_github_pelletier_go_toml_v2",
+        version = "v2.1.1",
+        importpath = "github.com/pelletier/go-toml/v2",
+        sum = "h1:LWAJwfNvjQZCFIDKWYQaM62NcYeYViCmWIwmOStowAI=",
+    )
+
+    maybe(
+        go_repository,
+        name = "in_gopkg_yaml_v3",
+        version = "v3.0.1",
+        importpath = "gopkg.in/yaml.v3",
+        sum = "h1:fxVm/GzAzEWqLHuvctI91KS9hhNmmWOoWu0XTYJS7CA=",
+    )
+
     maybe(
         # This actually already brought in by rules_go, and
         # is included here mostly for clarity.
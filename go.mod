@@ -4,8 +4,10 @@ require (
 	github.com/dustin/go-humanize v1.0.0
 	github.com/google/shlex v0.0.0-20191202100458-e7afc7fbc510
 	github.com/martian-lang/docopt.go v0.0.0-20180828184714-57cc8f5f669d
+	github.com/pelletier/go-toml/v2 v2.1.1
 	golang.org/x/sys v0.0.0-20220909162455-aba9fc2a8ff2
 	golang.org/x/tools v0.1.12
+	gopkg.in/yaml.v3 v3.0.1
 )
 
-go 1.17
+go 1.18
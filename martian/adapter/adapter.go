@@ -32,6 +32,10 @@
 // One executable handles all 3 phases.  Stages which do not split may pass
 // nil for the split and join arguments to RunStage.
 //
+// Alternatively, stages may use Run, which decodes the stage args, chunk
+// definitions, and chunk outputs into the types generated by mro2go, and
+// validates the returned outputs before saving them.
+//
 // Stage code should NEVER directly write to the log, errors, or assert files
 // through the metadata object, but should instead return an error.  For an
 // assertion error, use the StageAssertion method.  For logging, use
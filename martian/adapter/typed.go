@@ -0,0 +1,234 @@
+//
+// Copyright (c) 2020 10X Genomics, Inc. All rights reserved.
+//
+
+package adapter
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/martian-lang/martian/martian/core"
+)
+
+// StageContext provides typed stage code with access to the job's metadata,
+// resources, and progress reporting.
+type StageContext struct {
+	// The metadata for the running job.  Most stage code should not need
+	// to use this directly.
+	Metadata *core.Metadata
+}
+
+// Resources returns the resources which were reserved for this job.
+func (ctx *StageContext) Resources() core.JobResources {
+	info := GetJobInfo()
+	return core.JobResources{
+		Threads: info.Threads,
+		MemGB:   info.MemGB,
+		VMemGB:  info.VMemGB,
+	}
+}
+
+// FilesPath returns the directory in which the job should write its output
+// files.
+func (ctx *StageContext) FilesPath() string {
+	return ctx.Metadata.FilesPath()
+}
+
+// FilePath returns the path for an output file with the given name in the
+// job's files directory.
+func (ctx *StageContext) FilePath(name string) string {
+	return ctx.Metadata.FilePath(name)
+}
+
+// Progress writes stage progress information.  See UpdateProgress.
+func (ctx *StageContext) Progress(format string, args ...interface{}) error {
+	return UpdateProgress(ctx.Metadata, fmt.Sprintf(format, args...))
+}
+
+// Validator may be implemented by output types in order to check the
+// outputs of a stage before they are written.
+type Validator interface {
+	Validate() error
+}
+
+// ChunkDefConverter is implemented by chunk definition types generated by
+// mro2go.  Chunk definition types which do not implement it are converted
+// through json.
+type ChunkDefConverter interface {
+	ToChunkDef() (*core.ChunkDef, error)
+}
+
+// StageDefsOf is the typed equivalent of core.StageDefs, returned by a
+// TypedSplitFunc.
+type StageDefsOf[ChunkDef any] struct {
+	JoinDef   *core.JobResources
+	ChunkDefs []*ChunkDef
+}
+
+// A function for a stage's split phase, which receives the decoded stage
+// args.
+type TypedSplitFunc[Args, ChunkDef any] func(
+	ctx *StageContext, args *Args) (*StageDefsOf[ChunkDef], error)
+
+// A function for a stage's chunk phase, which receives the decoded stage args
+// and chunk definition, and returns the chunk outputs.
+//
+// For stages which do not split, the chunk definition only contains the job
+// resources, and the returned value is saved as the stage outputs.
+type TypedMainFunc[Args, ChunkDef, ChunkOuts any] func(
+	ctx *StageContext, args *Args, def *ChunkDef) (*ChunkOuts, error)
+
+// A function for a stage's join phase, which receives the decoded stage args
+// along with the definitions and outputs of each chunk, in the same order,
+// and returns the stage outputs.
+type TypedJoinFunc[Args, Outs, ChunkDef, ChunkOuts any] func(
+	ctx *StageContext, args *Args,
+	defs []*ChunkDef, outs []*ChunkOuts) (*Outs, error)
+
+// Run is the typed equivalent of RunStage.  The type parameters are normally
+// the types generated by mro2go for the stage, for example
+//
+//	adapter.Run(split, chunk, join)
+//
+// where
+//
+//	func split(ctx *adapter.StageContext, args *MyStageArgs) (
+//		*adapter.StageDefsOf[MyStageChunkDef], error)
+//	func chunk(ctx *adapter.StageContext, args *MyStageArgs,
+//		def *MyStageChunkDef) (*MyStageChunkOuts, error)
+//	func join(ctx *adapter.StageContext, args *MyStageArgs,
+//		defs []*MyStageChunkDef, outs []*MyStageChunkOuts) (*MyStageOuts, error)
+//
+// Stages which do not split may pass nil for split and join, in which case
+// the value returned from main is saved as the stage outputs.  Such stages
+// can use core.JobResources as the ChunkDef type.
+//
+// Outputs are checked before they are saved.  They must encode to a json
+// object, and if they implement Validator then Validate must succeed.
+func Run[Args, Outs, ChunkDef, ChunkOuts any](
+	split TypedSplitFunc[Args, ChunkDef],
+	main TypedMainFunc[Args, ChunkDef, ChunkOuts],
+	join TypedJoinFunc[Args, Outs, ChunkDef, ChunkOuts]) {
+	var splitFunc SplitFunc
+	if split != nil {
+		splitFunc = typedSplit(split)
+	}
+	var joinFunc MainFunc
+	if join != nil {
+		joinFunc = typedJoin(join)
+	}
+	RunStage(splitFunc, typedMain(main), joinFunc)
+}
+
+func typedSplit[Args, ChunkDef any](split TypedSplitFunc[Args, ChunkDef]) SplitFunc {
+	return func(metadata *core.Metadata) (*core.StageDefs, error) {
+		var args Args
+		if err := metadata.ReadInto(core.ArgsFile, &args); err != nil {
+			return nil, fmt.Errorf("error reading args: %w", err)
+		}
+		defs, err := split(&StageContext{Metadata: metadata}, &args)
+		if err != nil || defs == nil {
+			return nil, err
+		}
+		result := &core.StageDefs{
+			JoinDef:   defs.JoinDef,
+			ChunkDefs: make([]*core.ChunkDef, 0, len(defs.ChunkDefs)),
+		}
+		for i, def := range defs.ChunkDefs {
+			if def == nil {
+				return nil, fmt.Errorf("chunk definition %d is nil", i)
+			}
+			chunk, err := toChunkDef(def)
+			if err != nil {
+				return nil, fmt.Errorf("chunk definition %d: %w", i, err)
+			}
+			result.ChunkDefs = append(result.ChunkDefs, chunk)
+		}
+		return result, nil
+	}
+}
+
+func toChunkDef(def interface{}) (*core.ChunkDef, error) {
+	if c, ok := def.(ChunkDefConverter); ok {
+		return c.ToChunkDef()
+	}
+	b, err := json.Marshal(def)
+	if err != nil {
+		return nil, err
+	}
+	var chunk core.ChunkDef
+	return &chunk, json.Unmarshal(b, &chunk)
+}
+
+func typedMain[Args, ChunkDef, ChunkOuts any](
+	main TypedMainFunc[Args, ChunkDef, ChunkOuts]) MainFunc {
+	return func(metadata *core.Metadata) (interface{}, error) {
+		var args Args
+		if err := metadata.ReadInto(core.ArgsFile, &args); err != nil {
+			return nil, fmt.Errorf("error reading args: %w", err)
+		}
+		// The chunk arguments and resources are merged into the args file.
+		var def ChunkDef
+		if err := metadata.ReadInto(core.ArgsFile, &def); err != nil {
+			return nil, fmt.Errorf("error reading chunk definition: %w", err)
+		}
+		outs, err := main(&StageContext{Metadata: metadata}, &args, &def)
+		if err != nil {
+			return nil, err
+		}
+		return checkOuts(outs)
+	}
+}
+
+func typedJoin[Args, Outs, ChunkDef, ChunkOuts any](
+	join TypedJoinFunc[Args, Outs, ChunkDef, ChunkOuts]) MainFunc {
+	return func(metadata *core.Metadata) (interface{}, error) {
+		var args Args
+		if err := metadata.ReadInto(core.ArgsFile, &args); err != nil {
+			return nil, fmt.Errorf("error reading args: %w", err)
+		}
+		var defs []*ChunkDef
+		if err := metadata.ReadInto(core.ChunkDefsFile, &defs); err != nil {
+			return nil, fmt.Errorf("error reading chunk definitions: %w", err)
+		}
+		var chunkOuts []*ChunkOuts
+		if err := metadata.ReadInto(core.ChunkOutsFile, &chunkOuts); err != nil {
+			return nil, fmt.Errorf("error reading chunk outputs: %w", err)
+		}
+		if len(defs) != len(chunkOuts) {
+			return nil, fmt.Errorf(
+				"found %d chunk definitions but %d chunk outputs",
+				len(defs), len(chunkOuts))
+		}
+		outs, err := join(&StageContext{Metadata: metadata},
+			&args, defs, chunkOuts)
+		if err != nil {
+			return nil, err
+		}
+		return checkOuts(outs)
+	}
+}
+
+// checkOuts validates stage outputs and returns their encoded form, or nil
+// if there were no outputs.
+func checkOuts[T any](outs *T) (interface{}, error) {
+	if outs == nil {
+		return nil, nil
+	}
+	if v, ok := interface{}(outs).(Validator); ok {
+		if err := v.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid outputs: %w", err)
+		}
+	}
+	b, err := json.Marshal(outs)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding outputs: %w", err)
+	}
+	if b = bytes.TrimSpace(b); len(b) == 0 || b[0] != '{' {
+		return nil, fmt.Errorf(
+			"outputs of type %T do not encode to a json object", outs)
+	}
+	return json.RawMessage(b), nil
+}
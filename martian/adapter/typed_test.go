@@ -0,0 +1,150 @@
+// Copyright (c) 2020 10X Genomics, Inc. All rights reserved.
+
+package adapter
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/martian-lang/martian/martian/core"
+)
+
+type testArgs struct {
+	Values []float64 `json:"values"`
+}
+
+type testChunkDef struct {
+	*core.JobResources `json:",omitempty"`
+	Value              float64 `json:"value"`
+}
+
+type testChunkOuts struct {
+	Square float64 `json:"square"`
+}
+
+type testOuts struct {
+	Sum float64 `json:"sum"`
+}
+
+func (outs *testOuts) Validate() error {
+	if outs.Sum < 0 {
+		return errors.New("negative sum")
+	}
+	return nil
+}
+
+func testMetadata(t *testing.T) *core.Metadata {
+	t.Helper()
+	return core.NewMetadata("ID.test.TEST.fork0", t.TempDir())
+}
+
+func TestTypedSplit(t *testing.T) {
+	md := testMetadata(t)
+	if err := md.Write(core.ArgsFile, &testArgs{Values: []float64{1, 2}}); err != nil {
+		t.Fatal(err)
+	}
+	split := typedSplit(func(ctx *StageContext, args *testArgs) (
+		*StageDefsOf[testChunkDef], error) {
+		defs := &StageDefsOf[testChunkDef]{
+			JoinDef: &core.JobResources{Threads: 2},
+		}
+		for _, v := range args.Values {
+			defs.ChunkDefs = append(defs.ChunkDefs, &testChunkDef{
+				JobResources: &core.JobResources{MemGB: 3},
+				Value:        v,
+			})
+		}
+		return defs, nil
+	})
+	defs, err := split(md)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(defs.ChunkDefs) != 2 {
+		t.Fatalf("expected 2 chunks, got %d", len(defs.ChunkDefs))
+	}
+	if defs.JoinDef.Threads != 2 {
+		t.Errorf("expected 2 join threads, got %g", defs.JoinDef.Threads)
+	}
+	if r := defs.ChunkDefs[1].Resources; r == nil || r.MemGB != 3 {
+		t.Errorf("incorrect chunk resources %v", r)
+	}
+	if v := string(defs.ChunkDefs[1].Args["value"]); v != "2" {
+		t.Errorf("expected chunk value 2, got %q", v)
+	}
+}
+
+func TestTypedMain(t *testing.T) {
+	md := testMetadata(t)
+	if err := md.WriteRaw(core.ArgsFile,
+		`{"values":[1,2],"value":3,"__mem_gb":4}`); err != nil {
+		t.Fatal(err)
+	}
+	main := typedMain(func(ctx *StageContext, args *testArgs,
+		def *testChunkDef) (*testChunkOuts, error) {
+		if len(args.Values) != 2 {
+			t.Errorf("expected 2 values, got %v", args.Values)
+		}
+		if def.JobResources == nil || def.MemGB != 4 {
+			t.Errorf("incorrect chunk resources %v", def.JobResources)
+		}
+		return &testChunkOuts{Square: def.Value * def.Value}, nil
+	})
+	outs, err := main(md)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, err := json.Marshal(outs); err != nil {
+		t.Error(err)
+	} else if s := string(b); s != `{"square":9}` {
+		t.Errorf("incorrect outputs %s", s)
+	}
+}
+
+func TestTypedJoin(t *testing.T) {
+	md := testMetadata(t)
+	if err := md.WriteRaw(core.ArgsFile, `{"values":[1,2]}`); err != nil {
+		t.Fatal(err)
+	}
+	if err := md.WriteRaw(core.ChunkDefsFile,
+		`[{"value":1},{"value":2}]`); err != nil {
+		t.Fatal(err)
+	}
+	if err := md.WriteRaw(core.ChunkOutsFile,
+		`[{"square":1},{"square":4}]`); err != nil {
+		t.Fatal(err)
+	}
+	join := func(sign float64) MainFunc {
+		return typedJoin(func(ctx *StageContext, args *testArgs,
+			defs []*testChunkDef, chunkOuts []*testChunkOuts) (*testOuts, error) {
+			var outs testOuts
+			for _, c := range chunkOuts {
+				outs.Sum += sign * c.Square
+			}
+			return &outs, nil
+		})
+	}
+	outs, err := join(1)(md)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, err := json.Marshal(outs); err != nil {
+		t.Error(err)
+	} else if s := string(b); s != `{"sum":5}` {
+		t.Errorf("incorrect outputs %s", s)
+	}
+	if _, err := join(-1)(md); err == nil {
+		t.Error("expected validation failure")
+	}
+}
+
+func TestCheckOuts(t *testing.T) {
+	if outs, err := checkOuts[testOuts](nil); err != nil || outs != nil {
+		t.Errorf("expected nil outputs, got %v, %v", outs, err)
+	}
+	v := []int{1}
+	if _, err := checkOuts(&v); err == nil {
+		t.Error("expected error for non-object outputs")
+	}
+}
@@ -0,0 +1,434 @@
+// Copyright (c) 2020 10X Genomics, Inc. All rights reserved.
+
+package core
+
+//
+// Packaging a pipestance into a portable bundle, and unpacking it elsewhere.
+//
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/martian-lang/martian/martian/util"
+)
+
+// BundleScope selects which files of a pipestance are put in a bundle.
+type BundleScope string
+
+const (
+	// Only the pipestance and stage metadata, and the outs manifest.
+	BundleMetadata BundleScope = "metadata"
+
+	// The metadata and the top-level outs directory.
+	BundleOuts BundleScope = "outs"
+
+	// Everything in the pipestance directory.
+	BundleAll BundleScope = "all"
+)
+
+// ParseBundleScope returns an error if the scope is not a known value.
+func ParseBundleScope(s string) (BundleScope, error) {
+	switch scope := BundleScope(s); scope {
+	case BundleMetadata, BundleOuts, BundleAll:
+		return scope, nil
+	}
+	return "", fmt.Errorf("invalid bundle scope %q: must be %s, %s, or %s",
+		s, BundleMetadata, BundleOuts, BundleAll)
+}
+
+// BundleManifestFile is the name of the first entry in a bundle, which
+// describes its content.
+const BundleManifestFile = "_bundle.json"
+
+// BundleFile records a file or symlink in a bundle.
+type BundleFile struct {
+	// The path relative to the pipestance directory.
+	Path string `json:"path"`
+
+	Size int64 `json:"size,omitempty"`
+
+	// The target, for symlinks.
+	Link string `json:"link,omitempty"`
+}
+
+// BundleManifest describes the content of a bundle.
+type BundleManifest struct {
+	Psid  string      `json:"psid"`
+	Scope BundleScope `json:"scope"`
+
+	// The directories the pipestance was in, whose paths are embedded in
+	// its metadata.
+	Roots []string `json:"roots"`
+
+	MartianVersion string        `json:"martian_version"`
+	Created        string        `json:"created"`
+	Files          []*BundleFile `json:"files"`
+}
+
+// bundleEntry is a file, directory or symlink to add to a bundle.
+type bundleEntry struct {
+	// The path in the bundle.
+	name string
+
+	// The path to read the content from.
+	src  string
+	info os.FileInfo
+	link string
+}
+
+// isBundleMetadataSkipDir returns true for directories which only contain
+// stage or runtime data, rather than metadata.
+func isBundleMetadataSkipDir(rel, name string) bool {
+	if name == "files" {
+		return true
+	}
+	if rel != name {
+		return false
+	}
+	switch name {
+	case "journal", "tmp", "extras", RemoteInputsDir:
+		return true
+	}
+	return false
+}
+
+// collectBundleEntries returns the entries for the files in the pipestance
+// directory which are in the given scope.  The lock and UI port files are
+// never included, since they only apply to a running mrp.
+//
+// Symlinks in outs are replaced by the files they point to, so that the
+// outputs in the bundle do not depend on files which may not be in it.
+func collectBundleEntries(psdir string, scope BundleScope) ([]*bundleEntry, error) {
+	var entries []*bundleEntry
+	err := filepath.WalkDir(psdir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == psdir {
+			return nil
+		}
+		rel, err := filepath.Rel(psdir, p)
+		if err != nil {
+			return err
+		}
+		name := d.Name()
+		if rel == "outs" && d.IsDir() {
+			if scope == BundleMetadata {
+				fn := path.Join(p, OutsManifestFile)
+				if info, err := os.Lstat(fn); err == nil && info.Mode().IsRegular() {
+					entries = append(entries, &bundleEntry{
+						name: path.Join(rel, OutsManifestFile),
+						src:  fn,
+						info: info,
+					})
+				}
+			} else {
+				entries, err = appendDereferenced(entries, p, rel,
+					make(map[string]struct{}))
+				if err != nil {
+					return err
+				}
+			}
+			return filepath.SkipDir
+		}
+		if rel == Lock.FileName() || rel == UiPort.FileName() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		entry := &bundleEntry{name: rel, src: p, info: info}
+		switch {
+		case d.IsDir():
+			if scope != BundleAll && isBundleMetadataSkipDir(rel, name) {
+				return filepath.SkipDir
+			}
+		case info.Mode()&os.ModeSymlink != 0:
+			if scope != BundleAll && name == "files" {
+				return nil
+			}
+			if entry.link, err = os.Readlink(p); err != nil {
+				return err
+			}
+		case info.Mode().IsRegular():
+			if scope != BundleAll && !strings.HasPrefix(name, MetadataFilePrefix) {
+				return nil
+			}
+		default:
+			// Sockets, pipes and so on can't be bundled.
+			return nil
+		}
+		entries = append(entries, entry)
+		return nil
+	})
+	return entries, err
+}
+
+// appendDereferenced appends entries for the file or directory at p,
+// following symlinks.
+func appendDereferenced(entries []*bundleEntry, p, rel string,
+	seen map[string]struct{}) ([]*bundleEntry, error) {
+	info, err := os.Stat(p)
+	if os.IsNotExist(err) {
+		// Dangling symlink.
+		return entries, nil
+	} else if err != nil {
+		return entries, err
+	}
+	if info.Mode().IsRegular() {
+		return append(entries, &bundleEntry{name: rel, src: p, info: info}), nil
+	} else if !info.IsDir() {
+		return entries, nil
+	}
+	real, err := filepath.EvalSymlinks(p)
+	if err != nil {
+		return entries, err
+	}
+	if _, ok := seen[real]; ok {
+		return entries, fmt.Errorf("symlink loop at %s", p)
+	}
+	seen[real] = struct{}{}
+	defer delete(seen, real)
+	entries = append(entries, &bundleEntry{name: rel, src: p, info: info})
+	names, err := util.Readdirnames(p)
+	if err != nil {
+		return entries, err
+	}
+	for _, name := range names {
+		entries, err = appendDereferenced(entries,
+			path.Join(p, name), path.Join(rel, name), seen)
+		if err != nil {
+			return entries, err
+		}
+	}
+	return entries, nil
+}
+
+// ExportBundle packs the files of the pipestance in psdir which are in the
+// given scope into a gzipped tarball at dst, preceded by a manifest.  The
+// bundle can be unpacked on another machine with ImportBundle.
+func ExportBundle(psdir, dst string, scope BundleScope) (*BundleManifest, error) {
+	psdir, err := filepath.Abs(psdir)
+	if err != nil {
+		return nil, err
+	}
+	dst, err = filepath.Abs(dst)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(path.Join(psdir, InvocationFile.FileName())); err != nil {
+		return nil, &PipestancePathError{psdir}
+	}
+	if pathIsInside(dst, psdir) {
+		return nil, fmt.Errorf("cannot write a bundle of %s inside itself", psdir)
+	}
+	manifest := &BundleManifest{
+		Psid:           path.Base(psdir),
+		Scope:          scope,
+		Roots:          []string{psdir},
+		MartianVersion: util.GetVersion(),
+		Created:        util.Timestamp(),
+	}
+	if psid, root, err := readPipestanceRoot(psdir); err == nil {
+		manifest.Psid = psid
+		manifest.Roots = append(manifest.Roots, root)
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	if resolved, err := filepath.EvalSymlinks(psdir); err == nil {
+		manifest.Roots = append(manifest.Roots, resolved)
+	}
+	manifest.Roots = relocateRoots(manifest.Roots, "")
+
+	entries, err := collectBundleEntries(psdir, scope)
+	if err != nil {
+		return nil, err
+	}
+	manifest.Files = make([]*BundleFile, 0, len(entries))
+	for _, e := range entries {
+		if e.info.IsDir() {
+			continue
+		}
+		f := &BundleFile{Path: e.name, Link: e.link}
+		if e.link == "" {
+			f.Size = e.info.Size()
+		}
+		manifest.Files = append(manifest.Files, f)
+	}
+	mb, err := json.MarshalIndent(manifest, "", "    ")
+	if err != nil {
+		return nil, err
+	}
+
+	tmp := dst + ".partial"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return nil, err
+	}
+	err = func() error {
+		gz := gzip.NewWriter(f)
+		tw := tar.NewWriter(gz)
+		if err := tw.WriteHeader(&tar.Header{
+			Name:     BundleManifestFile,
+			Typeflag: tar.TypeReg,
+			Mode:     0644,
+			Size:     int64(len(mb)),
+		}); err != nil {
+			return err
+		}
+		if _, err := tw.Write(mb); err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if err := e.write(tw); err != nil {
+				return err
+			}
+		}
+		if err := tw.Close(); err != nil {
+			return err
+		}
+		return gz.Close()
+	}()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, dst)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+	return manifest, nil
+}
+
+func (self *bundleEntry) write(tw *tar.Writer) error {
+	hdr, err := tar.FileInfoHeader(self.info, self.link)
+	if err != nil {
+		return err
+	}
+	hdr.Name = self.name
+	if self.info.IsDir() {
+		hdr.Name += "/"
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if !self.info.Mode().IsRegular() {
+		return nil
+	}
+	in, err := os.Open(self.src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	_, err = io.CopyN(tw, in, hdr.Size)
+	return err
+}
+
+// ImportBundle unpacks a bundle created by ExportBundle into psdir, which
+// must not already exist, and rewrites the paths embedded in the pipestance
+// to refer to its new location.
+func ImportBundle(bundle, psdir string) (*BundleManifest, *RelocateResult, error) {
+	psdir, err := filepath.Abs(psdir)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := os.Lstat(psdir); err == nil {
+		return nil, nil, os.ErrExist
+	} else if !os.IsNotExist(err) {
+		return nil, nil, err
+	}
+	manifest, err := extractBundle(bundle, psdir)
+	if err != nil {
+		os.RemoveAll(psdir)
+		return manifest, nil, err
+	}
+	result, err := relocatePipestance(psdir, psdir, false, manifest.Roots)
+	return manifest, result, err
+}
+
+// extractBundle reads the manifest from a bundle and extracts the rest of
+// its entries into psdir.
+func extractBundle(bundle, psdir string) (*BundleManifest, error) {
+	f, err := os.Open(bundle)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+	hdr, err := tr.Next()
+	if err != nil || hdr.Name != BundleManifestFile {
+		return nil, fmt.Errorf("%s is not a pipestance bundle", bundle)
+	}
+	var manifest BundleManifest
+	if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("reading bundle manifest: %w", err)
+	}
+	if err := os.MkdirAll(psdir, 0777); err != nil {
+		return &manifest, err
+	}
+	expected := make(map[string]*BundleFile, len(manifest.Files))
+	for _, f := range manifest.Files {
+		expected[f.Path] = f
+	}
+	var symlinks []string
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return &manifest, err
+		}
+		name := path.Clean(hdr.Name)
+		if path.IsAbs(name) || name == "." || name == ".." ||
+			strings.HasPrefix(name, "../") {
+			return &manifest, fmt.Errorf("invalid bundle entry %q", hdr.Name)
+		}
+		// Refuse to write through a symlink from the bundle, which might
+		// point outside of the pipestance.
+		for _, link := range symlinks {
+			if strings.HasPrefix(name, link+"/") {
+				return &manifest, fmt.Errorf("bundle entry %q is under symlink %q",
+					hdr.Name, link)
+			}
+		}
+		if hdr.Typeflag == tar.TypeSymlink {
+			symlinks = append(symlinks, name)
+		}
+		if hdr.Typeflag != tar.TypeDir {
+			f := expected[name]
+			if f == nil {
+				return &manifest, fmt.Errorf("bundle entry %q is not in the manifest",
+					name)
+			} else if f.Link == "" && f.Size != hdr.Size {
+				return &manifest, fmt.Errorf("bundle entry %q is %d bytes, "+
+					"but the manifest says %d", name, hdr.Size, f.Size)
+			}
+			delete(expected, name)
+		}
+		if err := extractTarEntry(tr, hdr, path.Join(psdir, name)); err != nil {
+			return &manifest, err
+		}
+	}
+	if len(expected) > 0 {
+		return &manifest, fmt.Errorf("%d files in the manifest are missing "+
+			"from the bundle", len(expected))
+	}
+	return &manifest, nil
+}
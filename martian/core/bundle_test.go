@@ -0,0 +1,167 @@
+// Copyright (c) 2020 10X Genomics, Inc. All rights reserved.
+
+package core
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/martian-lang/martian/martian/util"
+)
+
+func TestBundle(t *testing.T) {
+	util.SetPrintLogger(&devNull)
+	rtOpts := DefaultRuntimeOptions()
+	psdir, pipestance := runTestPipestance(t, &rtOpts, "bundle")
+	pipestance.PostProcess()
+	pipestance.Unlock()
+
+	tmp := t.TempDir()
+	if _, err := ExportBundle(psdir, path.Join(psdir, "b.tar.gz"),
+		BundleAll); err == nil {
+		t.Error("expected an error writing a bundle inside the pipestance")
+	}
+	bundles := make(map[BundleScope]string)
+	files := make(map[BundleScope]map[string]*BundleFile)
+	for _, scope := range []BundleScope{BundleMetadata, BundleOuts, BundleAll} {
+		bundles[scope] = path.Join(tmp, string(scope)+".tar.gz")
+		manifest, err := ExportBundle(psdir, bundles[scope], scope)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if manifest.Psid != "bundle" {
+			t.Errorf("incorrect psid %q", manifest.Psid)
+		}
+		files[scope] = make(map[string]*BundleFile, len(manifest.Files))
+		for _, f := range manifest.Files {
+			files[scope][f.Path] = f
+		}
+		for _, fn := range []string{
+			FinalState.FileName(),
+			InvocationFile.FileName(),
+			path.Join("outs", OutsManifestFile),
+		} {
+			if files[scope][fn] == nil {
+				t.Errorf("%s bundle is missing %s", scope, fn)
+			}
+		}
+		if files[scope][Lock.FileName()] != nil {
+			t.Errorf("%s bundle includes the lock file", scope)
+		}
+	}
+	if files[BundleMetadata]["outs/file.txt"] != nil {
+		t.Error("metadata bundle includes outputs")
+	}
+	if f := files[BundleOuts]["outs/file.txt"]; f == nil {
+		t.Error("outs bundle is missing outputs")
+	} else if f.Size == 0 {
+		t.Error("outs bundle has incorrect size for outputs")
+	}
+	if len(files[BundleAll]) <= len(files[BundleOuts]) {
+		t.Error("expected the full bundle to have more files than the outs bundle")
+	}
+
+	dst := path.Join(tmp, "imported")
+	manifest, result, err := ImportBundle(bundles[BundleOuts], dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.Scope != BundleOuts {
+		t.Errorf("incorrect scope %q", manifest.Scope)
+	}
+	if result.Files == 0 {
+		t.Error("no paths were rewritten")
+	}
+	expect, err := os.ReadFile(path.Join(psdir, "outs", "file.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, err := os.ReadFile(path.Join(dst, "outs", "file.txt")); err != nil {
+		t.Error(err)
+	} else if string(b) != string(expect) {
+		t.Errorf("incorrect content %q", b)
+	}
+	if _, root, err := readPipestanceRoot(dst); err != nil {
+		t.Error(err)
+	} else if root != dst {
+		t.Errorf("final state refers to %s", root)
+	}
+	rt := rtOpts.NewRuntimeWithJobConfig(&JobManagerJson{
+		JobSettings: &JobManagerSettings{
+			ThreadsPerJob: 1,
+			MemGBPerJob:   1,
+		},
+	})
+	if err := rt.VerifyRelocatedPipestance(dst, context.Background()); err != nil {
+		t.Error(err)
+	}
+	if _, root, err := readPipestanceRoot(psdir); err != nil {
+		t.Error(err)
+	} else if root != psdir {
+		t.Error("importing modified the original pipestance")
+	}
+	if _, _, err := ImportBundle(bundles[BundleOuts], dst); err == nil {
+		t.Error("expected an error importing over an existing directory")
+	}
+}
+
+func TestImportBundleTraversal(t *testing.T) {
+	tmp := t.TempDir()
+	bundle := path.Join(tmp, "evil.tar.gz")
+	f, err := os.Create(bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	mb, err := json.Marshal(&BundleManifest{
+		Psid:  "evil",
+		Files: []*BundleFile{{Path: "../evil", Size: 4}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range []struct {
+		name    string
+		content []byte
+	}{
+		{BundleManifestFile, mb},
+		{"../evil", []byte("evil")},
+	} {
+		if err := tw.WriteHeader(&tar.Header{
+			Name:     e.name,
+			Typeflag: tar.TypeReg,
+			Mode:     0644,
+			Size:     int64(len(e.content)),
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(e.content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	dst := path.Join(tmp, "ps")
+	if _, _, err := ImportBundle(bundle, dst); err == nil {
+		t.Error("expected an error for an entry outside the pipestance")
+	}
+	if _, err := os.Stat(dst); !os.IsNotExist(err) {
+		t.Error("the partially imported pipestance was not removed")
+	}
+	if _, err := os.Stat(path.Join(tmp, "evil")); !os.IsNotExist(err) {
+		t.Error("wrote a file outside the pipestance")
+	}
+}
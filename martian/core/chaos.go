@@ -0,0 +1,257 @@
+// Copyright (c) 2020 10X Genomics, Inc. All rights reserved.
+
+// Fault injection for testing retry and recovery logic.
+
+package core
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/martian-lang/martian/martian/util"
+)
+
+// ChaosConfig configures the rates at which faults are injected into a
+// pipestance, for testing how the runtime and the pipeline recover from them.
+//
+// Each rate is a probability between 0 and 1 applied independently to every
+// opportunity for the fault to occur.  Decisions are derived from the seed,
+// the kind of fault, the job name, and the number of previous decisions of
+// that kind for that job.  A given seed therefore makes the same decision for
+// the nth opportunity of a fault in a job on every run, but how many
+// opportunities there are, for example how often a job's heartbeat is
+// checked, depends on timing, so runs are not guaranteed to see the same
+// faults.
+type ChaosConfig struct {
+	// The seed for fault injection decisions.
+	Seed int64 `json:"seed"`
+
+	// The rate at which local jobs are killed after starting.
+	KillJob float64 `json:"kill_job"`
+
+	// The signal used to kill jobs, e.g. SIGKILL (the default) or SIGTERM.
+	KillSignal string `json:"kill_signal,omitempty"`
+
+	// Killed jobs are killed at a time chosen between 0 and this many
+	// seconds after starting.  The default is 10.
+	KillWithin float64 `json:"kill_within,omitempty"`
+
+	// The rate at which heartbeat checks for running jobs behave as though
+	// the heartbeat had timed out.
+	DropHeartbeat float64 `json:"drop_heartbeat"`
+
+	// The rate at which jobs are omitted from the job manager's response
+	// to a queue check, as though they had vanished from the queue.
+	VanishJob float64 `json:"vanish_job"`
+
+	// The rate at which journal updates are left for a later refresh.
+	DelayJournal float64 `json:"delay_journal"`
+
+	// The rate at which jobs fail with TransientMessage instead of being
+	// submitted.
+	TransientError float64 `json:"transient_error"`
+
+	// The error written for injected transient failures.  It should match
+	// one of the patterns in jobmanagers/retry.json.  The default is
+	// "signal: killed".
+	TransientMessage string `json:"transient_message,omitempty"`
+}
+
+const (
+	defaultChaosSignal     = "SIGKILL"
+	defaultChaosKillWithin = 10
+	defaultChaosMessage    = "signal: killed"
+)
+
+var chaosSignals = map[string]syscall.Signal{
+	"SIGABRT": syscall.SIGABRT,
+	"SIGHUP":  syscall.SIGHUP,
+	"SIGINT":  syscall.SIGINT,
+	"SIGKILL": syscall.SIGKILL,
+	"SIGSEGV": syscall.SIGSEGV,
+	"SIGTERM": syscall.SIGTERM,
+}
+
+// ReadChaosConfig loads and validates a fault injection configuration from
+// a json file.
+func ReadChaosConfig(fn string) (*ChaosConfig, error) {
+	b, err := os.ReadFile(fn)
+	if err != nil {
+		return nil, err
+	}
+	var config ChaosConfig
+	if err := json.Unmarshal(b, &config); err != nil {
+		return nil, err
+	}
+	return &config, config.validate()
+}
+
+func (c *ChaosConfig) validate() error {
+	for _, rate := range [...]struct {
+		name  string
+		value float64
+	}{
+		{"kill_job", c.KillJob},
+		{"drop_heartbeat", c.DropHeartbeat},
+		{"vanish_job", c.VanishJob},
+		{"delay_journal", c.DelayJournal},
+		{"transient_error", c.TransientError},
+	} {
+		if rate.value < 0 || rate.value > 1 {
+			return fmt.Errorf("chaos rate %s=%g is not between 0 and 1",
+				rate.name, rate.value)
+		}
+	}
+	if c.KillWithin < 0 {
+		return fmt.Errorf("chaos kill_within=%g is negative", c.KillWithin)
+	}
+	if c.KillSignal != "" {
+		if _, ok := chaosSignals[chaosSignalName(c.KillSignal)]; !ok {
+			return fmt.Errorf("unsupported chaos kill_signal %q", c.KillSignal)
+		}
+	}
+	return nil
+}
+
+func chaosSignalName(name string) string {
+	name = strings.ToUpper(name)
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+	return name
+}
+
+// chaosMonkey decides when to inject faults.  A nil chaosMonkey never
+// injects any.
+type chaosMonkey struct {
+	config ChaosConfig
+	signal syscall.Signal
+	within time.Duration
+
+	mu     sync.Mutex
+	counts map[string]uint64
+}
+
+func newChaosMonkey(config *ChaosConfig) *chaosMonkey {
+	if config == nil {
+		return nil
+	}
+	c := &chaosMonkey{
+		config: *config,
+		signal: chaosSignals[defaultChaosSignal],
+		within: time.Second * defaultChaosKillWithin,
+		counts: make(map[string]uint64),
+	}
+	if sig, ok := chaosSignals[chaosSignalName(config.KillSignal)]; ok {
+		c.signal = sig
+	}
+	if config.KillWithin > 0 {
+		c.within = time.Duration(config.KillWithin * float64(time.Second))
+	}
+	if c.config.TransientMessage == "" {
+		c.config.TransientMessage = defaultChaosMessage
+	}
+	return c
+}
+
+// sample returns a value in [0, 1) for the next occurrence of the given kind
+// of event for the given key.
+func (c *chaosMonkey) sample(kind, key string) float64 {
+	id := kind + "\x00" + key
+	c.mu.Lock()
+	n := c.counts[id]
+	c.counts[id] = n + 1
+	c.mu.Unlock()
+	var buf [8]byte
+	h := fnv.New64a()
+	binary.LittleEndian.PutUint64(buf[:], uint64(c.config.Seed))
+	h.Write(buf[:])
+	h.Write([]byte(id))
+	binary.LittleEndian.PutUint64(buf[:], n)
+	h.Write(buf[:])
+	// Use the top 53 bits, which is all a float64 can represent exactly.
+	return float64(h.Sum64()>>11) / (1 << 53)
+}
+
+// roll returns true with the given probability.
+func (c *chaosMonkey) roll(rate float64, kind, key string) bool {
+	if rate <= 0 {
+		return false
+	}
+	return c.sample(kind, key) < rate
+}
+
+// jobStarted returns a function to call with the process for a local job
+// once it has started, or nil if the job should be left alone.
+func (c *chaosMonkey) jobStarted(fqname string) func(*os.Process) {
+	if c == nil || !c.roll(c.config.KillJob, "kill_job", fqname) {
+		return nil
+	}
+	delay := time.Duration(c.sample("kill_delay", fqname) * float64(c.within))
+	return func(proc *os.Process) {
+		time.AfterFunc(delay, func() {
+			util.PrintInfo("chaos", "Sending %v to %s", c.signal, fqname)
+			// The process may have already exited.
+			_ = proc.Signal(c.signal)
+		})
+	}
+}
+
+// dropHeartbeat returns true if a running job should be treated as though
+// its heartbeat had timed out.
+func (c *chaosMonkey) dropHeartbeat(metadata *Metadata) bool {
+	if c == nil {
+		return false
+	}
+	if state, _ := metadata.getState(); state != Running {
+		return false
+	}
+	if c.roll(c.config.DropHeartbeat, "drop_heartbeat", metadata.fqname) {
+		util.PrintInfo("chaos", "Dropping heartbeat for %s", metadata.fqname)
+		return true
+	}
+	return false
+}
+
+// vanish removes job IDs from the result of a queue check.
+func (c *chaosMonkey) vanish(queued []string) []string {
+	if c == nil || c.config.VanishJob <= 0 {
+		return queued
+	}
+	kept := queued[:0]
+	for _, id := range queued {
+		if c.roll(c.config.VanishJob, "vanish_job", id) {
+			util.PrintInfo("chaos", "Hiding job %s from queue check", id)
+		} else {
+			kept = append(kept, id)
+		}
+	}
+	return kept
+}
+
+// delayJournal returns true if the given journal file should be left for a
+// later refresh.
+func (c *chaosMonkey) delayJournal(filename string) bool {
+	if c != nil && c.roll(c.config.DelayJournal, "delay_journal", filename) {
+		util.LogInfo("chaos", "Delaying journal update %s", filename)
+		return true
+	}
+	return false
+}
+
+// transientError returns the message to fail the job with, if it should
+// fail rather than be submitted.
+func (c *chaosMonkey) transientError(fqname string) (string, bool) {
+	if c != nil && c.roll(c.config.TransientError, "transient_error", fqname) {
+		util.PrintInfo("chaos", "Injecting transient failure for %s", fqname)
+		return c.config.TransientMessage, true
+	}
+	return "", false
+}
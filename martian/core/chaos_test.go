@@ -0,0 +1,126 @@
+// Copyright (c) 2020 10X Genomics, Inc. All rights reserved.
+
+package core
+
+import (
+	"context"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/martian-lang/martian/martian/util"
+)
+
+func TestReadChaosConfig(t *testing.T) {
+	fn := path.Join(t.TempDir(), "chaos.json")
+	check := func(t *testing.T, content, expectErr string) *ChaosConfig {
+		t.Helper()
+		if err := os.WriteFile(fn, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		config, err := ReadChaosConfig(fn)
+		if expectErr == "" {
+			if err != nil {
+				t.Error(err)
+			}
+		} else if err == nil {
+			t.Errorf("expected error containing %q", expectErr)
+		} else if !strings.Contains(err.Error(), expectErr) {
+			t.Errorf("expected error containing %q, got %v", expectErr, err)
+		}
+		return config
+	}
+	if config := check(t, `{
+		"seed": 7,
+		"kill_job": 0.1,
+		"kill_signal": "term",
+		"delay_journal": 0.5
+	}`, ""); config != nil {
+		if config.Seed != 7 || config.KillJob != 0.1 || config.DelayJournal != 0.5 {
+			t.Errorf("incorrect config %#v", config)
+		}
+	}
+	check(t, `{"vanish_job": 1.5}`, "vanish_job")
+	check(t, `{"kill_signal": "SIGNOPE"}`, "SIGNOPE")
+	check(t, `{"kill_within": -1}`, "kill_within")
+}
+
+func TestChaosDeterministic(t *testing.T) {
+	decisions := func(seed int64) []bool {
+		c := newChaosMonkey(&ChaosConfig{Seed: seed, DelayJournal: 0.5})
+		result := make([]bool, 0, 64)
+		for i := 0; i < 16; i++ {
+			for _, key := range []string{"a", "b", "c", "d"} {
+				result = append(result, c.delayJournal(key))
+			}
+		}
+		return result
+	}
+	first := decisions(1)
+	if !reflect.DeepEqual(first, decisions(1)) {
+		t.Error("decisions differ for the same seed")
+	}
+	if reflect.DeepEqual(first, decisions(2)) {
+		t.Error("decisions match for different seeds")
+	}
+	count := 0
+	for _, d := range first {
+		if d {
+			count++
+		}
+	}
+	if count == 0 || count == len(first) {
+		t.Errorf("%d of %d decisions were true at rate 0.5", count, len(first))
+	}
+
+	var nilMonkey *chaosMonkey
+	if nilMonkey.delayJournal("a") {
+		t.Error("nil chaos monkey injected a fault")
+	}
+	if queued := nilMonkey.vanish([]string{"1", "2"}); len(queued) != 2 {
+		t.Errorf("nil chaos monkey hid jobs: %v", queued)
+	}
+	c := newChaosMonkey(&ChaosConfig{VanishJob: 1})
+	if queued := c.vanish([]string{"1", "2"}); len(queued) != 0 {
+		t.Errorf("expected all jobs hidden, got %v", queued)
+	}
+}
+
+func TestPipestanceChaosTransientError(t *testing.T) {
+	util.SetPrintLogger(testLogger{t: t})
+	defer util.SetPrintLogger(&devNull)
+	rtOpts := DefaultRuntimeOptions()
+	rtOpts.VdrMode = VdrDisable
+	rtOpts.Chaos = &ChaosConfig{TransientError: 1}
+	var runner testStageRunner
+	rt := newTestRuntime(&rtOpts, &runner)
+	pipestance, err := rt.InvokePipeline(readTestFixture(t, "chaos.mro"),
+		"chaos.mro", "chaos", path.Join(t.TempDir(), "chaos"),
+		[]string{"testdata"}, "<none>", nil, nil)
+	if err != nil {
+		t.Fatal("Invoking pipeline:", err)
+	}
+	defer pipestance.Unlock()
+	ctx := context.Background()
+	pipestance.LoadMetadata(ctx)
+	for {
+		pipestance.RefreshState(ctx)
+		if state := pipestance.GetState(ctx); state == Failed {
+			break
+		} else if state == Complete {
+			t.Fatal("pipestance completed despite injected failures")
+		}
+		if !pipestance.StepNodes(ctx) {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	if ran := runner.jobs(); len(ran) != 0 {
+		t.Errorf("expected no jobs to run, ran %v", ran)
+	}
+	if transient, log := pipestance.IsErrorTransient(); !transient {
+		t.Errorf("injected error %q was not transient", log)
+	}
+}
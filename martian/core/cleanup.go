@@ -0,0 +1,372 @@
+// Copyright (c) 2020 10X Genomics, Inc. All rights reserved.
+
+package core
+
+//
+// Removing intermediate files from a completed pipestance.
+//
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/martian-lang/martian/martian/syntax"
+	"github.com/martian-lang/martian/martian/util"
+)
+
+// CleanupOptions controls what CleanupPipestance removes.
+type CleanupOptions struct {
+	// Report what would be removed without removing anything.
+	DryRun bool
+
+	// Keep the pipestance performance report.
+	KeepPerf bool
+
+	// Keep the pipestance log.
+	KeepLog bool
+
+	// Keep the stage metadata.  If it was zipped, it is zipped again.
+	KeepMetadata bool
+}
+
+// CleanupReport summarizes what CleanupPipestance removed, or would have
+// removed in a dry run.
+type CleanupReport struct {
+	DryRun bool `json:"dry_run,omitempty"`
+
+	// The stage files which were not reachable from the pipeline outputs or
+	// a retain declaration.
+	Stages []*VdrStageReport `json:"stages"`
+
+	// The pipestance metadata files which were removed.
+	Metadata []string `json:"metadata,omitempty"`
+
+	MetadataCount uint   `json:"metadata_count"`
+	MetadataSize  uint64 `json:"metadata_size"`
+
+	Count  uint     `json:"count"`
+	Size   uint64   `json:"size"`
+	Errors []string `json:"errors,omitempty"`
+}
+
+func (self *CleanupReport) addStage(rep *VdrStageReport) {
+	self.Stages = append(self.Stages, rep)
+	self.Count += rep.Count
+	self.Size += rep.Size
+}
+
+func (self *CleanupReport) addMetadata(fn string, size int64) {
+	self.Metadata = append(self.Metadata, fn)
+	self.MetadataCount++
+	self.MetadataSize += uint64(size)
+	self.Count++
+	self.Size += uint64(size)
+}
+
+// CleanupPipestance removes the files of a completed pipestance which are
+// not reachable from the outputs of the top-level pipeline, keeping the
+// outputs named in retain declarations, as strict volatile data removal
+// would have done.  Unless the options say otherwise, the pipestance
+// performance report, log and stage metadata are removed as well.
+//
+// Pipestances which have a lock file or a UI port file are refused, since
+// mrp may still be running in them.
+func (self *Runtime) CleanupPipestance(psdir string, opts CleanupOptions,
+	ctx context.Context) (*CleanupReport, error) {
+	psdir, err := filepath.Abs(psdir)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(path.Join(psdir, InvocationFile.FileName())); err != nil {
+		return nil, &PipestancePathError{psdir}
+	}
+	for _, fn := range []MetadataFileName{Lock, UiPort} {
+		if _, err := os.Lstat(path.Join(psdir, fn.FileName())); err == nil {
+			return nil, &RuntimeError{Msg: fmt.Sprintf(
+				"%s has a %s file.  Make sure mrp is not running in the "+
+					"pipestance, and then remove it.",
+				psdir, fn.FileName())}
+		}
+	}
+	psid, _, err := readPipestanceRoot(psdir)
+	if os.IsNotExist(err) {
+		return nil, &RuntimeError{Msg: fmt.Sprintf(
+			"%s has not finished running.", psdir)}
+	} else if err != nil {
+		return nil, err
+	}
+	zipPath := path.Join(psdir, MetadataZip.FileName())
+	zipInfo, _ := os.Stat(zipPath)
+	loadDir := psdir
+	if zipInfo != nil {
+		// Extract the metadata before reattaching, so that the chunks of
+		// each fork are found when the forks are created.  A dry run must
+		// not modify the pipestance, so it extracts a scratch copy instead.
+		if opts.DryRun {
+			if loadDir, err = scratchPipestance(psdir); err != nil {
+				return nil, err
+			}
+			defer os.RemoveAll(loadDir)
+		} else if err := unzipMetadata(zipPath); err != nil {
+			return nil, err
+		}
+	}
+	pipestance, err := self.ReattachToPipestanceWithMroSrc(psid, loadDir,
+		"", "", nil, "", nil, false, true, ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !opts.DryRun {
+		if err := pipestance.Lock(); err != nil {
+			return nil, err
+		}
+		defer pipestance.Unlock()
+	}
+	pipestance.LoadMetadata(ctx)
+	var report *CleanupReport
+	if state := pipestance.GetState(ctx); state != Complete {
+		err = &RuntimeError{Msg: fmt.Sprintf(
+			"pipestance %s is %s, not complete.", psid, state)}
+	} else {
+		report = pipestance.cleanupFiles(opts, zipInfo)
+		if loadDir != psdir {
+			report.relocate(newPathRelocator([]string{loadDir}, psdir))
+		}
+	}
+	if zipInfo != nil && !opts.DryRun && (err != nil || opts.KeepMetadata) {
+		if zerr := pipestance.zipMetadata(zipPath); zerr != nil && err == nil {
+			err = zerr
+		}
+	}
+	return report, err
+}
+
+// relocate rewrites the paths in the report.
+func (self *CleanupReport) relocate(r *pathRelocator) {
+	for _, stage := range self.Stages {
+		for i, p := range stage.Paths {
+			stage.Paths[i], _ = r.relocate(p)
+		}
+	}
+	for i, p := range self.Metadata {
+		self.Metadata[i], _ = r.relocate(p)
+	}
+}
+
+// scratchPipestance creates a temporary copy of a pipestance with zipped
+// metadata, with the metadata extracted and the paths embedded in it
+// rewritten to refer to the copy.  Stage files are not copied.  Instead, the
+// directories containing them are replaced with relative symlinks to the
+// originals, which the copy's metadata will refer to.
+//
+// The caller is responsible for removing the copy.
+func scratchPipestance(psdir string) (string, error) {
+	scratch, err := os.MkdirTemp("", "cleanup")
+	if err != nil {
+		return "", err
+	}
+	err = filepath.WalkDir(psdir, func(p string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(psdir, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		target := path.Join(scratch, rel)
+		if d.Type()&os.ModeSymlink != 0 {
+			link, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		} else if d.IsDir() {
+			if !isScratchLinkedDir(rel) {
+				return os.Mkdir(target, 0777)
+			}
+			link, err := filepath.Rel(path.Dir(target), p)
+			if err == nil {
+				err = os.Symlink(link, target)
+			}
+			if err != nil {
+				return err
+			}
+			return filepath.SkipDir
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		return copyFile(p, target, info.Mode())
+	})
+	if err == nil {
+		// Extract the metadata first, so that the copy is left unzipped.
+		err = unzipMetadata(path.Join(scratch, MetadataZip.FileName()))
+	}
+	if err == nil {
+		_, err = relocatePipestance(scratch, scratch, false, []string{psdir})
+	}
+	if err != nil {
+		os.RemoveAll(scratch)
+		return "", err
+	}
+	return scratch, nil
+}
+
+// isScratchLinkedDir returns true if the directory at the given path relative
+// to the pipestance contains files which belong to stages or to the
+// pipestance outputs, rather than metadata.
+func isScratchLinkedDir(rel string) bool {
+	switch name := path.Base(rel); name {
+	case "files", "tmp":
+		return true
+	case "outs", "extras", RemoteInputsDir:
+		return name == rel
+	}
+	return false
+}
+
+// cleanupFiles removes the unreachable stage files and, unless the options
+// say to keep them, the pipestance metadata files.  If the stage metadata
+// was extracted from a zip file, the size of the zip file is reported
+// instead of the sizes of the extracted files.
+func (self *Pipestance) cleanupFiles(opts CleanupOptions,
+	zipInfo os.FileInfo) *CleanupReport {
+	report := &CleanupReport{DryRun: opts.DryRun}
+	for _, node := range self.allNodes() {
+		if node.call.Kind() != syntax.KindStage {
+			continue
+		}
+		// Refuse to remove files across a symlink, as VDR does.
+		if symlink, err := node.vdrCheckSymlink(); symlink != "" {
+			util.LogInfo("runtime", "Refuse to clean up across a symlink %s: %v",
+				symlink, node.GetFQName())
+			continue
+		} else if err != nil {
+			report.Errors = append(report.Errors, err.Error())
+			continue
+		}
+		for _, fork := range node.forks {
+			if rep, errs := fork.cleanupFiles(opts.DryRun); rep != nil {
+				report.addStage(rep)
+				report.Errors = append(report.Errors, errs...)
+			}
+		}
+	}
+
+	var mdFiles []string
+	if !opts.KeepPerf {
+		mdFiles = append(mdFiles, self.metadata.MetadataFilePath(Perf))
+	}
+	if !opts.KeepLog {
+		mdFiles = append(mdFiles, self.metadata.MetadataFilePath(LogFile))
+	}
+	if !opts.KeepMetadata {
+		// The symlinks to uniquified directories are kept, since files
+		// which are still referenced may be reached through them.
+		files, _ := self.stageMetadataFiles()
+		if zipInfo == nil {
+			mdFiles = append(mdFiles, files...)
+		} else {
+			report.addMetadata(self.metadata.MetadataFilePath(MetadataZip),
+				zipInfo.Size())
+			if !opts.DryRun {
+				for _, fn := range files {
+					if err := os.Remove(fn); err != nil {
+						report.Errors = append(report.Errors, err.Error())
+					}
+				}
+			}
+		}
+	}
+	for _, fn := range mdFiles {
+		info, err := os.Lstat(fn)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			report.Errors = append(report.Errors, err.Error())
+			continue
+		}
+		if !opts.DryRun {
+			if err := os.Remove(fn); err != nil {
+				report.Errors = append(report.Errors, err.Error())
+				continue
+			}
+		}
+		report.addMetadata(fn, info.Size())
+	}
+	if !opts.DryRun && !opts.KeepMetadata {
+		for _, node := range self.allNodes() {
+			node.removeMetadata()
+		}
+	}
+	return report
+}
+
+// cleanupFiles removes the files of a completed fork which are not kept
+// alive by the outputs of the top-level pipeline or by a retain declaration.
+// Since every node in the pipestance has completed, no other stage needs
+// them.
+func (self *Fork) cleanupFiles(dryRun bool) (*VdrStageReport, []string) {
+	if self.getState() != Complete ||
+		!self.node.top.rt.overrides.GetForceVolatile(
+			self.node.GetFQName(), true) {
+		return nil, nil
+	}
+	self.storageLock.Lock()
+	defer self.storageLock.Unlock()
+	doneNodes := make([]Nodable, 0, len(self.filePostNodes))
+	for node := range self.filePostNodes {
+		if node != nil {
+			doneNodes = append(doneNodes, node)
+		}
+	}
+	self.removeFilePostNodes(doneNodes)
+	self.cacheParamFileMap(nil)
+
+	var report VDRKillReport
+	paths, size, count := self.collapseKillPaths(self.unreferencedFiles())
+	report.Paths = paths
+	report.Size = size
+	report.Count = count
+	// Temporary files are normally removed when the stage completes, but
+	// may have been left behind if VDR was disabled.
+	for _, md := range self.collectMetadatas()[1:] {
+		temps, _ := md.enumerateTemp()
+		for _, p := range temps {
+			if err := util.Walk(p, func(_ string, info os.FileInfo, err error) error {
+				if err == nil {
+					report.Size += uint64(info.Size())
+					report.Count++
+				} else {
+					report.Errors = append(report.Errors, err.Error())
+				}
+				return nil
+			}); err != nil {
+				report.Errors = append(report.Errors, err.Error())
+			}
+			report.Paths = append(report.Paths, p)
+		}
+	}
+	if len(report.Paths) == 0 {
+		return nil, nil
+	}
+	if !dryRun {
+		util.EnterCriticalSection()
+		defer util.ExitCriticalSection()
+		self.removeVolatile(report.Paths, &report)
+		for _, p := range paths {
+			delete(self.fileParamMap, p)
+		}
+	}
+	return &VdrStageReport{
+		Fqname: self.fqname,
+		Paths:  report.Paths,
+		Count:  report.Count,
+		Size:   report.Size,
+		Kept:   self.listKeptArgs(),
+	}, report.Errors
+}
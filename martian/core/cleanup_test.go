@@ -0,0 +1,144 @@
+// Copyright (c) 2020 10X Genomics, Inc. All rights reserved.
+
+package core
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/martian-lang/martian/martian/util"
+)
+
+func TestCleanupPipestance(t *testing.T) {
+	util.SetPrintLogger(&devNull)
+	rtOpts := DefaultRuntimeOptions()
+	rtOpts.VdrMode = VdrDisable
+	rtOpts.Zip = true
+	psdir, pipestance := runTestPipestance(t, &rtOpts, "cleanup")
+	pipestance.PostProcess()
+	pipestance.Unlock()
+
+	cleanOpts := DefaultRuntimeOptions()
+	rt := cleanOpts.NewRuntimeWithJobConfig(&JobManagerJson{
+		JobSettings: &JobManagerSettings{
+			ThreadsPerJob: 1,
+			MemGBPerJob:   1,
+		},
+	})
+	ctx := context.Background()
+
+	uiport := path.Join(psdir, UiPort.FileName())
+	if err := os.WriteFile(uiport, []byte("localhost:1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rt.CleanupPipestance(psdir, CleanupOptions{}, ctx); err == nil {
+		t.Error("expected cleanup to refuse a pipestance with a ui port")
+	}
+	if err := os.Remove(uiport); err != nil {
+		t.Fatal(err)
+	}
+
+	findMakeFile := func(report *CleanupReport) string {
+		t.Helper()
+		for _, stage := range report.Stages {
+			if !strings.Contains(stage.Fqname, ".MAKE.") {
+				continue
+			}
+			for _, p := range stage.Paths {
+				if path.Base(p) == "file.txt" {
+					return p
+				}
+			}
+		}
+		t.Fatal("MAKE output was not reported")
+		return ""
+	}
+
+	before := snapshotTree(t, psdir)
+	report, err := rt.CleanupPipestance(psdir, CleanupOptions{
+		DryRun: true,
+	}, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fn := findMakeFile(report)
+	if !strings.HasPrefix(fn, psdir+"/") {
+		t.Errorf("reported %s, which is not in the pipestance", fn)
+	}
+	if report.Size == 0 {
+		t.Error("expected nonzero size")
+	}
+	if report.MetadataCount == 0 {
+		t.Error("expected metadata to be reported")
+	}
+	if after := snapshotTree(t, psdir); !reflect.DeepEqual(before, after) {
+		t.Errorf("dry run modified the pipestance:\n%v\n%v", before, after)
+	}
+
+	report, err = rt.CleanupPipestance(psdir, CleanupOptions{
+		KeepMetadata: true,
+	}, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Errors) > 0 {
+		t.Error(report.Errors)
+	}
+	if p := findMakeFile(report); p != fn {
+		t.Errorf("removed %s, expected %s", p, fn)
+	}
+	if _, err := os.Stat(fn); !os.IsNotExist(err) {
+		t.Error("cleanup did not remove", fn)
+	}
+	if _, err := os.Stat(path.Join(psdir, Perf.FileName())); !os.IsNotExist(err) {
+		t.Error("cleanup did not remove the performance report")
+	}
+	if _, err := os.Stat(path.Join(psdir, Lock.FileName())); !os.IsNotExist(err) {
+		t.Error("cleanup did not unlock the pipestance")
+	}
+	if err := rt.VerifyRelocatedPipestance(psdir, ctx); err != nil {
+		t.Error(err)
+	}
+}
+
+// snapshotTree returns the type, size, modification time and content of
+// every file in a directory, or the target for symlinks.
+func snapshotTree(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	result := make(map[string]string)
+	if err := filepath.WalkDir(dir, func(p string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		desc := fmt.Sprint(info.Mode(), info.Size(), info.ModTime())
+		if d.Type()&os.ModeSymlink != 0 {
+			link, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			desc += " -> " + link
+		} else if !d.IsDir() {
+			b, err := os.ReadFile(p)
+			if err != nil {
+				return err
+			}
+			desc += fmt.Sprintf(" %x", sha256.Sum256(b))
+		}
+		result[p] = desc
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
@@ -0,0 +1,193 @@
+// Copyright (c) 2020 10X Genomics, Inc. All rights reserved.
+
+package core
+
+//
+// Holding back job submission when the pipestance filesystem is low on space.
+//
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/dustin/go-humanize"
+	"github.com/martian-lang/martian/martian/syntax"
+	"github.com/martian-lang/martian/martian/util"
+)
+
+// ReadDiskForecast reads the storage used by each stage in a previous run of
+// a pipeline, from its _perf file or pipestance directory, for use as
+// RuntimeOptions.DiskForecast.
+//
+// The forecast for each stage is the largest total bytes written by any of
+// its forks, keyed by the fully-qualified stage name without the ID.psid.
+// prefix.
+func ReadDiskForecast(fn string) (map[string]uint64, error) {
+	if info, err := os.Stat(fn); err != nil {
+		return nil, err
+	} else if info.IsDir() {
+		fn = path.Join(fn, Perf.FileName())
+	}
+	b, err := os.ReadFile(fn)
+	if err != nil {
+		return nil, err
+	}
+	var perf []*NodePerfInfo
+	if err := json.Unmarshal(b, &perf); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", fn, err)
+	}
+	forecast := make(map[string]uint64, len(perf))
+	for _, node := range perf {
+		if node == nil || node.Type != syntax.KindStage {
+			continue
+		}
+		// Strip the ID.psid. prefix.
+		parts := strings.SplitN(node.Fqname, ".", 3)
+		if len(parts) < 3 {
+			continue
+		}
+		for _, fork := range node.Forks {
+			if fork == nil || fork.ForkStats == nil {
+				continue
+			}
+			if b := fork.ForkStats.TotalBytes; b > forecast[parts[2]] {
+				forecast[parts[2]] = b
+			}
+		}
+	}
+	return forecast, nil
+}
+
+// diskGuard holds back job submission while the filesystem containing the
+// pipestance is low on space, or while there is not enough space for the
+// output expected from a stage.
+type diskGuard struct {
+	path     string
+	minFree  uint64
+	forecast map[string]uint64
+
+	// The available space at the start of the current round of job
+	// submission.
+	available uint64
+
+	// The expected output of stages started in the current round.
+	reserved uint64
+
+	// Whether any jobs were queued or running at the start of the round.
+	active bool
+
+	// Whether job submission is paused because available space is below
+	// minFree.
+	paused bool
+}
+
+func newDiskGuard(config *RuntimeOptions, psdir string) *diskGuard {
+	if config.MinFreeDisk == 0 && len(config.DiskForecast) == 0 {
+		return nil
+	}
+	return &diskGuard{
+		path:     psdir,
+		minFree:  config.MinFreeDisk,
+		forecast: config.DiskForecast,
+	}
+}
+
+// checkDiskSpace updates the available space at the start of a round of job
+// submission, pausing or resuming submission if it crossed the threshold.
+func (self *Pipestance) checkDiskSpace() {
+	g := self.node.top.disk
+	if g == nil {
+		return
+	}
+	bytes, _, _, err := GetAvailableSpace(g.path)
+	if err != nil || bytes == 0 {
+		// As with CheckMinimalSpace, don't trust the filesystem if it
+		// claims to be completely full.
+		g.available, g.paused = 0, false
+		return
+	}
+	g.available = bytes
+	g.reserved = 0
+	if bytes < g.minFree {
+		if !g.paused {
+			g.paused = true
+			msg := fmt.Sprintf(
+				"Job submission paused: %s has only %s available, "+
+					"below the minimum of %s.",
+				g.path, humanize.Bytes(bytes), humanize.Bytes(g.minFree))
+			util.PrintInfo("runtime", "%s", msg)
+			if len(self.node.forks) > 0 {
+				if err := self.node.forks[0].metadata.AppendAlarm(msg + "\n"); err != nil {
+					util.LogError(err, "runtime", "Could not write alarm.")
+				}
+			}
+		}
+		return
+	} else if g.paused {
+		g.paused = false
+		util.PrintInfo("runtime",
+			"Job submission resumed: %s has %s available.",
+			g.path, humanize.Bytes(bytes))
+	}
+	if len(g.forecast) > 0 {
+		g.active = false
+		for _, node := range self.node.getFrontierNodes() {
+			for _, fork := range node.forks {
+				if st := fork.getState(); st.IsRunning() || st.IsQueued() {
+					g.active = true
+					return
+				}
+			}
+		}
+	}
+}
+
+// holdForDisk returns true if the fork should not submit jobs yet, because
+// disk space is low or the output expected from the stage, based on a
+// previous run, would not fit.  Once a fork has been allowed to start, only
+// the minimum free space threshold can hold it back.
+func (self *Fork) holdForDisk() bool {
+	g := self.node.top.disk
+	if g == nil || g.available == 0 {
+		return false
+	}
+	if g.paused {
+		return true
+	}
+	if self.diskAdmitted {
+		return false
+	}
+	expected := g.forecast[self.node.top.relativeFqid(self.node)]
+	if expected > 0 && g.available < g.minFree+g.reserved+expected {
+		if g.active {
+			if !self.diskHeld {
+				self.diskHeld = true
+				var free uint64
+				if g.available > g.reserved {
+					free = g.available - g.reserved
+				}
+				msg := fmt.Sprintf(
+					"Holding back %s: expected to write %s, but only %s "+
+						"is available.",
+					self.fqname, humanize.Bytes(expected),
+					humanize.Bytes(free))
+				util.PrintInfo("runtime", "%s", msg)
+				if err := self.metadata.AppendAlarm(msg + "\n"); err != nil {
+					util.LogError(err, "runtime", "Could not write alarm.")
+				}
+			}
+			return true
+		}
+		// Nothing else is running, so waiting won't free any space.
+		util.LogInfo("runtime",
+			"Starting %s even though it is expected to write %s, "+
+				"because no other jobs are running.",
+			self.fqname, humanize.Bytes(expected))
+	}
+	g.reserved += expected
+	self.diskAdmitted = true
+	return false
+}
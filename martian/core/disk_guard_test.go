@@ -0,0 +1,107 @@
+// Copyright (c) 2020 10X Genomics, Inc. All rights reserved.
+
+package core
+
+import (
+	"context"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/martian-lang/martian/martian/util"
+)
+
+func TestReadDiskForecast(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(path.Join(dir, Perf.FileName()), []byte(`[
+	{"fqname": "ID.ps.PIPE", "type": "pipeline", "forks": [
+		{"fork_stats": {"total_bytes": 1000}}
+	]},
+	{"fqname": "ID.ps.PIPE.STAGE", "type": "stage", "forks": [
+		{"fork_stats": {"total_bytes": 10}},
+		{"fork_stats": {"total_bytes": 30}},
+		{"fork_stats": null}
+	]}
+]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	forecast, err := ReadDiskForecast(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(forecast) != 1 || forecast["PIPE.STAGE"] != 30 {
+		t.Errorf("forecast %v", forecast)
+	}
+}
+
+func TestDiskGuard(t *testing.T) {
+	util.SetPrintLogger(testLogger{t: t})
+	defer util.SetPrintLogger(&devNull)
+	psdir := path.Join(t.TempDir(), "disk")
+	if b, _, _, err := GetAvailableSpace(path.Dir(psdir)); err != nil || b == 0 {
+		t.Skip("cannot get available space")
+	}
+	rtOpts := DefaultRuntimeOptions()
+	rtOpts.VdrMode = VdrDisable
+	rtOpts.MinFreeDisk = 1 << 62
+	var runner testStageRunner
+	rt := newTestRuntime(&rtOpts, &runner)
+	pipestance, err := rt.InvokePipeline(readTestFixture(t, "disk_guard.mro"),
+		"disk.mro", "disk", psdir, []string{"testdata"}, "<none>", nil, nil)
+	if err != nil {
+		t.Fatal("Invoking pipeline:", err)
+	}
+	defer pipestance.Unlock()
+	ctx := context.Background()
+	pipestance.LoadMetadata(ctx)
+	for i := 0; i < 5; i++ {
+		if done, _ := loopBody(t, pipestance); done {
+			t.Fatal("pipestance finished while paused")
+		}
+	}
+	if ran := runner.jobs(); len(ran) != 0 {
+		t.Errorf("ran %v while paused", ran)
+	}
+	if b, err := pipestance.node.forks[0].metadata.readRawBytes(
+		AlarmFile); err != nil {
+		t.Error(err)
+	} else if !strings.Contains(string(b), "Job submission paused") {
+		t.Errorf("unexpected alarm %q", b)
+	}
+
+	// Hold back MAKE based on its forecast, so long as something else
+	// might free space.
+	disk := pipestance.node.top.disk
+	disk.minFree = 0
+	disk.forecast = map[string]uint64{"DISK.MAKE": 1 << 62}
+	pipestance.checkDiskSpace()
+	fork := pipestance.node.top.allNodes["ID.disk.DISK.MAKE"].forks[0]
+	disk.active = true
+	if !fork.holdForDisk() {
+		t.Error("expected MAKE to be held back")
+	}
+	disk.active = false
+	if fork.holdForDisk() {
+		t.Error("expected MAKE to run with nothing else running")
+	}
+	if disk.reserved != 1<<62 {
+		t.Errorf("reserved %d", disk.reserved)
+	}
+
+	for {
+		flushChannel(rt.LocalJobManager.Done())
+		if done, hadProgress := loopBody(t, pipestance); done {
+			break
+		} else if !hadProgress {
+			select {
+			case <-time.After(time.Second):
+			case <-rt.LocalJobManager.Done():
+			}
+		}
+	}
+	if ran := runner.jobs(); len(ran) != 2 {
+		t.Errorf("expected 2 jobs, ran %v", ran)
+	}
+}
@@ -0,0 +1,800 @@
+// Copyright (c) 2020 10X Genomics, Inc. All rights reserved.
+
+package core
+
+// Support for invocations written as YAML or TOML documents.
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/martian-lang/martian/martian/syntax"
+	"github.com/pelletier/go-toml/v2/unstable"
+	"gopkg.in/yaml.v3"
+)
+
+// DocumentFormat is the serialization format of an invocation document.
+type DocumentFormat string
+
+const (
+	JsonDocument DocumentFormat = "json"
+	YamlDocument DocumentFormat = "yaml"
+	TomlDocument DocumentFormat = "toml"
+)
+
+// DocumentFormatForPath returns the document format implied by the extension
+// of the given file name, or the empty string if it is not a recognized
+// document format.
+func DocumentFormatForPath(fname string) DocumentFormat {
+	switch strings.ToLower(filepath.Ext(fname)) {
+	case ".json":
+		return JsonDocument
+	case ".yaml", ".yml":
+		return YamlDocument
+	case ".toml":
+		return TomlDocument
+	}
+	return ""
+}
+
+// InvocationDocument is the equivalent of InvocationData, read from a YAML
+// or TOML document.  The document has the same keys as the json form:
+//
+//	call: PIPELINE_NAME
+//	mro_file: pipeline.mro
+//	args:
+//	  sample_id: "123"
+//	  reads:
+//	    - /path/to/reads.fastq
+//
+// Unlike json, these formats do not distinguish between, for example,
+// strings and other scalars in the way mro does, so argument values are
+// converted based on the declared types of the callable's parameters when
+// the call is built.
+type InvocationDocument struct {
+	Call      string       `json:"call"`
+	Args      MarshalerMap `json:"args"`
+	Include   string       `json:"mro_file,omitempty"`
+	SplitArgs []string     `json:"splitargs,omitempty"`
+}
+
+// DocumentError is an error in an invocation document, reported with the
+// position in the source document where it was found.
+type DocumentError struct {
+	FileName string
+	Line     int
+	Column   int
+	Msg      string
+}
+
+func (err *DocumentError) Error() string {
+	return fmt.Sprintf("%s:%d:%d: %s",
+		err.FileName, err.Line, err.Column, err.Msg)
+}
+
+// ParseInvocationDocument parses a YAML or TOML invocation document.  The
+// file name is only used for error messages.
+func ParseInvocationDocument(src []byte, fname string,
+	format DocumentFormat) (*InvocationDocument, error) {
+	var root *docNode
+	var err error
+	switch format {
+	case YamlDocument:
+		root, err = parseYamlDocument(src, fname)
+	case TomlDocument:
+		root, err = parseTomlDocument(src, fname)
+	default:
+		return nil, fmt.Errorf("unsupported invocation document format %q",
+			format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return root.invocation()
+}
+
+// BuildCallAst generates the call ast for the invocation.  Unlike
+// InvocationData.BuildCallAst, it is an error to give an argument which is
+// not a parameter of the callable.
+func (doc *InvocationDocument) BuildCallAst(mroPaths []string) (*syntax.Ast, error) {
+	callable, lookup, err := getInvocationCallable(doc.Call, doc.Include, mroPaths)
+	if err != nil {
+		return nil, err
+	}
+	if doc.Args == nil {
+		return nil, fmt.Errorf("no args given")
+	}
+	params := make(map[string]struct{}, len(callable.GetInParams().List))
+	for _, param := range callable.GetInParams().List {
+		params[param.GetId()] = struct{}{}
+	}
+	keys := make([]string, 0, len(doc.Args))
+	for k := range doc.Args {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if _, ok := params[k]; !ok {
+			if n, ok := doc.Args[k].(*docNode); ok {
+				return nil, n.errorf("%s has no parameter %q",
+					callable.GetId(), k)
+			}
+		}
+	}
+	return BuildCallAst(
+		doc.Call,
+		doc.Args,
+		doc.SplitArgs,
+		callable,
+		lookup,
+		mroPaths)
+}
+
+type docKind int
+
+const (
+	docNull docKind = iota
+	docBool
+	docInt
+	docFloat
+	docString
+	docArray
+	docTable
+)
+
+func (k docKind) String() string {
+	switch k {
+	case docNull:
+		return syntax.KindNull
+	case docBool:
+		return syntax.KindBool
+	case docInt:
+		return syntax.KindInt
+	case docFloat:
+		return syntax.KindFloat
+	case docString:
+		return syntax.KindString
+	case docArray:
+		return "array"
+	case docTable:
+		return syntax.KindMap
+	}
+	return "unknown"
+}
+
+// A docNode is a value from a YAML or TOML document, along with its
+// position in the document.
+type docNode struct {
+	kind docKind
+
+	// The value of a string, or the source text of another scalar.
+	text string
+	b    bool
+	i    int64
+	f    float64
+
+	elems []*docNode
+
+	// The keys of a table, in the order in which they were declared.
+	keys   []string
+	fields map[string]*docNode
+
+	file   string
+	line   int
+	column int
+}
+
+func (n *docNode) errorf(format string, args ...interface{}) error {
+	return &DocumentError{
+		FileName: n.file,
+		Line:     n.line,
+		Column:   n.column,
+		Msg:      fmt.Sprintf(format, args...),
+	}
+}
+
+func (n *docNode) set(key string, v *docNode) {
+	if n.fields == nil {
+		n.fields = make(map[string]*docNode)
+	}
+	n.keys = append(n.keys, key)
+	n.fields[key] = v
+}
+
+// MarshalJSON renders the value as json, without regard to type.
+func (n *docNode) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := n.encodeJson(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (n *docNode) encodeJson(buf *bytes.Buffer) error {
+	switch n.kind {
+	case docNull:
+		buf.WriteString(syntax.KindNull)
+	case docBool:
+		buf.WriteString(strconv.FormatBool(n.b))
+	case docInt:
+		buf.WriteString(strconv.FormatInt(n.i, 10))
+	case docFloat:
+		buf.WriteString(strconv.FormatFloat(n.f, 'g', -1, 64))
+	case docString:
+		b, err := json.Marshal(n.text)
+		if err != nil {
+			return err
+		}
+		buf.Write(b)
+	case docArray:
+		buf.WriteByte('[')
+		for i, e := range n.elems {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := e.encodeJson(buf); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	case docTable:
+		buf.WriteByte('{')
+		for i, k := range n.keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			b, err := json.Marshal(k)
+			if err != nil {
+				return err
+			}
+			buf.Write(b)
+			buf.WriteByte(':')
+			if err := n.fields[k].encodeJson(buf); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	}
+	return nil
+}
+
+// invocation converts the document root to an InvocationDocument.
+func (n *docNode) invocation() (*InvocationDocument, error) {
+	if n.kind != docTable {
+		return nil, n.errorf("expected a table of invocation data, got %v",
+			n.kind)
+	}
+	var doc InvocationDocument
+	for _, key := range n.keys {
+		v := n.fields[key]
+		switch key {
+		case "call":
+			if v.kind != docString {
+				return nil, v.errorf("expected a pipeline or stage name")
+			}
+			doc.Call = v.text
+		case "mro_file":
+			if v.kind != docString {
+				return nil, v.errorf("expected an mro file name")
+			}
+			doc.Include = v.text
+		case "args":
+			if v.kind != docTable {
+				return nil, v.errorf("expected a table of arguments, got %v",
+					v.kind)
+			}
+			doc.Args = make(MarshalerMap, len(v.keys))
+			for _, k := range v.keys {
+				doc.Args[k] = v.fields[k]
+			}
+		case "splitargs":
+			if v.kind != docArray {
+				return nil, v.errorf("expected an array of argument names")
+			}
+			for _, e := range v.elems {
+				if e.kind != docString {
+					return nil, e.errorf("expected an argument name")
+				}
+				doc.SplitArgs = append(doc.SplitArgs, e.text)
+			}
+		case "sweepargs":
+			return nil, v.errorf(
+				"sweep is no longer supported - migrate to map call instead")
+		default:
+			return nil, v.errorf("unknown invocation key %q", key)
+		}
+	}
+	if doc.Call == "" {
+		return nil, n.errorf("no pipeline or stage specified")
+	}
+	return &doc, nil
+}
+
+// toExp converts a document value to an mro expression, using the given
+// type to decide how scalars should be interpreted.  Values for which the
+// type is not known are converted based on the document's own type.
+func (n *docNode) toExp(tname syntax.TypeId,
+	lookup *syntax.TypeLookup) (syntax.ValExp, error) {
+	if n.kind == docNull {
+		return new(syntax.NullExp), nil
+	}
+	if tname.MapDim > 0 {
+		if n.kind != docTable {
+			return nil, n.typeError(tname)
+		}
+		return n.tableExp(syntax.TypeId{
+			Tname:    tname.Tname,
+			ArrayDim: tname.MapDim - 1,
+		}, nil, lookup)
+	}
+	if tname.ArrayDim > 0 {
+		if n.kind != docArray {
+			return nil, n.typeError(tname)
+		}
+		tname.ArrayDim--
+		return n.arrayExp(tname, lookup)
+	}
+	switch tname.Tname {
+	case "":
+		return n.inferExp(lookup)
+	case syntax.KindInt:
+		if n.kind == docInt {
+			return &syntax.IntExp{Value: n.i}, nil
+		}
+	case syntax.KindFloat:
+		switch n.kind {
+		case docInt:
+			return &syntax.FloatExp{Value: float64(n.i)}, nil
+		case docFloat:
+			return &syntax.FloatExp{Value: n.f}, nil
+		}
+	case syntax.KindBool:
+		if n.kind == docBool {
+			return &syntax.BoolExp{Value: n.b}, nil
+		}
+	case syntax.KindString, syntax.KindFile, syntax.KindPath:
+		return n.stringExp(tname)
+	case syntax.KindMap:
+		if n.kind == docTable {
+			return n.tableExp(syntax.TypeId{}, nil, lookup)
+		}
+	default:
+		var t syntax.Type
+		if lookup != nil {
+			t = lookup.Get(tname)
+		}
+		switch t := t.(type) {
+		case *syntax.StructType:
+			if n.kind == docTable {
+				return n.tableExp(syntax.TypeId{}, t, lookup)
+			}
+		case *syntax.UserType:
+			return n.stringExp(tname)
+		case nil:
+			// The type may be declared in an include which was not parsed.
+			return n.inferExp(lookup)
+		}
+	}
+	return nil, n.typeError(tname)
+}
+
+func (n *docNode) typeError(tname syntax.TypeId) error {
+	if n.kind == docString || n.kind == docArray || n.kind == docTable {
+		return n.errorf("expected %s, got %v", tname.String(), n.kind)
+	}
+	return n.errorf("expected %s, got %v %s", tname.String(), n.kind, n.text)
+}
+
+func (n *docNode) stringExp(tname syntax.TypeId) (syntax.ValExp, error) {
+	switch n.kind {
+	case docString, docBool, docInt, docFloat:
+		return &syntax.StringExp{Value: n.text}, nil
+	}
+	return nil, n.typeError(tname)
+}
+
+func (n *docNode) arrayExp(elem syntax.TypeId,
+	lookup *syntax.TypeLookup) (syntax.ValExp, error) {
+	exp := syntax.ArrayExp{
+		Value: make([]syntax.Exp, 0, len(n.elems)),
+	}
+	for _, e := range n.elems {
+		v, err := e.toExp(elem, lookup)
+		if err != nil {
+			return nil, err
+		}
+		exp.Value = append(exp.Value, v)
+	}
+	return &exp, nil
+}
+
+// tableExp converts a table.  If st is non-nil, the result is a struct
+// and the members are converted according to their types.  Otherwise all
+// values are converted with the elem type.
+func (n *docNode) tableExp(elem syntax.TypeId, st *syntax.StructType,
+	lookup *syntax.TypeLookup) (syntax.ValExp, error) {
+	exp := syntax.MapExp{
+		Kind:  syntax.KindMap,
+		Value: make(map[string]syntax.Exp, len(n.keys)),
+	}
+	if st != nil {
+		exp.Kind = syntax.KindStruct
+	}
+	for _, k := range n.keys {
+		t := elem
+		if st != nil {
+			t = syntax.TypeId{}
+			for _, m := range st.Members {
+				if m.Id == k {
+					t = m.Tname
+					break
+				}
+			}
+		}
+		v, err := n.fields[k].toExp(t, lookup)
+		if err != nil {
+			return nil, err
+		}
+		exp.Value[k] = v
+	}
+	return &exp, nil
+}
+
+func (n *docNode) inferExp(lookup *syntax.TypeLookup) (syntax.ValExp, error) {
+	switch n.kind {
+	case docNull:
+		return new(syntax.NullExp), nil
+	case docBool:
+		return &syntax.BoolExp{Value: n.b}, nil
+	case docInt:
+		return &syntax.IntExp{Value: n.i}, nil
+	case docFloat:
+		return &syntax.FloatExp{Value: n.f}, nil
+	case docString:
+		return &syntax.StringExp{Value: n.text}, nil
+	case docArray:
+		return n.arrayExp(syntax.TypeId{}, lookup)
+	case docTable:
+		return n.tableExp(syntax.TypeId{}, nil, lookup)
+	}
+	return nil, n.errorf("unexpected value")
+}
+
+// splitExp converts the value for a split argument, which must be a table
+// with a "split" key containing an array or map of values of the given type.
+func (n *docNode) splitExp(tname syntax.TypeId,
+	lookup *syntax.TypeLookup) (syntax.ValExp, error) {
+	if n.kind != docTable {
+		return nil, n.errorf("expected a table with a split key, got %v",
+			n.kind)
+	}
+	v := n.fields["split"]
+	if v == nil {
+		return nil, n.errorf("expected a table with a split key")
+	}
+	var exp syntax.ValExp
+	var err error
+	switch v.kind {
+	case docNull:
+		return new(syntax.NullExp), nil
+	case docArray:
+		exp, err = v.arrayExp(tname, lookup)
+	case docTable:
+		exp, err = v.tableExp(tname, nil, lookup)
+	default:
+		return nil, v.errorf("expected an array or table to split over, got %v",
+			v.kind)
+	}
+	if err != nil {
+		return nil, err
+	}
+	src, _ := exp.(syntax.MapCallSource)
+	return &syntax.SplitExp{
+		Value:  exp,
+		Source: src,
+	}, nil
+}
+
+func parseYamlDocument(src []byte, fname string) (*docNode, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(src, &root); err != nil {
+		return nil, fmt.Errorf("%s: %w", fname, err)
+	}
+	if root.Kind == 0 {
+		return nil, &DocumentError{
+			FileName: fname,
+			Line:     1,
+			Column:   1,
+			Msg:      "empty document",
+		}
+	}
+	return yamlToDoc(&root, fname)
+}
+
+func yamlToDoc(n *yaml.Node, fname string) (*docNode, error) {
+	node := &docNode{
+		file:   fname,
+		line:   n.Line,
+		column: n.Column,
+		text:   n.Value,
+	}
+	switch n.Kind {
+	case yaml.DocumentNode:
+		if len(n.Content) == 0 {
+			return nil, node.errorf("empty document")
+		}
+		return yamlToDoc(n.Content[0], fname)
+	case yaml.AliasNode:
+		return yamlToDoc(n.Alias, fname)
+	case yaml.SequenceNode:
+		node.kind = docArray
+		node.elems = make([]*docNode, 0, len(n.Content))
+		for _, c := range n.Content {
+			e, err := yamlToDoc(c, fname)
+			if err != nil {
+				return nil, err
+			}
+			node.elems = append(node.elems, e)
+		}
+	case yaml.MappingNode:
+		node.kind = docTable
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			k, v := n.Content[i], n.Content[i+1]
+			if k.Kind != yaml.ScalarNode {
+				return nil, (&docNode{
+					file:   fname,
+					line:   k.Line,
+					column: k.Column,
+				}).errorf("keys must be scalars")
+			} else if k.ShortTag() == "!!merge" {
+				return nil, (&docNode{
+					file:   fname,
+					line:   k.Line,
+					column: k.Column,
+				}).errorf("merge keys are not supported")
+			}
+			e, err := yamlToDoc(v, fname)
+			if err != nil {
+				return nil, err
+			}
+			node.set(k.Value, e)
+		}
+	case yaml.ScalarNode:
+		var err error
+		switch n.ShortTag() {
+		case "!!null":
+			node.kind = docNull
+		case "!!bool":
+			node.kind = docBool
+			err = n.Decode(&node.b)
+		case "!!int":
+			node.kind = docInt
+			err = n.Decode(&node.i)
+		case "!!float":
+			node.kind = docFloat
+			err = n.Decode(&node.f)
+			if err == nil && (math.IsInf(node.f, 0) || math.IsNaN(node.f)) {
+				return nil, node.errorf("%s cannot be represented in mro",
+					n.Value)
+			}
+		default:
+			// Including timestamps, which mro treats as strings.
+			node.kind = docString
+		}
+		if err != nil {
+			return nil, node.errorf("%v", err)
+		}
+	default:
+		return nil, node.errorf("unexpected yaml node")
+	}
+	return node, nil
+}
+
+// tomlDocParser builds a docNode tree from the expressions in a TOML
+// document.
+type tomlDocParser struct {
+	parser unstable.Parser
+	file   string
+}
+
+func parseTomlDocument(src []byte, fname string) (*docNode, error) {
+	p := tomlDocParser{file: fname}
+	p.parser.Reset(src)
+	root := &docNode{
+		kind:   docTable,
+		file:   fname,
+		line:   1,
+		column: 1,
+	}
+	current := root
+	for p.parser.NextExpression() {
+		expr := p.parser.Expression()
+		switch expr.Kind {
+		case unstable.KeyValue:
+			if err := p.keyValue(current, expr); err != nil {
+				return nil, err
+			}
+		case unstable.Table:
+			t, err := p.table(root, expr.Key(), false)
+			if err != nil {
+				return nil, err
+			}
+			current = t
+		case unstable.ArrayTable:
+			t, err := p.table(root, expr.Key(), true)
+			if err != nil {
+				return nil, err
+			}
+			current = t
+		}
+	}
+	if err := p.parser.Error(); err != nil {
+		var perr *unstable.ParserError
+		if errors.As(err, &perr) && len(perr.Highlight) > 0 {
+			return nil, p.node(p.parser.Range(perr.Highlight),
+				nil).errorf("%s", perr.Message)
+		}
+		return nil, fmt.Errorf("%s: %w", fname, err)
+	}
+	return root, nil
+}
+
+// node returns a new docNode positioned at the given range, or at the
+// position of the fallback node if the range is empty.
+func (p *tomlDocParser) node(r unstable.Range, fallback *docNode) *docNode {
+	if r.Length == 0 && fallback != nil {
+		return &docNode{
+			file:   p.file,
+			line:   fallback.line,
+			column: fallback.column,
+		}
+	}
+	pos := p.parser.Shape(r).Start
+	return &docNode{
+		file:   p.file,
+		line:   pos.Line,
+		column: pos.Column,
+	}
+}
+
+// walkKey finds or creates the tables for all but the last component of a
+// dotted key, returning the table to which the last component belongs.
+func (p *tomlDocParser) walkKey(table *docNode,
+	key unstable.Iterator) (*docNode, string, *docNode, error) {
+	var parts []*unstable.Node
+	for key.Next() {
+		parts = append(parts, key.Node())
+	}
+	for _, part := range parts[:len(parts)-1] {
+		k := string(part.Data)
+		next := table.fields[k]
+		if next == nil {
+			next = p.node(part.Raw, table)
+			next.kind = docTable
+			table.set(k, next)
+		} else if next.kind == docArray && len(next.elems) > 0 &&
+			next.elems[len(next.elems)-1].kind == docTable {
+			// Array of tables.
+			next = next.elems[len(next.elems)-1]
+		} else if next.kind != docTable {
+			return nil, "", nil, p.node(part.Raw, table).errorf(
+				"key %q is already defined as %v", k, next.kind)
+		}
+		table = next
+	}
+	last := parts[len(parts)-1]
+	return table, string(last.Data), p.node(last.Raw, table), nil
+}
+
+func (p *tomlDocParser) keyValue(table *docNode, expr *unstable.Node) error {
+	t, k, keyNode, err := p.walkKey(table, expr.Key())
+	if err != nil {
+		return err
+	}
+	if _, ok := t.fields[k]; ok {
+		return keyNode.errorf("key %q is already defined", k)
+	}
+	v, err := p.value(expr.Value(), keyNode)
+	if err != nil {
+		return err
+	}
+	t.set(k, v)
+	return nil
+}
+
+func (p *tomlDocParser) table(root *docNode, key unstable.Iterator,
+	array bool) (*docNode, error) {
+	t, k, keyNode, err := p.walkKey(root, key)
+	if err != nil {
+		return nil, err
+	}
+	existing := t.fields[k]
+	if array {
+		if existing == nil {
+			existing = keyNode
+			existing.kind = docArray
+			t.set(k, existing)
+		} else if existing.kind != docArray {
+			return nil, keyNode.errorf(
+				"key %q is already defined as %v", k, existing.kind)
+		}
+		elem := &docNode{
+			kind:   docTable,
+			file:   p.file,
+			line:   keyNode.line,
+			column: keyNode.column,
+		}
+		existing.elems = append(existing.elems, elem)
+		return elem, nil
+	}
+	if existing == nil {
+		existing = keyNode
+		existing.kind = docTable
+		t.set(k, existing)
+	} else if existing.kind != docTable {
+		return nil, keyNode.errorf(
+			"key %q is already defined as %v", k, existing.kind)
+	}
+	return existing, nil
+}
+
+func (p *tomlDocParser) value(v *unstable.Node, key *docNode) (*docNode, error) {
+	node := p.node(v.Raw, key)
+	node.text = string(v.Data)
+	switch v.Kind {
+	case unstable.String:
+		node.kind = docString
+	case unstable.Bool:
+		node.kind = docBool
+		node.b = node.text == "true"
+	case unstable.Integer:
+		node.kind = docInt
+		i, err := strconv.ParseInt(node.text, 0, 64)
+		if err != nil {
+			return nil, node.errorf("invalid integer %s: %v", node.text, err)
+		}
+		node.i = i
+	case unstable.Float:
+		node.kind = docFloat
+		f, err := strconv.ParseFloat(strings.ReplaceAll(node.text, "_", ""), 64)
+		if err != nil {
+			return nil, node.errorf("invalid float %s: %v", node.text, err)
+		} else if math.IsInf(f, 0) || math.IsNaN(f) {
+			return nil, node.errorf("%s cannot be represented in mro",
+				node.text)
+		}
+		node.f = f
+	case unstable.LocalDate, unstable.LocalTime,
+		unstable.LocalDateTime, unstable.DateTime:
+		// mro has no date type.
+		node.kind = docString
+	case unstable.Array:
+		node.kind = docArray
+		it := v.Children()
+		for it.Next() {
+			e, err := p.value(it.Node(), node)
+			if err != nil {
+				return nil, err
+			}
+			node.elems = append(node.elems, e)
+		}
+	case unstable.InlineTable:
+		node.kind = docTable
+		it := v.Children()
+		for it.Next() {
+			if err := p.keyValue(node, it.Node()); err != nil {
+				return nil, err
+			}
+		}
+	default:
+		return nil, node.errorf("unexpected toml value %v", v.Kind)
+	}
+	return node, nil
+}
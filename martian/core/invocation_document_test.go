@@ -0,0 +1,163 @@
+// Copyright (c) 2020 10X Genomics, Inc. All rights reserved.
+
+package core
+
+import (
+	"strings"
+	"testing"
+)
+
+const expectedDocumentCall = `@include "testdata/invocation_document.mro"
+
+map call DOCUMENT_INPUTS(
+    name    = "123",
+    count   = 4,
+    scale   = 2,
+    flag    = true,
+    samples = [
+        {
+            id:     "s1",
+            reads: [
+                "r1.fastq",
+                "r2.fastq",
+            ],
+            weight: 1,
+        },
+        {
+            id:     "2021-01-02",
+            reads:  [],
+            weight: 0.5,
+        },
+    ],
+    counts  = {
+        "a": 1,
+        "b": 2,
+    },
+    extra   = {
+        "nested": {
+            "x": [
+                1,
+                "y",
+            ],
+        },
+    },
+    chunk   = split [
+        1,
+        2,
+    ],
+)
+`
+
+func checkDocumentCall(t *testing.T, src string, format DocumentFormat) {
+	t.Helper()
+	doc, err := ParseInvocationDocument([]byte(src), "test", format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ast, err := doc.BuildCallAst([]string{""})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := ast.Format(); s != expectedDocumentCall {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expectedDocumentCall, s)
+	}
+}
+
+func TestYamlInvocation(t *testing.T) {
+	checkDocumentCall(t, `
+call: DOCUMENT_INPUTS
+mro_file: testdata/invocation_document.mro
+args:
+  name: 123
+  count: 4
+  scale: 2
+  flag: true
+  samples:
+    - id: s1
+      reads: [r1.fastq, r2.fastq]
+      weight: 1
+    - id: 2021-01-02
+      reads: []
+      weight: 0.5
+  counts: {a: 1, b: 2}
+  extra:
+    nested:
+      x: [1, y]
+  chunk:
+    split: [1, 2]
+splitargs: [chunk]
+`, YamlDocument)
+}
+
+func TestTomlInvocation(t *testing.T) {
+	checkDocumentCall(t, `
+call = "DOCUMENT_INPUTS"
+mro_file = "testdata/invocation_document.mro"
+splitargs = ["chunk"]
+
+[args]
+name = 123
+count = 4
+scale = 2
+flag = true
+chunk.split = [1, 2]
+extra.nested.x = [1, "y"]
+
+[args.counts]
+a = 1
+b = 0x2
+
+[[args.samples]]
+id = "s1"
+reads = ["r1.fastq", "r2.fastq"]
+weight = 1
+
+[[args.samples]]
+id = 2021-01-02
+reads = []
+weight = 0.5
+`, TomlDocument)
+}
+
+func TestInvocationDocumentErrors(t *testing.T) {
+	check := func(t *testing.T, src string, format DocumentFormat, expect string) {
+		t.Helper()
+		doc, err := ParseInvocationDocument([]byte(src), "test", format)
+		if err == nil {
+			_, err = doc.BuildCallAst([]string{""})
+		}
+		if err == nil {
+			t.Errorf("expected error %q", expect)
+		} else if !strings.Contains(err.Error(), expect) {
+			t.Errorf("expected error %q, got %q", expect, err.Error())
+		}
+	}
+	const yamlHeader = `call: DOCUMENT_INPUTS
+mro_file: testdata/invocation_document.mro
+args:
+`
+	check(t, yamlHeader+"  count: 1.5\n", YamlDocument,
+		"test:4:10: expected int, got float 1.5")
+	check(t, yamlHeader+"  samples:\n    - weight: abc\n", YamlDocument,
+		"test:5:15: expected float, got string")
+	check(t, yamlHeader+"  counts: {a: [1]}\n", YamlDocument,
+		"test:4:15: expected int, got array")
+	check(t, yamlHeader+"  samples: [{id: [x]}]\n", YamlDocument,
+		"test:4:18: expected string, got array")
+	check(t, yamlHeader+"  count: 1\n  cont: 2\n", YamlDocument,
+		"test:5:9: DOCUMENT_INPUTS has no parameter \"cont\"")
+	check(t, "call: X\nargs: {}\nsweep: []\n", YamlDocument,
+		"test:3:8: unknown invocation key \"sweep\"")
+	const tomlHeader = `call = "DOCUMENT_INPUTS"
+mro_file = "testdata/invocation_document.mro"
+[args]
+`
+	check(t, tomlHeader+"flag = 1\n", TomlDocument,
+		"test:4:8: expected bool, got int 1")
+	check(t, tomlHeader+"[[args.samples]]\nweight = \"heavy\"\n", TomlDocument,
+		"test:5:10: expected float, got string")
+	check(t, tomlHeader+"count = 1\ncount = 2\n", TomlDocument,
+		"test:5:1: key \"count\" is already defined")
+	check(t, tomlHeader+"count = \n", TomlDocument,
+		"test:4:")
+}
@@ -0,0 +1,203 @@
+// Copyright (c) 2020 10X Genomics, Inc. All rights reserved.
+
+package core
+
+// Job templates using go text/template syntax.
+
+import (
+	"context"
+	"math"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/martian-lang/martian/martian/syntax"
+)
+
+// JobTemplateData is the data model for job templates for job modes with
+// go_template set in jobmanagers/config.json.
+//
+// Such templates are evaluated with go's text/template package, so for
+// example
+//
+//	{{if gt .MemGB 64}}#SBATCH -p highmem{{end}}
+//
+// adds a line only for jobs requesting more than 64 GB.  The legacy
+// __MRO_*__ placeholders are substituted in the output afterwards, so they
+// may still be used as well.
+//
+// Job modes whose templates use .Threads or .MemGB to reserve resources
+// should also set template_threads or template_mem, so that mrp knows the
+// reservations are enforced.
+//
+// In addition to the standard template functions, templates may use
+//
+//	quote:    quote a string for use in a shell script.
+//	getenv:   get the value of an environment variable in mrp.
+//	ceil:     round a number up to an integer.
+//	mul:      multiply two integers, e.g. {{mul .MemGB 1024}}.
+//	join:     join a list of strings with a separator.
+//	contains: check whether a string contains a substring.
+type JobTemplateData struct {
+	// The job name, e.g. ID.psid.PIPELINE.STAGE.fork0.chnk0.main
+	JobName string
+
+	// The fully-qualified name of the stage, e.g. ID.psid.PIPELINE.STAGE
+	Stage string
+
+	// The fork ID, e.g. fork0.
+	Fork string
+
+	// The chunk index, or -1 for split and join jobs.
+	Chunk int
+
+	// The phase being run: split, main, or join.
+	Phase string
+
+	// The job mode, e.g. sge or slurm.
+	JobMode string
+
+	// The resources for the job, after applying overrides and the
+	// defaults from the job manager settings.
+	Resources JobResources
+
+	// The number of threads to reserve.
+	Threads int
+
+	// The amount of memory to reserve, rounded up.
+	MemGB  int
+	MemMB  int
+	VMemGB int
+	VMemMB int
+
+	// The amount of memory to reserve per thread, rounded up.
+	MemGBPerThread  int
+	VMemGBPerThread int
+
+	// The resources option from the job mode configuration, with the
+	// resources mapped from Resources.Special through MRO_JOBRESOURCES,
+	// or empty if there is no mapping for the job.
+	SpecialResources string
+
+	// The value of MRO_ACCOUNT.
+	Account string
+
+	// The shell-quoted paths for the job's standard output and error, and
+	// the directory to run the job in.
+	Stdout  string
+	Stderr  string
+	WorkDir string
+
+	// The command to run, with environment variable settings, formatted
+	// for a shell script.
+	Cmd string
+
+	// Environment variables set for the job.
+	Env map[string]string
+
+	// The job manager settings from jobmanagers/config.json.
+	Settings *JobManagerSettings
+}
+
+var jobTemplateFuncs = template.FuncMap{
+	"quote":  shellSafeQuote,
+	"getenv": os.Getenv,
+	"ceil": func(f float64) int {
+		return int(math.Ceil(f))
+	},
+	"mul": func(a, b int) int {
+		return a * b
+	},
+	"join":     strings.Join,
+	"contains": strings.Contains,
+}
+
+func parseJobTemplate(name, text string) (*template.Template, error) {
+	return template.New(name).Funcs(jobTemplateFuncs).Option(
+		"missingkey=error").Parse(text)
+}
+
+// Matches a job name, separating the stage name, fork ID, and chunk index.
+var jobNameRe = regexp.MustCompile(`^(.*)\.(fork[^.]+)(?:\.chnk(\d+))?$`)
+
+// newJobTemplateData fills in the identifying fields of the template data
+// for the job with the given name.
+func newJobTemplateData(fqname, shellName, jobMode string) JobTemplateData {
+	data := JobTemplateData{
+		JobName: fqname + "." + shellName,
+		Stage:   fqname,
+		Chunk:   -1,
+		Phase:   shellName,
+		JobMode: jobMode,
+		Account: os.Getenv("MRO_ACCOUNT"),
+	}
+	if m := jobNameRe.FindStringSubmatch(fqname); m != nil {
+		data.Stage, data.Fork = m[1], m[2]
+		if m[3] != "" {
+			data.Chunk, _ = strconv.Atoi(m[3])
+		}
+	}
+	return data
+}
+
+// RenderJobScript returns the job script which would be submitted for the
+// first chunk of the given stage in the pipeline invoked by src, for
+// debugging job templates.  The stage may be given by its call ID or by a
+// suffix of its fully-qualified name.  Resources from the stage definition
+// and overrides are applied, but not those set by the stage's split.  The
+// uniquified chunk directory name differs from the one a real job would use.
+func (self *Runtime) RenderJobScript(src, srcPath, psid, pipestancePath string,
+	mroPaths []string, stage string) (string, error) {
+	remote, ok := self.JobManager.(*RemoteJobManager)
+	if !ok {
+		return "", &RuntimeError{
+			Msg: "job scripts are only used in cluster job modes",
+		}
+	}
+	_, _, pipestance, err := self.instantiatePipeline([]byte(src), srcPath,
+		psid, pipestancePath, mroPaths, "", nil, true, true,
+		context.Background())
+	if err != nil {
+		return "", err
+	}
+	top := pipestance.node.top
+	found := findStageNode(pipestance.node.call, top.fqname+".", stage)
+	if found == nil {
+		return "", &RuntimeError{Msg: "stage " + stage + " not found"}
+	}
+	node := top.allNodes[found.GetFqid()]
+	res := node.setChunkJobReqs(nil)
+
+	// The metadata for the first chunk, as NewChunk would create it.
+	fqname := node.GetFQName() + "." + defaultFork + ".chnk0"
+	metadata := newMetadataWithJournalPath(fqname,
+		strings.TrimPrefix(fqname, top.fqname+"."),
+		path.Join(node.path, defaultFork, "chnk0"), top.journalPath)
+	if !disableUniquification {
+		// As Metadata.uniquify would, but without creating directories.
+		metadata.uniquifier = makeUniquifier()
+		metadata.path = metadata.finalPath + "-u" + metadata.uniquifier
+		metadata.curFilesPath = path.Join(metadata.path, "files")
+	}
+	// Sets MRO_UUID in the job environment, for an existing pipestance.
+	pipestance.GetUuid()
+	shellCmd, argv, envs := node.jobCommand("main", metadata)
+	return remote.jobScript(shellCmd, argv, envs, metadata, &res,
+		fqname, "main")
+}
+
+func findStageNode(node syntax.CallGraphNode, prefix, stage string) syntax.CallGraphNode {
+	if node.Kind() == syntax.KindStage &&
+		replayMatches(stage, strings.TrimPrefix(node.GetFqid(), prefix)) {
+		return node
+	}
+	for _, child := range node.GetChildren() {
+		if found := findStageNode(child, prefix, stage); found != nil {
+			return found
+		}
+	}
+	return nil
+}
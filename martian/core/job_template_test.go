@@ -0,0 +1,184 @@
+// Copyright (c) 2020 10X Genomics, Inc. All rights reserved.
+
+package core
+
+import (
+	"context"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/martian-lang/martian/martian/util"
+)
+
+func TestNewJobTemplateData(t *testing.T) {
+	check := func(t *testing.T, fqname, stage, fork string, chunk int) {
+		t.Helper()
+		data := newJobTemplateData(fqname, "main", "sge")
+		if data.Stage != stage || data.Fork != fork || data.Chunk != chunk {
+			t.Errorf("%s: got stage %q fork %q chunk %d",
+				fqname, data.Stage, data.Fork, data.Chunk)
+		}
+		if data.JobName != fqname+".main" {
+			t.Errorf("job name %q", data.JobName)
+		}
+	}
+	check(t, "ID.ps.PIPE.STAGE.fork0.chnk12", "ID.ps.PIPE.STAGE", "fork0", 12)
+	check(t, "ID.ps.PIPE.STAGE.fork0", "ID.ps.PIPE.STAGE", "fork0", -1)
+	check(t, "ID.ps.PIPE.STAGE.fork_a-b", "ID.ps.PIPE.STAGE", "fork_a-b", -1)
+}
+
+func TestGoJobTemplate(t *testing.T) {
+	const src = `#!/bin/sh
+#$ -N __MRO_JOB_NAME__
+#$ -pe threads {{.Threads}}
+{{- if gt .MemGB 64}}
+#$ -q highmem
+{{- end}}
+{{- if eq .Phase "main"}}
+#$ -l chunk={{.Chunk}}
+{{- end}}
+#$ -l mem={{mul .MemGB 1024}}M
+#$ -o {{.Stdout}}
+{{.Cmd}}
+`
+	tmpl, err := parseJobTemplate("test.template", src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jm := newRemoteJobManager("sge", 0, 0, 0, "", jobManagerConfig{
+		jobSettings: &JobManagerSettings{
+			ThreadsPerJob: 1,
+			MemGBPerJob:   4,
+		},
+		jobTemplate:      src,
+		goTemplate:       tmpl,
+		threadingEnabled: true,
+	}, false)
+	md := NewMetadata("ID.ps.PIPE.STAGE.fork0.chnk3", "/ps/PIPE/STAGE/fork0/chnk3")
+	render := func(t *testing.T, memGB float64, shellName string) string {
+		t.Helper()
+		script, err := jm.jobScript("/bin/stage", []string{"arg"}, nil, md,
+			&JobResources{Threads: 2, MemGB: memGB}, md.fqname, shellName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return script
+	}
+	script := render(t, 8, "main")
+	for _, expect := range []string{
+		"#$ -N ID.ps.PIPE.STAGE.fork0.chnk3.main\n",
+		"#$ -pe threads 2\n",
+		"#$ -l chunk=3\n",
+		"#$ -l mem=8192M\n",
+		"#$ -o \"/ps/PIPE/STAGE/fork0/chnk3/_stdout\"\n",
+		"\"/bin/stage\" \\\n  \"arg\"\n",
+	} {
+		if !strings.Contains(script, expect) {
+			t.Errorf("expected %q in\n%s", expect, script)
+		}
+	}
+	if strings.Contains(script, "highmem") {
+		t.Errorf("unexpected highmem queue in\n%s", script)
+	}
+	if script := render(t, 100, "join"); !strings.Contains(script, "-q highmem") {
+		t.Errorf("expected highmem queue in\n%s", script)
+	} else if strings.Contains(script, "chunk=") {
+		t.Errorf("unexpected chunk line in\n%s", script)
+	}
+}
+
+func TestRenderJobScript(t *testing.T) {
+	util.SetPrintLogger(&devNull)
+	rtOpts := DefaultRuntimeOptions()
+	rtOpts.VdrMode = VdrDisable
+	settings := &JobManagerSettings{
+		ThreadsPerJob: 1,
+		MemGBPerJob:   1,
+	}
+	rt := rtOpts.NewRuntimeWithJobConfig(&JobManagerJson{JobSettings: settings})
+	rt.JobManager = newRemoteJobManager("test", 0, 0, 0, "", jobManagerConfig{
+		jobSettings: settings,
+		jobTemplate: `#!/bin/sh
+#TEST -N __MRO_JOB_NAME__
+#TEST -pe threads __MRO_THREADS__
+#TEST -l mem=__MRO_MEM_GB__G
+#TEST -o __MRO_STDOUT__
+cd __MRO_JOB_WORKDIR__
+__MRO_CMD__
+`,
+		jobCmd:           "true",
+		threadingEnabled: true,
+	}, false)
+
+	// Submit the first job for real, and compare the script it wrote.
+	src := readTestFixture(t, "job_template.mro")
+	psdir := path.Join(t.TempDir(), "render")
+	pipestance, err := rt.InvokePipeline(src, "render.mro", "render", psdir,
+		[]string{"testdata"}, "<none>", nil, nil)
+	if err != nil {
+		t.Fatal("Invoking pipeline:", err)
+	}
+	defer pipestance.Unlock()
+	ctx := context.Background()
+	pipestance.LoadMetadata(ctx)
+	jobscript := path.Join(psdir, "RENDER", "MAKE", defaultFork, "chnk0",
+		"_jobscript")
+	for i := 0; i < 100; i++ {
+		if _, err := os.Stat(jobscript); err == nil {
+			break
+		}
+		pipestance.RefreshState(ctx)
+		pipestance.StepNodes(ctx)
+		time.Sleep(10 * time.Millisecond)
+	}
+	expect, err := os.ReadFile(jobscript)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	script, err := rt.RenderJobScript(src, "render.mro", "render", psdir,
+		[]string{"testdata"}, "MAKE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The suffix of uniquified chunk directories depends on the time.
+	uniquifier := regexp.MustCompile(`([-.])u[0-9a-f]{10}\b`)
+	script = uniquifier.ReplaceAllString(script, "${1}uXXX")
+	expect = uniquifier.ReplaceAll(expect, []byte("${1}uXXX"))
+	if script != string(expect) {
+		t.Errorf("rendered script\n%s\ndiffers from submitted script\n%s",
+			script, expect)
+	}
+	if _, err := rt.RenderJobScript(src, "render.mro", "render", psdir,
+		[]string{"testdata"}, "NOT_A_STAGE"); err == nil {
+		t.Error("expected an error for an unknown stage")
+	}
+}
+
+func TestJobModeReserves(t *testing.T) {
+	const tmpl = "#$ -pe threads {{.Threads}}\n#$ -l mem={{.MemGB}}G\n"
+	mode := JobModeJson{GoTemplate: true}
+	if mode.reservesThreads(tmpl) || mode.reservesMem(tmpl) {
+		t.Error("reservations should not be inferred from the template text")
+	}
+	mode.TemplateThreads = true
+	if !mode.reservesThreads(tmpl) {
+		t.Error("expected template_threads to enable threading")
+	} else if mode.reservesMem(tmpl) {
+		t.Error("template_threads should not enable memory reservations")
+	}
+	mode.TemplateMem = true
+	if !mode.reservesMem(tmpl) {
+		t.Error("expected template_mem to enable memory reservations")
+	}
+	legacy := JobModeJson{TemplateThreads: true}
+	if legacy.reservesThreads("#$ -l mem=__MRO_MEM_GB__G\n") {
+		t.Error("template_threads should only apply to go templates")
+	} else if !legacy.reservesMem("#$ -l mem=__MRO_MEM_GB__G\n") {
+		t.Error("expected __MRO_MEM_GB__ to enable memory reservations")
+	}
+}
@@ -13,6 +13,7 @@ import (
 	"path"
 	"strconv"
 	"strings"
+	"text/template"
 	"time"
 
 	"github.com/martian-lang/martian/martian/util"
@@ -91,6 +92,32 @@ type JobModeJson struct {
 	JobEnvs         []*JobModeEnv `json:"envs"`
 	QueueQueryGrace int           `json:"queue_query_grace_secs,omitempty"`
 	AlwaysVmem      bool          `json:"mem_is_vmem,omitempty"`
+
+	// If true, the job template is evaluated with go's text/template
+	// package.  See JobTemplateData.
+	GoTemplate bool `json:"go_template,omitempty"`
+
+	// For job modes with go_template set, whether the template reserves
+	// threads or memory for each job.  Legacy templates are instead checked
+	// for the __MRO_THREADS__ and __MRO_MEM_GB__ or __MRO_MEM_MB__
+	// placeholders.
+	TemplateThreads bool `json:"template_threads,omitempty"`
+	TemplateMem     bool `json:"template_mem,omitempty"`
+}
+
+// reservesThreads returns true if the given job template reserves threads
+// for each job.
+func (self *JobModeJson) reservesThreads(jobTemplate string) bool {
+	return strings.Contains(jobTemplate, "__MRO_THREADS__") ||
+		self.GoTemplate && self.TemplateThreads
+}
+
+// reservesMem returns true if the given job template reserves memory for
+// each job.
+func (self *JobModeJson) reservesMem(jobTemplate string) bool {
+	return strings.Contains(jobTemplate, "__MRO_MEM_GB") ||
+		strings.Contains(jobTemplate, "__MRO_MEM_MB") ||
+		self.GoTemplate && self.TemplateMem
 }
 
 type JobManagerSettings struct {
@@ -104,6 +131,7 @@ type JobManagerJson struct {
 	JobSettings *JobManagerSettings            `json:"settings"`
 	JobModes    map[string]*JobModeJson        `json:"jobmodes"`
 	ProfileMode map[ProfileMode]*ProfileConfig `json:"profiles"`
+	Publish     *PublishConfig                 `json:"publish,omitempty"`
 }
 
 type jobManagerConfig struct {
@@ -111,6 +139,7 @@ type jobManagerConfig struct {
 	queueQueryCmd    string
 	jobResourcesOpt  string
 	jobTemplate      string
+	goTemplate       *template.Template
 	jobCmd           string
 	jobCmdArgs       []string
 	queueQueryGrace  time.Duration
@@ -235,10 +264,20 @@ To set up a job manager template, please follow instructions in %s.`,
 	}
 	util.LogInfo("jobmngr", "Job template = %s", jobTemplateFile)
 	jobTemplate := string(b)
+	var goTemplate *template.Template
+	if jobModeJson.GoTemplate {
+		goTemplate, err = parseJobTemplate(path.Base(jobTemplateFile), jobTemplate)
+		if err != nil {
+			util.PrintError(err, "jobmngr",
+				"Job manager template file %s could not be parsed.",
+				jobTemplateFile)
+			os.Exit(1)
+		}
+	}
 
 	// Check if template includes threading.
 	jobThreadingEnabled := false
-	if strings.Contains(jobTemplate, "__MRO_THREADS__") {
+	if jobModeJson.reservesThreads(jobTemplate) {
 		jobThreadingEnabled = true
 	} else if memGBPerCore > 0 {
 		util.Println(`
@@ -250,9 +289,7 @@ Please consult the documentation for details.
 	}
 
 	// Check if memory reservations or mempercore are enabled
-	if !strings.Contains(jobTemplate, "__MRO_MEM_GB") &&
-		!strings.Contains(jobTemplate, "__MRO_MEM_MB") &&
-		memGBPerCore <= 0 {
+	if !jobModeJson.reservesMem(jobTemplate) && memGBPerCore <= 0 {
 		util.Println(`
 CLUSTER MODE WARNING:
    Memory reservations are not enabled in your job template.
@@ -294,6 +331,7 @@ Please consult the documentation for details.
 		queueQueryGrace:  queueGrace,
 		jobResourcesOpt:  jobResourcesOpt,
 		jobTemplate:      jobTemplate,
+		goTemplate:       goTemplate,
 		threadingEnabled: jobThreadingEnabled,
 	}
 }
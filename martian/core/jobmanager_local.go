@@ -12,6 +12,7 @@ import (
 	"runtime/trace"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -59,8 +60,22 @@ type LocalJobManager struct {
 	debug       bool
 	limitLoad   bool
 	highMem     ObservedMemory
+	runner      LocalJobRunner
+	chaos       *chaosMonkey
+
+	// Tracks jobs which have been started and have not yet exited.
+	running sync.WaitGroup
 }
 
+// A LocalJobRunner runs a job in-process instead of executing the stage code.
+//
+// The runner is given the fully-qualified name of the job, the phase being
+// run (split, main, or join), and the job's metadata, from which it can read
+// the job's args and to which it should write the job's outs or stage defs.
+// If the runner returns nil, the job is marked complete.  Otherwise, the
+// error is written to the job's _errors file.
+type LocalJobRunner func(fqname, shellName string, metadata *Metadata) error
+
 func NewLocalJobManager(userMaxCores int,
 	userMaxMemGB, userMaxVMemGB int,
 	debug bool, limitLoadavg bool, clusterMode bool,
@@ -498,7 +513,9 @@ func (self *LocalJobManager) queueCheckGrace() time.Duration {
 func (self *LocalJobManager) Enqueue(shellCmd string, argv []string,
 	envs map[string]string, metadata *Metadata, resRequest *JobResources,
 	fqname string, retries int, waitTime int, localpreflight bool) {
+	self.running.Add(1)
 	enc := func() {
+		defer self.running.Done()
 		r := trace.StartRegion(context.Background(), "queueLocal")
 		defer r.End()
 
@@ -646,7 +663,8 @@ func (self *LocalJobManager) Enqueue(shellCmd string, argv []string,
 				util.LogInfo("jobmngr", "%d goroutines", runtime.NumGoroutine())
 			}
 		}
-		err := executeLocal(cmd, stdoutPath, stderrPath, localpreflight, metadata)
+		err := executeLocal(cmd, stdoutPath, stderrPath, localpreflight, metadata,
+			self.chaos.jobStarted(fqname))
 		// CentOS < 5.5 workaround
 		if err != nil {
 			if strings.Contains(err.Error(), exitCodeString) {
@@ -689,8 +707,10 @@ func (self *LocalJobManager) Enqueue(shellCmd string, argv []string,
 	}
 }
 
+// executeLocal runs the command and waits for it to complete.  If started is
+// not nil, it is called with the process once the command has started.
 func executeLocal(cmd *exec.Cmd, stdoutPath, stderrPath string,
-	localpreflight bool, metadata *Metadata) error {
+	localpreflight bool, metadata *Metadata, started func(*os.Process)) error {
 	if err := func(cmd *exec.Cmd, stdoutPath, stderrPath string,
 		localpreflight bool, metadata *Metadata) error {
 		// Set up _stdout and _stderr for the job.
@@ -726,6 +746,9 @@ func executeLocal(cmd *exec.Cmd, stdoutPath, stderrPath string,
 		defer util.ExitCriticalSection()
 		err = cmd.Start()
 		if err == nil {
+			if started != nil {
+				started(cmd.Process)
+			}
 			return metadata.remove(QueuedLocally)
 		}
 		return err
@@ -741,6 +764,12 @@ func (self *LocalJobManager) Done() <-chan struct{} {
 	return self.jobDone
 }
 
+// Wait waits for all jobs which the job manager has started to exit.  It
+// should only be called once no more jobs will be submitted.
+func (self *LocalJobManager) Wait() {
+	self.running.Wait()
+}
+
 func (self *LocalJobManager) GetMaxCores() int {
 	return self.maxCores
 }
@@ -753,12 +782,47 @@ func (self *LocalJobManager) GetMaxVMemGB() int {
 	return int(self.maxVmemMB / 1024)
 }
 
+// SetJobRunner causes jobs to be run in-process by the given function,
+// rather than by executing the stage code.  This is intended for tests
+// which mock out stage implementations.  Resource limits are not enforced
+// for jobs run this way.
+func (self *LocalJobManager) SetJobRunner(runner LocalJobRunner) {
+	self.runner = runner
+}
+
 func (self *LocalJobManager) execJob(shellCmd string, argv []string,
 	envs map[string]string, metadata *Metadata, resRequest *JobResources,
 	fqname string, shellName string, preflight bool) {
+	if self.runner != nil {
+		self.running.Add(1)
+		go func() {
+			defer self.running.Done()
+			self.runInProcess(metadata, fqname, shellName)
+		}()
+		return
+	}
 	self.Enqueue(shellCmd, argv, envs, metadata, resRequest, fqname, 0, 0, preflight)
 }
 
+func (self *LocalJobManager) runInProcess(metadata *Metadata,
+	fqname, shellName string) {
+	if err := metadata.remove(QueuedLocally); err != nil {
+		util.LogError(err, "jobmngr", "Error removing queue marker for %s.",
+			fqname)
+	}
+	if err := self.runner(fqname, shellName, metadata); err != nil {
+		metadata.WriteErrorString(err.Error())
+	} else if err := metadata.WriteTime(CompleteFile); err != nil {
+		util.LogError(err, "jobmngr", "Error writing completion file for %s.",
+			fqname)
+	}
+	// Notify
+	select {
+	case self.jobDone <- struct{}{}:
+	default:
+	}
+}
+
 func (self *LocalJobManager) endJob(*Metadata) {}
 
 // Reset the max jobs semaphore.
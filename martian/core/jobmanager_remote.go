@@ -30,17 +30,39 @@ type RemoteJobManager struct {
 	jobFreqMillis        int
 	queueMutex           sync.Mutex
 	debug                bool
+
+	// If set, jobs are submitted to and queried from this scheduler instead
+	// of by running the configured commands.
+	scheduler jobScheduler
+}
+
+// A jobScheduler accepts job scripts and reports which jobs are still queued
+// or running, in place of a cluster's submit and queue query commands.
+type jobScheduler interface {
+	// submit queues a job script to be run in the given directory, and
+	// returns the output of the submission, which is the job id.
+	submit(jobscript, dir string) ([]byte, error)
+
+	// query returns the subset of the given job ids which are still queued
+	// or running.
+	query(ids []string) []string
 }
 
 func NewRemoteJobManager(jobMode string, memGBPerCore int, maxJobs int, jobFreqMillis int,
 	jobResources string, config *JobManagerJson, debug bool) *RemoteJobManager {
+	return newRemoteJobManager(jobMode, memGBPerCore, maxJobs, jobFreqMillis,
+		jobResources, verifyJobManager(jobMode, config, memGBPerCore), debug)
+}
+
+func newRemoteJobManager(jobMode string, memGBPerCore int, maxJobs int, jobFreqMillis int,
+	jobResources string, config jobManagerConfig, debug bool) *RemoteJobManager {
 	self := &RemoteJobManager{}
 	self.jobMode = jobMode
 	self.memGBPerCore = memGBPerCore
 	self.maxJobs = maxJobs
 	self.jobFreqMillis = jobFreqMillis
 	self.debug = debug
-	self.config = verifyJobManager(jobMode, config, memGBPerCore)
+	self.config = config
 
 	// Parse jobresources mappings
 	self.jobResourcesMappings = map[string]string{}
@@ -181,7 +203,7 @@ func (self *RemoteJobManager) jobScript(
 	shellCmd string, argv []string, envs map[string]string,
 	metadata *Metadata,
 	resRequest *JobResources,
-	fqname, shellName string) string {
+	fqname, shellName string) (string, error) {
 	res := self.GetSystemReqs(resRequest)
 
 	// figure out per-thread memory requirements for the template.
@@ -214,7 +236,8 @@ func (self *RemoteJobManager) jobScript(
 	}
 
 	threads := int(math.Ceil(res.Threads))
-	argsStr := formatArgs(threadEnvs(self, threads, envs), shellCmd, argv)
+	jobEnvs := threadEnvs(self, threads, envs)
+	argsStr := formatArgs(jobEnvs, shellCmd, argv)
 	const prefix = "__MRO_"
 	const suffix = "__"
 	params := [...][2]string{
@@ -269,6 +292,29 @@ func (self *RemoteJobManager) jobScript(
 	}
 
 	template := self.config.jobTemplate
+	if self.config.goTemplate != nil {
+		data := newJobTemplateData(fqname, shellName, self.jobMode)
+		data.Resources = res
+		data.Threads = threads
+		data.MemGB = int(math.Ceil(res.MemGB))
+		data.MemMB = int(math.Ceil(res.MemGB * 1024))
+		data.VMemGB = int(math.Ceil(res.VMemGB))
+		data.VMemMB = int(math.Ceil(res.VMemGB * 1024))
+		data.MemGBPerThread = memGBPerThread
+		data.VMemGBPerThread = vmemGBPerThread
+		data.SpecialResources = mappedJobResourcesOpt
+		data.Stdout = shellSafeQuote(metadata.MetadataFilePath("stdout"))
+		data.Stderr = shellSafeQuote(metadata.MetadataFilePath("stderr"))
+		data.WorkDir = shellSafeQuote(metadata.curFilesPath)
+		data.Cmd = argsStr
+		data.Env = jobEnvs
+		data.Settings = self.config.jobSettings
+		var buf strings.Builder
+		if err := self.config.goTemplate.Execute(&buf, &data); err != nil {
+			return "", err
+		}
+		template = buf.String()
+	}
 	// Replace template annotations with actual values
 	args := make([]string, 0, 2*len(params))
 	for _, vals := range params {
@@ -285,7 +331,7 @@ func (self *RemoteJobManager) jobScript(
 		}
 	}
 	r := strings.NewReplacer(args...)
-	return r.Replace(template)
+	return r.Replace(template), nil
 }
 
 // Format a shell command line to set environment variables and run the command.
@@ -324,16 +370,19 @@ func formatArgs(envs map[string]string, shellCmd string, argv []string) string {
 func (self *RemoteJobManager) sendJob(shellCmd string, argv []string, envs map[string]string,
 	metadata *Metadata, resRequest *JobResources, fqname string, shellName string,
 	ctx context.Context) {
-	jobscript := self.jobScript(shellCmd, argv, envs, metadata,
+	jobscript, err := self.jobScript(shellCmd, argv, envs, metadata,
 		resRequest, fqname, shellName)
+	if err != nil {
+		if err := metadata.remove(QueuedLocally); err != nil {
+			util.LogError(err, "jobmngr", "Error removing queue sentinel file.")
+		}
+		metadata.WriteErrorString("job template error: " + err.Error())
+		return
+	}
 	if err := metadata.WriteRaw("jobscript", jobscript); err != nil {
 		util.LogError(err, "jobmngr", "Could not write job script.")
 	}
 
-	cmd := exec.CommandContext(ctx, self.config.jobCmd, self.config.jobCmdArgs...)
-	cmd.Dir = metadata.curFilesPath
-	cmd.Stdin = strings.NewReader(jobscript)
-
 	// Regardless of the limiter rate, only allow one pending submission to the queue
 	// at a time.  Otherwise there's a risk that if the submit command takes longer
 	// than jobFreqMillis, commands will still pile up.  It's also a more "natural"
@@ -352,7 +401,7 @@ func (self *RemoteJobManager) sendJob(shellCmd string, argv []string, envs map[s
 	if err := metadata.remove(QueuedLocally); err != nil {
 		util.LogError(err, "jobmngr", "Error removing queue sentinel file.")
 	}
-	if output, err := cmd.CombinedOutput(); err != nil {
+	if output, err := self.submit(ctx, jobscript, metadata.curFilesPath); err != nil {
 		metadata.WriteErrorString(
 			"jobcmd error (" + err.Error() + "):\n" + string(output))
 	} else {
@@ -368,7 +417,23 @@ func (self *RemoteJobManager) sendJob(shellCmd string, argv []string, envs map[s
 	}
 }
 
+// submit runs the job submit command, or submits to the scheduler if there
+// is one, and returns the output.
+func (self *RemoteJobManager) submit(ctx context.Context,
+	jobscript, dir string) ([]byte, error) {
+	if self.scheduler != nil {
+		return self.scheduler.submit(jobscript, dir)
+	}
+	cmd := exec.CommandContext(ctx, self.config.jobCmd, self.config.jobCmdArgs...)
+	cmd.Dir = dir
+	cmd.Stdin = strings.NewReader(jobscript)
+	return cmd.CombinedOutput()
+}
+
 func (self *RemoteJobManager) checkQueue(ids []string, ctx context.Context) ([]string, string) {
+	if self.scheduler != nil {
+		return self.scheduler.query(ids), ""
+	}
 	if self.config.queueQueryCmd == "" {
 		return ids, ""
 	}
@@ -386,7 +451,7 @@ func (self *RemoteJobManager) checkQueue(ids []string, ctx context.Context) ([]s
 }
 
 func (self *RemoteJobManager) hasQueueCheck() bool {
-	return self.config.queueQueryCmd != ""
+	return self.scheduler != nil || self.config.queueQueryCmd != ""
 }
 
 func (self *RemoteJobManager) queueCheckGrace() time.Duration {
@@ -0,0 +1,271 @@
+// Copyright (c) 2020 10X Genomics, Inc. All rights reserved.
+
+package core
+
+// A simulated cluster, for exercising the remote job manager without a
+// cluster scheduler.
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/martian-lang/martian/martian/util"
+)
+
+// The job mode which submits jobs to an in-process simulated cluster.
+const simClusterMode = "simcluster"
+
+// The job template for the simulated cluster.  The shell is replaced by the
+// job command, so that signals sent by the simulated scheduler reach it.
+const simClusterTemplate = `#!/bin/sh
+# __MRO_JOB_NAME__: __MRO_THREADS__ threads, __MRO_MEM_GB__ GB
+exec /usr/bin/env __MRO_CMD__ > __MRO_STDOUT__ 2> __MRO_STDERR__
+`
+
+// SimClusterConfig configures the behavior of the simulated cluster used in
+// the simcluster job mode.
+type SimClusterConfig struct {
+	// The seed for decisions about which jobs to kill or preempt.
+	Seed int64 `json:"seed"`
+
+	// The number of jobs which may run at once.  The default is 4.
+	Slots int `json:"slots,omitempty"`
+
+	// The minimum number of seconds a job spends in the queue before it
+	// starts running, including after being preempted.  The default is 1.
+	QueueLatency float64 `json:"queue_latency,omitempty"`
+
+	// The number of seconds after a job is found to be missing from the
+	// queue before it is assumed to have failed.  The default is 10.
+	QueueQueryGrace float64 `json:"queue_query_grace,omitempty"`
+
+	// The rate at which running jobs are killed by the scheduler.
+	KillRate float64 `json:"kill_rate"`
+
+	// The rate at which running jobs are preempted by the scheduler.
+	// Preempted jobs are killed and put back on the queue under the same
+	// job id.
+	PreemptRate float64 `json:"preempt_rate"`
+
+	// Jobs which are killed or preempted are stopped at a time chosen
+	// between 0 and this many seconds after starting.  The default is 10.
+	KillWithin float64 `json:"kill_within,omitempty"`
+}
+
+// ReadSimClusterConfig loads a simulated cluster configuration from a json
+// file.
+func ReadSimClusterConfig(fn string) (*SimClusterConfig, error) {
+	b, err := os.ReadFile(fn)
+	if err != nil {
+		return nil, err
+	}
+	var config SimClusterConfig
+	if err := json.Unmarshal(b, &config); err != nil {
+		return nil, err
+	}
+	if config.KillRate < 0 || config.PreemptRate < 0 ||
+		config.KillRate+config.PreemptRate > 1 {
+		return &config, fmt.Errorf(
+			"simcluster kill_rate=%g and preempt_rate=%g must be "+
+				"non-negative and add up to at most 1",
+			config.KillRate, config.PreemptRate)
+	}
+	if config.Slots < 0 || config.QueueLatency < 0 ||
+		config.QueueQueryGrace < 0 || config.KillWithin < 0 {
+		return &config, fmt.Errorf(
+			"simcluster slots, queue_latency, queue_query_grace, " +
+				"and kill_within may not be negative")
+	}
+	return &config, nil
+}
+
+func secondsOrDefault(secs, def float64) time.Duration {
+	if secs <= 0 {
+		secs = def
+	}
+	return time.Duration(secs * float64(time.Second))
+}
+
+// NewSimClusterJobManager creates a remote job manager which submits jobs to
+// an in-process simulated cluster, which runs them on the local machine.
+func NewSimClusterJobManager(memGBPerCore int, maxJobs int, jobFreqMillis int,
+	jobResources string, config *JobManagerJson,
+	sim *SimClusterConfig, debug bool) *RemoteJobManager {
+	if sim == nil {
+		sim = new(SimClusterConfig)
+	}
+	cluster := newSimCluster(sim)
+	self := newRemoteJobManager(simClusterMode, memGBPerCore, maxJobs,
+		jobFreqMillis, jobResources, jobManagerConfig{
+			jobSettings:      config.JobSettings,
+			jobTemplate:      simClusterTemplate,
+			queueQueryGrace:  secondsOrDefault(sim.QueueQueryGrace, 10),
+			threadingEnabled: true,
+		}, debug)
+	self.scheduler = cluster
+	util.LogInfo("jobmngr",
+		"Simulated cluster: %d slots, %v queue latency",
+		cluster.slots, cluster.latency)
+	return self
+}
+
+// simCluster is a jobScheduler which runs jobs on the local machine, with a
+// limited number of slots, after a queue delay.
+type simCluster struct {
+	slots     int
+	latency   time.Duration
+	within    time.Duration
+	kill      float64
+	preempt   float64
+	decisions *chaosMonkey
+
+	mu      sync.Mutex
+	nextId  int
+	jobs    map[string]*simJob
+	pending []*simJob
+	running int
+}
+
+type simJob struct {
+	id     string
+	script string
+	dir    string
+	ready  time.Time
+
+	// The process for the job while it is running.
+	proc *os.Process
+
+	// Set if the job was stopped to be requeued.
+	preempted bool
+}
+
+func newSimCluster(config *SimClusterConfig) *simCluster {
+	slots := config.Slots
+	if slots <= 0 {
+		slots = 4
+	}
+	return &simCluster{
+		slots:   slots,
+		latency: secondsOrDefault(config.QueueLatency, 1),
+		within:  secondsOrDefault(config.KillWithin, 10),
+		kill:    config.KillRate,
+		preempt: config.PreemptRate,
+		// Use the fault injection sampler so that decisions are
+		// deterministic for a given seed.
+		decisions: newChaosMonkey(&ChaosConfig{Seed: config.Seed}),
+		nextId:    1,
+		jobs:      make(map[string]*simJob),
+	}
+}
+
+func (c *simCluster) submit(jobscript, dir string) ([]byte, error) {
+	c.mu.Lock()
+	job := &simJob{
+		id:     strconv.Itoa(c.nextId),
+		script: jobscript,
+		dir:    dir,
+		ready:  time.Now().Add(c.latency),
+	}
+	c.nextId++
+	c.jobs[job.id] = job
+	c.pending = append(c.pending, job)
+	c.mu.Unlock()
+	time.AfterFunc(c.latency, c.dispatch)
+	return []byte(job.id + "\n"), nil
+}
+
+func (c *simCluster) query(ids []string) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	queued := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if _, ok := c.jobs[id]; ok {
+			queued = append(queued, id)
+		}
+	}
+	return queued
+}
+
+// dispatch starts as many ready jobs as there are free slots, in the order
+// they were queued.
+func (c *simCluster) dispatch() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	for c.running < c.slots && len(c.pending) > 0 &&
+		!c.pending[0].ready.After(now) {
+		job := c.pending[0]
+		c.pending = c.pending[1:]
+		c.running++
+		go c.run(job)
+	}
+}
+
+func (c *simCluster) run(job *simJob) {
+	cmd := exec.Command("/bin/sh")
+	cmd.Dir = job.dir
+	cmd.Stdin = strings.NewReader(job.script)
+	// Run the job in its own process group, so that it can be killed along
+	// with the processes it starts, as a real scheduler would.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		util.LogError(err, "simclust", "Could not start job %s.", job.id)
+	} else {
+		c.mu.Lock()
+		job.proc = cmd.Process
+		c.mu.Unlock()
+		c.schedulerStop(job, cmd.Process)
+		// Failures are reported by the job through its metadata, or by its
+		// absence from the queue.
+		_ = cmd.Wait()
+	}
+	c.mu.Lock()
+	job.proc = nil
+	c.running--
+	if job.preempted {
+		job.preempted = false
+		job.ready = time.Now().Add(c.latency)
+		c.pending = append(c.pending, job)
+		time.AfterFunc(c.latency, c.dispatch)
+	} else {
+		delete(c.jobs, job.id)
+	}
+	c.mu.Unlock()
+	c.dispatch()
+}
+
+// schedulerStop decides whether the scheduler will kill or preempt the job,
+// and if so arranges for it to happen.
+func (c *simCluster) schedulerStop(job *simJob, proc *os.Process) {
+	p := c.decisions.sample("sim_stop", job.id)
+	if p >= c.kill+c.preempt {
+		return
+	}
+	preempt := p >= c.kill
+	delay := time.Duration(
+		c.decisions.sample("sim_stop_delay", job.id) * float64(c.within))
+	time.AfterFunc(delay, func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if job.proc != proc {
+			// The job already finished.
+			return
+		}
+		if err := syscall.Kill(-proc.Pid, syscall.SIGKILL); err != nil {
+			return
+		}
+		job.preempted = preempt
+		if preempt {
+			util.PrintInfo("simclust", "Preempting job %s", job.id)
+		} else {
+			util.PrintInfo("simclust", "Killing job %s", job.id)
+		}
+	})
+}
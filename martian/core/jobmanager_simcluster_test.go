@@ -0,0 +1,99 @@
+// Copyright (c) 2020 10X Genomics, Inc. All rights reserved.
+
+package core
+
+import (
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// waitForQueue waits until the given jobs are no longer queued or running.
+func waitForQueue(t *testing.T, c *simCluster, ids ...string) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for len(c.query(ids)) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("jobs %v did not finish", c.query(ids))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func submitSim(t *testing.T, c *simCluster, script, dir string) string {
+	t.Helper()
+	out, err := c.submit(script, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func TestSimClusterSlots(t *testing.T) {
+	dir := t.TempDir()
+	c := newSimCluster(&SimClusterConfig{
+		Slots:        1,
+		QueueLatency: 0.01,
+	})
+	first := submitSim(t, c, "sleep 0.5\necho first >> order\n", dir)
+	second := submitSim(t, c, "echo second >> order\n", dir)
+	if first == second {
+		t.Errorf("duplicate job id %s", first)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if queued := c.query([]string{first, second, "nope"}); !reflect.DeepEqual(
+		queued, []string{first, second}) {
+		t.Errorf("queued %v", queued)
+	}
+	waitForQueue(t, c, first, second)
+	if b, err := os.ReadFile(path.Join(dir, "order")); err != nil {
+		t.Error(err)
+	} else if string(b) != "first\nsecond\n" {
+		t.Errorf("jobs ran out of order with one slot: %q", b)
+	}
+}
+
+func TestSimClusterKill(t *testing.T) {
+	dir := t.TempDir()
+	c := newSimCluster(&SimClusterConfig{
+		QueueLatency: 0.01,
+		KillRate:     1,
+		KillWithin:   0.01,
+	})
+	id := submitSim(t, c, "sleep 0.5\necho done > out\n", dir)
+	waitForQueue(t, c, id)
+	if _, err := os.Stat(path.Join(dir, "out")); !os.IsNotExist(err) {
+		t.Error("killed job ran to completion")
+	}
+
+	// Processes started by the job should be killed with it.
+	id = submitSim(t, c, "sh -c 'sleep 0.2; echo done > child'\n", dir)
+	waitForQueue(t, c, id)
+	time.Sleep(500 * time.Millisecond)
+	if _, err := os.Stat(path.Join(dir, "child")); !os.IsNotExist(err) {
+		t.Error("a process started by a killed job kept running")
+	}
+}
+
+func TestSimClusterPreempt(t *testing.T) {
+	dir := t.TempDir()
+	c := newSimCluster(&SimClusterConfig{
+		QueueLatency: 0.01,
+		PreemptRate:  1,
+		KillWithin:   0.01,
+	})
+	// The job is preempted every time it runs, so it should stay queued.
+	id := submitSim(t, c, "echo run >> runs\nsleep 0.5\n", dir)
+	time.Sleep(300 * time.Millisecond)
+	if queued := c.query([]string{id}); len(queued) != 1 {
+		t.Error("preempted job was removed from the queue")
+	}
+	if b, err := os.ReadFile(path.Join(dir, "runs")); err != nil {
+		t.Error(err)
+	} else if n := strings.Count(string(b), "run"); n < 2 {
+		t.Errorf("preempted job ran %d times", n)
+	}
+}
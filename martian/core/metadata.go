@@ -58,6 +58,7 @@ const (
 	UuidFile       MetadataFileName = "uuid"
 	VdrKill        MetadataFileName = "vdrkill"
 	PartialVdr     MetadataFileName = "vdrkill.partial"
+	VdrReportFile  MetadataFileName = "vdrreport"
 	VersionsFile   MetadataFileName = "versions"
 	DisabledFile   MetadataFileName = "disabled"
 )
@@ -975,22 +976,27 @@ func (self *Metadata) checkHeartbeat() {
 			self.lastHeartbeat = time.Now()
 		}
 		if self.lastRefresh.Sub(self.lastHeartbeat) > time.Minute*heartbeatTimeout {
-			// Check if the state changed but we just missed the journal.
-			self.poll()
-			if state, _ := self.getState(); state != Running {
-				return
-			}
-			self.WriteErrorString(fmt.Sprintf(
-				"%s: No heartbeat detected for %d minutes. "+
-					"Assuming job has failed. This may be "+
-					"due to a user manually terminating the job, "+
-					"or the operating system or cluster "+
-					"terminating it due to resource or time limits.",
-				util.Timestamp(), heartbeatTimeout))
+			self.failHeartbeat()
 		}
 	}
 }
 
+// failHeartbeat fails a running job whose heartbeat has timed out.
+func (self *Metadata) failHeartbeat() {
+	// Check if the state changed but we just missed the journal.
+	self.poll()
+	if state, _ := self.getState(); state != Running {
+		return
+	}
+	self.WriteErrorString(fmt.Sprintf(
+		"%s: No heartbeat detected for %d minutes. "+
+			"Assuming job has failed. This may be "+
+			"due to a user manually terminating the job, "+
+			"or the operating system or cluster "+
+			"terminating it due to resource or time limits.",
+		util.Timestamp(), heartbeatTimeout))
+}
+
 func (self *Metadata) serializeState() *MetadataInfo {
 	self.mutex.Lock()
 	names := make([]string, 0, len(self.contents))
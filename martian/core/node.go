@@ -95,6 +95,9 @@ type NodeInfo struct {
 	Edges         []EdgeInfo               `json:"edges"`
 	StagecodeLang syntax.StageCodeType     `json:"stagecodeLang"`
 	Type          syntax.CallGraphNodeType `json:"type"`
+
+	// The outputs which were published, for the top-level pipeline.
+	Published []*PublishedFile `json:"published,omitempty"`
 }
 
 func (self *Node) getNode() *Node { return self }
@@ -750,8 +753,13 @@ func (self *Node) restartLocalJobs() error {
 }
 
 func (self *Node) checkHeartbeats() {
+	chaos := self.top.rt.chaos
 	for _, metadata := range self.collectMetadatas() {
-		metadata.checkHeartbeat()
+		if chaos.dropHeartbeat(metadata) {
+			metadata.failHeartbeat()
+		} else {
+			metadata.checkHeartbeat()
+		}
 	}
 }
 
@@ -891,7 +899,7 @@ func (self *Node) step() bool {
 		}
 		self.addFrontierNode(self)
 	case Complete:
-		if vdr := self.top.rt.Config.VdrMode; vdr == VdrRolling || vdr == VdrStrict {
+		if vdr := self.top.rt.Config.VdrMode; vdr != VdrPost && vdr != VdrDisable {
 			for _, node := range self.prenodes {
 				node.getNode().cachePerf()
 			}
@@ -938,7 +946,8 @@ func (self *Node) refreshState(readOnly bool) {
 	updatedForks := make(map[*Fork]struct{})
 	for _, file := range files {
 		filename := path.Base(file)
-		if strings.HasSuffix(filename, ".tmp") {
+		if strings.HasSuffix(filename, ".tmp") ||
+			self.top.rt.chaos.delayJournal(filename) {
 			continue
 		}
 
@@ -1133,25 +1142,14 @@ func (self *Node) runChunk(fqname string, metadata *Metadata, res *JobResources)
 	self.runJob("main", fqname, STAGE_TYPE_CHUNK, metadata, res)
 }
 
-func (self *Node) runJob(shellName, fqname, stageType string,
-	metadata *Metadata, res *JobResources) {
-	// Configure local variable dumping.
-	stackVars := disable
-	if self.top.rt.Config.StackVars {
-		stackVars = "stackvars"
-	}
-
-	// Configure memory monitoring.
-	monitor := disable
-	if self.top.rt.Config.Monitor {
-		monitor = "monitor"
-	}
-
+// jobCommand returns the command, arguments, and environment for running the
+// given phase of the stage code in a job with the given metadata.
+func (self *Node) jobCommand(shellName string,
+	metadata *Metadata) (string, []string, map[string]string) {
 	// Construct path to the shell.
 	shellCmd := ""
 	var argv []string
 	runFile := metadata.journalFile()
-	version := &self.top.version
 	envs := self.top.envs
 	if td := metadata.TempDir(); td != "" {
 		envs = make(map[string]string, len(self.top.envs)+1)
@@ -1190,6 +1188,25 @@ func (self *Node) runJob(shellName, fqname, stageType string,
 	default:
 		panic(fmt.Sprint("Unknown stage code language: ", self.stagecode.Type))
 	}
+	return shellCmd, argv, envs
+}
+
+func (self *Node) runJob(shellName, fqname, stageType string,
+	metadata *Metadata, res *JobResources) {
+	// Configure local variable dumping.
+	stackVars := disable
+	if self.top.rt.Config.StackVars {
+		stackVars = "stackvars"
+	}
+
+	// Configure memory monitoring.
+	monitor := disable
+	if self.top.rt.Config.Monitor {
+		monitor = "monitor"
+	}
+
+	shellCmd, argv, envs := self.jobCommand(shellName, metadata)
+	version := &self.top.version
 
 	// Log the job run.
 	jobMode := self.top.rt.Config.JobMode
@@ -1237,6 +1254,14 @@ func (self *Node) runJob(shellName, fqname, stageType string,
 			"Could not write jobinfo file, aborting.")
 		util.Suicide(false)
 	}
+	if msg, ok := self.top.rt.chaos.transientError(fqname + "." + shellName); ok {
+		if err := metadata.remove(QueuedLocally); err != nil {
+			util.LogError(err, "chaos", "Could not remove %s.",
+				QueuedLocally.FileName())
+		}
+		metadata.WriteErrorString(msg)
+		return
+	}
 	jobManager.execJob(shellCmd, argv, envs, metadata, res, fqname,
 		shellName, self.call.Call().Modifiers.Preflight && self.local)
 }
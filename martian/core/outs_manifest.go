@@ -0,0 +1,280 @@
+// Copyright (c) 2020 10X Genomics, Inc. All rights reserved.
+
+package core
+
+//
+// A checksummed record of the files in a pipestance's outs directory.
+//
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/martian-lang/martian/martian/syntax"
+)
+
+// OutsManifestFile is the name of the manifest written in the top-level outs
+// directory of a pipestance.
+const OutsManifestFile = "_manifest.json"
+
+// OutsManifestEntry records one file in the outs directory.
+type OutsManifestEntry struct {
+	// The path to the file, relative to the outs directory.
+	Path string `json:"path"`
+
+	Size   int64  `json:"size"`
+	Sha256 string `json:"sha256"`
+
+	// The fully-qualified name of the stage which produced the file, if it
+	// was produced by a stage in the pipestance.
+	Stage string `json:"stage,omitempty"`
+}
+
+// OutsManifest lists the files in a pipestance's outs directory.
+type OutsManifest struct {
+	Files []*OutsManifestEntry `json:"files"`
+}
+
+// ReadOutsManifest reads the manifest for the outs directory of a pipestance.
+func ReadOutsManifest(psdir string) (*OutsManifest, error) {
+	b, err := os.ReadFile(path.Join(psdir, "outs", OutsManifestFile))
+	if err != nil {
+		return nil, err
+	}
+	var manifest OutsManifest
+	return &manifest, json.Unmarshal(b, &manifest)
+}
+
+// movedOut records an output file moved or linked into the outs directory.
+type movedOut struct {
+	src, dst string
+}
+
+type movedOuts []movedOut
+
+func (self *movedOuts) add(src, dst string) {
+	if self != nil {
+		*self = append(*self, movedOut{src: src, dst: dst})
+	}
+}
+
+// writeOutsManifest updates the manifest in the outs directory with the
+// files moved into this fork's outs directory.  Entries for other forks of a
+// sweep are kept.
+func (self *Fork) writeOutsManifest(outsRoot, outsPath string, moved movedOuts) error {
+	old, err := ReadOutsManifest(path.Dir(outsRoot))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	entries := make(map[string]*OutsManifestEntry)
+	oldStages := make(map[string]string)
+	if old != nil {
+		forkRel, err := filepath.Rel(outsRoot, outsPath)
+		if err != nil {
+			return err
+		}
+		for _, e := range old.Files {
+			if forkRel == "." || pathIsInside(e.Path, forkRel) {
+				oldStages[e.Path] = e.Stage
+			} else {
+				entries[e.Path] = e
+			}
+		}
+	}
+	for _, m := range moved {
+		stage := self.node.top.stageForPath(m.src)
+		files, err := hashOutsTree(outsRoot, m.dst)
+		if err != nil {
+			return err
+		}
+		for _, e := range files {
+			if stage != "" {
+				e.Stage = stage
+			} else {
+				e.Stage = oldStages[e.Path]
+			}
+			entries[e.Path] = e
+		}
+	}
+	manifest := OutsManifest{
+		Files: make([]*OutsManifestEntry, 0, len(entries)),
+	}
+	for _, e := range entries {
+		manifest.Files = append(manifest.Files, e)
+	}
+	sort.Slice(manifest.Files, func(i, j int) bool {
+		return manifest.Files[i].Path < manifest.Files[j].Path
+	})
+	b, err := json.MarshalIndent(&manifest, "", "    ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(outsRoot, 0775); err != nil {
+		return err
+	}
+	return writeAtomic(path.Join(outsRoot, OutsManifestFile), b)
+}
+
+// stageForPath returns the fully-qualified name of the stage whose directory
+// contains the given path, or the empty string if there is none.
+func (self *TopNode) stageForPath(p string) string {
+	if !filepath.IsAbs(p) {
+		if abs, err := filepath.Abs(p); err == nil {
+			p = abs
+		}
+	}
+	for fqname, node := range self.allNodes {
+		if node.call.Kind() != syntax.KindStage {
+			continue
+		}
+		nodePath := node.path
+		if !filepath.IsAbs(nodePath) {
+			if abs, err := filepath.Abs(nodePath); err == nil {
+				nodePath = abs
+			}
+		}
+		if pathIsInside(p, nodePath) {
+			return fqname
+		}
+	}
+	return ""
+}
+
+// hashOutsTree returns manifest entries for the file at p, or for every file
+// under it if it is a directory, following symlinks.
+func hashOutsTree(outsRoot, p string) ([]*OutsManifestEntry, error) {
+	info, err := os.Stat(p)
+	if err != nil {
+		if os.IsNotExist(err) {
+			// Dangling symlinks were reported when they were created.
+			return nil, nil
+		}
+		return nil, err
+	}
+	if !info.IsDir() {
+		e, err := hashOutsFile(outsRoot, p, info)
+		if err != nil {
+			return nil, err
+		}
+		return []*OutsManifestEntry{e}, nil
+	}
+	var entries []*OutsManifestEntry
+	err = filepath.WalkDir(p+"/", func(fn string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := os.Stat(fn)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() {
+			sub, err := hashOutsTree(outsRoot, fn)
+			entries = append(entries, sub...)
+			return err
+		}
+		e, err := hashOutsFile(outsRoot, fn, info)
+		if err == nil {
+			entries = append(entries, e)
+		}
+		return err
+	})
+	return entries, err
+}
+
+func hashOutsFile(outsRoot, fn string, info os.FileInfo) (*OutsManifestEntry, error) {
+	rel, err := filepath.Rel(outsRoot, filepath.Clean(fn))
+	if err != nil {
+		return nil, err
+	}
+	sum, err := sha256File(fn)
+	if err != nil {
+		return nil, err
+	}
+	return &OutsManifestEntry{
+		Path:   rel,
+		Size:   info.Size(),
+		Sha256: sum,
+	}, nil
+}
+
+func sha256File(fn string) (string, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// OutsVerification is the result of checking the files in a pipestance's
+// outs directory against its manifest.
+type OutsVerification struct {
+	// The number of files listed in the manifest.
+	Checked int `json:"checked"`
+
+	// Files listed in the manifest which no longer exist.
+	Missing []string `json:"missing,omitempty"`
+
+	// Files whose size or checksum no longer match the manifest.
+	Modified []string `json:"modified,omitempty"`
+}
+
+// OK returns true if every file in the manifest was present and unmodified.
+func (self *OutsVerification) OK() bool {
+	return len(self.Missing) == 0 && len(self.Modified) == 0
+}
+
+// VerifyOutsManifest re-hashes the files listed in the manifest for the
+// outs directory of a pipestance, and reports the ones which are missing or
+// were modified.
+func VerifyOutsManifest(psdir string) (*OutsVerification, error) {
+	manifest, err := ReadOutsManifest(psdir)
+	if err != nil {
+		return nil, err
+	}
+	outsRoot := path.Join(psdir, "outs")
+	result := &OutsVerification{Checked: len(manifest.Files)}
+	for _, e := range manifest.Files {
+		if e.Path == "" || e.Path == ".." || strings.HasPrefix(e.Path, "../") ||
+			filepath.IsAbs(e.Path) {
+			result.Modified = append(result.Modified, e.Path)
+			continue
+		}
+		fn := path.Join(outsRoot, e.Path)
+		info, err := os.Stat(fn)
+		if os.IsNotExist(err) {
+			result.Missing = append(result.Missing, e.Path)
+			continue
+		} else if err != nil {
+			return result, err
+		}
+		if info.IsDir() || info.Size() != e.Size {
+			result.Modified = append(result.Modified, e.Path)
+			continue
+		}
+		if sum, err := sha256File(fn); err != nil {
+			return result, err
+		} else if sum != e.Sha256 {
+			result.Modified = append(result.Modified, e.Path)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,70 @@
+// Copyright (c) 2020 10X Genomics, Inc. All rights reserved.
+
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/martian-lang/martian/martian/util"
+)
+
+func TestOutsManifest(t *testing.T) {
+	util.SetPrintLogger(&devNull)
+	rtOpts := DefaultRuntimeOptions()
+	psdir, pipestance := runTestPipestance(t, &rtOpts, "outs_manifest")
+	pipestance.PostProcess()
+
+	manifest, err := ReadOutsManifest(psdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Files) != 1 {
+		t.Fatalf("expected 1 file in manifest, got %d", len(manifest.Files))
+	}
+	entry := manifest.Files[0]
+	if entry.Path != "file.txt" {
+		t.Errorf("expected file.txt, got %s", entry.Path)
+	}
+	if entry.Stage != "ID.outs_manifest.OUTS.MAKE" {
+		t.Errorf("expected stage ID.outs_manifest.OUTS.MAKE, got %q", entry.Stage)
+	}
+	fn := path.Join(psdir, "outs", "file.txt")
+	if b, err := os.ReadFile(fn); err != nil {
+		t.Fatal(err)
+	} else if sum := sha256.Sum256(b); entry.Sha256 != hex.EncodeToString(sum[:]) {
+		t.Errorf("incorrect checksum %s", entry.Sha256)
+	} else if entry.Size != int64(len(b)) {
+		t.Errorf("incorrect size %d", entry.Size)
+	}
+
+	check := func(t *testing.T, missing, modified int) {
+		t.Helper()
+		result, err := VerifyOutsManifest(psdir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Checked != 1 {
+			t.Errorf("checked %d files", result.Checked)
+		}
+		if len(result.Missing) != missing || len(result.Modified) != modified {
+			t.Errorf("expected %d missing and %d modified, got %v and %v",
+				missing, modified, result.Missing, result.Modified)
+		}
+		if result.OK() != (missing == 0 && modified == 0) {
+			t.Error("incorrect OK result")
+		}
+	}
+	check(t, 0, 0)
+	if err := os.WriteFile(fn, []byte("tampered"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	check(t, 0, 1)
+	if err := os.Remove(fn); err != nil {
+		t.Fatal(err)
+	}
+	check(t, 1, 0)
+}
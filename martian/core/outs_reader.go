@@ -0,0 +1,51 @@
+// Copyright (c) 2020 10X Genomics, Inc. All rights reserved.
+
+package core
+
+import (
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ReadPipestanceOuts reads the outputs of a completed pipestance into the
+// given target object.
+//
+// The call is the ID of the top-level call in the pipestance invocation,
+// which is usually the name of the pipeline.  File paths in the outputs
+// are not modified.  See OutsPathResolver.
+func ReadPipestanceOuts(pipestancePath, call string, target interface{}) error {
+	metadata := NewMetadata(call,
+		path.Join(pipestancePath, call, defaultFork))
+	return metadata.ReadInto(OutsFile, target)
+}
+
+// OutsPathResolver returns a function which converts a file path from the
+// outputs of a completed pipestance into a path in the pipestance's outs
+// directory.
+//
+// Relative paths are taken to be relative to the outs directory.  Absolute
+// paths which refer to the outs directory of a pipestance at a different
+// location, for example because the pipestance was moved after it completed,
+// are rewritten to refer to the given pipestance.
+func OutsPathResolver(pipestancePath string) func(string) string {
+	outsPath := path.Join(pipestancePath, "outs")
+	if abs, err := filepath.Abs(outsPath); err == nil {
+		outsPath = abs
+	}
+	return func(p string) string {
+		if p == "" {
+			return p
+		}
+		if !path.IsAbs(p) {
+			return path.Join(outsPath, p)
+		}
+		if p == outsPath || strings.HasPrefix(p, outsPath+"/") {
+			return p
+		}
+		if i := strings.LastIndex(p, "/outs/"); i >= 0 {
+			return path.Join(outsPath, p[i+len("/outs/"):])
+		}
+		return p
+	}
+}
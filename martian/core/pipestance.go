@@ -135,6 +135,10 @@ type Pipestance struct {
 	allNodesCache    []*Node
 	queueCheckLock   sync.Mutex
 	queueCheckActive bool
+
+	// The outputs uploaded by PublishOuts.
+	published     []*PublishedFile
+	publishedLock sync.Mutex
 }
 
 // Run a script whenever a pipestance finishes.
@@ -540,6 +544,7 @@ func (self *Pipestance) queryQueue(outerCtx context.Context) {
 	go func(ctx context.Context, task *trace.Task) {
 		defer task.End()
 		queued, raw := self.node.top.rt.JobManager.checkQueue(jobsIn, ctx)
+		queued = self.node.top.rt.chaos.vanish(queued)
 		for _, id := range queued {
 			delete(needsQuery, id)
 		}
@@ -616,6 +621,7 @@ func (self *Pipestance) StepNodes(ctx context.Context) bool {
 			return false
 		}
 	}
+	self.checkDiskSpace()
 	if err := self.node.top.rt.LocalJobManager.refreshResources(
 		self.node.top.rt.Config.JobMode == localMode); err != nil {
 		util.LogError(err, "runtime",
@@ -657,7 +663,13 @@ func (self *Pipestance) SerializeState() []*NodeInfo {
 	nodes := self.allNodes()
 	ser := make([]*NodeInfo, 0, len(nodes))
 	for _, node := range nodes {
-		ser = append(ser, node.serializeState())
+		info := node.serializeState()
+		if node == self.node {
+			self.publishedLock.Lock()
+			info.Published = self.published
+			self.publishedLock.Unlock()
+		}
+		ser = append(ser, info)
 	}
 	return ser
 }
@@ -690,12 +702,23 @@ func (self *Pipestance) Serialize(name MetadataFileName) interface{} {
 }
 
 func (self *Pipestance) ComputeDiskUsage(nodePerf *NodePerfInfo) *NodePerfInfo {
+	// In report mode, the removal events in the VDR reports did not
+	// actually happen.
+	nodePerf.BytesHist, nodePerf.MaxBytes = self.diskUsage(
+		self.node.top.rt.Config.VdrMode != VdrReport)
+	return nodePerf
+}
+
+// diskUsage returns the history of the storage used by the pipestance, and
+// its high water mark.  If deletes is false, storage events which removed
+// files are ignored.
+func (self *Pipestance) diskUsage(deletes bool) ([]*NodeByteStamp, int64) {
 	nodes := self.allNodes()
 	allStorageEvents := make(StorageEventByTimestamp, 0, len(nodes)*2)
 	for _, node := range nodes {
 		_, storageEvents := node.serializePerf()
 		for _, ev := range storageEvents {
-			if ev.DeltaBytes != 0 {
+			if ev.DeltaBytes > 0 || (deletes && ev.DeltaBytes < 0) {
 				allStorageEvents = append(allStorageEvents,
 					NewStorageEvent(ev.Timestamp, ev.DeltaBytes, func(name string, ev *VdrEvent) string {
 						if ev.DeltaBytes > 0 {
@@ -720,30 +743,39 @@ func (self *Pipestance) ComputeDiskUsage(nodePerf *NodePerfInfo) *NodePerfInfo {
 			highMark = currentMark
 		}
 	}
-
-	nodePerf.MaxBytes = highMark
-	nodePerf.BytesHist = byteStamps
-	return nodePerf
+	return byteStamps, highMark
 }
 
 func (self *Pipestance) ZipMetadata(zipPath string) error {
 	if !self.node.top.rt.Config.Zip {
 		return nil
 	}
+	return self.zipMetadata(zipPath)
+}
 
-	nodes := self.allNodes()
+// stageMetadataFiles returns the metadata files for all of the nodes in the
+// pipestance, and the symlinks to their uniquified directories.
+func (self *Pipestance) stageMetadataFiles() ([]string, []string) {
 	metadatas := []*Metadata{}
-	for _, node := range nodes {
+	for _, node := range self.allNodes() {
 		metadatas = append(metadatas, node.collectMetadatas()...)
 	}
-	filePaths := make([]string, 0, 7*len(metadatas))
-	removePaths := make([]string, 0, len(metadatas))
+	files := make([]string, 0, 6*len(metadatas))
+	var symlinks []string
 	for _, metadata := range metadatas {
-		files, _ := metadata.glob()
-		filePaths = append(filePaths, files...)
-		removePaths = append(removePaths, files...)
-		filePaths = append(filePaths, metadata.symlinks()...)
+		mdFiles, _ := metadata.glob()
+		files = append(files, mdFiles...)
+		symlinks = append(symlinks, metadata.symlinks()...)
 	}
+	return files, symlinks
+}
+
+func (self *Pipestance) zipMetadata(zipPath string) error {
+	nodes := self.allNodes()
+	removePaths, symlinks := self.stageMetadataFiles()
+	filePaths := make([]string, 0, len(removePaths)+len(symlinks))
+	filePaths = append(filePaths, removePaths...)
+	filePaths = append(filePaths, symlinks...)
 
 	util.EnterCriticalSection()
 	defer util.ExitCriticalSection()
@@ -798,6 +830,11 @@ func (self *Pipestance) GetVersions() (string, string, error) {
 
 func (self *Pipestance) PostProcess() {
 	self.node.postProcess()
+	if dest := self.node.top.rt.Config.PublishUri; dest != "" {
+		if _, err := self.PublishOuts(dest, context.Background()); err != nil {
+			util.PrintError(err, "publish", "Error publishing outputs.")
+		}
+	}
 	start, _ := self.metadata.readRawBytes(TimestampFile)
 	start = append(start, "\nend: "...)
 	if err := self.metadata.WriteRawBytes(TimestampFile, append(start, util.Timestamp()...)); err != nil {
@@ -893,6 +930,12 @@ func (self *Pipestance) unlock() {
 	}
 }
 
+// WaitForStorageCleanup waits for storage cleanup which the runtime runs in
+// the background after stages complete.
+func (self *Pipestance) WaitForStorageCleanup() {
+	self.node.top.storageTasks.Wait()
+}
+
 func (self *Pipestance) Unlock() {
 	self.unlock()
 	util.UnregisterSignalHandler(self)
@@ -947,6 +990,10 @@ type TopNode struct {
 	version     VersionInfo
 	allNodes    map[string]*Node
 	node        Node
+	disk        *diskGuard
+
+	// Tracks storage cleanup running in the background.
+	storageTasks sync.WaitGroup
 }
 
 func (self *TopNode) getNode() *Node { return &self.node }
@@ -999,6 +1046,7 @@ func NewTopNode(rt *Runtime, fqname string, p string,
 		},
 		journalPath: path.Join(p, "journal"),
 		tmpPath:     path.Join(p, "tmp"),
+		disk:        newDiskGuard(rt.Config, p),
 		envs:        make(map[string]string, len(envs)+1),
 		allNodes:    make(map[string]*Node),
 	}
@@ -47,6 +47,7 @@ func (self *Fork) postProcess() error {
 
 	var errs syntax.ErrorList
 	var newOuts json.Marshaler
+	var moved movedOuts
 	switch ro.Type.(type) {
 	case *syntax.ArrayType:
 		var arr []json.RawMessage
@@ -58,7 +59,7 @@ func (self *Fork) postProcess() error {
 			k := strconv.Itoa(i)
 			util.Print("Fork %s:\n", k)
 			nout, err := self.processStructOuts(pipestancePath,
-				path.Join(outsPath, k), elem)
+				path.Join(outsPath, k), elem, &moved)
 			if err != nil {
 				errs = append(errs, err)
 			}
@@ -74,7 +75,7 @@ func (self *Fork) postProcess() error {
 		for k, elem := range outs {
 			util.Print("Fork \"%s\":\n", k)
 			nout, err := self.processStructOuts(pipestancePath,
-				path.Join(outsPath, k), elem)
+				path.Join(outsPath, k), elem, &moved)
 			if err != nil {
 				errs = append(errs, err)
 			}
@@ -86,7 +87,7 @@ func (self *Fork) postProcess() error {
 			errs = append(errs, err)
 		} else {
 			newOuts, err = self.processStructOuts(
-				pipestancePath, outsPath, b)
+				pipestancePath, outsPath, b, &moved)
 			if err != nil {
 				errs = append(errs, err)
 			}
@@ -96,12 +97,17 @@ func (self *Fork) postProcess() error {
 	if err := self.metadata.WriteAtomic(OutsFile, newOuts); err != nil {
 		errs = append(errs, err)
 	}
+	// Record checksums for everything which was put in outs.
+	if err := self.writeOutsManifest(path.Join(pipestancePath, "outs"),
+		outsPath, moved); err != nil {
+		errs = append(errs, err)
+	}
 	self.printAlarms()
 	return errs.If()
 }
 
 func (self *Fork) processStructOuts(pipestancePath, outsPath string,
-	outputs json.RawMessage) (LazyArgumentMap, error) {
+	outputs json.RawMessage, moved *movedOuts) (LazyArgumentMap, error) {
 	var errs syntax.ErrorList
 	paramList := self.OutParams().List
 	for _, p := range paramList {
@@ -120,7 +126,7 @@ func (self *Fork) processStructOuts(pipestancePath, outsPath string,
 		errs = append(errs, err)
 	}
 
-	newOuts, oerrs := self.handleOuts(paramList, outs, pipestancePath, outsPath)
+	newOuts, oerrs := self.handleOuts(paramList, outs, pipestancePath, outsPath, moved)
 	if len(oerrs) != 0 {
 		if len(errs) == 0 {
 			errs = oerrs
@@ -135,7 +141,8 @@ func (self *Fork) processStructOuts(pipestancePath, outsPath string,
 
 func (self *Fork) handleOuts(paramList []*syntax.OutParam,
 	outs LazyArgumentMap,
-	pipestancePath, outsPath string) (LazyArgumentMap, syntax.ErrorList) {
+	pipestancePath, outsPath string,
+	moved *movedOuts) (LazyArgumentMap, syntax.ErrorList) {
 	var errs syntax.ErrorList
 
 	// Calculate longest key name for alignment
@@ -154,7 +161,7 @@ func (self *Fork) handleOuts(paramList []*syntax.OutParam,
 					result.Grow(len(out))
 					err := moveOutFiles(&result,
 						&param.StructMember, k, out, self.node.top.types,
-						pipestancePath, outsPath)
+						pipestancePath, outsPath, moved)
 					if err != nil {
 						errs = append(errs, err)
 					}
@@ -211,7 +218,7 @@ func moveOutFiles(w *bytes.Buffer, param *syntax.StructMember,
 	isFile syntax.FileKind,
 	value json.RawMessage,
 	lookup *syntax.TypeLookup,
-	pipestancePath, outsPath string) error {
+	pipestancePath, outsPath string, moved *movedOuts) error {
 	if value == nil || bytes.Equal(value, nullBytes) {
 		_, err := w.Write(nullBytes)
 		return err
@@ -219,11 +226,11 @@ func moveOutFiles(w *bytes.Buffer, param *syntax.StructMember,
 	switch isFile {
 	case syntax.KindIsFile:
 		return moveOutFile(w, param,
-			value, pipestancePath, outsPath)
+			value, pipestancePath, outsPath, moved)
 	case syntax.KindIsDirectory:
 		return moveOutDir(w,
 			value, param, lookup,
-			pipestancePath, outsPath)
+			pipestancePath, outsPath, moved)
 	default:
 		_, err := w.Write(value)
 		return err
@@ -232,7 +239,7 @@ func moveOutFiles(w *bytes.Buffer, param *syntax.StructMember,
 
 func moveOutDir(w *bytes.Buffer, value json.RawMessage,
 	member *syntax.StructMember, lookup *syntax.TypeLookup,
-	pipestancePath, outsPath string) error {
+	pipestancePath, outsPath string, moved *movedOuts) error {
 	t := lookup.Get(member.Tname)
 	outPath := path.Join(outsPath, member.GetOutFilename())
 	if member.Tname.ArrayDim > 0 {
@@ -244,7 +251,7 @@ func moveOutDir(w *bytes.Buffer, value json.RawMessage,
 		} else {
 			return moveOutArrayDir(w, value,
 				at, member, lookup,
-				pipestancePath, outPath)
+				pipestancePath, outPath, moved)
 		}
 	}
 	var valueMap LazyArgumentMap
@@ -305,7 +312,8 @@ func moveOutDir(w *bytes.Buffer, value json.RawMessage,
 				valueMap[k],
 				lookup,
 				pipestancePath,
-				outPath); err != nil {
+				outPath,
+				moved); err != nil {
 				errs = append(errs, err)
 			}
 		}
@@ -324,7 +332,8 @@ func moveOutDir(w *bytes.Buffer, value json.RawMessage,
 				valueMap[k],
 				lookup,
 				pipestancePath,
-				outPath); err != nil {
+				outPath,
+				moved); err != nil {
 				errs = append(errs, err)
 			}
 		}
@@ -340,7 +349,7 @@ func moveOutDir(w *bytes.Buffer, value json.RawMessage,
 func moveOutArrayDir(w *bytes.Buffer, value json.RawMessage,
 	t *syntax.ArrayType,
 	member *syntax.StructMember, lookup *syntax.TypeLookup,
-	pipestancePath, outPath string) error {
+	pipestancePath, outPath string, moved *movedOuts) error {
 	var valueArr []json.RawMessage
 	if err := json.Unmarshal(value, &valueArr); err != nil {
 		if err := fmtJson(w, value); err != nil {
@@ -376,7 +385,8 @@ func moveOutArrayDir(w *bytes.Buffer, value json.RawMessage,
 			v,
 			lookup,
 			pipestancePath,
-			outPath); err != nil {
+			outPath,
+			moved); err != nil {
 			errs = append(errs, err)
 		}
 	}
@@ -388,7 +398,8 @@ func moveOutArrayDir(w *bytes.Buffer, value json.RawMessage,
 
 // Move files to the top-level pipestance outs directory.
 func moveOutFile(w *bytes.Buffer, param *syntax.StructMember,
-	value json.RawMessage, pipestancePath, outsPath string) error {
+	value json.RawMessage, pipestancePath, outsPath string,
+	moved *movedOuts) error {
 	var filePath string
 	if err := json.Unmarshal(value, &filePath); err != nil {
 		if _, err := w.Write(value); err != nil {
@@ -415,7 +426,7 @@ func moveOutFile(w *bytes.Buffer, param *syntax.StructMember,
 			return err
 		}
 		// The source is a symlink, so we will put a symlink in outs/
-		return copyOutSymlink(w, param, value, filePath, pipestancePath, outsPath)
+		return copyOutSymlink(w, param, value, filePath, pipestancePath, outsPath, moved)
 	}
 
 	// Generate the outs path for this param
@@ -431,6 +442,7 @@ func moveOutFile(w *bytes.Buffer, param *syntax.StructMember,
 				if err := os.MkdirAll(outsPath, 0775); err != nil {
 					return err
 				}
+				moved.add(absFilePath, outPath)
 				// But we still want a symlink in outs/
 				return os.Symlink(absFilePath, outPath)
 			}
@@ -439,6 +451,7 @@ func moveOutFile(w *bytes.Buffer, param *syntax.StructMember,
 
 	// If this param has already been moved to outs/, we're done
 	if _, err := os.Stat(outPath); err == nil {
+		moved.add(filePath, outPath)
 		_, err := w.Write(value)
 		return err
 	}
@@ -452,6 +465,7 @@ func moveOutFile(w *bytes.Buffer, param *syntax.StructMember,
 		}
 		return err
 	}
+	moved.add(filePath, outPath)
 
 	// Generate the relative path from files/ to outs/
 	relPath, err := filepath.Rel(filepath.Dir(filePath), outPath)
@@ -485,7 +499,8 @@ func moveOutFile(w *bytes.Buffer, param *syntax.StructMember,
 // is simple, but if it was relative it needs to be converted to be relative
 // to the location in the outs directory.
 func copyOutSymlink(w *bytes.Buffer, param *syntax.StructMember,
-	value json.RawMessage, filePath, pipestancePath, outsPath string) error {
+	value json.RawMessage, filePath, pipestancePath, outsPath string,
+	moved *movedOuts) error {
 	// Generate the outs path for this param
 	outPath := path.Join(outsPath, param.GetOutFilename())
 
@@ -496,6 +511,7 @@ func copyOutSymlink(w *bytes.Buffer, param *syntax.StructMember,
 				if _, err := w.Write(value); err != nil {
 					return err
 				}
+				moved.add(absFilePath, outPath)
 				// But we still want a symlink
 				return os.Symlink(absFilePath, outPath)
 			}
@@ -504,6 +520,7 @@ func copyOutSymlink(w *bytes.Buffer, param *syntax.StructMember,
 
 	// If this param has already been moved to outs/, we're done
 	if _, err := os.Stat(outPath); err == nil {
+		moved.add(filePath, outPath)
 		if b, err := json.Marshal(outPath); err != nil {
 			if _, err := w.Write(value); err != nil {
 				return err
@@ -547,6 +564,7 @@ func copyOutSymlink(w *bytes.Buffer, param *syntax.StructMember,
 		if _, err := w.Write(pb); err != nil {
 			return err
 		}
+		moved.add(filePath, outPath)
 		if filepath.IsAbs(p) {
 			return os.Symlink(p, outPath)
 		} else if rel, err := filepath.Rel(filepath.Dir(outPath), ap); err != nil {
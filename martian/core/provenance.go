@@ -0,0 +1,506 @@
+// Copyright (c) 2020 10X Genomics, Inc. All rights reserved.
+
+package core
+
+//
+// Exporting the provenance of a completed pipestance as an RO-Crate.
+//
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/martian-lang/martian/martian/syntax"
+	"github.com/martian-lang/martian/martian/util"
+)
+
+// RoCrateMetadataFile is the name of the RO-Crate metadata descriptor, which
+// must be in the root directory of the crate.
+const RoCrateMetadataFile = "ro-crate-metadata.json"
+
+const (
+	roCrateContext = "https://w3id.org/ro/crate/1.1/context"
+	roCrateSpec    = "https://w3id.org/ro/crate/1.1"
+)
+
+// RoCrateRef is a reference to another entity in an RO-Crate.
+type RoCrateRef struct {
+	Id string `json:"@id"`
+}
+
+// RoCrateType is the @type of an RO-Crate entity, which may have more than
+// one type.
+type RoCrateType []string
+
+func (t RoCrateType) MarshalJSON() ([]byte, error) {
+	if len(t) == 1 {
+		return json.Marshal(t[0])
+	}
+	return json.Marshal([]string(t))
+}
+
+func (t *RoCrateType) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*t = RoCrateType{s}
+		return nil
+	}
+	return json.Unmarshal(b, (*[]string)(t))
+}
+
+// RoCrateEntity is an entity in the @graph of an RO-Crate.  Only the
+// properties used to describe pipestances are included.
+type RoCrateEntity struct {
+	Id   string      `json:"@id"`
+	Type RoCrateType `json:"@type"`
+
+	Name        string `json:"name,omitempty"`
+	Description string `json:"description,omitempty"`
+	Identifier  string `json:"identifier,omitempty"`
+	Version     string `json:"version,omitempty"`
+
+	// Properties of the metadata descriptor.
+	ConformsTo *RoCrateRef `json:"conformsTo,omitempty"`
+	About      *RoCrateRef `json:"about,omitempty"`
+
+	// Properties of the root dataset.
+	DatePublished string       `json:"datePublished,omitempty"`
+	HasPart       []RoCrateRef `json:"hasPart,omitempty"`
+	Mentions      []RoCrateRef `json:"mentions,omitempty"`
+
+	// Properties of software.
+	ProgrammingLanguage  string       `json:"programmingLanguage,omitempty"`
+	SoftwareRequirements []RoCrateRef `json:"softwareRequirements,omitempty"`
+
+	// Properties of actions.
+	Instrument   *RoCrateRef  `json:"instrument,omitempty"`
+	Object       []RoCrateRef `json:"object,omitempty"`
+	Result       []RoCrateRef `json:"result,omitempty"`
+	StartTime    string       `json:"startTime,omitempty"`
+	EndTime      string       `json:"endTime,omitempty"`
+	Location     []string     `json:"location,omitempty"`
+	ActionStatus *RoCrateRef  `json:"actionStatus,omitempty"`
+
+	// Properties of files.
+	ContentSize    string `json:"contentSize,omitempty"`
+	Sha256         string `json:"sha256,omitempty"`
+	EncodingFormat string `json:"encodingFormat,omitempty"`
+}
+
+// RoCrate is the content of an RO-Crate metadata file.
+type RoCrate struct {
+	Context string           `json:"@context"`
+	Graph   []*RoCrateEntity `json:"@graph"`
+}
+
+// Entity returns the entity with the given @id, or nil.
+func (self *RoCrate) Entity(id string) *RoCrateEntity {
+	for _, e := range self.Graph {
+		if e.Id == id {
+			return e
+		}
+	}
+	return nil
+}
+
+// provenanceBuilder accumulates the RO-Crate entities for a pipestance.
+type provenanceBuilder struct {
+	psdir string
+
+	// The pipestance directory when the pipestance ran, which paths in the
+	// metadata are relative to.
+	origPsdir string
+
+	// The path to the metadata zip, if the pipestance metadata was zipped.
+	zipPath string
+
+	files    map[string]*RoCrateEntity
+	entities []*RoCrateEntity
+	actions  []RoCrateRef
+
+	// The actions for the forks of each stage, by stage fqname.
+	stageActions map[string][]*RoCrateEntity
+}
+
+// ExportProvenance describes a pipestance as an RO-Crate, with an action for
+// the pipeline run and for each stage fork which ran, linked to the files
+// which they used and generated.
+//
+// Files are identified by their path relative to the pipestance directory,
+// or by a file:// URL if they are outside of it.  Stage arguments and outputs
+// are assumed to be files if they are absolute paths.
+func ExportProvenance(psdir string) (*RoCrate, error) {
+	b := provenanceBuilder{
+		psdir:        psdir,
+		files:        make(map[string]*RoCrateEntity),
+		stageActions: make(map[string][]*RoCrateEntity),
+	}
+	var nodes []*NodeInfo
+	if err := NewMetadata("", psdir).ReadInto(FinalState, &nodes); err != nil {
+		return nil, fmt.Errorf("reading pipestance state: %w", err)
+	}
+	var top *NodeInfo
+	for _, node := range nodes {
+		if strings.Count(node.Fqname, ".") == 2 {
+			top = node
+			break
+		}
+	}
+	if top == nil {
+		return nil, fmt.Errorf("no top-level pipeline in %s", FinalState.FileName())
+	}
+	b.origPsdir = path.Dir(top.Path)
+	zipPath := path.Join(psdir, MetadataZip.FileName())
+	if _, err := os.Stat(zipPath); err == nil {
+		b.zipPath = zipPath
+	}
+
+	var versions VersionInfo
+	if data, err := b.read(VersionsFile.FileName()); err == nil {
+		if err := json.Unmarshal(data, &versions); err != nil {
+			util.LogError(err, "provenance", "Could not parse versions.")
+		}
+	}
+	// If the pipestance did not record the version of Martian which ran it,
+	// leave it out rather than guess.
+	b.add(&RoCrateEntity{
+		Id:      "#martian",
+		Type:    RoCrateType{"SoftwareApplication"},
+		Name:    "Martian",
+		Version: versions.Martian,
+	})
+
+	parts := strings.SplitN(top.Fqname, ".", 3)
+	psid, pipeline := parts[1], parts[2]
+
+	// The pipeline source and invocation.
+	workflow := &RoCrateEntity{
+		Id:                   MroSourceFile.FileName(),
+		Type:                 RoCrateType{"File", "SoftwareSourceCode", "ComputationalWorkflow"},
+		Name:                 pipeline,
+		ProgrammingLanguage:  "Martian MRO",
+		Version:              versions.Pipelines,
+		SoftwareRequirements: []RoCrateRef{{Id: "#martian"}},
+		EncodingFormat:       "text/plain",
+	}
+	b.files[workflow.Id] = workflow
+	invocation := b.file(path.Join(b.origPsdir, InvocationFile.FileName()))
+	invocation.Name = "Pipeline invocation"
+	invocation.EncodingFormat = "text/plain"
+
+	// Stage executions.
+	stages := make(map[string]*RoCrateEntity)
+	for _, node := range nodes {
+		if node.Type != syntax.KindStage {
+			continue
+		}
+		code := stages[node.Name]
+		if code == nil {
+			code = &RoCrateEntity{
+				Id:                  "#stage/" + node.Name,
+				Type:                RoCrateType{"SoftwareApplication"},
+				Name:                node.Name,
+				Identifier:          node.StagecodeCmd,
+				ProgrammingLanguage: node.StagecodeLang.String(),
+			}
+			stages[node.Name] = code
+			b.add(code)
+		}
+		for _, fork := range node.Forks {
+			if fork.State != Complete && fork.State != Failed {
+				continue
+			}
+			b.addStageAction(node, fork, code)
+		}
+	}
+
+	// The pipeline run as a whole.
+	run := &RoCrateEntity{
+		Id:           "#run",
+		Type:         RoCrateType{"CreateAction"},
+		Name:         "Run of " + pipeline + " in pipestance " + psid,
+		Instrument:   &RoCrateRef{Id: workflow.Id},
+		Object:       []RoCrateRef{{Id: invocation.Id}},
+		ActionStatus: actionStatus(top.State),
+	}
+	if data, err := b.read(TimestampFile.FileName()); err == nil {
+		run.StartTime, run.EndTime = parseTimestamps(string(data))
+	}
+	if len(top.Forks) > 0 && top.Forks[0].Bindings != nil {
+		for _, arg := range top.Forks[0].Bindings.Argument {
+			run.Object = append(run.Object, b.fileRefs(arg.Value)...)
+		}
+	}
+	if manifest, err := ReadOutsManifest(psdir); err == nil {
+		for _, e := range manifest.Files {
+			f := b.file(path.Join(b.origPsdir, "outs", e.Path))
+			f.ContentSize = fmt.Sprint(e.Size)
+			f.Sha256 = e.Sha256
+			run.Result = append(run.Result, RoCrateRef{Id: f.Id})
+			// The manifest doesn't say which fork of a stage produced the
+			// file, so it can only be attributed to stages with one fork.
+			if actions := b.stageActions[e.Stage]; len(actions) == 1 {
+				actions[0].Result = appendRef(actions[0].Result, f.Id)
+			}
+		}
+	} else if len(top.Forks) > 0 && top.Forks[0].Metadata != nil {
+		run.Result = b.fileRefsIn(path.Join(top.Forks[0].Metadata.Path,
+			OutsFile.FileName()))
+	}
+	b.add(run)
+	b.actions = append([]RoCrateRef{{Id: run.Id}}, b.actions...)
+
+	return b.crate(psid, pipeline), nil
+}
+
+// addStageAction adds the action for one fork of a stage.
+func (self *provenanceBuilder) addStageAction(node *NodeInfo,
+	fork *ForkInfo, code *RoCrateEntity) {
+	fqname := node.Fqname
+	if fork.Metadata != nil {
+		if id, err := filepath.Rel(node.Path, fork.Metadata.Path); err == nil {
+			fqname += "." + id
+		}
+	}
+	action := &RoCrateEntity{
+		Id:           "#" + fqname,
+		Type:         RoCrateType{"CreateAction"},
+		Name:         fqname,
+		Instrument:   &RoCrateRef{Id: code.Id},
+		ActionStatus: actionStatus(fork.State),
+	}
+	// The arguments to the split are the stage arguments.  Without a split,
+	// the single chunk has them.
+	var jobs []*MetadataInfo
+	if fork.SplitMetadata != nil && len(fork.SplitMetadata.Names) > 0 {
+		jobs = append(jobs, fork.SplitMetadata)
+	}
+	for _, chunk := range fork.Chunks {
+		if chunk.Metadata != nil {
+			jobs = append(jobs, chunk.Metadata)
+		}
+	}
+	if fork.JoinMetadata != nil && len(fork.JoinMetadata.Names) > 0 {
+		jobs = append(jobs, fork.JoinMetadata)
+	}
+	if len(jobs) > 0 {
+		action.Object = self.fileRefsIn(path.Join(jobs[0].Path,
+			ArgsFile.FileName()))
+	}
+	if fork.Metadata != nil {
+		action.Result = self.fileRefsIn(path.Join(fork.Metadata.Path,
+			OutsFile.FileName()))
+	}
+	var start, end time.Time
+	for _, job := range jobs {
+		data, err := self.read(self.rel(path.Join(job.Path, JobInfoFile.FileName())))
+		if err != nil {
+			continue
+		}
+		var info JobInfo
+		if err := json.Unmarshal(data, &info); err != nil {
+			continue
+		}
+		if info.Host != "" {
+			found := false
+			for _, h := range action.Location {
+				if h == info.Host {
+					found = true
+					break
+				}
+			}
+			if !found {
+				action.Location = append(action.Location, info.Host)
+			}
+		}
+		if wc := info.WallClockInfo; wc != nil {
+			if t := time.Time(wc.Start); !t.IsZero() && (start.IsZero() || t.Before(start)) {
+				start = t
+			}
+			if t := time.Time(wc.End); t.After(end) {
+				end = t
+			}
+		}
+	}
+	if !start.IsZero() {
+		action.StartTime = start.Format(time.RFC3339)
+	}
+	if !end.IsZero() {
+		action.EndTime = end.Format(time.RFC3339)
+	}
+	self.add(action)
+	self.actions = append(self.actions, RoCrateRef{Id: action.Id})
+	self.stageActions[node.Fqname] = append(self.stageActions[node.Fqname], action)
+}
+
+func (self *provenanceBuilder) add(e *RoCrateEntity) {
+	self.entities = append(self.entities, e)
+}
+
+// rel returns the path relative to the pipestance directory, or the empty
+// string if it is outside of the pipestance.
+func (self *provenanceBuilder) rel(p string) string {
+	rel, err := filepath.Rel(self.origPsdir, p)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+		return ""
+	}
+	return rel
+}
+
+// read reads a file given relative to the pipestance directory, from the
+// metadata zip if it is not on disk.
+func (self *provenanceBuilder) read(rel string) ([]byte, error) {
+	if rel == "" {
+		return nil, os.ErrNotExist
+	}
+	data, err := os.ReadFile(path.Join(self.psdir, rel))
+	if err != nil && os.IsNotExist(err) && self.zipPath != "" {
+		if zdata, zerr := util.ReadZip(self.zipPath, rel); zerr == nil {
+			return zdata, nil
+		}
+	}
+	return data, err
+}
+
+// file returns the entity for a file, creating it if needed.
+func (self *provenanceBuilder) file(p string) *RoCrateEntity {
+	id := self.rel(p)
+	if id == "" {
+		id = (&url.URL{Scheme: "file", Path: p}).String()
+	}
+	f := self.files[id]
+	if f == nil {
+		f = &RoCrateEntity{
+			Id:   id,
+			Type: RoCrateType{"File"},
+			Name: path.Base(p),
+		}
+		self.files[id] = f
+	}
+	return f
+}
+
+// fileRefs returns references to the files named by absolute paths in a
+// json value.
+func (self *provenanceBuilder) fileRefs(v interface{}) []RoCrateRef {
+	var refs []RoCrateRef
+	var walk func(v interface{})
+	walk = func(v interface{}) {
+		switch v := v.(type) {
+		case string:
+			if path.IsAbs(v) {
+				refs = appendRef(refs, self.file(path.Clean(v)).Id)
+			}
+		case []interface{}:
+			for _, elem := range v {
+				walk(elem)
+			}
+		case map[string]interface{}:
+			keys := make([]string, 0, len(v))
+			for k := range v {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				walk(v[k])
+			}
+		}
+	}
+	walk(v)
+	return refs
+}
+
+// fileRefsIn returns references to the files named in a metadata json file.
+func (self *provenanceBuilder) fileRefsIn(p string) []RoCrateRef {
+	data, err := self.read(self.rel(p))
+	if err != nil {
+		return nil
+	}
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil
+	}
+	return self.fileRefs(v)
+}
+
+func (self *provenanceBuilder) crate(psid, pipeline string) *RoCrate {
+	fileIds := make([]string, 0, len(self.files))
+	for id := range self.files {
+		fileIds = append(fileIds, id)
+	}
+	sort.Strings(fileIds)
+	root := &RoCrateEntity{
+		Id:            "./",
+		Type:          RoCrateType{"Dataset"},
+		Name:          psid,
+		Description:   "Martian pipestance " + psid + " of " + pipeline,
+		DatePublished: time.Now().Format(time.RFC3339),
+		HasPart:       make([]RoCrateRef, len(fileIds)),
+		Mentions:      self.actions,
+	}
+	for i, id := range fileIds {
+		root.HasPart[i] = RoCrateRef{Id: id}
+	}
+	graph := make([]*RoCrateEntity, 0, 2+len(self.entities)+len(fileIds))
+	graph = append(graph, &RoCrateEntity{
+		Id:         RoCrateMetadataFile,
+		Type:       RoCrateType{"CreativeWork"},
+		ConformsTo: &RoCrateRef{Id: roCrateSpec},
+		About:      &RoCrateRef{Id: root.Id},
+	}, root)
+	graph = append(graph, self.entities...)
+	for _, id := range fileIds {
+		graph = append(graph, self.files[id])
+	}
+	return &RoCrate{
+		Context: roCrateContext,
+		Graph:   graph,
+	}
+}
+
+func appendRef(refs []RoCrateRef, id string) []RoCrateRef {
+	for _, r := range refs {
+		if r.Id == id {
+			return refs
+		}
+	}
+	return append(refs, RoCrateRef{Id: id})
+}
+
+func actionStatus(state MetadataState) *RoCrateRef {
+	switch state {
+	case Complete:
+		return &RoCrateRef{Id: "http://schema.org/CompletedActionStatus"}
+	case Failed:
+		return &RoCrateRef{Id: "http://schema.org/FailedActionStatus"}
+	}
+	return &RoCrateRef{Id: "http://schema.org/ActiveActionStatus"}
+}
+
+// parseTimestamps parses the start and end times from a pipestance's
+// _timestamp file, in RFC 3339 format.
+func parseTimestamps(data string) (string, string) {
+	var start, end string
+	for _, line := range strings.Split(data, "\n") {
+		var dest *string
+		if strings.HasPrefix(line, "start:") {
+			dest = &start
+		} else if strings.HasPrefix(line, "end:") {
+			dest = &end
+		} else {
+			continue
+		}
+		ts := strings.TrimSpace(line[strings.IndexByte(line, ':')+1:])
+		if t, err := time.ParseInLocation(util.TIMEFMT, ts, time.Local); err == nil {
+			*dest = t.Format(time.RFC3339)
+		}
+	}
+	return start, end
+}
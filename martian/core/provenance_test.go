@@ -0,0 +1,97 @@
+// Copyright (c) 2020 10X Genomics, Inc. All rights reserved.
+
+package core
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/martian-lang/martian/martian/util"
+)
+
+func TestExportProvenance(t *testing.T) {
+	util.SetPrintLogger(&devNull)
+	rtOpts := DefaultRuntimeOptions()
+	psdir, pipestance := runTestPipestance(t, &rtOpts, "provenance")
+	pipestance.PostProcess()
+
+	crate, err := ExportProvenance(psdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := json.Marshal(crate); err != nil {
+		t.Fatal(err)
+	}
+	root := crate.Entity("./")
+	if root == nil {
+		t.Fatal("no root dataset")
+	}
+	if len(root.Mentions) != 3 {
+		t.Errorf("expected 3 actions, got %v", root.Mentions)
+	}
+	if e := crate.Entity(RoCrateMetadataFile); e == nil || e.About == nil ||
+		e.About.Id != "./" {
+		t.Error("missing or incorrect metadata descriptor")
+	}
+	if run := crate.Entity("#run"); run == nil {
+		t.Error("no pipeline run action")
+	} else if run.Instrument == nil || run.Instrument.Id != MroSourceFile.FileName() {
+		t.Errorf("incorrect pipeline instrument %v", run.Instrument)
+	}
+	mk := crate.Entity("#ID.provenance.PROVENANCE.MAKE.fork0")
+	use := crate.Entity("#ID.provenance.PROVENANCE.USE.fork0")
+	if mk == nil || use == nil {
+		t.Fatal("missing stage actions")
+	}
+	if mk.Instrument == nil || mk.Instrument.Id != "#stage/MAKE" {
+		t.Errorf("incorrect stage instrument %v", mk.Instrument)
+	}
+	if mk.ActionStatus == nil ||
+		mk.ActionStatus.Id != "http://schema.org/CompletedActionStatus" {
+		t.Errorf("incorrect action status %v", mk.ActionStatus)
+	}
+	// The file generated by MAKE should be used by USE.
+	var file string
+	for _, r := range mk.Result {
+		if strings.HasSuffix(r.Id, "/files/file.txt") {
+			file = r.Id
+		}
+	}
+	if file == "" {
+		t.Fatalf("expected file.txt in MAKE results %v", mk.Result)
+	} else if !strings.HasPrefix(file, "PROVENANCE/MAKE/fork0/") {
+		t.Errorf("expected a path relative to the pipestance, got %s", file)
+	}
+	found := false
+	for _, r := range use.Object {
+		if r.Id == file {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("expected %s in USE objects %v", file, use.Object)
+	}
+	if f := crate.Entity(file); f == nil {
+		t.Errorf("no entity for %s", file)
+	}
+	if m := crate.Entity("#martian"); m == nil {
+		t.Error("no martian entity")
+	} else if m.Version != rtOpts.MartianVersion {
+		t.Errorf("incorrect martian version %q", m.Version)
+	}
+
+	// Without a recorded version, the version should not be guessed.
+	if err := os.Remove(path.Join(psdir, VersionsFile.FileName())); err != nil {
+		t.Fatal(err)
+	}
+	if crate, err := ExportProvenance(psdir); err != nil {
+		t.Error(err)
+	} else if m := crate.Entity("#martian"); m == nil {
+		t.Error("no martian entity")
+	} else if m.Version != "" {
+		t.Errorf("expected no martian version, got %q", m.Version)
+	}
+}
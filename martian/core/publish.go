@@ -0,0 +1,318 @@
+// Copyright (c) 2020 10X Genomics, Inc. All rights reserved.
+
+package core
+
+//
+// Uploading the top-level outputs of a completed pipestance.
+//
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/martian-lang/martian/martian/util"
+)
+
+// A Publisher uploads files to a destination with a given URI scheme.
+type Publisher interface {
+	// Publish writes the content read from r, which is size bytes long, to
+	// the given URI.
+	Publish(ctx context.Context, uri *url.URL, r io.Reader, size int64,
+		contentType string) error
+}
+
+// FilePublisher publishes to file URIs, for example on a shared filesystem.
+type FilePublisher struct{}
+
+func (FilePublisher) Publish(ctx context.Context, uri *url.URL, r io.Reader,
+	size int64, contentType string) error {
+	dst := uri.Path
+	if err := os.MkdirAll(path.Dir(dst), 0775); err != nil {
+		return err
+	}
+	tmp := dst + ".partial"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dst)
+}
+
+// HttpPublisher publishes http and https URIs with PUT requests, as accepted
+// by WebDAV servers and S3-compatible object stores with presigned or public
+// write access.
+type HttpPublisher struct {
+	// The client to use.  If nil, http.DefaultClient is used.
+	Client *http.Client
+}
+
+func (p HttpPublisher) Publish(ctx context.Context, uri *url.URL, r io.Reader,
+	size int64, contentType string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, uri.String(), r)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", contentType)
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("server returned %s", resp.Status)
+	}
+	_, err = io.Copy(io.Discard, resp.Body)
+	return err
+}
+
+// CommandPublisher publishes URIs by running a command with "-" and the URI
+// appended to its arguments, which reads the content from standard input.
+type CommandPublisher struct {
+	Command []string
+
+	// If set, this flag and the content type are appended to the arguments.
+	ContentTypeFlag string
+}
+
+func (p CommandPublisher) Publish(ctx context.Context, uri *url.URL, r io.Reader,
+	size int64, contentType string) error {
+	if len(p.Command) == 0 {
+		return fmt.Errorf("no command to publish %s URIs", uri.Scheme)
+	}
+	args := make([]string, 0, len(p.Command)+3)
+	args = append(args, p.Command[1:]...)
+	args = append(args, "-", uri.String())
+	if p.ContentTypeFlag != "" {
+		args = append(args, p.ContentTypeFlag, contentType)
+	}
+	cmd := exec.CommandContext(ctx, p.Command[0], args...)
+	cmd.Stdin = r
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("%s: %w: %s", p.Command[0], err, msg)
+		}
+		return fmt.Errorf("%s: %w", p.Command[0], err)
+	}
+	return nil
+}
+
+var (
+	publishersLock sync.RWMutex
+	publishers     = map[string]Publisher{
+		"file":  FilePublisher{},
+		"http":  HttpPublisher{},
+		"https": HttpPublisher{},
+		"s3": CommandPublisher{
+			Command:         []string{"aws", "s3", "cp"},
+			ContentTypeFlag: "--content-type",
+		},
+		"gs": CommandPublisher{
+			Command:         []string{"gcloud", "storage", "cp"},
+			ContentTypeFlag: "--content-type",
+		},
+	}
+)
+
+// RegisterPublisher sets the publisher used for destinations with the given
+// URI scheme.  Registering a nil publisher removes support for the scheme.
+func RegisterPublisher(scheme string, p Publisher) {
+	scheme = strings.ToLower(scheme)
+	publishersLock.Lock()
+	defer publishersLock.Unlock()
+	if p == nil {
+		delete(publishers, scheme)
+	} else {
+		publishers[scheme] = p
+	}
+}
+
+func getPublisher(scheme string) Publisher {
+	publishersLock.RLock()
+	defer publishersLock.RUnlock()
+	return publishers[strings.ToLower(scheme)]
+}
+
+// ParsePublishUri parses a destination for published outputs.  Absolute
+// paths are treated as file URIs.
+func ParsePublishUri(value string) (*url.URL, error) {
+	if filepath.IsAbs(value) {
+		return &url.URL{Scheme: "file", Path: path.Clean(value)}, nil
+	}
+	uri, err := url.Parse(value)
+	if err != nil {
+		return nil, err
+	}
+	if uri.Scheme == "" {
+		return nil, fmt.Errorf("%s is not a URI or absolute path", value)
+	}
+	if getPublisher(uri.Scheme) == nil {
+		return nil, fmt.Errorf("publishing to %s URIs is not supported",
+			uri.Scheme)
+	}
+	if uri.Fragment != "" || uri.RawQuery != "" {
+		return nil, fmt.Errorf("%s may not have a query or fragment", value)
+	}
+	return uri, nil
+}
+
+// PublishConfig is the publish section of the job manager configuration.
+type PublishConfig struct {
+	// The number of times to retry a failed upload.
+	Retries int `json:"retries,omitempty"`
+
+	// The time to wait before the first retry.  The wait doubles for each
+	// subsequent retry.
+	RetryWaitSecs float64 `json:"retry_wait_secs,omitempty"`
+
+	// Content types for published files, keyed by extension, which is
+	// usually the name of the file type in mro.  Extensions which are not
+	// listed here fall back to the system mime types, or
+	// application/octet-stream.
+	ContentTypes map[string]string `json:"content_types,omitempty"`
+}
+
+// contentType returns the content type for a file name.
+func (c *PublishConfig) contentType(fn string) string {
+	ext := path.Ext(fn)
+	if ext == "" {
+		return "application/octet-stream"
+	}
+	if c != nil {
+		if t := c.ContentTypes[ext[1:]]; t != "" {
+			return t
+		}
+	}
+	if t := mime.TypeByExtension(ext); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
+
+// PublishedFile records a top-level output file which was published.
+type PublishedFile struct {
+	// The path to the file, relative to the outs directory.
+	Path string `json:"path"`
+
+	Uri         string `json:"uri"`
+	ContentType string `json:"content_type"`
+
+	// If set, the upload failed with this error, after retrying.
+	Error string `json:"error,omitempty"`
+}
+
+// publishFile uploads a file, retrying as configured.
+func publishFile(ctx context.Context, p Publisher, uri *url.URL,
+	fn, contentType string, config *PublishConfig) error {
+	var retries int
+	var wait time.Duration
+	if config != nil {
+		retries = config.Retries
+		wait = time.Duration(config.RetryWaitSecs * float64(time.Second))
+	}
+	for attempt := 0; ; attempt++ {
+		err := func() error {
+			f, err := os.Open(fn)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			info, err := f.Stat()
+			if err != nil {
+				return err
+			}
+			return p.Publish(ctx, uri, f, info.Size(), contentType)
+		}()
+		if err == nil || attempt >= retries || ctx.Err() != nil {
+			return err
+		}
+		util.LogInfo("publish", "Upload of %s failed (%v).  Retrying.",
+			uri.Redacted(), err)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		wait *= 2
+	}
+}
+
+// PublishOuts uploads the files listed in the outs manifest of the pipestance
+// to the given destination, at the same paths relative to it as they are in
+// the outs directory, followed by the manifest itself.  The results are
+// recorded in the final state of the top-level pipeline.
+func (self *Pipestance) PublishOuts(dest string, ctx context.Context) ([]*PublishedFile, error) {
+	base, err := ParsePublishUri(dest)
+	if err != nil {
+		return nil, err
+	}
+	p := getPublisher(base.Scheme)
+	config := self.node.top.rt.jobConfig.Publish
+	outsDir := path.Join(self.GetPath(), "outs")
+	manifest, err := ReadOutsManifest(self.GetPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	files := make([]string, 0, len(manifest.Files)+1)
+	for _, e := range manifest.Files {
+		files = append(files, e.Path)
+	}
+	files = append(files, OutsManifestFile)
+	published := make([]*PublishedFile, 0, len(files))
+	var failed int
+	for _, rel := range files {
+		uri := *base
+		uri.Path = path.Join(base.Path, rel)
+		result := &PublishedFile{
+			Path:        rel,
+			Uri:         uri.Redacted(),
+			ContentType: config.contentType(rel),
+		}
+		if err := publishFile(ctx, p, &uri, path.Join(outsDir, rel),
+			result.ContentType, config); err != nil {
+			util.LogError(err, "publish", "Failed to publish %s to %s",
+				rel, result.Uri)
+			result.Error = err.Error()
+			failed++
+		} else {
+			util.LogInfo("publish", "Published %s to %s", rel, result.Uri)
+		}
+		published = append(published, result)
+	}
+	self.publishedLock.Lock()
+	self.published = published
+	self.publishedLock.Unlock()
+	if failed > 0 {
+		return published, &RuntimeError{Msg: fmt.Sprintf(
+			"failed to publish %d of %d files to %s",
+			failed, len(files), base.Redacted())}
+	}
+	return published, nil
+}
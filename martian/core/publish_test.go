@@ -0,0 +1,198 @@
+// Copyright (c) 2020 10X Genomics, Inc. All rights reserved.
+
+package core
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"sync"
+	"testing"
+
+	"github.com/martian-lang/martian/martian/util"
+)
+
+func TestParsePublishUri(t *testing.T) {
+	if uri, err := ParsePublishUri("/data/outs/"); err != nil {
+		t.Error(err)
+	} else if uri.Scheme != "file" || uri.Path != "/data/outs" {
+		t.Errorf("incorrect uri %v", uri)
+	}
+	if uri, err := ParsePublishUri("s3://bucket/prefix"); err != nil {
+		t.Error(err)
+	} else if uri.Host != "bucket" || uri.Path != "/prefix" {
+		t.Errorf("incorrect uri %v", uri)
+	}
+	for _, bad := range []string{
+		"relative/path",
+		"unknown://bucket/prefix",
+		"https://example.com/a#frag",
+	} {
+		if _, err := ParsePublishUri(bad); err == nil {
+			t.Errorf("expected an error for %s", bad)
+		}
+	}
+}
+
+func TestPublishOuts(t *testing.T) {
+	util.SetPrintLogger(&devNull)
+	dest := path.Join(t.TempDir(), "published")
+	rtOpts := DefaultRuntimeOptions()
+	rtOpts.PublishUri = dest
+	psdir, pipestance := runTestPipestance(t, &rtOpts, "publish")
+	pipestance.node.top.rt.jobConfig.Publish = &PublishConfig{
+		ContentTypes: map[string]string{"txt": "text/x-test"},
+	}
+	pipestance.PostProcess()
+
+	for _, fn := range []string{"file.txt", OutsManifestFile} {
+		expect, err := os.ReadFile(path.Join(psdir, "outs", fn))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b, err := os.ReadFile(path.Join(dest, fn)); err != nil {
+			t.Error(err)
+		} else if string(b) != string(expect) {
+			t.Errorf("incorrect content for %s", fn)
+		}
+	}
+
+	var nodes []*NodeInfo
+	if err := NewMetadata("", psdir).ReadInto(FinalState, &nodes); err != nil {
+		t.Fatal(err)
+	}
+	var published []*PublishedFile
+	for _, node := range nodes {
+		if node.Fqname == "ID.publish.PUBLISH" {
+			published = node.Published
+		} else if len(node.Published) > 0 {
+			t.Errorf("%s has published files", node.Fqname)
+		}
+	}
+	if len(published) != 2 {
+		t.Fatalf("expected 2 published files, got %d", len(published))
+	}
+	if p := published[0]; p.Path != "file.txt" ||
+		p.Uri != "file://"+path.Join(dest, "file.txt") ||
+		p.ContentType != "text/x-test" || p.Error != "" {
+		t.Errorf("incorrect record %+v", p)
+	}
+	if p := published[1]; p.Path != OutsManifestFile ||
+		p.ContentType != "application/json" {
+		t.Errorf("incorrect record %+v", p)
+	}
+}
+
+func TestPublishOutsRetry(t *testing.T) {
+	util.SetPrintLogger(&devNull)
+	var mu sync.Mutex
+	attempts := make(map[string]int)
+	stored := make(map[string]string)
+	types := make(map[string]string)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			http.Error(w, "", http.StatusMethodNotAllowed)
+			return
+		}
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		attempts[r.URL.Path]++
+		if attempts[r.URL.Path] == 1 {
+			// Fail the first attempt at each object, as an overloaded
+			// object store might.
+			http.Error(w, "slow down", http.StatusServiceUnavailable)
+			return
+		}
+		stored[r.URL.Path] = string(b)
+		types[r.URL.Path] = r.Header.Get("Content-Type")
+	}))
+	defer srv.Close()
+
+	rtOpts := DefaultRuntimeOptions()
+	psdir, pipestance := runTestPipestance(t, &rtOpts, "publish")
+	pipestance.PostProcess()
+	ctx := context.Background()
+
+	pipestance.node.top.rt.jobConfig.Publish = &PublishConfig{}
+	published, err := pipestance.PublishOuts(srv.URL+"/bucket/run", ctx)
+	if err == nil {
+		t.Error("expected publishing to fail without retries")
+	}
+	for _, p := range published {
+		if p.Error == "" {
+			t.Errorf("%s was not recorded as failed", p.Path)
+		}
+	}
+
+	attempts = make(map[string]int)
+	pipestance.node.top.rt.jobConfig.Publish = &PublishConfig{
+		Retries: 1,
+	}
+	if _, err := pipestance.PublishOuts(srv.URL+"/bucket/run", ctx); err != nil {
+		t.Fatal(err)
+	}
+	expect, err := os.ReadFile(path.Join(psdir, "outs", "file.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := stored["/bucket/run/file.txt"]; s != string(expect) {
+		t.Errorf("incorrect content %q", s)
+	}
+	if ct := types["/bucket/run/file.txt"]; ct != "text/plain; charset=utf-8" {
+		t.Errorf("incorrect content type %q", ct)
+	}
+	if _, ok := stored["/bucket/run/"+OutsManifestFile]; !ok {
+		t.Error("manifest was not published")
+	}
+	if ps := pipestance.SerializeState(); len(ps[0].Published) != 2 {
+		t.Errorf("expected 2 published files in state, got %d",
+			len(ps[0].Published))
+	}
+}
+
+// Check that the published outputs can be read, as the web server does,
+// while they are being published.
+func TestPublishOutsConcurrentState(t *testing.T) {
+	util.SetPrintLogger(&devNull)
+	rtOpts := DefaultRuntimeOptions()
+	_, pipestance := runTestPipestance(t, &rtOpts, "publish")
+	// Write the outs manifest.
+	pipestance.PostProcess()
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				pipestance.SerializeState()
+			}
+		}
+	}()
+	dest := path.Join(t.TempDir(), "published")
+	for i := 0; i < 3; i++ {
+		if _, err := pipestance.PublishOuts(dest, context.Background()); err != nil {
+			t.Error(err)
+		}
+	}
+	close(done)
+	wg.Wait()
+	var published int
+	for _, info := range pipestance.SerializeState() {
+		published += len(info.Published)
+	}
+	if published != 2 {
+		t.Errorf("expected 2 published files, got %d", published)
+	}
+}
@@ -0,0 +1,437 @@
+// Copyright (c) 2020 10X Genomics, Inc. All rights reserved.
+
+package core
+
+//
+// Moving a pipestance directory and rewriting the paths embedded in it.
+//
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/martian-lang/martian/martian/util"
+)
+
+// RelocateResult summarizes the changes made to relocate a pipestance.
+type RelocateResult struct {
+	Psid string
+
+	// The pipestance directories which were replaced in embedded paths.
+	OldRoots []string
+
+	// The number of metadata files which were rewritten.
+	Files int
+
+	// The number of symlinks which were rewritten.
+	Symlinks int
+}
+
+// readPipestanceRoot returns the pipestance ID and the directory the
+// pipestance was in when it last ran, from its final state.
+func readPipestanceRoot(psdir string) (string, string, error) {
+	var nodes []*NodeInfo
+	if err := NewMetadata("", psdir).ReadInto(FinalState, &nodes); err != nil {
+		return "", "", err
+	}
+	for _, node := range nodes {
+		if parts := strings.Split(node.Fqname, "."); len(parts) == 3 {
+			return parts[1], path.Dir(node.Path), nil
+		}
+	}
+	return "", "", fmt.Errorf("no top-level pipeline in %s", FinalState.FileName())
+}
+
+// RelocatePipestance moves the pipestance directory src to dst, or copies it
+// if keepSrc is true, and rewrites the paths under the old pipestance directory
+// which are embedded in its metadata files, journal and symlinks.  Stage
+// output files are not modified, other than symlinks.
+//
+// The pipestance may already have been moved to src by other means, in which
+// case src and dst may be the same, so long as the pipestance's final state
+// records where it was when it ran.
+//
+// Zipped metadata is extracted so that it can be rewritten, and then zipped
+// again.
+func RelocatePipestance(src, dst string, keepSrc bool) (*RelocateResult, error) {
+	return relocatePipestance(src, dst, keepSrc, nil)
+}
+
+// relocatePipestance is RelocatePipestance, with additional directories
+// which the pipestance is known to have been in.
+func relocatePipestance(src, dst string, keepSrc bool,
+	oldRoots []string) (*RelocateResult, error) {
+	src, err := filepath.Abs(src)
+	if err != nil {
+		return nil, err
+	}
+	dst, err = filepath.Abs(dst)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(path.Join(src, InvocationFile.FileName())); err != nil {
+		return nil, &PipestancePathError{src}
+	}
+	if _, err := os.Lstat(path.Join(src, Lock.FileName())); err == nil {
+		return nil, &RuntimeError{Msg: fmt.Sprintf(
+			"%s is locked.  Make sure mrp is not running in the pipestance, "+
+				"and then remove %s.", src, Lock.FileName())}
+	}
+	result := &RelocateResult{
+		OldRoots: append([]string{src}, oldRoots...),
+	}
+	psid, oldRoot, err := readPipestanceRoot(src)
+	if err == nil {
+		result.Psid = psid
+		result.OldRoots = append(result.OldRoots, oldRoot)
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	} else {
+		result.Psid = path.Base(src)
+	}
+	if resolved, err := filepath.EvalSymlinks(src); err == nil {
+		result.OldRoots = append(result.OldRoots, resolved)
+	}
+	result.OldRoots = relocateRoots(result.OldRoots, dst)
+
+	if src != dst {
+		if pathIsInside(dst, src) {
+			return nil, fmt.Errorf("cannot move %s inside itself", src)
+		}
+		if _, err := os.Lstat(dst); err == nil {
+			return nil, os.ErrExist
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+		if keepSrc {
+			err = copyTree(src, dst)
+		} else if err = os.Rename(src, dst); err != nil {
+			// Probably a different filesystem.
+			if err = copyTree(src, dst); err == nil {
+				err = os.RemoveAll(src)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	zipPath := path.Join(dst, MetadataZip.FileName())
+	var zipped []string
+	if _, err := os.Stat(zipPath); err == nil {
+		if zipped, err = zipEntries(zipPath); err != nil {
+			return result, err
+		}
+		if err := unzipMetadata(zipPath); err != nil {
+			return result, err
+		}
+	}
+
+	r := newPathRelocator(result.OldRoots, dst)
+	err = filepath.WalkDir(dst, func(p string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		name := d.Name()
+		if d.Type()&os.ModeSymlink != 0 {
+			if changed, err := r.relocateSymlink(p); err != nil {
+				return err
+			} else if changed {
+				result.Symlinks++
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+		dir := path.Base(path.Dir(p))
+		if strings.HasPrefix(name, MetadataFilePrefix) {
+			if !relocateMetadataFile(MetadataFileName(name[len(MetadataFilePrefix):])) ||
+				isStageOutputPath(dst, p) {
+				return nil
+			}
+		} else if dir != "journal" || path.Dir(path.Dir(p)) != dst {
+			return nil
+		}
+		if changed, err := r.relocateFile(p); err != nil {
+			return err
+		} else if changed {
+			result.Files++
+		}
+		return nil
+	})
+	if err == nil && len(zipped) > 0 {
+		err = rezipMetadata(zipPath, zipped)
+	}
+	return result, err
+}
+
+// relocateRoots returns the unique old roots which are not the new root,
+// longest first so that they are replaced before any of their prefixes.
+func relocateRoots(roots []string, dst string) []string {
+	seen := make(map[string]struct{}, len(roots))
+	result := roots[:0]
+	for _, r := range roots {
+		r = path.Clean(r)
+		if _, ok := seen[r]; ok || r == dst {
+			continue
+		}
+		seen[r] = struct{}{}
+		result = append(result, r)
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return len(result[i]) > len(result[j])
+	})
+	return result
+}
+
+// relocateMetadataFile returns false for metadata files which either cannot
+// contain paths which need to be relocated, or are logs which are not worth
+// rewriting.
+func relocateMetadataFile(name MetadataFileName) bool {
+	switch name {
+	case MetadataZip, PerfData, ProfileOut,
+		StdOut, StdErr, LogFile,
+		Heartbeat, UiPort:
+		return false
+	}
+	return true
+}
+
+// isStageOutputPath returns true if p is in a files or outs directory, where
+// files belong to stages rather than the runtime.
+func isStageOutputPath(psdir, p string) bool {
+	rel, err := filepath.Rel(psdir, p)
+	if err != nil {
+		return false
+	}
+	for _, part := range strings.Split(path.Dir(rel), "/") {
+		if part == "files" || part == "outs" {
+			return true
+		}
+	}
+	return false
+}
+
+// unzipMetadata extracts the zipped metadata for a pipestance, and removes
+// the zip file.
+func unzipMetadata(zipPath string) error {
+	if err := util.UnzipIgnoreExisting(zipPath); err != nil {
+		return err
+	}
+	return os.Remove(zipPath)
+}
+
+// zipEntries returns the paths of the files in a metadata zip.
+func zipEntries(zipPath string) ([]string, error) {
+	zr, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	dir := path.Dir(zipPath)
+	paths := make([]string, 0, len(zr.File))
+	for _, f := range zr.File {
+		paths = append(paths, path.Join(dir, f.Name))
+	}
+	return paths, nil
+}
+
+// rezipMetadata zips metadata files which were extracted by unzipMetadata
+// again, and removes the extracted files along with any directories which
+// are left empty, as Pipestance.zipMetadata does.  Symlinks are left in
+// place.
+func rezipMetadata(zipPath string, filePaths []string) error {
+	if err := util.CreateZip(zipPath, filePaths); err != nil {
+		return err
+	}
+	root := path.Dir(zipPath)
+	for _, p := range filePaths {
+		if info, err := os.Lstat(p); err != nil || info.Mode()&os.ModeSymlink != 0 {
+			continue
+		}
+		if err := os.Remove(p); err != nil {
+			return err
+		}
+		// Remove directories which only held metadata.
+		for dir := path.Dir(p); pathIsInside(dir, root) && dir != root; dir = path.Dir(dir) {
+			if os.Remove(dir) != nil {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// pathRelocator rewrites paths under a set of old roots to be under a new
+// root.
+type pathRelocator struct {
+	oldRoots []string
+	newRoot  string
+	patterns []*regexp.Regexp
+}
+
+func newPathRelocator(oldRoots []string, newRoot string) *pathRelocator {
+	r := &pathRelocator{
+		oldRoots: oldRoots,
+		newRoot:  newRoot,
+		patterns: make([]*regexp.Regexp, len(oldRoots)),
+	}
+	for i, root := range oldRoots {
+		// Only match the whole path, not a prefix of a longer file name.
+		r.patterns[i] = regexp.MustCompile(`(^|[^\w.\-/])` +
+			regexp.QuoteMeta(root) + `(/|[^\w.\-/]|$)`)
+	}
+	return r
+}
+
+// relocate returns the relocated path, and whether it was changed.
+func (self *pathRelocator) relocate(p string) (string, bool) {
+	for _, root := range self.oldRoots {
+		if p == root {
+			return self.newRoot, true
+		} else if strings.HasPrefix(p, root+"/") {
+			return self.newRoot + p[len(root):], true
+		}
+	}
+	return p, false
+}
+
+// relocateJson relocates the string values in a json object.
+func (self *pathRelocator) relocateJson(v interface{}) (interface{}, bool) {
+	switch v := v.(type) {
+	case string:
+		return self.relocate(v)
+	case []interface{}:
+		changed := false
+		for i, elem := range v {
+			if nv, ok := self.relocateJson(elem); ok {
+				v[i] = nv
+				changed = true
+			}
+		}
+		return v, changed
+	case map[string]interface{}:
+		changed := false
+		for k, elem := range v {
+			if nv, ok := self.relocateJson(elem); ok {
+				v[k] = nv
+				changed = true
+			}
+		}
+		return v, changed
+	}
+	return v, false
+}
+
+// relocateText relocates paths in plain text.
+func (self *pathRelocator) relocateText(b []byte) ([]byte, bool) {
+	changed := false
+	repl := []byte("${1}" + strings.ReplaceAll(self.newRoot, "$", "$$") + "${2}")
+	for _, re := range self.patterns {
+		if re.Match(b) {
+			b = re.ReplaceAll(b, repl)
+			changed = true
+		}
+	}
+	return b, changed
+}
+
+// relocateFile rewrites the paths in a file, treating it as json if it is
+// valid json, and otherwise as plain text.
+func (self *pathRelocator) relocateFile(p string) (bool, error) {
+	b, err := os.ReadFile(p)
+	if err != nil {
+		return false, err
+	}
+	var changed bool
+	if json.Valid(b) {
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.UseNumber()
+		var v interface{}
+		if err := dec.Decode(&v); err != nil {
+			return false, err
+		}
+		if v, changed = self.relocateJson(v); changed {
+			var buf bytes.Buffer
+			enc := json.NewEncoder(&buf)
+			enc.SetEscapeHTML(false)
+			enc.SetIndent("", "    ")
+			if err := enc.Encode(v); err != nil {
+				return false, err
+			}
+			b = bytes.TrimSuffix(buf.Bytes(), []byte{'\n'})
+		}
+	} else {
+		b, changed = self.relocateText(b)
+	}
+	if !changed {
+		return false, nil
+	}
+	return true, writeAtomic(p, b)
+}
+
+// relocateSymlink rewrites an absolute symlink target under an old root.
+func (self *pathRelocator) relocateSymlink(p string) (bool, error) {
+	target, err := os.Readlink(p)
+	if err != nil {
+		return false, err
+	}
+	if !path.IsAbs(target) {
+		return false, nil
+	}
+	target, changed := self.relocate(path.Clean(target))
+	if !changed {
+		return false, nil
+	}
+	if err := os.Remove(p); err != nil {
+		return false, err
+	}
+	return true, os.Symlink(target, p)
+}
+
+// VerifyRelocatedPipestance checks that a relocated pipestance can be loaded
+// in read-only mode, and that its state is the same as it was when it
+// finished running.
+func (self *Runtime) VerifyRelocatedPipestance(psdir string,
+	ctx context.Context) error {
+	var nodes []*NodeInfo
+	if err := NewMetadata("", psdir).ReadInto(FinalState, &nodes); err != nil {
+		return err
+	}
+	psid, root, err := readPipestanceRoot(psdir)
+	if err != nil {
+		return err
+	}
+	if abs, err := filepath.Abs(psdir); err == nil && abs != root {
+		return &RuntimeError{Msg: fmt.Sprintf(
+			"final state still refers to %s", root)}
+	}
+	pipestance, err := self.ReattachToPipestanceWithMroSrc(psid, psdir,
+		"", "", nil, "", nil, false, true, ctx)
+	if err != nil {
+		return err
+	}
+	pipestance.LoadMetadata(ctx)
+	for _, node := range nodes {
+		if strings.Count(node.Fqname, ".") != 2 {
+			continue
+		}
+		if state := pipestance.GetState(ctx); state != node.State {
+			return &RuntimeError{Msg: fmt.Sprintf(
+				"pipestance state is %s after relocation, but was %s",
+				state, node.State)}
+		}
+		break
+	}
+	return nil
+}
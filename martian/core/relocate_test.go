@@ -0,0 +1,124 @@
+// Copyright (c) 2020 10X Genomics, Inc. All rights reserved.
+
+package core
+
+import (
+	"context"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/martian-lang/martian/martian/util"
+)
+
+func TestPathRelocator(t *testing.T) {
+	r := newPathRelocator(relocateRoots([]string{"/a/ps", "/b/real/ps"}, "/c/ps"), "/c/ps")
+	check := func(t *testing.T, in, expect string) {
+		t.Helper()
+		if p, _ := r.relocate(in); p != expect {
+			t.Errorf("relocate(%q) = %q, expected %q", in, p, expect)
+		}
+		if b, _ := r.relocateText([]byte("x " + in + "\n")); string(b) != "x "+expect+"\n" {
+			t.Errorf("relocateText(%q) = %q, expected %q", in, b, expect)
+		}
+	}
+	check(t, "/a/ps", "/c/ps")
+	check(t, "/a/ps/PIPE/STAGE/fork0/files/x.txt", "/c/ps/PIPE/STAGE/fork0/files/x.txt")
+	check(t, "/b/real/ps/outs", "/c/ps/outs")
+	check(t, "/a/ps2/file", "/a/ps2/file")
+	check(t, "/x/a/ps/file", "/x/a/ps/file")
+}
+
+func TestRelocatePipestance(t *testing.T) {
+	util.SetPrintLogger(&devNull)
+	rtOpts := DefaultRuntimeOptions()
+	rtOpts.Zip = true
+	psdir, pipestance := runTestPipestance(t, &rtOpts, "relocate")
+	pipestance.PostProcess()
+	pipestance.Unlock()
+
+	rt := rtOpts.NewRuntimeWithJobConfig(&JobManagerJson{
+		JobSettings: &JobManagerSettings{
+			ThreadsPerJob: 1,
+			MemGBPerJob:   1,
+		},
+	})
+	naive := path.Join(path.Dir(psdir), "naive")
+	if err := copyTree(psdir, naive); err != nil {
+		t.Fatal(err)
+	}
+	if err := rt.VerifyRelocatedPipestance(naive, context.Background()); err == nil {
+		t.Error("expected verification to fail for a copied pipestance")
+	}
+	// Fixing up the copy in place should work.
+	if _, err := RelocatePipestance(naive, naive, false); err != nil {
+		t.Error(err)
+	} else if err := rt.VerifyRelocatedPipestance(naive, context.Background()); err != nil {
+		t.Error(err)
+	}
+
+	dst := path.Join(path.Dir(psdir), "moved")
+	result, err := RelocatePipestance(psdir, dst, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Psid != "relocate" {
+		t.Errorf("incorrect psid %q", result.Psid)
+	}
+	if result.Files == 0 {
+		t.Error("no files were rewritten")
+	}
+	if _, err := os.Stat(psdir); !os.IsNotExist(err) {
+		t.Error("source pipestance was not moved")
+	}
+	zipPath := path.Join(dst, MetadataZip.FileName())
+	if _, err := os.Stat(zipPath); err != nil {
+		t.Error("metadata was not zipped again:", err)
+	}
+	if _, err := os.Stat(path.Join(dst, "RELOCATE", "MAKE", "fork0",
+		"split", MetadataFilePrefix+string(ArgsFile))); !os.IsNotExist(err) {
+		t.Error("extracted metadata was not removed")
+	}
+	if b, err := util.ReadZip(zipPath, "RELOCATE/MAKE/fork0/_outs"); err != nil {
+		t.Error(err)
+	} else if strings.Contains(string(b), psdir+"/") {
+		t.Errorf("zipped outs still refer to %s", psdir)
+	} else if !strings.Contains(string(b), dst+"/") {
+		t.Errorf("zipped outs do not refer to %s", dst)
+	}
+	// Nothing should refer to the old location.
+	err = util.Walk(dst, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			if target, err := os.Readlink(p); err != nil {
+				return err
+			} else if strings.HasPrefix(target, psdir+"/") {
+				t.Errorf("symlink %s still points to %s", p, target)
+			}
+		} else if !info.IsDir() &&
+			strings.HasPrefix(info.Name(), MetadataFilePrefix) &&
+			relocateMetadataFile(MetadataFileName(info.Name()[1:])) {
+			if b, err := os.ReadFile(p); err != nil {
+				return err
+			} else if strings.Contains(string(b), psdir+"/") {
+				t.Errorf("%s still refers to %s", p, psdir)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	if b, err := os.ReadFile(path.Join(dst, "outs", "file.txt")); err != nil {
+		t.Error(err)
+	} else if len(b) == 0 {
+		t.Error("empty output file")
+	}
+
+	if err := rt.VerifyRelocatedPipestance(dst, context.Background()); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,436 @@
+// Copyright (c) 2020 10X Genomics, Inc. All rights reserved.
+
+package core
+
+//
+// Staging in file inputs which are given as remote URIs.
+//
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/martian-lang/martian/martian/syntax"
+	"github.com/martian-lang/martian/martian/util"
+)
+
+// RemoteInputsDir is the directory in the pipestance where remote file
+// inputs are downloaded.
+const RemoteInputsDir = "inputs"
+
+var (
+	// How often to report the progress of downloads.
+	remoteInputProgressInterval = 30 * time.Second
+
+	// Downloads which receive no data for this long are abandoned.
+	remoteInputStallTimeout = 5 * time.Minute
+)
+
+// remoteInputClient is the client used by an HttpFetcher without one.  It
+// has no overall timeout, since inputs may be large, but gives up on servers
+// which do not respond.  Downloads which stop receiving data once they have
+// started are abandoned by stageIn.
+var remoteInputClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		ForceAttemptHTTP2:     true,
+		TLSHandshakeTimeout:   30 * time.Second,
+		ResponseHeaderTimeout: time.Minute,
+		IdleConnTimeout:       90 * time.Second,
+	},
+}
+
+// A RemoteFetcher downloads the content of remote file inputs for a URI
+// scheme.
+type RemoteFetcher interface {
+	// Fetch writes the content at the given URI to w.  The URI does not
+	// include the fragment.
+	Fetch(ctx context.Context, uri *url.URL, w io.Writer) error
+}
+
+// HttpFetcher fetches http and https URIs.
+type HttpFetcher struct {
+	// The client to use.  If nil, a client which gives up on servers which
+	// do not respond within a minute is used.
+	Client *http.Client
+}
+
+func (f HttpFetcher) Fetch(ctx context.Context, uri *url.URL, w io.Writer) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri.String(), nil)
+	if err != nil {
+		return err
+	}
+	client := f.Client
+	if client == nil {
+		client = remoteInputClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("server returned %s", resp.Status)
+	}
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// CommandFetcher fetches URIs by running a command with the URI appended to
+// its arguments, which writes the content to standard output.
+type CommandFetcher struct {
+	Command []string
+}
+
+func (f CommandFetcher) Fetch(ctx context.Context, uri *url.URL, w io.Writer) error {
+	if len(f.Command) == 0 {
+		return fmt.Errorf("no command to fetch %s URIs", uri.Scheme)
+	}
+	args := make([]string, 0, len(f.Command))
+	args = append(args, f.Command[1:]...)
+	args = append(args, uri.String(), "-")
+	cmd := exec.CommandContext(ctx, f.Command[0], args...)
+	cmd.Stdout = w
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("%s: %w: %s", f.Command[0], err, msg)
+		}
+		return fmt.Errorf("%s: %w", f.Command[0], err)
+	}
+	return nil
+}
+
+var (
+	remoteFetchersLock sync.RWMutex
+	remoteFetchers     = map[string]RemoteFetcher{
+		"http":  HttpFetcher{},
+		"https": HttpFetcher{},
+		"s3":    CommandFetcher{Command: []string{"aws", "s3", "cp"}},
+		"gs":    CommandFetcher{Command: []string{"gsutil", "cp"}},
+	}
+)
+
+// RegisterRemoteFetcher sets the fetcher used for file inputs with the given
+// URI scheme.  Registering a nil fetcher removes support for the scheme.
+func RegisterRemoteFetcher(scheme string, f RemoteFetcher) {
+	scheme = strings.ToLower(scheme)
+	remoteFetchersLock.Lock()
+	defer remoteFetchersLock.Unlock()
+	if f == nil {
+		delete(remoteFetchers, scheme)
+	} else {
+		remoteFetchers[scheme] = f
+	}
+}
+
+func getRemoteFetcher(scheme string) RemoteFetcher {
+	remoteFetchersLock.RLock()
+	defer remoteFetchersLock.RUnlock()
+	return remoteFetchers[strings.ToLower(scheme)]
+}
+
+// remoteInput is a file input given as a URI with a registered scheme.
+type remoteInput struct {
+	// The URI, without its fragment.
+	uri *url.URL
+
+	fetcher RemoteFetcher
+
+	// The expected sha256 checksum, if given in the fragment of the URI as
+	// #sha256=<hex digest>.
+	sha256 string
+
+	// The expressions which refer to this input.
+	exps []*syntax.StringExp
+}
+
+// parseRemoteInput returns nil if the value is not a URI with a scheme for
+// which a fetcher is registered.
+func parseRemoteInput(value string) (*remoteInput, error) {
+	i := strings.Index(value, "://")
+	if i <= 0 {
+		return nil, nil
+	}
+	fetcher := getRemoteFetcher(value[:i])
+	if fetcher == nil {
+		return nil, nil
+	}
+	uri, err := url.Parse(value)
+	if err != nil {
+		return nil, err
+	}
+	input := &remoteInput{
+		uri:     uri,
+		fetcher: fetcher,
+	}
+	if frag := uri.Fragment; frag != "" {
+		if sum := strings.TrimPrefix(frag, "sha256="); sum != frag {
+			if b, err := hex.DecodeString(sum); err != nil || len(b) != sha256.Size {
+				return nil, fmt.Errorf("invalid sha256 checksum %q", sum)
+			}
+			input.sha256 = strings.ToLower(sum)
+		} else {
+			return nil, fmt.Errorf("unrecognized fragment %q: "+
+				"only sha256=<checksum> is supported", frag)
+		}
+		uri.Fragment = ""
+		uri.RawFragment = ""
+	}
+	return input, nil
+}
+
+// localPath returns the path in the inputs directory where the input is
+// downloaded.  Inputs from different URIs do not share a directory, even if
+// they have the same file name.
+func (self *remoteInput) localPath(psdir string) string {
+	sum := sha256.Sum256([]byte(self.uri.String()))
+	name := path.Base(self.uri.Path)
+	if name == "/" || name == "." || name == "" {
+		name = "input"
+	}
+	return path.Join(psdir, RemoteInputsDir,
+		hex.EncodeToString(sum[:8]), name)
+}
+
+// stageIn downloads the input to dst, unless it is already there with the
+// expected checksum.
+func (self *remoteInput) stageIn(dst string, ctx context.Context) error {
+	if _, err := os.Stat(dst); err == nil {
+		if self.sha256 == "" {
+			return nil
+		}
+		if sum, err := sha256File(dst); err == nil && sum == self.sha256 {
+			return nil
+		}
+		util.PrintInfo("runtime",
+			"Cached copy of %s does not match its checksum.",
+			self.uri.Redacted())
+	}
+	if err := os.MkdirAll(path.Dir(dst), 0775); err != nil {
+		return err
+	}
+	name := self.uri.Redacted()
+	util.PrintInfo("runtime", "Downloading %s", name)
+	tmp := dst + ".partial"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	var progress downloadProgress
+	w := io.MultiWriter(f, &progress)
+	var h hash.Hash
+	if self.sha256 != "" {
+		h = sha256.New()
+		w = io.MultiWriter(f, &progress, h)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go progress.watch(name, cancel, done)
+	err = self.fetcher.Fetch(ctx, self.uri, w)
+	close(done)
+	cancel()
+	if err != nil && progress.stalled() {
+		err = fmt.Errorf("no data received for %v", remoteInputStallTimeout)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && h != nil {
+		if sum := hex.EncodeToString(h.Sum(nil)); sum != self.sha256 {
+			err = fmt.Errorf("checksum %s does not match expected %s",
+				sum, self.sha256)
+		}
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	util.PrintInfo("runtime", "Downloaded %s (%s)",
+		name, humanize.Bytes(uint64(progress.bytes())))
+	return os.Rename(tmp, dst)
+}
+
+// downloadProgress counts the bytes written for a download.
+type downloadProgress struct {
+	n         int64
+	isStalled int32
+}
+
+func (p *downloadProgress) Write(b []byte) (int, error) {
+	atomic.AddInt64(&p.n, int64(len(b)))
+	return len(b), nil
+}
+
+func (p *downloadProgress) bytes() int64 {
+	return atomic.LoadInt64(&p.n)
+}
+
+func (p *downloadProgress) stalled() bool {
+	return atomic.LoadInt32(&p.isStalled) != 0
+}
+
+// watch periodically reports the progress of the download until done is
+// closed, and cancels it if no data is received for
+// remoteInputStallTimeout.
+func (p *downloadProgress) watch(name string, cancel context.CancelFunc,
+	done <-chan struct{}) {
+	interval := remoteInputProgressInterval
+	if interval > remoteInputStallTimeout {
+		interval = remoteInputStallTimeout
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var last int64
+	lastChange := time.Now()
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			if n := p.bytes(); n != last {
+				last, lastChange = n, now
+				util.PrintInfo("runtime", "Downloaded %s of %s",
+					humanize.Bytes(uint64(n)), name)
+			} else if now.Sub(lastChange) >= remoteInputStallTimeout {
+				util.PrintInfo("runtime",
+					"No data received for %s in %v.  Giving up.",
+					name, remoteInputStallTimeout)
+				atomic.StoreInt32(&p.isStalled, 1)
+				cancel()
+				return
+			}
+		}
+	}
+}
+
+// findRemoteInputs calls found for every string literal of a file type in
+// the expression.
+func findRemoteInputs(exp syntax.Exp, t syntax.Type, lookup *syntax.TypeLookup,
+	found func(*syntax.StringExp)) {
+	if exp == nil || t == nil {
+		return
+	}
+	switch exp := exp.(type) {
+	case *syntax.StringExp:
+		if t.IsFile() == syntax.KindIsFile {
+			found(exp)
+		}
+	case *syntax.ArrayExp:
+		tid := t.TypeId()
+		if tid.ArrayDim == 0 {
+			return
+		}
+		tid.ArrayDim--
+		if et := lookup.Get(tid); et != nil {
+			for _, e := range exp.Value {
+				findRemoteInputs(e, et, lookup, found)
+			}
+		}
+	case *syntax.MapExp:
+		switch t := t.(type) {
+		case *syntax.TypedMapType:
+			for _, e := range exp.Value {
+				findRemoteInputs(e, t.Elem, lookup, found)
+			}
+		case *syntax.StructType:
+			for _, m := range t.Members {
+				findRemoteInputs(exp.Value[m.Id], lookup.Get(m.Tname),
+					lookup, found)
+			}
+		}
+	case *syntax.SplitExp:
+		var inner syntax.Type
+		switch val := exp.Value.(type) {
+		case *syntax.MapExp:
+			if mt := lookup.GetMap(t); mt != nil {
+				inner = mt
+			}
+		case *syntax.ArrayExp:
+			inner = lookup.GetArray(t, 1)
+		case *syntax.SplitExp:
+			inner, _ = lookup.AddDim(t, val.CallMode())
+		case *syntax.DisabledExp:
+			inner = t
+		}
+		findRemoteInputs(exp.Value, inner, lookup, found)
+	case *syntax.DisabledExp:
+		findRemoteInputs(exp.Value, t, lookup, found)
+	}
+}
+
+// stageInRemoteInputs finds file-typed literal bindings in the call graph
+// which are remote URIs, downloads them to the pipestance's inputs directory,
+// and replaces the bindings with the local paths, so that stages only ever
+// see local files.  Inputs which were already downloaded are not fetched
+// again.  In read-only mode, nothing is downloaded.
+func (self *TopNode) stageInRemoteInputs(readOnly bool, ctx context.Context) error {
+	inputs := make(map[string]*remoteInput)
+	var errs syntax.ErrorList
+	found := func(exp *syntax.StringExp) {
+		if input := inputs[exp.Value]; input != nil {
+			input.exps = append(input.exps, exp)
+		} else if input, err := parseRemoteInput(exp.Value); err != nil {
+			errs = append(errs, &RuntimeError{Msg: fmt.Sprintf(
+				"remote input %s: %v", exp.Value, err)})
+		} else if input != nil {
+			input.exps = []*syntax.StringExp{exp}
+			inputs[exp.Value] = input
+		}
+	}
+	for _, node := range self.allNodes {
+		if node.call == nil {
+			continue
+		}
+		for _, b := range node.call.ResolvedInputs() {
+			findRemoteInputs(b.Exp, b.Type, self.types, found)
+		}
+		if b := node.call.ResolvedOutputs(); b != nil {
+			findRemoteInputs(b.Exp, b.Type, self.types, found)
+		}
+	}
+	if err := errs.If(); err != nil {
+		return err
+	}
+	uris := make([]string, 0, len(inputs))
+	for uri := range inputs {
+		uris = append(uris, uri)
+	}
+	sort.Strings(uris)
+	for _, uri := range uris {
+		input := inputs[uri]
+		dst := input.localPath(self.node.path)
+		if !readOnly {
+			if err := input.stageIn(dst, ctx); err != nil {
+				errs = append(errs, &RuntimeError{Msg: fmt.Sprintf(
+					"staging in %s: %v", input.uri.Redacted(), err)})
+				continue
+			}
+		}
+		for _, exp := range input.exps {
+			exp.Value = dst
+		}
+	}
+	return errs.If()
+}
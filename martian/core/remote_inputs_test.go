@@ -0,0 +1,203 @@
+// Copyright (c) 2020 10X Genomics, Inc. All rights reserved.
+
+package core
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/martian-lang/martian/martian/util"
+)
+
+// remoteInputInvocation returns an invocation of the pipeline in
+// testdata/remote_inputs.mro with the given file input.
+func remoteInputInvocation(file string) string {
+	return fmt.Sprintf("@include \"remote_inputs.mro\"\n\ncall FETCH(\n    file = %q,\n)\n", file)
+}
+
+func remoteInputServer(t *testing.T, content string) (*httptest.Server, *int32) {
+	t.Helper()
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if r.URL.Path != "/data/input.txt" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, content)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &requests
+}
+
+func TestParseRemoteInput(t *testing.T) {
+	for _, local := range []string{"/data/input.txt", "input.txt", "unknown://x/y"} {
+		if input, err := parseRemoteInput(local); err != nil {
+			t.Error(err)
+		} else if input != nil {
+			t.Errorf("%s is not a remote input", local)
+		}
+	}
+	sum := strings.Repeat("ab", sha256.Size)
+	input, err := parseRemoteInput("https://example.com/a/b.txt#sha256=" + sum)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if input.sha256 != sum {
+		t.Errorf("incorrect checksum %q", input.sha256)
+	}
+	if s := input.uri.String(); s != "https://example.com/a/b.txt" {
+		t.Errorf("incorrect uri %q", s)
+	}
+	if p := input.localPath("/ps"); !strings.HasPrefix(p, "/ps/inputs/") ||
+		path.Base(p) != "b.txt" {
+		t.Errorf("incorrect local path %q", p)
+	}
+	if _, err := parseRemoteInput("https://example.com/b.txt#md5=00"); err == nil {
+		t.Error("expected an error for an unsupported fragment")
+	}
+	if _, err := parseRemoteInput("https://example.com/b.txt#sha256=00"); err == nil {
+		t.Error("expected an error for a short checksum")
+	}
+}
+
+func TestRemoteInputCache(t *testing.T) {
+	util.SetPrintLogger(&devNull)
+	srv, requests := remoteInputServer(t, "hello")
+	sum := sha256.Sum256([]byte("hello"))
+	input, err := parseRemoteInput(srv.URL + "/data/input.txt#sha256=" +
+		hex.EncodeToString(sum[:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst := input.localPath(t.TempDir())
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if err := input.stageIn(dst, ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := atomic.LoadInt32(requests); n != 1 {
+		t.Errorf("expected 1 request, got %d", n)
+	}
+	if err := os.WriteFile(dst, []byte("corrupt"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := input.stageIn(dst, ctx); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(requests); n != 2 {
+		t.Errorf("expected a corrupted copy to be downloaded again")
+	}
+	if b, err := os.ReadFile(dst); err != nil {
+		t.Error(err)
+	} else if string(b) != "hello" {
+		t.Errorf("incorrect content %q", b)
+	}
+
+	input, err = parseRemoteInput(srv.URL + "/data/input.txt#sha256=" +
+		strings.Repeat("00", sha256.Size))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst = input.localPath(t.TempDir())
+	if err := input.stageIn(dst, ctx); err == nil {
+		t.Error("expected a checksum mismatch")
+	} else if _, err := os.Stat(dst); !os.IsNotExist(err) {
+		t.Error("kept a file which did not match its checksum")
+	}
+}
+
+func TestRemoteInputPipeline(t *testing.T) {
+	util.SetPrintLogger(&devNull)
+	srv, _ := remoteInputServer(t, "hello")
+	rtOpts := DefaultRuntimeOptions()
+	var runner testStageRunner
+	psdir := path.Join(t.TempDir(), "fetch")
+	runTestInvocation(t, newTestRuntime(&rtOpts, &runner),
+		remoteInputInvocation(srv.URL+"/data/input.txt"), "fetch", psdir)
+	var outs struct {
+		Result string `json:"result"`
+	}
+	if err := NewMetadata("", path.Join(psdir, "FETCH", defaultFork)).ReadInto(
+		OutsFile, &outs); err != nil {
+		t.Fatal(err)
+	}
+	if outs.Result != "hello/fetched" {
+		t.Errorf("incorrect result %q", outs.Result)
+	}
+	var args struct {
+		File string `json:"file"`
+	}
+	if err := NewMetadata("", path.Join(psdir, "FETCH", "USE", defaultFork, "chnk0")).ReadInto(
+		ArgsFile, &args); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(args.File, path.Join(psdir, RemoteInputsDir)+"/") {
+		t.Errorf("stage was given %q rather than a staged copy", args.File)
+	}
+}
+
+func TestRemoteInputChecksumMismatch(t *testing.T) {
+	util.SetPrintLogger(&devNull)
+	srv, _ := remoteInputServer(t, "hello")
+	rtOpts := DefaultRuntimeOptions()
+	rt := rtOpts.NewRuntimeWithJobConfig(&JobManagerJson{
+		JobSettings: &JobManagerSettings{
+			ThreadsPerJob: 1,
+			MemGBPerJob:   1,
+		},
+	})
+	psdir := path.Join(t.TempDir(), "mismatch")
+	_, err := rt.InvokePipeline(remoteInputInvocation(
+		srv.URL+"/data/input.txt#sha256="+strings.Repeat("00", sha256.Size)),
+		"remote.mro", "mismatch", psdir, []string{"testdata"}, "<none>", nil, nil)
+	if err == nil {
+		t.Fatal("expected a checksum mismatch")
+	} else if !strings.Contains(err.Error(), "checksum") {
+		t.Errorf("unexpected error %v", err)
+	}
+	if _, err := os.Stat(psdir); !os.IsNotExist(err) {
+		t.Error("pipestance directory was not removed")
+	}
+}
+
+func TestRemoteInputStalled(t *testing.T) {
+	util.SetPrintLogger(&devNull)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "partial")
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	t.Cleanup(srv.Close)
+	defer func(interval, timeout time.Duration) {
+		remoteInputProgressInterval, remoteInputStallTimeout = interval, timeout
+	}(remoteInputProgressInterval, remoteInputStallTimeout)
+	remoteInputProgressInterval = 10 * time.Millisecond
+	remoteInputStallTimeout = 100 * time.Millisecond
+	input, err := parseRemoteInput(srv.URL + "/data/input.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst := input.localPath(t.TempDir())
+	if err := input.stageIn(dst, context.Background()); err == nil {
+		t.Fatal("expected a stalled download to fail")
+	} else if !strings.Contains(err.Error(), "no data received") {
+		t.Errorf("unexpected error %v", err)
+	}
+	for _, p := range []string{dst, dst + ".partial"} {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Errorf("%s was not removed", p)
+		}
+	}
+}
@@ -0,0 +1,250 @@
+// Copyright (c) 2020 10X Genomics, Inc. All rights reserved.
+
+// Replaying stage outputs recorded by another pipestance.
+
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/martian-lang/martian/martian/syntax"
+	"github.com/martian-lang/martian/martian/util"
+)
+
+// replayMatches returns true if the replay stage name matches the given
+// fully-qualified call ID, without the ID.psid. prefix.
+//
+// A replay stage name matches the call ID, or any suffix of it starting
+// after a '.', so "STAGE" and "SUBPIPELINE.STAGE" both match
+// "PIPELINE.SUBPIPELINE.STAGE".
+func replayMatches(name, fqid string) bool {
+	return fqid == name || strings.HasSuffix(fqid, "."+name)
+}
+
+// replays returns true if the stage with the given fully-qualified call ID,
+// without the ID.psid. prefix, should be replayed from another pipestance.
+func (c *RuntimeOptions) replays(fqid string) bool {
+	if c.ReplayFrom == "" {
+		return false
+	}
+	for _, name := range c.ReplayStages {
+		if replayMatches(name, fqid) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkReplayStages returns an error if the replay source is not a directory
+// or if any of the replay stage names do not match a stage in the pipestance.
+func (self *TopNode) checkReplayStages() error {
+	config := self.rt.Config
+	if config.ReplayFrom == "" {
+		return nil
+	}
+	if info, err := os.Stat(config.ReplayFrom); err != nil {
+		return &RuntimeError{Msg: "replay source: " + err.Error()}
+	} else if !info.IsDir() {
+		return &RuntimeError{
+			Msg: "replay source " + config.ReplayFrom + " is not a directory",
+		}
+	}
+	for _, name := range config.ReplayStages {
+		found := false
+		for _, node := range self.allNodes {
+			if node.call.Kind() == syntax.KindStage &&
+				replayMatches(name, self.relativeFqid(node)) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return &RuntimeError{
+				Msg: "replay stage " + name + " does not match any stage",
+			}
+		}
+	}
+	return nil
+}
+
+// relativeFqid returns the node's fully-qualified name without the
+// ID.psid. prefix.
+func (self *TopNode) relativeFqid(node *Node) string {
+	return strings.TrimPrefix(node.GetFQName(), self.fqname+".")
+}
+
+// doReplay materializes the outputs for the fork from the corresponding
+// fork in the replay source pipestance, and marks the fork complete.
+func (self *Fork) doReplay(bindings MarshalerMap) MetadataState {
+	top := self.node.top
+	oldRoot := top.rt.Config.ReplayFrom
+	newRoot := top.node.path
+	src := path.Join(oldRoot, strings.TrimPrefix(self.path, newRoot))
+	if _, err := os.Stat(path.Join(src, CompleteFile.FileName())); err != nil {
+		self.metadata.writeError("Replay source is not complete", err)
+		return Failed
+	}
+	self.checkReplayArgs(src, bindings, oldRoot, newRoot)
+
+	// Bring along the files directories for the fork and its chunks.
+	for _, pattern := range []string{"files", "*/files"} {
+		dirs, _ := filepath.Glob(path.Join(src, pattern))
+		for _, dir := range dirs {
+			rel, err := filepath.Rel(src, dir)
+			if err != nil {
+				self.metadata.writeError("Could not replay files", err)
+				return Failed
+			}
+			if err := copyResolvedTree(dir, path.Join(self.path, rel)); err != nil {
+				self.metadata.writeError("Could not replay files", err)
+				return Failed
+			}
+		}
+	}
+
+	outs, err := os.ReadFile(path.Join(src, OutsFile.FileName()))
+	if err != nil {
+		self.metadata.writeError("Could not read replay source outs", err)
+		return Failed
+	}
+	var value interface{}
+	if err := json.Unmarshal(outs, &value); err != nil {
+		self.metadata.writeError("Could not parse replay source outs", err)
+		return Failed
+	}
+	if err := self.metadata.Write(OutsFile,
+		rewriteReplayPaths(value, oldRoot, newRoot)); err != nil {
+		return Failed
+	}
+	if err := self.metadata.WriteTime(CompleteFile); err != nil {
+		return Failed
+	}
+	util.PrintInfo("runtime", "(replayed)        %s", self.fqname)
+	return Complete
+}
+
+// checkReplayArgs warns if the arguments for the fork differ from those used
+// by the replay source, after rewriting paths from the old pipestance.
+func (self *Fork) checkReplayArgs(src string, bindings MarshalerMap,
+	oldRoot, newRoot string) {
+	oldArgs, err := os.ReadFile(path.Join(src, "split", ArgsFile.FileName()))
+	if err != nil {
+		util.LogError(err, "runtime", "Could not read replay source args.")
+		return
+	}
+	newArgs, err := json.Marshal(bindings)
+	if err != nil {
+		return
+	}
+	var oldValues, newValues map[string]interface{}
+	if json.Unmarshal(oldArgs, &oldValues) != nil ||
+		json.Unmarshal(newArgs, &newValues) != nil {
+		return
+	}
+	var diffs []string
+	for k, v := range newValues {
+		if !reflect.DeepEqual(rewriteReplayPaths(oldValues[k], oldRoot, newRoot), v) {
+			diffs = append(diffs, k)
+		}
+	}
+	for k := range oldValues {
+		if _, ok := newValues[k]; !ok {
+			diffs = append(diffs, k)
+		}
+	}
+	if len(diffs) == 0 {
+		return
+	}
+	sort.Strings(diffs)
+	msg := fmt.Sprintf(
+		"Replayed outputs were produced from different values for %s",
+		strings.Join(diffs, ", "))
+	util.PrintInfo("runtime", "WARNING: %s: %s", self.fqname, msg)
+	if err := self.metadata.AppendAlarm(msg); err != nil {
+		util.LogError(err, "runtime", "Could not write alarms.")
+	}
+}
+
+// rewriteReplayPaths replaces the prefix oldRoot with newRoot for all
+// strings in a decoded json value.
+func rewriteReplayPaths(value interface{}, oldRoot, newRoot string) interface{} {
+	switch v := value.(type) {
+	case string:
+		if v == oldRoot {
+			return newRoot
+		} else if strings.HasPrefix(v, oldRoot+"/") {
+			return newRoot + v[len(oldRoot):]
+		}
+	case []interface{}:
+		for i, e := range v {
+			v[i] = rewriteReplayPaths(e, oldRoot, newRoot)
+		}
+	case map[string]interface{}:
+		for k, e := range v {
+			v[k] = rewriteReplayPaths(e, oldRoot, newRoot)
+		}
+	}
+	return value
+}
+
+// copyResolvedTree recreates the directory tree src at dst.  Files are copied
+// rather than hard-linked, so that the replaying pipestance does not share
+// them with the source, which may modify or remove them.  Symlinks are
+// replaced by the content they point to, since relative links may not resolve
+// in the new location.
+func copyResolvedTree(src, dst string) error {
+	return util.Walk(src, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		target := path.Join(dst, rel)
+		if info.Mode()&os.ModeSymlink != 0 {
+			if p, err = filepath.EvalSymlinks(p); err != nil {
+				return err
+			}
+			if info, err = os.Stat(p); err != nil {
+				return err
+			}
+			if info.IsDir() {
+				return copyResolvedTree(p, target)
+			}
+		}
+		if info.IsDir() {
+			return os.MkdirAll(target, 0777)
+		}
+		// Replace anything left over from an earlier attempt.
+		if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return copyFile(p, target, info.Mode())
+	})
+}
+
+func copyFile(src, dst string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode.Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
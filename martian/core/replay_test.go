@@ -0,0 +1,135 @@
+// Copyright (c) 2020 10X Genomics, Inc. All rights reserved.
+
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/martian-lang/martian/martian/util"
+)
+
+func TestReplayMatches(t *testing.T) {
+	check := func(t *testing.T, name, fqid string, expect bool) {
+		t.Helper()
+		if replayMatches(name, fqid) != expect {
+			t.Errorf("replayMatches(%q, %q) != %v", name, fqid, expect)
+		}
+	}
+	check(t, "STAGE", "PIPELINE.STAGE", true)
+	check(t, "SUB.STAGE", "PIPELINE.SUB.STAGE", true)
+	check(t, "PIPELINE.SUB.STAGE", "PIPELINE.SUB.STAGE", true)
+	check(t, "STAGE", "PIPELINE.MY_STAGE", false)
+	check(t, "SUB", "PIPELINE.SUB.STAGE", false)
+}
+
+func TestRewriteReplayPaths(t *testing.T) {
+	var value interface{}
+	if err := json.Unmarshal([]byte(`{
+		"a": "/old/ps/STAGE/fork0/files/a.txt",
+		"b": ["/old/ps", "/old/psx/a", "rel/old/ps"],
+		"c": {"d": "/old/ps/b", "e": 1}
+	}`), &value); err != nil {
+		t.Fatal(err)
+	}
+	value = rewriteReplayPaths(value, "/old/ps", "/new")
+	b, err := json.Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const expect = `{"a":"/new/STAGE/fork0/files/a.txt",` +
+		`"b":["/new","/old/psx/a","rel/old/ps"],` +
+		`"c":{"d":"/new/b","e":1}}`
+	if string(b) != expect {
+		t.Errorf("Expected %s, got %s", expect, b)
+	}
+}
+
+// replayInvocation returns an invocation of the pipeline in
+// testdata/replay.mro with the given input.
+func replayInvocation(what string) string {
+	return fmt.Sprintf("@include \"replay.mro\"\n\ncall REPLAY(\n    what = %q,\n)\n", what)
+}
+
+func runReplayTest(t *testing.T, rtOpts *RuntimeOptions, psdir, what string) []string {
+	t.Helper()
+	rtOpts.VdrMode = VdrDisable
+	var runner testStageRunner
+	runTestInvocation(t, newTestRuntime(rtOpts, &runner),
+		replayInvocation(what), "replay", psdir)
+	var outs struct {
+		Result string `json:"result"`
+	}
+	if b, err := os.ReadFile(path.Join(psdir, "REPLAY", defaultFork,
+		OutsFile.FileName())); err != nil {
+		t.Error(err)
+	} else if err := json.Unmarshal(b, &outs); err != nil {
+		t.Error(err)
+	} else if outs.Result != "first/first" {
+		t.Errorf("result %q != first/first", outs.Result)
+	}
+	return runner.jobs()
+}
+
+func TestPipestanceReplay(t *testing.T) {
+	util.SetPrintLogger(testLogger{t: t})
+	defer util.SetPrintLogger(&devNull)
+	root := t.TempDir()
+	first := path.Join(root, "first")
+	firstOpts := DefaultRuntimeOptions()
+	if ran := runReplayTest(t, &firstOpts, first, "first"); len(ran) != 2 {
+		t.Errorf("expected 2 jobs, ran %v", ran)
+	}
+
+	// The new pipestance has a different input, but MAKE is replayed so
+	// USE should see the old value.
+	second := path.Join(root, "second")
+	secondOpts := DefaultRuntimeOptions()
+	secondOpts.ReplayFrom = first
+	secondOpts.ReplayStages = []string{"MAKE"}
+	if ran := runReplayTest(t, &secondOpts, second, "second"); !reflect.DeepEqual(
+		ran, []string{"ID.replay.REPLAY.USE.fork0.chnk0"}) {
+		t.Errorf("expected only USE to run, ran %v", ran)
+	}
+	if info, err := os.Stat(path.Join(second, "REPLAY", "MAKE",
+		defaultFork, "files", "file.txt")); err != nil {
+		t.Error("replayed files not copied:", err)
+	} else if orig, err := os.Stat(path.Join(first, "REPLAY", "MAKE",
+		defaultFork, "files", "file.txt")); err != nil {
+		t.Error(err)
+	} else if os.SameFile(info, orig) {
+		t.Error("replayed files are shared with the source pipestance")
+	}
+	if b, err := os.ReadFile(path.Join(second, "REPLAY", "MAKE",
+		defaultFork, AlarmFile.FileName())); err != nil {
+		t.Error("expected an alarm for args divergence:", err)
+	} else if !strings.Contains(string(b), "different values for what") {
+		t.Errorf("unexpected alarm %q", b)
+	}
+}
+
+func TestPipestanceReplayBadStage(t *testing.T) {
+	rtOpts := DefaultRuntimeOptions()
+	rtOpts.ReplayFrom = t.TempDir()
+	rtOpts.ReplayStages = []string{"NOT_A_STAGE"}
+	rt := rtOpts.NewRuntimeWithJobConfig(&JobManagerJson{
+		JobSettings: &JobManagerSettings{
+			ThreadsPerJob: 1,
+			MemGBPerJob:   1,
+		},
+	})
+	_, err := rt.InvokePipeline(
+		replayInvocation("x"),
+		"replay.mro", "replay", path.Join(t.TempDir(), "ps"),
+		[]string{"testdata"}, "<none>", nil, nil)
+	if err == nil {
+		t.Error("expected an error")
+	} else if !strings.Contains(err.Error(), "NOT_A_STAGE") {
+		t.Errorf("unexpected error %v", err)
+	}
+}
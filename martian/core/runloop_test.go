@@ -14,6 +14,7 @@ import (
 	"path"
 	"runtime/trace"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -243,3 +244,117 @@ func TestPipestanceRun(t *testing.T) {
 			len(b))
 	}
 }
+
+// testStageRunner runs stages in-process, without stage code, recording
+// which jobs were run.  Every file output of a stage is written with the
+// value of its what input, and its result output is set to what, prefixed
+// by the content of its file input if it has one.
+type testStageRunner struct {
+	mu  sync.Mutex
+	ran []string
+}
+
+func (r *testStageRunner) run(fqname, shellName string, metadata *Metadata) error {
+	var args struct {
+		File string `json:"file"`
+		What string `json:"what"`
+	}
+	if err := metadata.ReadInto(ArgsFile, &args); err != nil {
+		return err
+	}
+	// The runtime initializes file outputs to their paths.
+	var outs map[string]interface{}
+	if err := metadata.ReadInto(OutsFile, &outs); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.ran = append(r.ran, fqname)
+	r.mu.Unlock()
+	result := args.What
+	if args.File != "" {
+		b, err := os.ReadFile(args.File)
+		if err != nil {
+			return err
+		}
+		result = string(b) + "/" + args.What
+	}
+	for _, v := range outs {
+		if fn, ok := v.(string); ok {
+			if err := os.WriteFile(fn, []byte(args.What), 0644); err != nil {
+				return err
+			}
+		}
+	}
+	outs["result"] = result
+	return metadata.Write(OutsFile, outs)
+}
+
+func (r *testStageRunner) jobs() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.ran...)
+}
+
+// newTestRuntime returns a runtime which runs stages with the given runner.
+func newTestRuntime(rtOpts *RuntimeOptions, runner *testStageRunner) *Runtime {
+	rt := rtOpts.NewRuntimeWithJobConfig(&JobManagerJson{
+		JobSettings: &JobManagerSettings{
+			ThreadsPerJob: 1,
+			MemGBPerJob:   1,
+			ExtraVmemGB:   1,
+			ThreadEnvs:    []string{"GOMAXPROCS"},
+		},
+	})
+	rt.LocalJobManager.SetJobRunner(runner.run)
+	return rt
+}
+
+// readTestFixture returns the content of the given file in testdata.
+func readTestFixture(t testing.TB, name string) string {
+	t.Helper()
+	b, err := os.ReadFile(path.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+// runTestInvocation runs the given invocation to completion in psdir,
+// including the final VDR pass, and waits for the runtime to finish with it.
+func runTestInvocation(t *testing.T, rt *Runtime, src, psid, psdir string) *Pipestance {
+	t.Helper()
+	pipestance, err := rt.InvokePipeline(src, psid+".mro", psid, psdir,
+		[]string{"testdata"}, "<none>", nil, nil)
+	if err != nil {
+		t.Fatal("Invoking pipeline:", err)
+	}
+	t.Cleanup(pipestance.Unlock)
+	pipestance.LoadMetadata(context.Background())
+	for {
+		flushChannel(rt.LocalJobManager.Done())
+		if done, hadProgress := loopBody(t, pipestance); done {
+			break
+		} else if !hadProgress {
+			select {
+			case <-time.After(time.Second):
+			case <-rt.LocalJobManager.Done():
+			}
+		}
+	}
+	pipestance.VDRKill()
+	rt.LocalJobManager.Wait()
+	pipestance.WaitForStorageCleanup()
+	return pipestance
+}
+
+// runTestPipestance runs the invocation in testdata/<name>.mro to
+// completion in a pipestance named name, and returns the pipestance
+// directory and the pipestance.
+func runTestPipestance(t *testing.T, rtOpts *RuntimeOptions, name string) (string, *Pipestance) {
+	t.Helper()
+	var runner testStageRunner
+	rt := newTestRuntime(rtOpts, &runner)
+	psdir := path.Join(t.TempDir(), name)
+	return psdir, runTestInvocation(t, rt,
+		readTestFixture(t, name+".mro"), name, psdir)
+}
@@ -55,11 +55,12 @@ func ParseVersions(data string) (string, string, error) {
 
 func VerifyVDRMode(vdrMode VdrMode) {
 	switch vdrMode {
-	case VdrRolling, VdrPost, VdrDisable, VdrStrict:
+	case VdrRolling, VdrPost, VdrDisable, VdrStrict, VdrArchive, VdrReport:
 		return
 	}
 	util.PrintInfo("runtime",
-		"Invalid VDR mode: %s. Valid VDR modes: rolling, post, disable, strict",
+		"Invalid VDR mode: %s. Valid VDR modes: "+
+			"rolling, post, disable, strict, archive, report",
 		vdrMode)
 	os.Exit(1)
 }
@@ -122,6 +123,14 @@ const (
 	VdrPost    = "post"
 	VdrRolling = "rolling"
 	VdrStrict  = "strict"
+
+	// Like rolling, but files are moved to VdrArchiveRoot rather than
+	// deleted.
+	VdrArchive = "archive"
+
+	// Like strict, but nothing is removed.  Instead, a report is written
+	// of what would have been removed.
+	VdrReport = "report"
 )
 
 // Configuration required to initialize a Runtime object.
@@ -133,9 +142,19 @@ type RuntimeOptions struct {
 	JobMode string
 
 	// The volatile disk recovery mode (required): either "post",
-	// "rolling", "strict", or "disable".
+	// "rolling", "strict", "archive", "report", or "disable".
 	VdrMode VdrMode
 
+	// The directory to which volatile files are moved in archive VDR
+	// mode.  Files are archived under a subdirectory named for the
+	// pipestance ID, at the same path relative to it as they were in the
+	// pipestance directory.
+	VdrArchiveRoot string
+
+	// If set in archive VDR mode, volatile files are packed into a
+	// gzipped tarball for each stage fork rather than moved.
+	VdrArchiveTar bool
+
 	// The profiling mode (required): "disable" or one of the available
 	// constants.
 	ProfileMode     ProfileMode
@@ -157,6 +176,38 @@ type RuntimeOptions struct {
 	StressTest      bool
 	LimitLoadavg    bool
 	NeverLocal      bool
+
+	// If set, the stages named in ReplayStages are not run.  Instead, their
+	// outputs are copied from the pipestance in this directory, which must
+	// be an absolute path.
+	ReplayFrom string
+
+	// Stage call IDs, or suffixes of fully-qualified call IDs, which
+	// should be replayed from ReplayFrom.
+	ReplayStages []string
+
+	// If set, faults are injected into the pipestance at the configured
+	// rates, for testing retry and recovery logic.
+	Chaos *ChaosConfig
+
+	// Configuration for the simulated cluster, if JobMode is "simcluster".
+	// If nil, defaults are used.
+	SimCluster *SimClusterConfig
+
+	// If nonzero, no new jobs are submitted while the filesystem containing
+	// the pipestance has fewer than this many bytes available.
+	MinFreeDisk uint64
+
+	// The number of bytes each stage is expected to write, keyed by
+	// fully-qualified stage name without the ID.psid. prefix, usually from
+	// ReadDiskForecast.  Stages are held back while their expected output
+	// would not fit in the available space, so long as other jobs are
+	// running which might free some.
+	DiskForecast map[string]uint64
+
+	// If set, the top-level outputs and their manifest are uploaded to this
+	// URI or absolute path after the pipestance completes successfully.
+	PublishUri string
 }
 
 const localMode = "local"
@@ -255,26 +306,40 @@ type Runtime struct {
 	LocalJobManager *LocalJobManager
 	overrides       *PipestanceOverrides
 	jobConfig       *JobManagerJson
+	chaos           *chaosMonkey
 	adaptersPath    string
 	mrjob           string
 }
 
 func (c *RuntimeOptions) NewRuntime() *Runtime {
+	return c.NewRuntimeWithJobConfig(getJobConfig(c.ProfileMode))
+}
+
+// NewRuntimeWithJobConfig creates a new runtime using the given job manager
+// configuration, rather than loading it from the martian installation
+// directory.
+func (c *RuntimeOptions) NewRuntimeWithJobConfig(jobConfig *JobManagerJson) *Runtime {
 	self := &Runtime{
 		Config:       c,
 		adaptersPath: util.RelPath(path.Join("..", "adapters")),
 		mrjob:        util.RelPath("mrjob"),
+		jobConfig:    jobConfig,
+		chaos:        newChaosMonkey(c.Chaos),
 	}
 
-	self.jobConfig = getJobConfig(c.ProfileMode)
 	self.LocalJobManager = NewLocalJobManager(c.LocalCores,
 		c.LocalMem, c.LocalVMem,
 		c.Debug,
 		c.LimitLoadavg,
 		c.JobMode != localMode,
 		self.jobConfig)
+	self.LocalJobManager.chaos = self.chaos
 	if c.JobMode == localMode {
 		self.JobManager = self.LocalJobManager
+	} else if c.JobMode == simClusterMode {
+		self.JobManager = NewSimClusterJobManager(c.MemPerCore, c.MaxJobs,
+			c.JobFreqMillis, c.ResourceSpecial, self.jobConfig, c.SimCluster,
+			c.Debug)
 	} else {
 		self.JobManager = NewRemoteJobManager(c.JobMode, c.MemPerCore, c.MaxJobs,
 			c.JobFreqMillis, c.ResourceSpecial, self.jobConfig, c.Debug)
@@ -347,11 +412,19 @@ func (self *Runtime) instantiatePipeline(src []byte, srcPath, psid,
 
 	// Lock the pipestance if not in read-only mode.
 	if !readOnly {
+		if err := pipestance.node.top.checkReplayStages(); err != nil {
+			return "", nil, nil, err
+		}
 		if err := pipestance.Lock(); err != nil {
 			return "", nil, nil, err
 		}
 		err = pipestance.getNode().mkdirs()
 	}
+	if err == nil {
+		if err = pipestance.node.top.stageInRemoteInputs(readOnly, ctx); err != nil && !readOnly {
+			pipestance.Unlock()
+		}
+	}
 
 	ast.TypeTable.Freeze()
 	return postsrc, ast, pipestance, err
@@ -651,6 +724,43 @@ func GetCallableFrom(pName, incPath string, mroPaths []string) (syntax.Callable,
 	}
 }
 
+// CompileCallableFrom returns the named callable from the given include path,
+// after compiling the file and its includes.  Unlike GetCallableFrom, the
+// type table is guaranteed to be complete.
+//
+// If incPath is empty, every file in the MROPATH is searched, as with
+// GetCallable.
+func CompileCallableFrom(pName, incPath string, mroPaths []string) (syntax.Callable, *syntax.TypeLookup, error) {
+	if incPath == "" {
+		return findCallable(mroPaths, pName,
+			func(data []byte, fpath string) (*syntax.Ast, error) {
+				_, _, ast, err := syntax.ParseSourceBytes(
+					data, fpath, mroPaths, false)
+				return ast, err
+			})
+	}
+	fpath, err := util.FindUniquePath(incPath, mroPaths)
+	if err != nil {
+		return nil, nil, err
+	}
+	b, err := os.ReadFile(fpath)
+	if err != nil {
+		return nil, nil, err
+	}
+	_, _, ast, err := syntax.ParseSourceBytes(b, fpath, mroPaths, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	if c := ast.Callables.Table[pName]; c != nil {
+		return c, &ast.TypeTable, nil
+	}
+	return nil, &ast.TypeTable, &RuntimeError{
+		Msg: fmt.Sprintf(
+			"%q is not a declared pipeline or stage in %q",
+			pName, fpath),
+	}
+}
+
 // GetCallable searches every file in $MROPATH/[^_]*.mro until it finds one
 // containing the given callable object (stage or pipeline) and returns it.
 //
@@ -668,6 +778,11 @@ func GetCallable(mroPaths []string, name string, compile bool) (syntax.Callable,
 			return ast, err
 		}
 	}
+	return findCallable(mroPaths, name, parse)
+}
+
+func findCallable(mroPaths []string, name string,
+	parse func([]byte, string) (*syntax.Ast, error)) (syntax.Callable, *syntax.TypeLookup, error) {
 	for _, mroPath := range mroPaths {
 		if fpaths, err := util.Readdirnames(mroPath); err == nil {
 			for _, fpath := range fpaths {
@@ -781,6 +896,21 @@ func convertToExp(parser *syntax.Parser, split bool, val json.Marshaler,
 		exp, err := parser.ParseValExp(val)
 		fixExpressionTypes(exp, tname, lookup)
 		return exp, err
+	case *docNode:
+		// Values from YAML or TOML documents, which are converted based
+		// on the expected type.
+		var exp syntax.ValExp
+		var err error
+		if split {
+			exp, err = val.splitExp(tname, lookup)
+		} else {
+			exp, err = val.toExp(tname, lookup)
+		}
+		if err != nil {
+			return nil, err
+		}
+		fixExpressionTypes(exp, tname, lookup)
+		return exp, nil
 	case LazyArgumentMap:
 		res := syntax.MapExp{
 			Kind:  syntax.KindMap,
@@ -938,6 +1068,16 @@ func BuildCallSource(
 	return ast.Format(), nil
 }
 
+// getInvocationCallable finds the callable for an invocation, either in the
+// given include file or by searching the MROPATH.
+func getInvocationCallable(call, include string,
+	mroPaths []string) (syntax.Callable, *syntax.TypeLookup, error) {
+	if include != "" {
+		return GetCallableFrom(call, include, mroPaths)
+	}
+	return GetCallable(mroPaths, call, false)
+}
+
 func (invocation *InvocationData) BuildCallAst(mroPaths []string) (*syntax.Ast, error) {
 	if invocation.Call == "" {
 		return nil, fmt.Errorf("no pipeline or stage specified")
@@ -945,23 +1085,10 @@ func (invocation *InvocationData) BuildCallAst(mroPaths []string) (*syntax.Ast,
 	if len(invocation.SweepArgs) > 0 {
 		return nil, fmt.Errorf("sweep is no longer supported - migrate to map call instead")
 	}
-	var callable syntax.Callable
-	var lookup *syntax.TypeLookup
-	if invocation.Include != "" {
-		c, l, err := GetCallableFrom(
-			invocation.Call, invocation.Include, mroPaths)
-		if err != nil {
-			return nil, err
-		}
-		callable = c
-		lookup = l
-	} else {
-		c, l, err := GetCallable(mroPaths, invocation.Call, false)
-		if err != nil {
-			return nil, err
-		}
-		callable = c
-		lookup = l
+	callable, lookup, err := getInvocationCallable(
+		invocation.Call, invocation.Include, mroPaths)
+	if err != nil {
+		return nil, err
 	}
 
 	if invocation.Args == nil {
@@ -271,7 +271,7 @@ func (self *Chunk) step(bindings MarshalerMap) {
 	}
 
 	// Belt and suspenders for not double-submitting a job.
-	if self.hasBeenRun {
+	if self.hasBeenRun || self.fork.holdForDisk() {
 		return
 	} else {
 		self.hasBeenRun = true
@@ -365,6 +365,11 @@ type Fork struct {
 	index         int
 	split_has_run bool
 	join_has_run  bool
+
+	// Set once the fork has been allowed to start, or held back, based on
+	// the available disk space.
+	diskAdmitted bool
+	diskHeld     bool
 }
 
 // Exportable information from a Fork object.
@@ -1004,13 +1009,17 @@ func (self *Fork) doSplit(getBindings func() MarshalerMap) MetadataState {
 		return Failed
 	}
 	self.writeInvocation()
-	if err := self.split_metadata.Write(ArgsFile, getBindings()); err != nil {
+	bindings := getBindings()
+	if err := self.split_metadata.Write(ArgsFile, bindings); err != nil {
 		util.LogError(err, "runtime",
 			"%s: Error writing args file.",
 			self.fqname)
 	}
+	if top := self.node.top; top.rt.Config.replays(top.relativeFqid(self.node)) {
+		return self.doReplay(bindings)
+	}
 	if self.Split() {
-		if !self.split_has_run {
+		if !self.split_has_run && !self.holdForDisk() {
 			self.split_has_run = true
 			self.lastPrint = time.Now()
 			self.node.runSplit(self.fqname, self.split_metadata)
@@ -1031,12 +1040,12 @@ func (self *Fork) doChunks(state MetadataState, getBindings func() MarshalerMap)
 	self.node.top.rt.JobManager.endJob(self.split_metadata)
 	if self.isVolatile() {
 		lockAquired := make(chan struct{}, 1)
-		go func() {
+		self.inBackground(func() {
 			self.storageLock.Lock()
 			defer self.storageLock.Unlock()
 			lockAquired <- struct{}{}
 			self.cleanSplitTemp(nil)
-		}()
+		})
 		<-lockAquired
 	}
 	// MARTIAN-395 We have observed a possible race condition where
@@ -1104,7 +1113,7 @@ Chunk count: %d`,
 }
 
 func (self *Fork) doJoin(state MetadataState, getBindings func() MarshalerMap) MetadataState {
-	go self.partialVdrKill()
+	self.inBackground(func() { self.partialVdrKill() })
 	if self.stageDefs.JoinDef == nil {
 		self.stageDefs.JoinDef = &JobResources{}
 	}
@@ -1186,7 +1195,7 @@ func (self *Fork) doJoin(state MetadataState, getBindings func() MarshalerMap) M
 			OutsFile,
 			makeOutArgs(self.OutParams(),
 				self.join_metadata.curFilesPath, false))
-		if !self.join_has_run {
+		if !self.join_has_run && !self.holdForDisk() {
 			self.join_has_run = true
 			self.lastPrint = time.Now()
 			self.node.runJoin(self.fqname, self.join_metadata, &res)
@@ -1256,14 +1265,14 @@ func (self *Fork) doComplete() {
 	}
 	self.removeEmptyFileArgs(joinOut)
 	if self.node.top.rt.Config.VdrMode != VdrPost {
-		go func() {
+		self.inBackground(func() {
 			func() {
 				self.storageLock.Lock()
 				defer self.storageLock.Unlock()
 				self.cacheParamFileMap(joinOut)
 			}()
 			self.partialVdrKill()
-		}()
+		})
 	}
 }
 
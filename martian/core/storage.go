@@ -37,6 +37,12 @@ type VDRKillReport struct {
 	Events    []*VdrEvent   `json:"events,omitempty"`
 	Count     uint          `json:"count"`
 	Size      uint64        `json:"size"`
+
+	// Where the paths were archived, in archive mode.
+	Archived []VdrArchivedPath `json:"archived,omitempty"`
+
+	// In report mode, the outputs which kept files from being removed.
+	Kept []*VdrKeptArg `json:"kept,omitempty"`
 }
 
 // Merge events with the same timestamp.
@@ -73,6 +79,8 @@ func mergeVDRKillReports(killReports []*VDRKillReport) *VDRKillReport {
 			allKillReport.Count += killReport.Count
 			allKillReport.Errors = append(allKillReport.Errors, killReport.Errors...)
 			allKillReport.Paths = append(allKillReport.Paths, killReport.Paths...)
+			allKillReport.Archived = append(allKillReport.Archived, killReport.Archived...)
+			allKillReport.Kept = append(allKillReport.Kept, killReport.Kept...)
 			allEvents = append(allEvents, killReport.Events...)
 			if allKillReport.Timestamp.IsZero() || allKillReport.Timestamp.Before(killReport.Timestamp) {
 				allKillReport.Timestamp = killReport.Timestamp
@@ -113,7 +121,7 @@ func (self *Fork) isStrictVolatile() bool {
 	if stage.Resources != nil && stage.Resources.StrictVolatile {
 		return true
 	}
-	if self.node.top.rt.Config.VdrMode == VdrStrict {
+	if mode := self.node.top.rt.Config.VdrMode; mode == VdrStrict || mode == VdrReport {
 		if stage.Resources == nil || stage.Resources.VolatileNode == nil {
 			return true
 		}
@@ -125,6 +133,17 @@ func (self *Fork) isStrictVolatile() bool {
 	return false
 }
 
+// inBackground runs f in a new goroutine, which
+// Pipestance.WaitForStorageCleanup waits for.
+func (self *Fork) inBackground(f func()) {
+	top := self.node.top
+	top.storageTasks.Add(1)
+	go func() {
+		defer top.storageTasks.Done()
+		f()
+	}()
+}
+
 func (self *Fork) partialVdrKill() (*VDRKillReport, bool) {
 	self.storageLock.Lock()
 	defer self.storageLock.Unlock()
@@ -241,20 +260,24 @@ func (self *Fork) vdrKillSome(partial *PartialVdrKillReport, done bool) (*VDRKil
 			return &partial.VDRKillReport, false
 		}
 	}
-	killPaths := make([]string, 0, len(self.fileParamMap))
-	for file, keepAliveArgs := range self.fileParamMap {
-		if keepAliveArgs.args == nil {
-			killPaths = append(killPaths, file)
-		}
+	killPaths := self.unreferencedFiles()
+	if partial != nil && self.node.top.rt.Config.VdrMode == VdrReport {
+		// Files are not actually removed in report mode, so don't count
+		// them twice if mrp was restarted.
+		killPaths = skipReportedPaths(killPaths, partial.Paths)
 	}
 	if len(killPaths) == 0 {
 		if done {
 			if partial != nil {
+				partial.Kept = self.keptArgs()
 				partial.VDRKillReport.mergeEvents()
 				self.metadata.Write(VdrKill, &partial.VDRKillReport)
 			} else {
 				self.metadata.Write(VdrKill,
-					VDRKillReport{Timestamp: WallClockTime(time.Now())})
+					VDRKillReport{
+						Timestamp: WallClockTime(time.Now()),
+						Kept:      self.keptArgs(),
+					})
 			}
 			self.deletePartialKill()
 		}
@@ -267,38 +290,25 @@ func (self *Fork) vdrKillSome(partial *PartialVdrKillReport, done bool) (*VDRKil
 	if partial == nil {
 		partial = new(PartialVdrKillReport)
 	}
-	sort.Strings(killPaths)
-	collapsedPaths := make([]string, 0, len(killPaths))
+	collapsedPaths, size, count := self.collapseKillPaths(killPaths)
 
 	var event VdrEvent
-	for _, fpath := range killPaths {
-		entry := self.fileParamMap[fpath]
-		event.DeltaBytes -= entry.size
-		partial.Size += uint64(entry.size)
-		partial.Count += uint(entry.count)
-		if len(collapsedPaths) == 0 || !pathIsInside(fpath, collapsedPaths[len(collapsedPaths)-1]) {
-			collapsedPaths = append(collapsedPaths, fpath)
-		} else {
-			other := self.fileParamMap[collapsedPaths[len(collapsedPaths)-1]]
-			other.size += entry.size
-			other.count += entry.count
-			delete(self.fileParamMap, fpath)
-		}
-	}
+	event.DeltaBytes -= int64(size)
+	partial.Size += size
+	partial.Count += count
 	partial.Paths = append(partial.Paths, collapsedPaths...)
 	partial.Events = append(partial.Events, &event)
 	util.EnterCriticalSection()
 	defer util.ExitCriticalSection()
+	self.removeVolatile(collapsedPaths, &partial.VDRKillReport)
 	for _, fpath := range collapsedPaths {
-		if err := os.RemoveAll(fpath); err != nil {
-			partial.Errors = append(partial.Errors, err.Error())
-		}
 		delete(self.fileParamMap, fpath)
 	}
 	event.Timestamp = time.Now()
 	partial.Timestamp = WallClockTime(event.Timestamp)
 
 	if len(self.fileParamMap) == 0 || done || len(self.filePostNodes) == 0 {
+		partial.Kept = self.keptArgs()
 		partial.VDRKillReport.mergeEvents()
 		self.metadata.Write(VdrKill, &partial.VDRKillReport)
 		self.deletePartialKill()
@@ -323,6 +333,53 @@ func (self *Fork) vdrKillSome(partial *PartialVdrKillReport, done bool) (*VDRKil
 	}
 }
 
+// unreferencedFiles returns the files in the parameter file map which are
+// not being kept alive by any argument.
+func (self *Fork) unreferencedFiles() []string {
+	killPaths := make([]string, 0, len(self.fileParamMap))
+	for file, keepAliveArgs := range self.fileParamMap {
+		if keepAliveArgs.args == nil {
+			killPaths = append(killPaths, file)
+		}
+	}
+	return killPaths
+}
+
+// collapseKillPaths sorts the given paths from the parameter file map and
+// merges any path inside another one into the entry for its parent.  It
+// returns the remaining paths, and the total size and file count of all of
+// the paths.
+func (self *Fork) collapseKillPaths(killPaths []string) ([]string, uint64, uint) {
+	sort.Strings(killPaths)
+	collapsedPaths := make([]string, 0, len(killPaths))
+	var size uint64
+	var count uint
+	for _, fpath := range killPaths {
+		entry := self.fileParamMap[fpath]
+		size += uint64(entry.size)
+		count += uint(entry.count)
+		if len(collapsedPaths) == 0 || !pathIsInside(fpath, collapsedPaths[len(collapsedPaths)-1]) {
+			collapsedPaths = append(collapsedPaths, fpath)
+		} else {
+			other := self.fileParamMap[collapsedPaths[len(collapsedPaths)-1]]
+			other.size += entry.size
+			other.count += entry.count
+			delete(self.fileParamMap, fpath)
+		}
+	}
+	return collapsedPaths, size, count
+}
+
+// removeTemp removes a temporary directory, unless in report mode.
+func (self *Fork) removeTemp(td string, report *VDRKillReport) {
+	if self.node.top.rt.Config.VdrMode == VdrReport {
+		return
+	}
+	if err := os.RemoveAll(td); err != nil {
+		report.Errors = append(report.Errors, err.Error())
+	}
+}
+
 func pathIsInside(test, parent string) bool {
 	// Early abort in the common case.
 	if test == parent {
@@ -731,9 +788,7 @@ func (self *Fork) cleanSplitTemp(partial *PartialVdrKillReport) *PartialVdrKillR
 			defer util.ExitCriticalSection()
 		}
 		if td := self.split_metadata.TempDir(); td != "" {
-			if err := os.RemoveAll(self.split_metadata.TempDir()); err != nil {
-				partial.Errors = append(partial.Errors, err.Error())
-			}
+			self.removeTemp(td, &partial.VDRKillReport)
 			if cleanupEvent.DeltaBytes != 0 {
 				cleanupEvent.Timestamp = time.Now()
 				partial.Events = append(partial.Events, &cleanupEvent)
@@ -830,9 +885,7 @@ func (self *Fork) cleanChunkTemp(partial *PartialVdrKillReport) *PartialVdrKillR
 
 	for _, chunk := range self.chunks {
 		if td := chunk.metadata.TempDir(); td != "" {
-			if err := os.RemoveAll(td); err != nil {
-				partial.Errors = append(partial.Errors, err.Error())
-			}
+			self.removeTemp(td, &partial.VDRKillReport)
 		}
 	}
 	if cleanupEvent.DeltaBytes != 0 {
@@ -916,9 +969,7 @@ func (self *Fork) cleanJoinTemp(partial *PartialVdrKillReport) *PartialVdrKillRe
 			defer util.ExitCriticalSection()
 		}
 		if td := self.join_metadata.TempDir(); td != "" {
-			if err := os.RemoveAll(td); err != nil {
-				partial.Errors = append(partial.Errors, err.Error())
-			}
+			self.removeTemp(td, &partial.VDRKillReport)
 			if cleanupEvent.DeltaBytes != 0 {
 				cleanupEvent.Timestamp = time.Now()
 				partial.Events = append(partial.Events, &cleanupEvent)
@@ -984,10 +1035,8 @@ func (self *Fork) vdrKill(partialKill *PartialVdrKillReport) *VDRKillReport {
 	// Critical section to avoid loosing accounting info.
 	util.EnterCriticalSection()
 	defer util.ExitCriticalSection()
-	// Actually delete the paths.
-	for _, p := range killPaths {
-		os.RemoveAll(p)
-	}
+	// Actually delete (or archive) the paths.
+	self.removeVolatile(killPaths, killReport)
 	// update timestamp to mark actual kill time
 	killReport.Timestamp = WallClockTime(time.Now())
 	if killReport.Size > 0 {
@@ -0,0 +1,388 @@
+// Copyright (c) 2020 10X Genomics, Inc. All rights reserved.
+
+package core
+
+//
+// Archiving volatile files instead of deleting them, and restoring them.
+//
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/martian-lang/martian/martian/util"
+)
+
+// VdrArchivedPath records where a file or directory removed by VDR was
+// archived.
+type VdrArchivedPath struct {
+	// The original path.
+	Path string `json:"path"`
+
+	// For directory archives, the path the file was moved to.  For tarball
+	// archives, the path to the tarball.
+	Archive string `json:"archive"`
+
+	// For tarball archives, the name of the tarball entry for Path.
+	Member string `json:"member,omitempty"`
+}
+
+// removeVolatile removes the given paths, after archiving them if the VDR
+// mode is archive, or does nothing in report mode.  Paths which could not
+// be archived are not removed.  Archive locations and errors are recorded
+// in the report.
+func (self *Fork) removeVolatile(paths []string, report *VDRKillReport) {
+	switch self.node.top.rt.Config.VdrMode {
+	case VdrReport:
+		return
+	case VdrArchive:
+		if len(paths) == 0 {
+			return
+		}
+		archived, err := self.archiveVolatile(paths)
+		if err != nil {
+			report.Errors = append(report.Errors, err.Error())
+		}
+		report.Archived = append(report.Archived, archived...)
+		paths = make([]string, len(archived))
+		for i, a := range archived {
+			paths[i] = a.Path
+		}
+	}
+	for _, p := range paths {
+		if err := os.RemoveAll(p); err != nil {
+			report.Errors = append(report.Errors, err.Error())
+		}
+	}
+}
+
+// archiveVolatile copies or moves the given paths to the archive, and
+// returns the records for the paths which were successfully archived.
+func (self *Fork) archiveVolatile(paths []string) ([]VdrArchivedPath, error) {
+	top := self.node.top
+	root := top.rt.Config.VdrArchiveRoot
+	if root == "" {
+		return nil, fmt.Errorf("no VDR archive root is configured")
+	}
+	root = path.Join(root, top.GetPsid())
+	psdir := top.node.path
+	if top.rt.Config.VdrArchiveTar {
+		rel, err := archiveRelPath(psdir, self.path)
+		if err != nil {
+			return nil, err
+		}
+		return archiveTarball(path.Join(root, rel), psdir, paths)
+	}
+	archived := make([]VdrArchivedPath, 0, len(paths))
+	var errs []string
+	for _, p := range paths {
+		rel, err := archiveRelPath(psdir, p)
+		if err == nil {
+			dest := path.Join(root, rel)
+			if err = moveTree(p, dest); err == nil {
+				archived = append(archived, VdrArchivedPath{
+					Path:    p,
+					Archive: dest,
+				})
+				continue
+			}
+		}
+		errs = append(errs, err.Error())
+	}
+	if len(errs) > 0 {
+		return archived, fmt.Errorf("archiving volatile files: %s",
+			strings.Join(errs, "; "))
+	}
+	return archived, nil
+}
+
+// archiveRelPath returns the path relative to the pipestance directory.
+func archiveRelPath(psdir, p string) (string, error) {
+	rel, err := filepath.Rel(psdir, p)
+	if err != nil {
+		return rel, err
+	}
+	if rel == ".." || strings.HasPrefix(rel, "../") {
+		return rel, fmt.Errorf("%s is not in the pipestance directory", p)
+	}
+	return rel, nil
+}
+
+// moveTree moves src to dst, copying it if it cannot be renamed, for example
+// because dst is on a different filesystem.  A copied src is not removed.
+func moveTree(src, dst string) error {
+	if err := os.MkdirAll(path.Dir(dst), 0777); err != nil {
+		return err
+	}
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+	return copyTree(src, dst)
+}
+
+// copyTree recreates the directory tree src at dst, preserving symlinks and
+// replacing any existing files.
+func copyTree(src, dst string) error {
+	return util.Walk(src, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		target := path.Join(dst, rel)
+		if info.IsDir() {
+			return os.MkdirAll(target, info.Mode().Perm()|0700)
+		}
+		if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			link, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		}
+		return copyFile(p, target, info.Mode())
+	})
+}
+
+// archiveTarball packs the given paths into a new gzipped tarball in dir,
+// with entries named relative to psdir.
+func archiveTarball(dir, psdir string, paths []string) ([]VdrArchivedPath, error) {
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return nil, err
+	}
+	existing, _ := filepath.Glob(path.Join(dir, "volatile_*.tar.gz"))
+	tarball := path.Join(dir, fmt.Sprintf("volatile_%d.tar.gz", len(existing)))
+	f, err := os.OpenFile(tarball, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return nil, err
+	}
+	archived := make([]VdrArchivedPath, 0, len(paths))
+	err = func() error {
+		gz := gzip.NewWriter(f)
+		tw := tar.NewWriter(gz)
+		for _, p := range paths {
+			member, err := archiveRelPath(psdir, p)
+			if err != nil {
+				return err
+			}
+			if err := addToTar(tw, psdir, p); err != nil {
+				return err
+			}
+			archived = append(archived, VdrArchivedPath{
+				Path:    p,
+				Archive: tarball,
+				Member:  member,
+			})
+		}
+		if err := tw.Close(); err != nil {
+			return err
+		}
+		return gz.Close()
+	}()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		// Don't leave a partial tarball around, and don't claim anything
+		// was archived.
+		os.Remove(tarball)
+		return nil, err
+	}
+	return archived, nil
+}
+
+func addToTar(tw *tar.Writer, psdir, root string) error {
+	return util.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		var link string
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(p); err != nil {
+				return err
+			}
+		}
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		if hdr.Name, err = filepath.Rel(psdir, p); err != nil {
+			return err
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		in, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		_, err = io.Copy(tw, in)
+		return err
+	})
+}
+
+// RestoreVolatile restores the files which volatile data removal archived
+// for the stages in the pipestance which match the given name, so that
+// stages downstream of them can be rerun.  The stage may be given by its
+// call ID or by a suffix of its fully-qualified name.
+//
+// Files are copied back from the archive, which is left in place.  Returns
+// the paths which were restored.
+func RestoreVolatile(psdir, stage string) ([]string, error) {
+	reports, err := findVdrKillReports(psdir, stage)
+	if err != nil {
+		return nil, err
+	}
+	if len(reports) == 0 {
+		return nil, fmt.Errorf("no volatile data removal records for %s in %s",
+			stage, psdir)
+	}
+	var restored []string
+	for _, report := range reports {
+		tarballs := make(map[string][]VdrArchivedPath)
+		for _, a := range report.Archived {
+			if a.Member == "" {
+				if err := copyTree(a.Archive, a.Path); err != nil {
+					return restored, err
+				}
+				restored = append(restored, a.Path)
+			} else {
+				tarballs[a.Archive] = append(tarballs[a.Archive], a)
+			}
+		}
+		names := make([]string, 0, len(tarballs))
+		for tarball := range tarballs {
+			names = append(names, tarball)
+		}
+		sort.Strings(names)
+		for _, tarball := range names {
+			if err := extractTarball(tarball, tarballs[tarball]); err != nil {
+				return restored, err
+			}
+			for _, a := range tarballs[tarball] {
+				restored = append(restored, a.Path)
+			}
+		}
+	}
+	return restored, nil
+}
+
+// findVdrKillReports reads the volatile data removal reports for the forks
+// of stages matching the given name.
+func findVdrKillReports(psdir, stage string) ([]*VDRKillReport, error) {
+	var reports []*VDRKillReport
+	err := filepath.WalkDir(psdir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			// Don't bother looking through stage outputs.
+			if name := d.Name(); name == "files" || name == "outs" ||
+				name == "journal" || name == "tmp" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.Name() != VdrKill.FileName() {
+			return nil
+		}
+		rel, err := filepath.Rel(psdir, path.Dir(path.Dir(p)))
+		if err != nil {
+			return err
+		}
+		if !replayMatches(stage, strings.ReplaceAll(rel, "/", ".")) {
+			return nil
+		}
+		var report VDRKillReport
+		if err := NewMetadata("", path.Dir(p)).ReadInto(VdrKill, &report); err != nil {
+			return err
+		}
+		reports = append(reports, &report)
+		return nil
+	})
+	return reports, err
+}
+
+// extractTarball extracts the entries for the given archived paths from a
+// tarball back to their original locations.
+func extractTarball(tarball string, archived []VdrArchivedPath) error {
+	f, err := os.Open(tarball)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		target := ""
+		for _, a := range archived {
+			if hdr.Name == a.Member {
+				target = a.Path
+			} else if strings.HasPrefix(hdr.Name, a.Member+"/") {
+				target = a.Path + hdr.Name[len(a.Member):]
+			}
+		}
+		if target == "" {
+			continue
+		}
+		if err := extractTarEntry(tr, hdr, target); err != nil {
+			return err
+		}
+	}
+}
+
+func extractTarEntry(tr *tar.Reader, hdr *tar.Header, target string) error {
+	mode := hdr.FileInfo().Mode()
+	if mode.IsDir() {
+		return os.MkdirAll(target, mode.Perm()|0700)
+	}
+	if err := os.MkdirAll(path.Dir(target), 0777); err != nil {
+		return err
+	}
+	if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	switch hdr.Typeflag {
+	case tar.TypeSymlink:
+		return os.Symlink(hdr.Linkname, target)
+	case tar.TypeReg:
+		out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL,
+			mode.Perm())
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(out, tr); err != nil {
+			out.Close()
+			return err
+		}
+		return out.Close()
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+// Copyright (c) 2020 10X Genomics, Inc. All rights reserved.
+
+package core
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/martian-lang/martian/martian/util"
+)
+
+func testVdrArchive(t *testing.T, tarball bool) {
+	util.SetPrintLogger(testLogger{t: t})
+	defer util.SetPrintLogger(&devNull)
+	archive := t.TempDir()
+	rtOpts := DefaultRuntimeOptions()
+	rtOpts.VdrMode = VdrArchive
+	rtOpts.VdrArchiveRoot = archive
+	rtOpts.VdrArchiveTar = tarball
+	psdir, _ := runTestPipestance(t, &rtOpts, "vdr_archive")
+	forkdir := path.Join(psdir, "ARCHIVE", "MAKE", defaultFork)
+	file := path.Join(forkdir, "files", "file.txt")
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Fatal("volatile file was not removed")
+	}
+	var report VDRKillReport
+	if err := NewMetadata("", forkdir).ReadInto(VdrKill, &report); err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Errors) > 0 {
+		t.Errorf("vdr errors: %v", report.Errors)
+	}
+	if len(report.Archived) == 0 {
+		t.Fatal("no archived paths recorded")
+	}
+	for _, a := range report.Archived {
+		if !strings.HasPrefix(a.Archive, path.Join(archive, "vdr_archive")+"/") {
+			t.Errorf("%s archived outside of the archive root: %s",
+				a.Path, a.Archive)
+		}
+		if tarball != (a.Member != "") {
+			t.Errorf("unexpected archive member %q", a.Member)
+		}
+	}
+	if _, err := RestoreVolatile(psdir, "NOT_A_STAGE"); err == nil {
+		t.Error("expected an error restoring a nonexistent stage")
+	}
+	// Restoring twice should work, since the archive is left in place.
+	for i := 0; i < 2; i++ {
+		if restored, err := RestoreVolatile(psdir, "ARCHIVE.MAKE"); err != nil {
+			t.Fatal(err)
+		} else if len(restored) != len(report.Archived) {
+			t.Errorf("restored %v", restored)
+		}
+		if b, err := os.ReadFile(file); err != nil {
+			t.Error(err)
+		} else if string(b) != "first" {
+			t.Errorf("restored content %q", b)
+		}
+	}
+}
+
+func TestVdrArchive(t *testing.T) {
+	testVdrArchive(t, false)
+}
+
+func TestVdrArchiveTar(t *testing.T) {
+	testVdrArchive(t, true)
+}
@@ -0,0 +1,186 @@
+// Copyright (c) 2020 10X Genomics, Inc. All rights reserved.
+
+package core
+
+//
+// Reporting what volatile data removal would remove, without removing it.
+//
+
+import (
+	"sort"
+
+	"github.com/martian-lang/martian/martian/syntax"
+)
+
+// VdrKeptArg records the files which volatile data removal could not remove
+// because they were referenced by a stage output which was retained or
+// returned by the top-level pipeline.
+type VdrKeptArg struct {
+	// The name of the stage output.
+	Arg string `json:"arg"`
+
+	// Set if the output is named in a retain declaration of the stage or a
+	// pipeline, rather than only being used by the pipeline outputs.
+	Retain bool `json:"retain,omitempty"`
+
+	Count uint   `json:"count"`
+	Size  uint64 `json:"size"`
+}
+
+// VdrStageReport summarizes what volatile data removal would have removed
+// for one fork of a stage.
+type VdrStageReport struct {
+	Fqname string   `json:"fqname"`
+	Paths  []string `json:"paths"`
+	Count  uint     `json:"count"`
+	Size   uint64   `json:"size"`
+
+	// When the files would have been removed, and how many bytes were
+	// freed at each time.
+	Freed []*VdrEvent `json:"freed,omitempty"`
+
+	// The outputs which kept files from being removed.
+	Kept []*VdrKeptArg `json:"kept,omitempty"`
+}
+
+// VdrDryRunReport is the summary written by report VDR mode.
+type VdrDryRunReport struct {
+	Stages []*VdrStageReport `json:"stages"`
+	Count  uint              `json:"count"`
+	Size   uint64            `json:"size"`
+
+	// The high water mark of the storage actually used by the pipestance.
+	MaxBytes int64 `json:"maxbytes"`
+
+	// The high water mark the storage used by the pipestance would have
+	// had with volatile data removal.
+	ProjectedMaxBytes int64 `json:"projected_maxbytes"`
+
+	// The storage the pipestance would have used over time with volatile
+	// data removal.
+	ProjectedBytesHist []*NodeByteStamp `json:"projected_bytehist"`
+}
+
+// keptArgs returns the outputs keeping files in the fork's parameter file
+// map alive, in report mode.
+func (self *Fork) keptArgs() []*VdrKeptArg {
+	if self.node.top.rt.Config.VdrMode != VdrReport {
+		return nil
+	}
+	return self.listKeptArgs()
+}
+
+// listKeptArgs returns the outputs keeping files in the fork's parameter file
+// map alive.
+func (self *Fork) listKeptArgs() []*VdrKeptArg {
+	if len(self.fileParamMap) == 0 {
+		return nil
+	}
+	retained := self.retainedArgs()
+	kept := make(map[string]*VdrKeptArg)
+	for _, entry := range self.fileParamMap {
+		for arg := range entry.args {
+			k := kept[arg]
+			if k == nil {
+				_, retain := retained[arg]
+				k = &VdrKeptArg{Arg: arg, Retain: retain}
+				kept[arg] = k
+			}
+			k.Count += uint(entry.count)
+			k.Size += uint64(entry.size)
+		}
+	}
+	result := make([]*VdrKeptArg, 0, len(kept))
+	for _, k := range kept {
+		result = append(result, k)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Arg < result[j].Arg
+	})
+	return result
+}
+
+// retainedArgs returns the set of outputs of the fork's stage which are
+// named in retain declarations, either by the stage or by a pipeline.
+func (self *Fork) retainedArgs() map[string]struct{} {
+	retained := make(map[string]struct{})
+	fqid := self.node.call.GetFqid()
+	for _, r := range self.node.call.Retained() {
+		retained[r.OutputId] = struct{}{}
+	}
+	for p := self.node.parent; p != nil; p = p.getNode().parent {
+		if call := p.getNode().call; call != nil {
+			for _, r := range call.Retained() {
+				if r.Id == fqid {
+					retained[r.OutputId] = struct{}{}
+				}
+			}
+		}
+	}
+	return retained
+}
+
+// skipReportedPaths returns the paths which are not inside any of the
+// reported paths.
+func skipReportedPaths(paths, reported []string) []string {
+	if len(reported) == 0 {
+		return paths
+	}
+	result := paths[:0]
+	for _, p := range paths {
+		found := false
+		for _, r := range reported {
+			if pathIsInside(p, r) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+// VDRReport summarizes what volatile data removal would have removed from
+// each stage of the pipestance, and when, based on the reports written in
+// report VDR mode.  The summary is written to the pipestance's _vdrreport
+// file.
+func (self *Pipestance) VDRReport() *VdrDryRunReport {
+	report := &VdrDryRunReport{
+		Stages: make([]*VdrStageReport, 0),
+	}
+	for _, node := range self.allNodes() {
+		if node.call.Kind() != syntax.KindStage {
+			continue
+		}
+		for _, fork := range node.forks {
+			killReport, ok := fork.getVdrKillReport()
+			if !ok || (killReport.Count == 0 && len(killReport.Kept) == 0) {
+				continue
+			}
+			stage := &VdrStageReport{
+				Fqname: fork.fqname,
+				Paths:  killReport.Paths,
+				Count:  killReport.Count,
+				Size:   killReport.Size,
+				Kept:   killReport.Kept,
+			}
+			for _, ev := range killReport.Events {
+				if ev.DeltaBytes < 0 {
+					stage.Freed = append(stage.Freed, ev)
+				}
+			}
+			report.Stages = append(report.Stages, stage)
+			report.Count += stage.Count
+			report.Size += stage.Size
+		}
+	}
+	sort.SliceStable(report.Stages, func(i, j int) bool {
+		return report.Stages[i].Size > report.Stages[j].Size
+	})
+	report.ProjectedBytesHist, report.ProjectedMaxBytes = self.diskUsage(true)
+	_, report.MaxBytes = self.diskUsage(false)
+	self.metadata.Write(VdrReportFile, report)
+	return report
+}
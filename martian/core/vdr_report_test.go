@@ -0,0 +1,83 @@
+// Copyright (c) 2020 10X Genomics, Inc. All rights reserved.
+
+package core
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+
+	"github.com/martian-lang/martian/martian/util"
+)
+
+func TestVdrReport(t *testing.T) {
+	util.SetPrintLogger(testLogger{t: t})
+	defer util.SetPrintLogger(&devNull)
+	rtOpts := DefaultRuntimeOptions()
+	rtOpts.VdrMode = VdrReport
+	psdir, pipestance := runTestPipestance(t, &rtOpts, "vdr_report")
+	report := pipestance.VDRReport()
+	file := path.Join(psdir, "REPORT", "MAKE", defaultFork, "files", "file.txt")
+	if _, err := os.Stat(file); err != nil {
+		t.Error("file removed in report mode:", err)
+	}
+	var stage *VdrStageReport
+	for _, s := range report.Stages {
+		if s.Fqname == "ID.vdr_report.REPORT.MAKE.fork0" {
+			stage = s
+		}
+	}
+	if stage == nil {
+		t.Fatalf("MAKE not in report %v", report.Stages)
+	}
+	if stage.Size == 0 || len(stage.Freed) == 0 {
+		t.Errorf("expected MAKE to free space, got %d bytes, %d events",
+			stage.Size, len(stage.Freed))
+	}
+	resolved, err := filepath.EvalSymlinks(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, p := range stage.Paths {
+		if pathIsInside(resolved, p) {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("%s not in reported paths %v", file, stage.Paths)
+	}
+	if report.ProjectedMaxBytes > report.MaxBytes {
+		t.Errorf("projected peak %d larger than actual peak %d",
+			report.ProjectedMaxBytes, report.MaxBytes)
+	}
+	if _, err := os.Stat(path.Join(psdir, VdrReportFile.FileName())); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestVdrReportRetain(t *testing.T) {
+	util.SetPrintLogger(testLogger{t: t})
+	defer util.SetPrintLogger(&devNull)
+	rtOpts := DefaultRuntimeOptions()
+	rtOpts.VdrMode = VdrReport
+	_, pipestance := runTestPipestance(t, &rtOpts, "vdr_report")
+	report := pipestance.VDRReport()
+	for _, s := range report.Stages {
+		if s.Fqname != "ID.vdr_report.REPORT.KEEP.fork0" {
+			continue
+		}
+		for _, k := range s.Kept {
+			if k.Arg == "file" {
+				if !k.Retain {
+					t.Error("file was not kept by retain")
+				}
+				return
+			}
+		}
+		t.Errorf("file not kept: %v", s.Kept)
+		return
+	}
+	t.Errorf("KEEP not in report %v", report.Stages)
+}
@@ -0,0 +1,464 @@
+// Copyright (c) 2020 10X Genomics, Inc. All rights reserved.
+
+// Utilities for computing the differences in the callable API between two
+// versions of a set of pipeline definitions.
+
+package syntax
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ApiChangeKind identifies the kind of change reported in an ApiChange.
+type ApiChangeKind string
+
+const (
+	ApiCallableAdded       ApiChangeKind = "callable_added"
+	ApiCallableRemoved     ApiChangeKind = "callable_removed"
+	ApiCallableKindChanged ApiChangeKind = "callable_kind_changed"
+	ApiInputAdded          ApiChangeKind = "input_added"
+	ApiInputRemoved        ApiChangeKind = "input_removed"
+	ApiInputRetyped        ApiChangeKind = "input_retyped"
+	ApiOutputAdded         ApiChangeKind = "output_added"
+	ApiOutputRemoved       ApiChangeKind = "output_removed"
+	ApiOutputRetyped       ApiChangeKind = "output_retyped"
+	ApiOutputRenamed       ApiChangeKind = "output_renamed"
+	ApiFieldAdded          ApiChangeKind = "field_added"
+	ApiFieldRemoved        ApiChangeKind = "field_removed"
+	ApiFieldRetyped        ApiChangeKind = "field_retyped"
+	ApiSplitChanged        ApiChangeKind = "split_changed"
+	ApiResourcesChanged    ApiChangeKind = "resources_changed"
+	ApiSrcChanged          ApiChangeKind = "src_changed"
+)
+
+// ApiChange describes a single difference in the API of a callable.
+type ApiChange struct {
+	// The ID of the stage or pipeline which changed.
+	Callable string `json:"callable"`
+
+	// The kind of change.
+	Kind ApiChangeKind `json:"kind"`
+
+	// The parameter affected by the change, if any.  For changes to struct
+	// fields, this is the path to the field, e.g. `param.field.subfield`.
+	Param string `json:"param,omitempty"`
+
+	// A description of the old value, if applicable.
+	Old string `json:"old,omitempty"`
+
+	// A description of the new value, if applicable.
+	New string `json:"new,omitempty"`
+
+	// True if existing calls to the callable, or invocations of it, may
+	// fail to compile or run after the change, or if consumers of its
+	// outputs may be broken by it.
+	Breaking bool `json:"breaking"`
+}
+
+// ApiChanges is a list of changes, ordered by callable.
+type ApiChanges []ApiChange
+
+// HasBreaking returns true if any of the changes are breaking.
+func (changes ApiChanges) HasBreaking() bool {
+	for i := range changes {
+		if changes[i].Breaking {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *ApiChange) String() string {
+	var buf strings.Builder
+	if c.Breaking {
+		buf.WriteString("breaking:     ")
+	} else {
+		buf.WriteString("non-breaking: ")
+	}
+	buf.WriteString(c.Callable)
+	buf.WriteString(": ")
+	buf.WriteString(strings.ReplaceAll(string(c.Kind), "_", " "))
+	if c.Param != "" {
+		buf.WriteRune(' ')
+		buf.WriteString(c.Param)
+	}
+	switch {
+	case c.Old != "" && c.New != "":
+		buf.WriteString(" (")
+		buf.WriteString(c.Old)
+		buf.WriteString(" -> ")
+		buf.WriteString(c.New)
+		buf.WriteRune(')')
+	case c.Old != "":
+		buf.WriteString(" (")
+		buf.WriteString(c.Old)
+		buf.WriteRune(')')
+	case c.New != "":
+		buf.WriteString(" (")
+		buf.WriteString(c.New)
+		buf.WriteRune(')')
+	}
+	return buf.String()
+}
+
+// ApiDiff returns the set of changes to the callable API between this
+// compiled AST and a newer version.
+//
+// Every callable present in either AST is compared, including those
+// defined in included files.  Changes to struct types are reported for
+// every parameter of a callable which uses the struct, since the impact
+// of the change depends on whether the struct is used as an input or an
+// output.
+//
+// Adding or removing an input is breaking, as is removing an output.
+// Changing the type of an input is only breaking if values of the old type
+// cannot be assigned to the new type, and conversely for outputs.  Changes
+// to resources, stage source, or split status are never breaking.
+func (ast *Ast) ApiDiff(newer *Ast) ApiChanges {
+	d := apiDiffer{
+		oldTypes: &ast.TypeTable,
+		newTypes: &newer.TypeTable,
+	}
+	oldCallables := callableTable(ast.Callables)
+	newCallables := callableTable(newer.Callables)
+	for _, c := range ast.Callables.List {
+		if nc := newCallables[c.GetId()]; nc == nil {
+			d.add(ApiChange{
+				Callable: c.GetId(),
+				Kind:     ApiCallableRemoved,
+				Old:      c.Type(),
+				Breaking: true,
+			})
+		} else {
+			d.diffCallable(c, nc)
+		}
+	}
+	for _, c := range newer.Callables.List {
+		if oldCallables[c.GetId()] == nil {
+			d.add(ApiChange{
+				Callable: c.GetId(),
+				Kind:     ApiCallableAdded,
+				New:      c.Type(),
+			})
+		}
+	}
+	sort.SliceStable(d.changes, func(i, j int) bool {
+		return d.changes[i].Callable < d.changes[j].Callable
+	})
+	return d.changes
+}
+
+func callableTable(callables *Callables) map[string]Callable {
+	if callables == nil {
+		return nil
+	}
+	if callables.Table != nil {
+		return callables.Table
+	}
+	table := make(map[string]Callable, len(callables.List))
+	for _, c := range callables.List {
+		table[c.GetId()] = c
+	}
+	return table
+}
+
+type apiDiffer struct {
+	oldTypes, newTypes *TypeLookup
+	changes            ApiChanges
+
+	// The name of the callable currently being compared.
+	callable string
+}
+
+func (d *apiDiffer) add(c ApiChange) {
+	if c.Callable == "" {
+		c.Callable = d.callable
+	}
+	d.changes = append(d.changes, c)
+}
+
+func (d *apiDiffer) diffCallable(oldCallable, newCallable Callable) {
+	d.callable = oldCallable.GetId()
+	if oldCallable.Type() != newCallable.Type() {
+		d.add(ApiChange{
+			Kind:     ApiCallableKindChanged,
+			Old:      oldCallable.Type(),
+			New:      newCallable.Type(),
+			Breaking: true,
+		})
+	}
+	d.diffInParams(oldCallable.GetInParams(), newCallable.GetInParams())
+	d.diffOutParams(oldCallable.GetOutParams(), newCallable.GetOutParams())
+	if oldStage, ok := oldCallable.(*Stage); ok {
+		if newStage, ok := newCallable.(*Stage); ok {
+			d.diffStage(oldStage, newStage)
+		}
+	}
+}
+
+func (d *apiDiffer) diffInParams(oldParams, newParams *InParams) {
+	if oldParams != nil {
+		for _, p := range oldParams.List {
+			if np := findInParam(newParams, p.Id); np == nil {
+				d.add(ApiChange{
+					Kind:     ApiInputRemoved,
+					Param:    p.Id,
+					Old:      p.Tname.String(),
+					Breaking: true,
+				})
+			} else {
+				d.diffType(p.Id, p.Tname, np.Tname, false,
+					make(map[string]struct{}))
+			}
+		}
+	}
+	if newParams != nil {
+		for _, p := range newParams.List {
+			if findInParam(oldParams, p.Id) == nil {
+				d.add(ApiChange{
+					Kind:     ApiInputAdded,
+					Param:    p.Id,
+					New:      p.Tname.String(),
+					Breaking: true,
+				})
+			}
+		}
+	}
+}
+
+func (d *apiDiffer) diffOutParams(oldParams, newParams *OutParams) {
+	if oldParams != nil {
+		for _, p := range oldParams.List {
+			if np := findOutParam(newParams, p.Id); np == nil {
+				d.add(ApiChange{
+					Kind:     ApiOutputRemoved,
+					Param:    p.Id,
+					Old:      p.Tname.String(),
+					Breaking: true,
+				})
+			} else {
+				d.diffType(p.Id, p.Tname, np.Tname, true,
+					make(map[string]struct{}))
+				if p.OutName != np.OutName {
+					d.add(ApiChange{
+						Kind:     ApiOutputRenamed,
+						Param:    p.Id,
+						Old:      strconv.Quote(p.OutName),
+						New:      strconv.Quote(np.OutName),
+						Breaking: true,
+					})
+				}
+			}
+		}
+	}
+	if newParams != nil {
+		for _, p := range newParams.List {
+			if findOutParam(oldParams, p.Id) == nil {
+				d.add(ApiChange{
+					Kind:  ApiOutputAdded,
+					Param: p.Id,
+					New:   p.Tname.String(),
+				})
+			}
+		}
+	}
+}
+
+// diffType compares the types of a parameter or struct field.
+//
+// If output is true, then the change is breaking if values of the new type
+// cannot be assigned to the old type.  Otherwise it is breaking if values of
+// the old type cannot be assigned to the new type.
+//
+// If both types refer to the same struct type, the struct fields are compared
+// recursively.  seen tracks the struct types already visited on this path.
+func (d *apiDiffer) diffType(path string, oldId, newId TypeId,
+	output bool, seen map[string]struct{}) {
+	kind := ApiInputRetyped
+	if output {
+		kind = ApiOutputRetyped
+	}
+	if strings.ContainsRune(path, '.') {
+		kind = ApiFieldRetyped
+	}
+	if oldId != newId {
+		d.add(ApiChange{
+			Kind:     kind,
+			Param:    path,
+			Old:      oldId.String(),
+			New:      newId.String(),
+			Breaking: !d.typeWidens(oldId, newId, output),
+		})
+		return
+	}
+	oldStruct, ok := d.oldTypes.Get(TypeId{Tname: oldId.Tname}).(*StructType)
+	if !ok {
+		return
+	}
+	newStruct, ok := d.newTypes.Get(TypeId{Tname: newId.Tname}).(*StructType)
+	if !ok {
+		return
+	}
+	if _, ok := seen[oldStruct.Id]; ok {
+		return
+	}
+	seen[oldStruct.Id] = struct{}{}
+	defer delete(seen, oldStruct.Id)
+	for _, m := range oldStruct.Members {
+		fpath := path + "." + m.Id
+		if nm := newStruct.getMember(m.Id); nm == nil {
+			d.add(ApiChange{
+				Kind:     ApiFieldRemoved,
+				Param:    fpath,
+				Old:      m.Tname.String(),
+				Breaking: true,
+			})
+		} else {
+			d.diffType(fpath, m.Tname, nm.Tname, output, seen)
+		}
+	}
+	for _, m := range newStruct.Members {
+		if oldStruct.getMember(m.Id) == nil {
+			// Struct values bound to an input must supply every field, so
+			// adding a field is breaking for inputs.  Consumers of an
+			// output will not notice an additional field.
+			d.add(ApiChange{
+				Kind:     ApiFieldAdded,
+				Param:    path + "." + m.Id,
+				New:      m.Tname.String(),
+				Breaking: !output,
+			})
+		}
+	}
+}
+
+// typeWidens returns true if a change of type from oldId to newId cannot
+// break existing users of a parameter.
+func (d *apiDiffer) typeWidens(oldId, newId TypeId, output bool) bool {
+	if oldId.ArrayDim != newId.ArrayDim || oldId.MapDim != newId.MapDim {
+		return false
+	}
+	oldType := d.oldTypes.Get(TypeId{Tname: oldId.Tname})
+	newType := d.newTypes.Get(TypeId{Tname: newId.Tname})
+	if oldType == nil || newType == nil {
+		return false
+	}
+	// Struct assignability depends on the type table in which the member
+	// types are defined, so changes to struct type names are treated as
+	// breaking.
+	if _, ok := oldType.(*StructType); ok {
+		return false
+	}
+	if _, ok := newType.(*StructType); ok {
+		return false
+	}
+	if output {
+		return oldType.IsAssignableFrom(newType, d.oldTypes) == nil
+	}
+	return newType.IsAssignableFrom(oldType, d.newTypes) == nil
+}
+
+func (d *apiDiffer) diffStage(oldStage, newStage *Stage) {
+	if oldStage.Split != newStage.Split {
+		d.add(ApiChange{
+			Kind: ApiSplitChanged,
+			Old:  strconv.FormatBool(oldStage.Split),
+			New:  strconv.FormatBool(newStage.Split),
+		})
+	}
+	if o, n := describeResources(oldStage.Resources),
+		describeResources(newStage.Resources); o != n {
+		d.add(ApiChange{
+			Kind: ApiResourcesChanged,
+			Old:  o,
+			New:  n,
+		})
+	}
+	if o, n := describeSrc(oldStage.Src),
+		describeSrc(newStage.Src); o != n {
+		d.add(ApiChange{
+			Kind: ApiSrcChanged,
+			Old:  o,
+			New:  n,
+		})
+	}
+}
+
+func describeResources(res *Resources) string {
+	if res == nil {
+		return "default"
+	}
+	var parts []string
+	if res.MemNode != nil {
+		parts = append(parts, fmt.Sprintf("mem_gb = %g", res.MemGB))
+	}
+	if res.SpecialNode != nil {
+		parts = append(parts, "special = "+strconv.Quote(res.Special))
+	}
+	if res.ThreadNode != nil {
+		parts = append(parts, fmt.Sprintf("threads = %g", res.Threads))
+	}
+	if res.VMemNode != nil {
+		parts = append(parts, fmt.Sprintf("vmem_gb = %g", res.VMemGB))
+	}
+	if res.VolatileNode != nil {
+		if res.StrictVolatile {
+			parts = append(parts, "volatile = strict")
+		} else {
+			parts = append(parts, "volatile = false")
+		}
+	}
+	if len(parts) == 0 {
+		return "default"
+	}
+	return strings.Join(parts, ", ")
+}
+
+func describeSrc(src *SrcParam) string {
+	if src == nil {
+		return ""
+	}
+	var buf strings.Builder
+	buf.WriteString(string(src.Lang))
+	buf.WriteString(` "`)
+	buf.WriteString(src.Path)
+	for _, arg := range src.Args {
+		buf.WriteRune(' ')
+		buf.WriteString(arg)
+	}
+	buf.WriteRune('"')
+	return buf.String()
+}
+
+func findInParam(params *InParams, id string) *InParam {
+	if params == nil {
+		return nil
+	}
+	if params.Table != nil {
+		return params.Table[id]
+	}
+	for _, p := range params.List {
+		if p.Id == id {
+			return p
+		}
+	}
+	return nil
+}
+
+func findOutParam(params *OutParams, id string) *OutParam {
+	if params == nil {
+		return nil
+	}
+	if params.Table != nil {
+		return params.Table[id]
+	}
+	for _, p := range params.List {
+		if p.Id == id {
+			return p
+		}
+	}
+	return nil
+}
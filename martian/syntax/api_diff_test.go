@@ -0,0 +1,123 @@
+// Copyright (c) 2020 10X Genomics, Inc. All rights reserved.
+
+package syntax
+
+import (
+	"testing"
+)
+
+func TestApiDiff(t *testing.T) {
+	ast1 := testGood(t, `
+filetype txt;
+
+struct POINT(
+    int x,
+    int y,
+)
+
+stage SUM_SQUARES(
+    in  int[] values,
+    in  POINT origin,
+    in  bool  dropped,
+    out int   sum,
+    out POINT centroid,
+    out txt   report,
+    out int   removed,
+    src py    "stages/sum_squares",
+) using (
+    mem_gb = 2,
+)
+
+stage UNCHANGED(
+    in  int  value,
+    out int  value,
+    src py   "stages/unchanged",
+)
+
+stage GONE(
+    src py "stages/gone",
+)
+`)
+	ast2 := testGood(t, `
+filetype txt;
+
+struct POINT(
+    int   x,
+    float y,
+    int   z,
+)
+
+stage SUM_SQUARES(
+    in  float[] values,
+    in  POINT   origin,
+    in  int     added,
+    out float   sum,
+    out POINT   centroid,
+    out file    report     "Report"  "summary.txt",
+    out int     new_output,
+    src py      "stages/sum_squares2",
+) split (
+) using (
+    mem_gb = 4,
+)
+
+stage UNCHANGED(
+    in  int  value,
+    out int  value,
+    src py   "stages/unchanged",
+)
+
+stage NEW_STAGE(
+    src py "stages/new",
+)
+`)
+	if ast1 == nil || ast2 == nil {
+		return
+	}
+	changes := ast1.ApiDiff(ast2)
+	type key struct {
+		callable string
+		kind     ApiChangeKind
+		param    string
+	}
+	expect := map[key]bool{
+		{"GONE", ApiCallableRemoved, ""}:               true,
+		{"NEW_STAGE", ApiCallableAdded, ""}:            false,
+		{"SUM_SQUARES", ApiInputRetyped, "values"}:     false,
+		{"SUM_SQUARES", ApiFieldRetyped, "origin.y"}:   false,
+		{"SUM_SQUARES", ApiFieldAdded, "origin.z"}:     true,
+		{"SUM_SQUARES", ApiInputRemoved, "dropped"}:    true,
+		{"SUM_SQUARES", ApiInputAdded, "added"}:        true,
+		{"SUM_SQUARES", ApiOutputRetyped, "sum"}:       true,
+		{"SUM_SQUARES", ApiFieldRetyped, "centroid.y"}: true,
+		{"SUM_SQUARES", ApiFieldAdded, "centroid.z"}:   false,
+		{"SUM_SQUARES", ApiOutputRetyped, "report"}:    false,
+		{"SUM_SQUARES", ApiOutputRenamed, "report"}:    true,
+		{"SUM_SQUARES", ApiOutputRemoved, "removed"}:   true,
+		{"SUM_SQUARES", ApiOutputAdded, "new_output"}:  false,
+		{"SUM_SQUARES", ApiSplitChanged, ""}:           false,
+		{"SUM_SQUARES", ApiResourcesChanged, ""}:       false,
+		{"SUM_SQUARES", ApiSrcChanged, ""}:             false,
+	}
+	for _, c := range changes {
+		k := key{c.Callable, c.Kind, c.Param}
+		if breaking, ok := expect[k]; !ok {
+			t.Errorf("unexpected change %s", c.String())
+		} else {
+			if breaking != c.Breaking {
+				t.Errorf("expected breaking=%v for %s", breaking, c.String())
+			}
+			delete(expect, k)
+		}
+	}
+	for k := range expect {
+		t.Errorf("missing change %s %s %s", k.callable, k.kind, k.param)
+	}
+	if !changes.HasBreaking() {
+		t.Error("expected breaking changes")
+	}
+	if changes := ast1.ApiDiff(ast1); len(changes) != 0 {
+		t.Errorf("expected no changes comparing an ast with itself, got %d",
+			len(changes))
+	}
+}
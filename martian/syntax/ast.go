@@ -140,6 +140,19 @@ func GetComments(node AstNodable) []string {
 	return nil
 }
 
+// SetDefiningFile changes the file recorded for a node and all of its
+// subnodes, for example when moving a declaration to a different file.
+func SetDefiningFile(node AstNodable, file *SourceFile) {
+	if n := node.getNode(); n != nil {
+		n.Loc.File = file
+	}
+	for _, sub := range node.getSubnodes() {
+		if sub != nil {
+			SetDefiningFile(sub, file)
+		}
+	}
+}
+
 func (s *Ast) inheritComments() bool { return false }
 func (s *Ast) getSubnodes() []AstNodable {
 	subs := make([]AstNodable, 0,
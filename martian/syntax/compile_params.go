@@ -272,6 +272,14 @@ func (exp *RefExp) resolveType(global *Ast, pipeline *Pipeline) (TypeId, MapCall
 	panic("invalid ref kind")
 }
 
+// ResolveType returns the type of the value referred to by the expression
+// within the given pipeline.  The pipeline must have been compiled as part
+// of the given Ast.
+func (exp *RefExp) ResolveType(global *Ast, pipeline *Pipeline) (TypeId, error) {
+	t, _, err := exp.resolveType(global, pipeline)
+	return t, err
+}
+
 func (bindings *BindStms) addBinding(global *Ast, pipeline *Pipeline,
 	binding *BindStm, params Params) error {
 	var errs ErrorList
@@ -0,0 +1,184 @@
+// Copyright (c) 2020 10X Genomics, Inc. All rights reserved.
+
+// Change-impact analysis for pipeline definitions.
+
+package syntax
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// ImpactedCallables returns the stages and pipelines in the AST whose
+// definitions depend on any of the given paths, in declaration order.
+//
+// Paths may be mro files, stage code files, or directories containing either.
+// A callable is impacted if
+//
+//   - it is declared in a changed mro file,
+//   - it uses a struct type, directly or through a struct member, which is
+//     declared in a changed mro file,
+//   - it is a stage whose src path contains or is contained by a changed
+//     path, or
+//   - it is a pipeline which calls an impacted callable.
+//
+// Stage src paths are resolved relative to the file in which the stage was
+// declared, or else in stagecodePaths.  Paths which cannot be found are
+// assumed to be relative to the declaring file.
+func (ast *Ast) ImpactedCallables(paths, stagecodePaths []string) []Callable {
+	if ast.Callables == nil || len(paths) == 0 {
+		return nil
+	}
+	finder := impactFinder{
+		ast:            ast,
+		paths:          make([]string, 0, len(paths)),
+		stagecodePaths: stagecodePaths,
+		structs:        make(map[string]bool, len(ast.StructTypes)),
+		callables:      make(map[string]bool, len(ast.Callables.List)),
+	}
+	for _, p := range paths {
+		if abs, err := filepath.Abs(p); err == nil {
+			p = abs
+		}
+		finder.paths = append(finder.paths, filepath.Clean(p))
+	}
+	callables := callableTable(ast.Callables)
+	var result []Callable
+	for _, c := range ast.Callables.List {
+		if finder.callableImpacted(c, callables) {
+			result = append(result, c)
+		}
+	}
+	return result
+}
+
+type impactFinder struct {
+	ast            *Ast
+	paths          []string
+	stagecodePaths []string
+
+	// Memoized results for struct types and callables.  A value of false
+	// is also used to break cycles while a result is being computed.
+	structs   map[string]bool
+	callables map[string]bool
+}
+
+// pathContains returns true if p is the same as, or a parent directory of,
+// child.
+func pathContains(p, child string) bool {
+	return p == child ||
+		len(child) > len(p) && strings.HasPrefix(child, p) &&
+			(child[len(p)] == filepath.Separator || p == "/")
+}
+
+func (finder *impactFinder) fileChanged(file *SourceFile) bool {
+	if file == nil {
+		return false
+	}
+	for _, p := range finder.paths {
+		if pathContains(p, file.FullPath) {
+			return true
+		}
+	}
+	return false
+}
+
+func (finder *impactFinder) srcChanged(src *SrcParam) bool {
+	if src == nil || src.Path == "" {
+		return false
+	}
+	p, err := src.FindPath(finder.stagecodePaths)
+	if err != nil && !filepath.IsAbs(p) && src.Node.Loc.File != nil {
+		// The path may have been deleted in the change being examined.
+		p = filepath.Join(filepath.Dir(src.Node.Loc.File.FullPath), p)
+	} else if abs, err := filepath.Abs(p); err == nil {
+		p = abs
+	}
+	for _, changed := range finder.paths {
+		if pathContains(p, changed) || pathContains(changed, p) {
+			return true
+		}
+	}
+	return false
+}
+
+func (finder *impactFinder) structImpacted(id string) bool {
+	if impacted, ok := finder.structs[id]; ok {
+		return impacted
+	}
+	finder.structs[id] = false
+	// The same struct may be declared in several files.  A change to any of
+	// those declarations counts.
+	var members []*StructMember
+	for _, st := range finder.ast.StructTypes {
+		if st.Id == id {
+			if finder.fileChanged(st.File()) {
+				finder.structs[id] = true
+				return true
+			}
+			if members == nil {
+				members = st.Members
+			}
+		}
+	}
+	for _, m := range members {
+		if finder.structImpacted(m.Tname.Tname) {
+			finder.structs[id] = true
+			return true
+		}
+	}
+	return false
+}
+
+func (finder *impactFinder) inParamsImpacted(params *InParams) bool {
+	if params == nil {
+		return false
+	}
+	for _, p := range params.List {
+		if finder.structImpacted(p.Tname.Tname) {
+			return true
+		}
+	}
+	return false
+}
+
+func (finder *impactFinder) outParamsImpacted(params *OutParams) bool {
+	if params == nil {
+		return false
+	}
+	for _, p := range params.List {
+		if finder.structImpacted(p.Tname.Tname) {
+			return true
+		}
+	}
+	return false
+}
+
+func (finder *impactFinder) callableImpacted(c Callable,
+	callables map[string]Callable) bool {
+	if impacted, ok := finder.callables[c.GetId()]; ok {
+		return impacted
+	}
+	finder.callables[c.GetId()] = false
+	impacted := finder.fileChanged(c.File()) ||
+		finder.inParamsImpacted(c.GetInParams()) ||
+		finder.outParamsImpacted(c.GetOutParams())
+	if !impacted {
+		switch c := c.(type) {
+		case *Stage:
+			impacted = finder.srcChanged(c.Src) ||
+				finder.inParamsImpacted(c.ChunkIns) ||
+				finder.outParamsImpacted(c.ChunkOuts)
+		case *Pipeline:
+			for _, call := range c.Calls {
+				if callee := callables[call.DecId]; callee != nil &&
+					finder.callableImpacted(callee, callables) {
+					impacted = true
+					break
+				}
+			}
+		}
+	}
+	finder.callables[c.GetId()] = impacted
+	return impacted
+}
@@ -0,0 +1,132 @@
+// Copyright (c) 2020 10X Genomics, Inc. All rights reserved.
+
+package syntax
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestImpactedCallables(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	files := map[string]string{
+		"types.mro": `
+struct POINT(
+    int x,
+    int y,
+)
+
+struct SHAPE(
+    POINT[] points,
+)
+`,
+		"stages.mro": `
+@include "types.mro"
+
+stage MAKE_SHAPE(
+    out SHAPE shape,
+    src py    "stages/make_shape",
+)
+
+stage COUNT(
+    in  int n,
+    out int count,
+    src py  "stages/count",
+)
+`,
+		"other_stages.mro": `
+stage UNRELATED(
+    in  int n,
+    out int count,
+    src py  "stages/unrelated",
+)
+`,
+		"pipeline.mro": `
+@include "stages.mro"
+@include "other_stages.mro"
+
+pipeline USES_SHAPE(
+    out SHAPE shape,
+)
+{
+    call MAKE_SHAPE()
+    return (
+        shape = MAKE_SHAPE.shape,
+    )
+}
+
+pipeline USES_COUNT(
+    out int count,
+)
+{
+    call COUNT(
+        n = 1,
+    )
+    return (
+        count = COUNT.count,
+    )
+}
+
+pipeline OUTER(
+    out int count,
+)
+{
+    call USES_COUNT()
+    call UNRELATED(
+        n = 2,
+    )
+    return (
+        count = USES_COUNT.count,
+    )
+}
+`,
+	}
+	for name, src := range files {
+		if err := os.WriteFile(filepath.Join(dir, name),
+			[]byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, _, ast, err := Compile(filepath.Join(dir, "pipeline.mro"),
+		[]string{dir}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check := func(t *testing.T, paths []string, expect ...string) {
+		t.Helper()
+		impacted := ast.ImpactedCallables(paths, []string{dir})
+		names := make([]string, 0, len(impacted))
+		for _, c := range impacted {
+			names = append(names, c.GetId())
+		}
+		sort.Strings(names)
+		sort.Strings(expect)
+		if strings.Join(names, ",") != strings.Join(expect, ",") {
+			t.Errorf("expected %v, got %v", expect, names)
+		}
+	}
+	t.Run("struct", func(t *testing.T) {
+		check(t, []string{filepath.Join(dir, "types.mro")},
+			"MAKE_SHAPE", "USES_SHAPE")
+	})
+	t.Run("stage_file", func(t *testing.T) {
+		check(t, []string{filepath.Join(dir, "stages.mro")},
+			"MAKE_SHAPE", "COUNT", "USES_SHAPE", "USES_COUNT", "OUTER")
+	})
+	t.Run("src_dir", func(t *testing.T) {
+		check(t, []string{filepath.Join(dir, "stages", "count")},
+			"COUNT", "USES_COUNT", "OUTER")
+	})
+	t.Run("src_file", func(t *testing.T) {
+		check(t, []string{
+			filepath.Join(dir, "stages", "unrelated", "__init__.py"),
+		}, "UNRELATED", "OUTER")
+	})
+	t.Run("none", func(t *testing.T) {
+		check(t, []string{filepath.Join(dir, "README.md")})
+	})
+}
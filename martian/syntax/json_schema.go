@@ -0,0 +1,397 @@
+// Copyright (c) 2020 10X Genomics, Inc. All rights reserved.
+
+// Generation of JSON Schema documents from MRO types.
+
+package syntax
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The JSON Schema dialect used for generated schemas.
+const JsonSchemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// JsonSchema is the subset of a JSON Schema (draft 2020-12) document which
+// is required to describe MRO types.
+//
+// A nil or empty JsonSchema accepts any value.  A JsonSchema with Never set
+// is the boolean schema false, which accepts no values.
+type JsonSchema struct {
+	Schema      string   `json:"$schema,omitempty"`
+	Ref         string   `json:"$ref,omitempty"`
+	Title       string   `json:"title,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Type        []string `json:"type,omitempty"`
+
+	Properties           map[string]*JsonSchema `json:"properties,omitempty"`
+	Required             []string               `json:"required,omitempty"`
+	AdditionalProperties *JsonSchema            `json:"additionalProperties,omitempty"`
+	Items                *JsonSchema            `json:"items,omitempty"`
+
+	// For user-defined file types, the expected file extension, without
+	// the leading '.'.  This is an annotation, and is not validated.
+	FileExtension string `json:"x-martian-file-extension,omitempty"`
+
+	Defs map[string]*JsonSchema `json:"$defs,omitempty"`
+
+	Never bool `json:"-"`
+}
+
+func (s *JsonSchema) MarshalJSON() ([]byte, error) {
+	if s.Never {
+		return []byte("false"), nil
+	}
+	type plain JsonSchema
+	return json.Marshal((*plain)(s))
+}
+
+func (s *JsonSchema) UnmarshalJSON(b []byte) error {
+	switch string(bytes.TrimSpace(b)) {
+	case "true":
+		*s = JsonSchema{}
+		return nil
+	case "false":
+		*s = JsonSchema{Never: true}
+		return nil
+	}
+	type plain JsonSchema
+	// The type keyword may be a single string.
+	var withType struct {
+		*plain
+		Type json.RawMessage `json:"type,omitempty"`
+	}
+	withType.plain = (*plain)(s)
+	if err := json.Unmarshal(b, &withType); err != nil {
+		return err
+	}
+	s.Type = nil
+	if t := bytes.TrimSpace(withType.Type); len(t) > 0 && t[0] == '"' {
+		var single string
+		if err := json.Unmarshal(t, &single); err != nil {
+			return err
+		}
+		s.Type = []string{single}
+	} else if len(t) > 0 {
+		return json.Unmarshal(t, &s.Type)
+	}
+	return nil
+}
+
+// Schema types for mro types, which also accept null.
+var (
+	schemaInteger = []string{"integer", "null"}
+	schemaNumber  = []string{"number", "null"}
+	schemaBoolean = []string{"boolean", "null"}
+	schemaString  = []string{"string", "null"}
+	schemaObject  = []string{"object", "null"}
+	schemaArray   = []string{"array", "null"}
+)
+
+func commentText(comments []string) string {
+	lines := make([]string, 0, len(comments))
+	for _, c := range comments {
+		lines = append(lines, strings.TrimSpace(strings.TrimPrefix(c, "#")))
+	}
+	return strings.Join(lines, "\n")
+}
+
+type jsonSchemaBuilder struct {
+	lookup *TypeLookup
+	defs   map[string]*JsonSchema
+}
+
+func (b *jsonSchemaBuilder) typeSchema(t Type) *JsonSchema {
+	switch t := t.(type) {
+	case *BuiltinType:
+		switch t.Id {
+		case KindInt:
+			return &JsonSchema{Type: schemaInteger}
+		case KindFloat:
+			return &JsonSchema{Type: schemaNumber}
+		case KindBool:
+			return &JsonSchema{Type: schemaBoolean}
+		case KindMap:
+			return &JsonSchema{Type: schemaObject}
+		default:
+			return &JsonSchema{Type: schemaString}
+		}
+	case *UserType:
+		return &JsonSchema{
+			Type:          schemaString,
+			FileExtension: t.Id,
+		}
+	case *ArrayType:
+		s := b.typeSchema(t.Elem)
+		for i := t.Dim; i > 0; i-- {
+			s = &JsonSchema{
+				Type:  schemaArray,
+				Items: s,
+			}
+		}
+		return s
+	case *TypedMapType:
+		return &JsonSchema{
+			Type:                 schemaObject,
+			AdditionalProperties: b.typeSchema(t.Elem),
+		}
+	case *StructType:
+		if _, ok := b.defs[t.Id]; !ok {
+			// Add a placeholder before processing members.
+			def := new(JsonSchema)
+			b.defs[t.Id] = def
+			members := make([]StructMemberLike, len(t.Members))
+			for i, m := range t.Members {
+				members[i] = m
+			}
+			*def = *b.membersSchema(t.Id,
+				commentText(t.Node.Comments), members, true)
+			def.Type = schemaObject
+		}
+		return &JsonSchema{Ref: "#/$defs/" + t.Id}
+	}
+	return nil
+}
+
+func (b *jsonSchemaBuilder) paramSchema(param StructMemberLike) *JsonSchema {
+	t := b.lookup.Get(param.GetTname())
+	if t == nil {
+		return nil
+	}
+	s := b.typeSchema(t)
+	if help := param.GetHelp(); help != "" {
+		if s.Ref != "" {
+			// Don't modify the definition.
+			return &JsonSchema{Ref: s.Ref, Description: help}
+		}
+		s.Description = help
+	}
+	return s
+}
+
+func (b *jsonSchemaBuilder) membersSchema(title, description string,
+	members []StructMemberLike, required bool) *JsonSchema {
+	s := &JsonSchema{
+		Title:       title,
+		Description: description,
+		Type:        []string{"object"},
+		Properties:  make(map[string]*JsonSchema, len(members)),
+	}
+	for _, m := range members {
+		s.Properties[m.GetId()] = b.paramSchema(m)
+		if required {
+			s.Required = append(s.Required, m.GetId())
+		}
+	}
+	return s
+}
+
+// CallableJsonSchema returns a JSON Schema describing the inputs and outputs
+// of a callable object, as properties named "args" and "outs".
+//
+// Struct types are described in the $defs section.  Any value may be null.
+// For args, all parameters are optional, since missing parameters are
+// treated as null, but unknown parameters are not permitted.  Struct values
+// must have all of their fields, but may have additional fields.
+//
+// The lookup must contain the types used by the callable's parameters.
+func CallableJsonSchema(callable Callable, lookup *TypeLookup) *JsonSchema {
+	b := jsonSchemaBuilder{
+		lookup: lookup,
+		defs:   make(map[string]*JsonSchema),
+	}
+	var description string
+	if n := callable.getNode(); n != nil {
+		description = commentText(n.Comments)
+	}
+	root := &JsonSchema{
+		Schema:      JsonSchemaDialect,
+		Title:       callable.GetId(),
+		Description: description,
+		Type:        []string{"object"},
+		Properties:  make(map[string]*JsonSchema, 2),
+	}
+	if ins := callable.GetInParams(); ins != nil {
+		args := make([]StructMemberLike, len(ins.List))
+		for i, p := range ins.List {
+			args[i] = p
+		}
+		s := b.membersSchema(callable.GetId()+" args", "", args, false)
+		s.AdditionalProperties = &JsonSchema{Never: true}
+		root.Properties["args"] = s
+	}
+	if outs := callable.GetOutParams(); outs != nil {
+		members := make([]StructMemberLike, len(outs.List))
+		for i, p := range outs.List {
+			members[i] = p
+		}
+		root.Properties["outs"] = b.membersSchema(
+			callable.GetId()+" outs", "", members, true)
+	}
+	if len(b.defs) > 0 {
+		root.Defs = b.defs
+	}
+	return root
+}
+
+// JsonSchemaError is a validation failure for a JSON value.
+type JsonSchemaError struct {
+	// The JSON Pointer to the value which failed validation.
+	Path    string
+	Message string
+}
+
+func (err *JsonSchemaError) Error() string {
+	if err.Path == "" {
+		return "/: " + err.Message
+	}
+	return err.Path + ": " + err.Message
+}
+
+func (err *JsonSchemaError) writeTo(w stringWriter) {
+	mustWriteString(w, err.Error())
+}
+
+// Validate checks a JSON value against the schema, returning an ErrorList of
+// JsonSchemaError for any values which are invalid.
+//
+// Only the subset of JSON Schema which is generated by CallableJsonSchema is
+// supported.  $ref must refer to a definition in the $defs section of this
+// schema.
+func (s *JsonSchema) Validate(data json.RawMessage) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var value interface{}
+	if err := dec.Decode(&value); err != nil {
+		return err
+	}
+	return s.ValidateAt("", value)
+}
+
+// ValidateAt checks a decoded JSON value, which must have been decoded using
+// json.Decoder.UseNumber, against the schema.  Errors are reported with
+// paths relative to the given JSON pointer.
+func (s *JsonSchema) ValidateAt(path string, value interface{}) error {
+	var errs ErrorList
+	s.validate(s, path, value, &errs)
+	return errs.If()
+}
+
+func escapePointer(key string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(key, "~", "~0"), "/", "~1")
+}
+
+func jsonTypeOf(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case json.Number:
+		if _, err := strconv.ParseInt(string(v), 10, 64); err == nil {
+			return "integer"
+		}
+		return "number"
+	case float64:
+		return "number"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+func (s *JsonSchema) validate(root *JsonSchema, path string,
+	value interface{}, errs *ErrorList) {
+	if s == nil {
+		return
+	}
+	if s.Never {
+		*errs = append(*errs, &JsonSchemaError{
+			Path:    path,
+			Message: "no value is allowed here",
+		})
+		return
+	}
+	if s.Ref != "" {
+		const prefix = "#/$defs/"
+		if !strings.HasPrefix(s.Ref, prefix) {
+			*errs = append(*errs, &JsonSchemaError{
+				Path:    path,
+				Message: "unsupported reference " + s.Ref,
+			})
+			return
+		}
+		def := root.Defs[strings.TrimPrefix(s.Ref, prefix)]
+		if def == nil {
+			*errs = append(*errs, &JsonSchemaError{
+				Path:    path,
+				Message: "undefined reference " + s.Ref,
+			})
+			return
+		}
+		def.validate(root, path, value, errs)
+	}
+	if len(s.Type) > 0 {
+		actual := jsonTypeOf(value)
+		ok := false
+		for _, t := range s.Type {
+			if t == actual || t == "number" && actual == "integer" {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			*errs = append(*errs, &JsonSchemaError{
+				Path: path,
+				Message: fmt.Sprintf("expected %s, got %s",
+					strings.Join(s.Type, " or "), actual),
+			})
+			return
+		}
+	}
+	switch v := value.(type) {
+	case []interface{}:
+		if s.Items != nil {
+			for i, elem := range v {
+				s.Items.validate(root, path+"/"+strconv.Itoa(i), elem, errs)
+			}
+		}
+	case map[string]interface{}:
+		for _, key := range s.Required {
+			if _, ok := v[key]; !ok {
+				*errs = append(*errs, &JsonSchemaError{
+					Path:    path,
+					Message: "missing required property " + strconv.Quote(key),
+				})
+			}
+		}
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			p := path + "/" + escapePointer(key)
+			if prop, ok := s.Properties[key]; ok {
+				prop.validate(root, p, v[key], errs)
+			} else if s.AdditionalProperties != nil {
+				if s.AdditionalProperties.Never {
+					*errs = append(*errs, &JsonSchemaError{
+						Path:    p,
+						Message: "unknown property " + strconv.Quote(key),
+					})
+				} else {
+					s.AdditionalProperties.validate(root, p, v[key], errs)
+				}
+			}
+		}
+	}
+}
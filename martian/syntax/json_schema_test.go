@@ -0,0 +1,282 @@
+// Copyright (c) 2020 10X Genomics, Inc. All rights reserved.
+
+package syntax
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+const jsonSchemaTestSrc = `
+filetype bam;
+
+# A point in the plane.
+struct POINT(
+    int   x,
+    float y,
+)
+
+struct SHAPE(
+    POINT[]    points,
+    map<POINT> named,
+    bam        source,
+)
+
+# Does things with shapes.
+stage SHAPES(
+    in  SHAPE      shape    "The shape",
+    in  int[][]    counts,
+    in  map<bam[]> reads,
+    in  map        extra,
+    in  bool       flag,
+    in  string     name,
+    out POINT      centroid,
+    out file       report,
+    src py         "stages/shapes",
+)
+`
+
+func TestCallableJsonSchema(t *testing.T) {
+	ast := testGood(t, jsonSchemaTestSrc)
+	schema := CallableJsonSchema(ast.Callables.Table["SHAPES"], &ast.TypeTable)
+	b, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const expected = `{
+  "$schema": "https://json-schema.org/draft/2020-12/schema",
+  "title": "SHAPES",
+  "description": "Does things with shapes.",
+  "type": [
+    "object"
+  ],
+  "properties": {
+    "args": {
+      "title": "SHAPES args",
+      "type": [
+        "object"
+      ],
+      "properties": {
+        "counts": {
+          "type": [
+            "array",
+            "null"
+          ],
+          "items": {
+            "type": [
+              "array",
+              "null"
+            ],
+            "items": {
+              "type": [
+                "integer",
+                "null"
+              ]
+            }
+          }
+        },
+        "extra": {
+          "type": [
+            "object",
+            "null"
+          ]
+        },
+        "flag": {
+          "type": [
+            "boolean",
+            "null"
+          ]
+        },
+        "name": {
+          "type": [
+            "string",
+            "null"
+          ]
+        },
+        "reads": {
+          "type": [
+            "object",
+            "null"
+          ],
+          "additionalProperties": {
+            "type": [
+              "array",
+              "null"
+            ],
+            "items": {
+              "type": [
+                "string",
+                "null"
+              ],
+              "x-martian-file-extension": "bam"
+            }
+          }
+        },
+        "shape": {
+          "$ref": "#/$defs/SHAPE",
+          "description": "The shape"
+        }
+      },
+      "additionalProperties": false
+    },
+    "outs": {
+      "title": "SHAPES outs",
+      "type": [
+        "object"
+      ],
+      "properties": {
+        "centroid": {
+          "$ref": "#/$defs/POINT"
+        },
+        "report": {
+          "type": [
+            "string",
+            "null"
+          ]
+        }
+      },
+      "required": [
+        "centroid",
+        "report"
+      ]
+    }
+  },
+  "$defs": {
+    "POINT": {
+      "title": "POINT",
+      "description": "A point in the plane.",
+      "type": [
+        "object",
+        "null"
+      ],
+      "properties": {
+        "x": {
+          "type": [
+            "integer",
+            "null"
+          ]
+        },
+        "y": {
+          "type": [
+            "number",
+            "null"
+          ]
+        }
+      },
+      "required": [
+        "x",
+        "y"
+      ]
+    },
+    "SHAPE": {
+      "title": "SHAPE",
+      "type": [
+        "object",
+        "null"
+      ],
+      "properties": {
+        "named": {
+          "type": [
+            "object",
+            "null"
+          ],
+          "additionalProperties": {
+            "$ref": "#/$defs/POINT"
+          }
+        },
+        "points": {
+          "type": [
+            "array",
+            "null"
+          ],
+          "items": {
+            "$ref": "#/$defs/POINT"
+          }
+        },
+        "source": {
+          "type": [
+            "string",
+            "null"
+          ],
+          "x-martian-file-extension": "bam"
+        }
+      },
+      "required": [
+        "points",
+        "named",
+        "source"
+      ]
+    }
+  }
+}`
+	if s := string(b); s != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, s)
+	}
+
+	// Check that the schema round-trips.
+	var parsed JsonSchema
+	if err := json.Unmarshal(b, &parsed); err != nil {
+		t.Fatal(err)
+	}
+	if b2, err := json.MarshalIndent(&parsed, "", "  "); err != nil {
+		t.Error(err)
+	} else if string(b2) != expected {
+		t.Errorf("Round trip changed schema:\n%s", b2)
+	}
+}
+
+func TestJsonSchemaValidate(t *testing.T) {
+	ast := testGood(t, jsonSchemaTestSrc)
+	schema := CallableJsonSchema(ast.Callables.Table["SHAPES"], &ast.TypeTable)
+	check := func(t *testing.T, data string, expect ...string) {
+		t.Helper()
+		err := schema.Validate(json.RawMessage(data))
+		if len(expect) == 0 {
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			return
+		}
+		if err == nil {
+			t.Fatal("expected an error")
+		}
+		var errs ErrorList
+		if list, ok := err.(ErrorList); ok {
+			errs = list
+		} else {
+			errs = ErrorList{err}
+		}
+		if len(errs) != len(expect) {
+			t.Errorf("expected %d errors, got %v", len(expect), err)
+		}
+		for i, e := range errs {
+			if i < len(expect) && !strings.HasPrefix(e.Error(), expect[i]) {
+				t.Errorf("expected error %q, got %q", expect[i], e.Error())
+			}
+		}
+	}
+	check(t, `{"args": {
+		"shape": {
+			"points": [{"x": 1, "y": 2.5}],
+			"named": {"a": null},
+			"source": "x.bam"
+		},
+		"counts": [[1, 2], null],
+		"flag": null
+	}}`)
+	check(t, `{"args": {
+		"shape": {
+			"points": [{"x": 1.5, "y": 2}],
+			"named": {"a/b": {"x": 1}},
+			"source": 3
+		},
+		"counts": [[1, "2"]],
+		"flags": true
+	}}`,
+		"/args/counts/0/1: expected integer or null, got string",
+		"/args/flags: unknown property \"flags\"",
+		"/args/shape/named/a~1b: missing required property \"y\"",
+		"/args/shape/points/0/x: expected integer or null, got number",
+		"/args/shape/source: expected string or null, got integer")
+}
@@ -0,0 +1,320 @@
+// Copyright (c) 2020 10X Genomics, Inc. All rights reserved.
+
+// Package lint implements configurable style and usage checks for pipeline
+// definitions.
+//
+// Each check is a named Rule, which may be disabled or have its severity
+// changed through a Config.  Findings are suppressed by the same keep
+// comments which mro edit respects, on the node or on the stage or pipeline
+// containing it.  A comment of the form
+//
+//	# keep
+//
+// suppresses the rules which report unused inputs, outputs, and callables,
+// just as it stops mro edit from removing them.  A comment of the form
+//
+//	# keep: naming-convention, missing-resources
+//
+// suppresses only the named rules.
+package lint
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+
+	"github.com/martian-lang/martian/martian/syntax"
+	"github.com/martian-lang/martian/martian/syntax/refactoring"
+)
+
+// Severity is the severity level for a finding.
+//
+// The values correspond to the levels defined by SARIF.
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+	SeverityNote    Severity = "note"
+)
+
+func (s Severity) valid() bool {
+	switch s {
+	case SeverityError, SeverityWarning, SeverityNote:
+		return true
+	}
+	return false
+}
+
+// A Finding is a single problem reported by a lint rule.
+type Finding struct {
+	// The name of the rule which reported the finding.
+	Rule string `json:"rule"`
+
+	Severity Severity `json:"severity"`
+
+	Message string `json:"message"`
+
+	// The absolute path to the file containing the problem.
+	File string `json:"file"`
+
+	Line int `json:"line"`
+}
+
+// RuleConfig holds the configuration for a single rule.
+type RuleConfig struct {
+	// If true, the rule will not be run.
+	Disabled bool `json:"disabled,omitempty"`
+
+	// Overrides the default severity for the rule.
+	Severity Severity `json:"severity,omitempty"`
+
+	// For naming-convention, the regular expression which stage, pipeline
+	// and struct names must match.
+	CallablePattern string `json:"callable_pattern,omitempty"`
+
+	// For naming-convention, the regular expression which parameter and
+	// struct field names must match.
+	ParamPattern string `json:"param_pattern,omitempty"`
+
+	// For nested-map, the maximum number of nested array or map levels.
+	MaxDepth int `json:"max_depth,omitempty"`
+}
+
+// Config holds the configuration for a lint run.
+type Config struct {
+	// The names of pipelines to treat as top-level.  If empty, every
+	// pipeline which is not called by another pipeline, as well as the
+	// target of any top-level call, is treated as top-level.
+	TopCalls []string `json:"top_calls,omitempty"`
+
+	// Per-rule configuration, by rule name.
+	Rules map[string]*RuleConfig `json:"rules,omitempty"`
+}
+
+// LoadConfig reads a json-formatted configuration file.
+func LoadConfig(fname string) (*Config, error) {
+	b, err := os.ReadFile(fname)
+	if err != nil {
+		return nil, err
+	}
+	var config Config
+	if err := json.Unmarshal(b, &config); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", fname, err)
+	}
+	return &config, config.Validate()
+}
+
+// Validate returns an error if the configuration refers to unknown rules,
+// unknown severity levels, or invalid patterns.
+func (config *Config) Validate() error {
+	if config == nil {
+		return nil
+	}
+	for name, rc := range config.Rules {
+		if findRule(name) == nil {
+			return fmt.Errorf("unknown lint rule %q", name)
+		}
+		if rc == nil {
+			continue
+		}
+		if rc.Severity != "" && !rc.Severity.valid() {
+			return fmt.Errorf("invalid severity %q for lint rule %s",
+				rc.Severity, name)
+		}
+		for _, p := range [...]string{rc.CallablePattern, rc.ParamPattern} {
+			if p != "" {
+				if _, err := regexp.Compile(p); err != nil {
+					return fmt.Errorf("invalid pattern for lint rule %s: %w",
+						name, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (config *Config) ruleConfig(name string) *RuleConfig {
+	if config != nil {
+		if rc := config.Rules[name]; rc != nil {
+			return rc
+		}
+	}
+	return new(RuleConfig)
+}
+
+// A Rule is a named lint check.
+type Rule struct {
+	Name        string
+	Description string
+
+	// The severity used for findings unless overridden by configuration.
+	DefaultSeverity Severity
+
+	// If true, findings are suppressed by keep comments which do not list
+	// any rules, because the rule reports something which mro edit would
+	// remove.
+	keep bool
+
+	check func(l *linter, rc *RuleConfig)
+}
+
+func findRule(name string) *Rule {
+	for _, r := range Rules {
+		if r.Name == name {
+			return r
+		}
+	}
+	return nil
+}
+
+type linter struct {
+	asts     []*syntax.Ast
+	topCalls refactoring.StringSet
+
+	// The rule currently being run.
+	rule     *Rule
+	severity Severity
+
+	findings []Finding
+	seen     map[Finding]struct{}
+}
+
+// Lint runs the enabled rules over the given compiled ASTs.
+//
+// Declarations which are included by more than one of the ASTs are only
+// reported once.  Findings are sorted by file and line.
+func Lint(asts []*syntax.Ast, config *Config) []Finding {
+	l := linter{
+		asts:     asts,
+		topCalls: topCalls(asts, config),
+		seen:     make(map[Finding]struct{}),
+	}
+	for _, rule := range Rules {
+		rc := config.ruleConfig(rule.Name)
+		if rc.Disabled {
+			continue
+		}
+		l.rule = rule
+		l.severity = rule.DefaultSeverity
+		if rc.Severity != "" {
+			l.severity = rc.Severity
+		}
+		rule.check(&l, rc)
+	}
+	sort.SliceStable(l.findings, func(i, j int) bool {
+		fi, fj := &l.findings[i], &l.findings[j]
+		if fi.File != fj.File {
+			return fi.File < fj.File
+		}
+		return fi.Line < fj.Line
+	})
+	return l.findings
+}
+
+// HasErrors returns true if any of the findings have error severity.
+func HasErrors(findings []Finding) bool {
+	for i := range findings {
+		if findings[i].Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+func topCalls(asts []*syntax.Ast, config *Config) refactoring.StringSet {
+	if config != nil && len(config.TopCalls) > 0 {
+		result := make(refactoring.StringSet, len(config.TopCalls))
+		for _, c := range config.TopCalls {
+			result.Add(c)
+		}
+		return result
+	}
+	called := make(refactoring.StringSet)
+	for _, ast := range asts {
+		for _, pipe := range ast.Pipelines {
+			for _, call := range pipe.Calls {
+				called.Add(call.DecId)
+			}
+		}
+	}
+	result := make(refactoring.StringSet)
+	for _, ast := range asts {
+		if ast.Call != nil {
+			result.Add(ast.Call.DecId)
+		}
+		for _, pipe := range ast.Pipelines {
+			if !called.Contains(pipe.Id) {
+				result.Add(pipe.Id)
+			}
+		}
+	}
+	return result
+}
+
+// suppressed returns true if any of the nodes has a keep comment which
+// applies to the given rule.
+func suppressed(rule *Rule, nodes ...syntax.AstNodable) bool {
+	for _, node := range nodes {
+		if node == nil {
+			continue
+		}
+		names, all := refactoring.KeepCommentNames(node)
+		if all && rule.keep || names.Contains(rule.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+// report adds a finding located at node, unless node or one of the given
+// enclosing nodes suppresses it.
+func (l *linter) report(node syntax.AstNodable, enclosing syntax.AstNodable,
+	format string, args ...interface{}) {
+	if suppressed(l.rule, node, enclosing) {
+		return
+	}
+	f := Finding{
+		Rule:     l.rule.Name,
+		Severity: l.severity,
+		Message:  fmt.Sprintf(format, args...),
+		File:     syntax.DefiningFile(node),
+		Line:     node.Line(),
+	}
+	if _, ok := l.seen[f]; ok {
+		return
+	}
+	l.seen[f] = struct{}{}
+	l.findings = append(l.findings, f)
+}
+
+// forEachCallable calls fn once for each distinct callable declaration.
+func (l *linter) forEachCallable(fn func(syntax.Callable)) {
+	seen := make(map[string]struct{})
+	for _, ast := range l.asts {
+		for _, c := range ast.Callables.List {
+			key := syntax.DefiningFile(c) + ":" + c.GetId()
+			if _, ok := seen[key]; !ok {
+				seen[key] = struct{}{}
+				fn(c)
+			}
+		}
+	}
+}
+
+// forEachStruct calls fn once for each distinct struct declaration, along
+// with the type table for the AST in which it was found.
+func (l *linter) forEachStruct(fn func(*syntax.StructType, *syntax.TypeLookup)) {
+	seen := make(map[string]struct{})
+	for _, ast := range l.asts {
+		for _, st := range ast.StructTypes {
+			key := syntax.DefiningFile(st) + ":" + st.Id
+			if _, ok := seen[key]; !ok {
+				seen[key] = struct{}{}
+				fn(st, &ast.TypeTable)
+			}
+		}
+	}
+}
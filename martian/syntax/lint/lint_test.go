@@ -0,0 +1,123 @@
+// Copyright (c) 2020 10X Genomics, Inc. All rights reserved.
+
+package lint
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/martian-lang/martian/martian/syntax"
+)
+
+func compileTestdata(t *testing.T) []*syntax.Ast {
+	t.Helper()
+	var parser syntax.Parser
+	_, _, ast, err := parser.Compile("testdata/lint.mro", nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return []*syntax.Ast{ast}
+}
+
+func findingKeys(findings []Finding) []string {
+	keys := make([]string, len(findings))
+	for i, f := range findings {
+		keys[i] = f.Rule + ":" + string(f.Severity) + ":" + strings.Fields(f.Message)[1]
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestLint(t *testing.T) {
+	asts := compileTestdata(t)
+	findings := Lint(asts, nil)
+	expect := []string{
+		"always-null-input:warning:seed",
+		"missing-resources:note:UNCALLED",
+		"missing-resources:note:lowerCase",
+		"naming-convention:warning:name",
+		"nested-map:warning:has",
+		"split-without-chunk-outs:note:stage",
+		"unreachable-callable:warning:UNCALLED",
+		"unused-output:warning:dropped",
+	}
+	if got := findingKeys(findings); strings.Join(got, "\n") !=
+		strings.Join(expect, "\n") {
+		t.Errorf("expected\n%s\ngot\n%s",
+			strings.Join(expect, "\n"), strings.Join(got, "\n"))
+	}
+	if HasErrors(findings) {
+		t.Error("expected no errors")
+	}
+}
+
+func TestLintConfig(t *testing.T) {
+	asts := compileTestdata(t)
+	var config Config
+	if err := json.Unmarshal([]byte(`{
+    "rules": {
+        "missing-resources": {"disabled": true},
+        "unreachable-callable": {"disabled": true},
+        "unused-output": {"disabled": true},
+        "split-without-chunk-outs": {"disabled": true},
+        "always-null-input": {"severity": "error"},
+        "naming-convention": {"callable_pattern": "^[A-Za-z_]+$"},
+        "nested-map": {"max_depth": 3}
+    }
+}`), &config); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	findings := Lint(asts, &config)
+	expect := []string{
+		"always-null-input:error:seed",
+	}
+	if got := findingKeys(findings); strings.Join(got, "\n") !=
+		strings.Join(expect, "\n") {
+		t.Errorf("expected\n%s\ngot\n%s",
+			strings.Join(expect, "\n"), strings.Join(got, "\n"))
+	}
+	if !HasErrors(findings) {
+		t.Error("expected errors")
+	}
+	config.Rules["no-such-rule"] = new(RuleConfig)
+	if err := config.Validate(); err == nil {
+		t.Error("expected validation failure for unknown rule")
+	}
+}
+
+func TestWriteSarif(t *testing.T) {
+	findings := Lint(compileTestdata(t), nil)
+	var buf bytes.Buffer
+	if err := WriteSarif(&buf, findings, "", "test"); err != nil {
+		t.Fatal(err)
+	}
+	var log struct {
+		Version string
+		Runs    []struct {
+			Results []struct {
+				RuleId    string
+				RuleIndex int
+			}
+		}
+	}
+	if err := json.Unmarshal(buf.Bytes(), &log); err != nil {
+		t.Fatal(err)
+	}
+	if log.Version != "2.1.0" {
+		t.Errorf("unexpected version %q", log.Version)
+	}
+	if len(log.Runs) != 1 || len(log.Runs[0].Results) != len(findings) {
+		t.Fatal("incorrect result count")
+	}
+	for _, r := range log.Runs[0].Results {
+		if Rules[r.RuleIndex].Name != r.RuleId {
+			t.Errorf("rule index %d does not match %s", r.RuleIndex, r.RuleId)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+// Copyright (c) 2020 10X Genomics, Inc. All rights reserved.
+
+package lint
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// relPath returns the path relative to root, if it is under root.
+func relPath(p, root string) string {
+	if root == "" {
+		return p
+	}
+	if rel, err := filepath.Rel(root, p); err == nil &&
+		!strings.HasPrefix(rel, "..") {
+		return rel
+	}
+	return p
+}
+
+// WriteText writes findings in the form
+//
+//	file:line: severity: message [rule]
+//
+// with file paths relative to root where possible.
+func WriteText(w io.Writer, findings []Finding, root string) error {
+	for _, f := range findings {
+		if _, err := fmt.Fprintf(w, "%s:%d: %s: %s [%s]\n",
+			relPath(f.File, root), f.Line,
+			f.Severity, f.Message, f.Rule); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteJson writes findings as a json array, with file paths relative to
+// root where possible.
+func WriteJson(w io.Writer, findings []Finding, root string) error {
+	out := make([]Finding, len(findings))
+	for i, f := range findings {
+		f.File = relPath(f.File, root)
+		out[i] = f
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	return enc.Encode(out)
+}
+
+type (
+	sarifLog struct {
+		Schema  string     `json:"$schema"`
+		Version string     `json:"version"`
+		Runs    []sarifRun `json:"runs"`
+	}
+
+	sarifRun struct {
+		Tool    sarifTool     `json:"tool"`
+		Results []sarifResult `json:"results"`
+	}
+
+	sarifTool struct {
+		Driver sarifDriver `json:"driver"`
+	}
+
+	sarifDriver struct {
+		Name           string      `json:"name"`
+		Version        string      `json:"version,omitempty"`
+		InformationUri string      `json:"informationUri"`
+		Rules          []sarifRule `json:"rules"`
+	}
+
+	sarifRule struct {
+		Id                   string            `json:"id"`
+		ShortDescription     sarifMessage      `json:"shortDescription"`
+		DefaultConfiguration sarifRuleDefaults `json:"defaultConfiguration"`
+	}
+
+	sarifRuleDefaults struct {
+		Level Severity `json:"level"`
+	}
+
+	sarifMessage struct {
+		Text string `json:"text"`
+	}
+
+	sarifResult struct {
+		RuleId    string          `json:"ruleId"`
+		RuleIndex int             `json:"ruleIndex"`
+		Level     Severity        `json:"level"`
+		Message   sarifMessage    `json:"message"`
+		Locations []sarifLocation `json:"locations"`
+	}
+
+	sarifLocation struct {
+		PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+	}
+
+	sarifPhysicalLocation struct {
+		ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+		Region           sarifRegion           `json:"region"`
+	}
+
+	sarifArtifactLocation struct {
+		Uri string `json:"uri"`
+	}
+
+	sarifRegion struct {
+		StartLine int `json:"startLine"`
+	}
+)
+
+// WriteSarif writes findings in SARIF 2.1.0 format.  File paths are written
+// relative to root where possible.
+func WriteSarif(w io.Writer, findings []Finding, root, version string) error {
+	driver := sarifDriver{
+		Name:           "mro check",
+		Version:        version,
+		InformationUri: "https://martian-lang.org",
+		Rules:          make([]sarifRule, len(Rules)),
+	}
+	ruleIndex := make(map[string]int, len(Rules))
+	for i, r := range Rules {
+		ruleIndex[r.Name] = i
+		driver.Rules[i] = sarifRule{
+			Id:               r.Name,
+			ShortDescription: sarifMessage{Text: r.Description},
+			DefaultConfiguration: sarifRuleDefaults{
+				Level: r.DefaultSeverity,
+			},
+		}
+	}
+	results := make([]sarifResult, len(findings))
+	for i, f := range findings {
+		uri := relPath(f.File, root)
+		if filepath.IsAbs(uri) {
+			uri = "file://" + filepath.ToSlash(uri)
+		} else {
+			uri = filepath.ToSlash(uri)
+		}
+		results[i] = sarifResult{
+			RuleId:    f.Rule,
+			RuleIndex: ruleIndex[f.Rule],
+			Level:     f.Severity,
+			Message:   sarifMessage{Text: f.Message},
+			Locations: []sarifLocation{{
+				PhysicalLocation: sarifPhysicalLocation{
+					ArtifactLocation: sarifArtifactLocation{Uri: uri},
+					Region:           sarifRegion{StartLine: f.Line},
+				},
+			}},
+		}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	return enc.Encode(&sarifLog{
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Version: "2.1.0",
+		Runs: []sarifRun{{
+			Tool:    sarifTool{Driver: driver},
+			Results: results,
+		}},
+	})
+}
@@ -0,0 +1,310 @@
+// Copyright (c) 2020 10X Genomics, Inc. All rights reserved.
+
+package lint
+
+import (
+	"regexp"
+
+	"github.com/martian-lang/martian/martian/syntax"
+	"github.com/martian-lang/martian/martian/syntax/refactoring"
+)
+
+const (
+	defaultCallablePattern = `^[A-Z][A-Z0-9_]*$`
+	defaultParamPattern    = `^[a-z][a-z0-9_]*$`
+	defaultMaxDepth        = 2
+)
+
+// Rules is the set of available lint rules, in the order in which they run.
+var Rules = [...]*Rule{
+	{
+		Name:            "always-null-input",
+		Description:     "Input parameters which are bound to null in every call.",
+		DefaultSeverity: SeverityWarning,
+		keep:            true,
+		check:           checkNullInputs,
+	},
+	{
+		Name: "unused-output",
+		Description: "Stage outputs which are not used by any other stage " +
+			"or top-level pipeline output.",
+		DefaultSeverity: SeverityWarning,
+		keep:            true,
+		check:           checkUnusedOutputs,
+	},
+	{
+		Name:            "missing-resources",
+		Description:     "Stages which do not declare resources with using.",
+		DefaultSeverity: SeverityNote,
+		check:           checkMissingResources,
+	},
+	{
+		Name: "naming-convention",
+		Description: "Stage, pipeline, struct, parameter, and struct field " +
+			"names which do not match the configured patterns.",
+		DefaultSeverity: SeverityWarning,
+		check:           checkNaming,
+	},
+	{
+		Name:            "split-without-chunk-outs",
+		Description:     "Split stages which do not declare any chunk outputs.",
+		DefaultSeverity: SeverityNote,
+		check:           checkSplitChunkOuts,
+	},
+	{
+		Name: "nested-map",
+		Description: "Parameters or struct fields with maps nested more " +
+			"deeply than the configured maximum depth.",
+		DefaultSeverity: SeverityWarning,
+		check:           checkNestedMaps,
+	},
+	{
+		Name: "unreachable-callable",
+		Description: "Stages or pipelines which cannot be reached from any " +
+			"top-level pipeline.",
+		DefaultSeverity: SeverityWarning,
+		keep:            true,
+		check:           checkUnreachable,
+	},
+}
+
+// checkNullInputs reports input parameters for which every call binds
+// null.  Inputs which are bound to a value in some calls but never used by
+// the callable are not reported, nor are inputs to callables which are never
+// called.
+//
+// Pipeline inputs which are never referenced are already rejected by the
+// compiler.
+func checkNullInputs(l *linter, _ *RuleConfig) {
+	// For each callable which is called, the set of parameters bound to a
+	// value other than null in at least one call.
+	bound := make(map[string]refactoring.StringSet)
+	addCall := func(call *syntax.CallStm) {
+		set := bound[call.DecId]
+		if set == nil {
+			set = make(refactoring.StringSet)
+			bound[call.DecId] = set
+		}
+		if call.Bindings == nil {
+			return
+		}
+		for _, b := range call.Bindings.List {
+			if _, isNull := b.Exp.(*syntax.NullExp); !isNull && b.Exp != nil {
+				set.Add(b.Id)
+			}
+		}
+	}
+	for _, ast := range l.asts {
+		if ast.Call != nil {
+			addCall(ast.Call)
+		}
+		for _, pipe := range ast.Pipelines {
+			for _, call := range pipe.Calls {
+				addCall(call)
+			}
+		}
+	}
+	l.forEachCallable(func(c syntax.Callable) {
+		set, called := bound[c.GetId()]
+		if !called || c.GetInParams() == nil {
+			return
+		}
+		for _, param := range c.GetInParams().List {
+			if !set.Contains(param.Id) {
+				l.report(param, c,
+					"input %s of %s %s is null in every call",
+					param.Id, c.Type(), c.GetId())
+			}
+		}
+	})
+}
+
+func checkUnusedOutputs(l *linter, _ *RuleConfig) {
+	if len(l.topCalls) == 0 {
+		return
+	}
+	unused, err := refactoring.FindUnusedStageOutputs(l.topCalls, l.asts)
+	if err != nil {
+		// Errors building the call graph are reported by the compiler.
+		return
+	}
+	for _, u := range unused {
+		if u.Output == nil {
+			continue
+		}
+		l.report(u.Output, u.Stage,
+			"output %s of stage %s is never used",
+			u.Output.Id, u.Stage.Id)
+	}
+}
+
+func checkMissingResources(l *linter, _ *RuleConfig) {
+	l.forEachCallable(func(c syntax.Callable) {
+		if stage, ok := c.(*syntax.Stage); ok && stage.Resources == nil {
+			l.report(stage, nil,
+				"stage %s does not declare resources", stage.Id)
+		}
+	})
+}
+
+func checkNaming(l *linter, rc *RuleConfig) {
+	cp, pp := rc.CallablePattern, rc.ParamPattern
+	if cp == "" {
+		cp = defaultCallablePattern
+	}
+	if pp == "" {
+		pp = defaultParamPattern
+	}
+	// Patterns were checked by Config.Validate.
+	callableRe := regexp.MustCompile(cp)
+	paramRe := regexp.MustCompile(pp)
+	checkParams := func(c syntax.Callable, params []syntax.StructMemberLike) {
+		for _, p := range params {
+			if !paramRe.MatchString(p.GetId()) {
+				l.report(p, c,
+					"parameter %s of %s does not match pattern %s",
+					p.GetId(), c.GetId(), pp)
+			}
+		}
+	}
+	l.forEachCallable(func(c syntax.Callable) {
+		if !callableRe.MatchString(c.GetId()) {
+			l.report(c, nil,
+				"%s name %s does not match pattern %s",
+				c.Type(), c.GetId(), cp)
+		}
+		checkParams(c, inParamList(c.GetInParams()))
+		checkParams(c, outParamList(c.GetOutParams()))
+		if stage, ok := c.(*syntax.Stage); ok {
+			checkParams(c, inParamList(stage.ChunkIns))
+			checkParams(c, outParamList(stage.ChunkOuts))
+		}
+	})
+	l.forEachStruct(func(st *syntax.StructType, _ *syntax.TypeLookup) {
+		if !callableRe.MatchString(st.Id) {
+			l.report(st, nil,
+				"struct name %s does not match pattern %s",
+				st.Id, cp)
+		}
+		for _, m := range st.Members {
+			if !paramRe.MatchString(m.Id) {
+				l.report(m, st,
+					"field %s of struct %s does not match pattern %s",
+					m.Id, st.Id, pp)
+			}
+		}
+	})
+}
+
+func checkSplitChunkOuts(l *linter, _ *RuleConfig) {
+	l.forEachCallable(func(c syntax.Callable) {
+		if stage, ok := c.(*syntax.Stage); ok && stage.Split &&
+			(stage.ChunkOuts == nil || len(stage.ChunkOuts.List) == 0) {
+			l.report(stage, nil,
+				"split stage %s does not declare any chunk outputs",
+				stage.Id)
+		}
+	})
+}
+
+func checkNestedMaps(l *linter, rc *RuleConfig) {
+	maxDepth := rc.MaxDepth
+	if maxDepth <= 0 {
+		maxDepth = defaultMaxDepth
+	}
+	check := func(node syntax.StructMemberLike, enclosing syntax.AstNodable,
+		owner string, lookup *syntax.TypeLookup) {
+		id := node.GetTname()
+		if id.ArrayDim == 0 && id.MapDim == 0 {
+			// Nesting within a struct type is reported on the struct field.
+			return
+		}
+		depth, hasMap := nestDepth(id, lookup, make(map[string]struct{}))
+		if hasMap && depth > maxDepth {
+			l.report(node, enclosing,
+				"%s.%s has type %s, which nests collections %d deep "+
+					"(maximum %d)",
+				owner, node.GetId(), id.String(), depth, maxDepth)
+		}
+	}
+	for _, ast := range l.asts {
+		lookup := &ast.TypeTable
+		for _, c := range ast.Callables.List {
+			for _, p := range inParamList(c.GetInParams()) {
+				check(p, c, c.GetId(), lookup)
+			}
+			for _, p := range outParamList(c.GetOutParams()) {
+				check(p, c, c.GetId(), lookup)
+			}
+		}
+	}
+	l.forEachStruct(func(st *syntax.StructType, lookup *syntax.TypeLookup) {
+		for _, m := range st.Members {
+			check(m, st, st.Id, lookup)
+		}
+	})
+}
+
+// nestDepth returns the number of levels of nested arrays and maps in a
+// type, including those in the fields of struct types, and whether any of
+// those levels is a map.
+func nestDepth(id syntax.TypeId, lookup *syntax.TypeLookup,
+	seen map[string]struct{}) (int, bool) {
+	depth := int(id.ArrayDim) + int(id.MapDim)
+	hasMap := id.MapDim > 0
+	if id.Tname == syntax.KindMap {
+		depth++
+		hasMap = true
+	}
+	if _, ok := seen[id.Tname]; ok {
+		return depth, hasMap
+	}
+	st, ok := lookup.Get(syntax.TypeId{Tname: id.Tname}).(*syntax.StructType)
+	if !ok {
+		return depth, hasMap
+	}
+	seen[id.Tname] = struct{}{}
+	defer delete(seen, id.Tname)
+	inner := 0
+	for _, m := range st.Members {
+		d, h := nestDepth(m.Tname, lookup, seen)
+		if d > inner {
+			inner = d
+		}
+		hasMap = hasMap || h
+	}
+	return depth + inner, hasMap
+}
+
+func checkUnreachable(l *linter, _ *RuleConfig) {
+	if len(l.topCalls) == 0 {
+		return
+	}
+	for _, c := range refactoring.FindUnusedCallables(l.topCalls, l.asts) {
+		l.report(c, nil,
+			"%s %s is not reachable from any top-level pipeline",
+			c.Type(), c.GetId())
+	}
+}
+
+func inParamList(params *syntax.InParams) []syntax.StructMemberLike {
+	if params == nil {
+		return nil
+	}
+	result := make([]syntax.StructMemberLike, len(params.List))
+	for i, p := range params.List {
+		result[i] = p
+	}
+	return result
+}
+
+func outParamList(params *syntax.OutParams) []syntax.StructMemberLike {
+	if params == nil {
+		return nil
+	}
+	result := make([]syntax.StructMemberLike, len(params.List))
+	for i, p := range params.List {
+		result[i] = p
+	}
+	return result
+}
@@ -5,6 +5,7 @@ package refactoring
 import (
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/martian-lang/martian/martian/syntax"
 )
@@ -115,3 +116,124 @@ func makeDecId(node syntax.NamedNode) decId {
 		Line: node.Line(),
 	}
 }
+
+// replaceRefs returns a copy of exp with each reference replaced by the
+// result of fn.  Sub-expressions which contain no changed references are
+// not copied.
+func replaceRefs(exp syntax.Exp,
+	fn func(*syntax.RefExp) (syntax.Exp, error)) (syntax.Exp, error) {
+	if exp == nil || !exp.HasRef() {
+		return exp, nil
+	}
+	switch exp := exp.(type) {
+	case *syntax.RefExp:
+		return fn(exp)
+	case *syntax.SplitExp:
+		e, err := replaceRefs(exp.Value, fn)
+		if err != nil || e == exp.Value {
+			return exp, err
+		}
+		ee := *exp
+		ee.Value = e
+		return &ee, nil
+	case *syntax.ArrayExp:
+		arr := make([]syntax.Exp, 0, len(exp.Value))
+		change := false
+		for _, v := range exp.Value {
+			e, err := replaceRefs(v, fn)
+			if err != nil {
+				return exp, err
+			}
+			arr = append(arr, e)
+			if e != v {
+				change = true
+			}
+		}
+		if !change {
+			return exp, nil
+		}
+		ee := *exp
+		ee.Value = arr
+		return &ee, nil
+	case *syntax.MapExp:
+		m := make(map[string]syntax.Exp, len(exp.Value))
+		change := false
+		for k, v := range exp.Value {
+			e, err := replaceRefs(v, fn)
+			if err != nil {
+				return exp, err
+			}
+			m[k] = e
+			if e != v {
+				change = true
+			}
+		}
+		if !change {
+			return exp, nil
+		}
+		ee := *exp
+		ee.Value = m
+		return &ee, nil
+	}
+	return exp, nil
+}
+
+// replaceBindingRefs applies replaceRefs to each binding in the set, returning
+// the number of bindings which were changed.
+func replaceBindingRefs(bindings *syntax.BindStms,
+	fn func(*syntax.RefExp) (syntax.Exp, error)) (int, error) {
+	if bindings == nil {
+		return 0, nil
+	}
+	count := 0
+	for _, binding := range bindings.List {
+		exp, err := replaceRefs(binding.Exp, fn)
+		if err != nil {
+			return count, err
+		}
+		if exp != binding.Exp {
+			binding.Exp = exp
+			count++
+		}
+	}
+	return count, nil
+}
+
+// splitRefPath splits a reference output path into the parameter name and
+// the path within that parameter, if any.
+func splitRefPath(p string) (string, string) {
+	if i := strings.IndexByte(p, '.'); i >= 0 {
+		return p[:i], p[i+1:]
+	}
+	return p, ""
+}
+
+func joinRefPath(a, b string) string {
+	if a == "" {
+		return b
+	} else if b == "" {
+		return a
+	}
+	return a + "." + b
+}
+
+func findPipeline(ast *syntax.Ast, id, file string) *syntax.Pipeline {
+	for _, pipe := range ast.Pipelines {
+		if pipe.Id == id && syntax.DefiningFile(pipe) == file {
+			return pipe
+		}
+	}
+	return nil
+}
+
+// getAst returns the first of the given compiled ASTs which contains the
+// given callable.
+func getAst(callable syntax.Callable, asts []*syntax.Ast) *syntax.Ast {
+	for _, ast := range asts {
+		if ast != nil && ast.Callables != nil &&
+			ast.Callables.Table[callable.GetId()] == callable {
+			return ast
+		}
+	}
+	return nil
+}
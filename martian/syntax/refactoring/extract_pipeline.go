@@ -0,0 +1,542 @@
+// Copyright (c) 2020 10X Genomics, Inc. All rights reserved.
+
+package refactoring
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/martian-lang/martian/martian/syntax"
+)
+
+// ExtractPipeline creates an edit which moves the given calls out of a
+// pipeline into a new pipeline, and replaces them with a single call to the
+// new pipeline.
+//
+// The new pipeline gets an input for each pipeline input or output of
+// another call which is referenced by the moved calls, and an output for each
+// output of the moved calls which is referenced by the remaining calls or by
+// the pipeline's return bindings.  Parameters for call outputs are named
+// after the call and output, e.g. first_y for FIRST.y, and parameters for
+// pipeline inputs keep their names.  Retained outputs of the moved calls are
+// retained by the new pipeline instead.
+//
+// Extraction fails if a call which is not moved both depends on a moved call
+// and is depended on by one, since the new pipeline would then depend on
+// itself.
+//
+// The pipeline must be fully compiled.
+func ExtractPipeline(pipe *syntax.Pipeline, calls []string,
+	newName string, asts []*syntax.Ast) (Edit, error) {
+	if len(calls) == 0 {
+		return nil, nil
+	}
+	if pipe.Callables == nil {
+		panic("pipeline was not fully compiled")
+	}
+	if getCallable(newName, asts) != nil {
+		return nil, fmt.Errorf("callable %s already exists", newName)
+	}
+	global := getAst(pipe, asts)
+	if global == nil {
+		return nil, fmt.Errorf("pipeline %s not found", pipe.Id)
+	}
+	moved := make(StringSet, len(calls))
+	for _, id := range calls {
+		moved.Add(id)
+	}
+	for _, call := range pipe.Calls {
+		if call.Id == newName && !moved.Contains(call.Id) {
+			return nil, fmt.Errorf("pipeline %s already has a call named %s",
+				pipe.Id, newName)
+		}
+	}
+	edit := extractPipelineEdit{
+		Pipeline: pipe.Id,
+		File:     syntax.DefiningFile(pipe),
+		NewName:  newName,
+		Calls:    make(StringSet, len(calls)),
+	}
+	inputs := make(map[extractedRef]*extractedParam)
+	outputs := make(map[extractedRef]*extractedParam)
+	usedIns := make(StringSet)
+	usedOuts := make(StringSet)
+	addParam := func(ref *syntax.RefExp, params map[extractedRef]*extractedParam,
+		used StringSet, list *[]*extractedParam) error {
+		key := extractedRef{Kind: ref.Kind, Id: ref.Id}
+		if ref.Kind == syntax.KindSelf {
+			key.Root = ref.Id
+			key.Id = ""
+		} else {
+			key.Root, _ = splitRefPath(ref.OutputId)
+		}
+		if params[key] != nil {
+			return nil
+		}
+		rootRef := syntax.RefExp{Kind: key.Kind, Id: ref.Id}
+		if key.Kind == syntax.KindCall {
+			rootRef.OutputId = key.Root
+		}
+		t, err := rootRef.ResolveType(global, pipe)
+		if err != nil {
+			return err
+		}
+		name := strings.ToLower(joinRefPath(key.Id, key.Root))
+		name = uniqueName(strings.ReplaceAll(name, ".", "_"), used)
+		param := &extractedParam{extractedRef: key, Param: name, Tname: t}
+		params[key] = param
+		*list = append(*list, param)
+		return nil
+	}
+	for _, call := range pipe.Calls {
+		if !moved.Contains(call.Id) {
+			continue
+		}
+		edit.Calls.Add(call.Id)
+		for _, bindings := range [...]*syntax.BindStms{
+			call.Bindings, call.Modifiers.Bindings,
+		} {
+			if bindings == nil {
+				continue
+			}
+			for _, binding := range bindings.List {
+				if binding.Id == "*" {
+					return nil, fmt.Errorf(
+						"call %s uses a wildcard binding, "+
+							"which cannot be extracted",
+						call.Id)
+				}
+				if binding.Exp == nil {
+					continue
+				}
+				for _, ref := range binding.Exp.FindRefs() {
+					if ref.Kind == syntax.KindCall && moved.Contains(ref.Id) {
+						continue
+					}
+					if err := addParam(ref, inputs, usedIns,
+						&edit.Inputs); err != nil {
+						return nil, err
+					}
+				}
+			}
+		}
+	}
+	for _, id := range calls {
+		if !edit.Calls.Contains(id) {
+			return nil, fmt.Errorf("pipeline %s has no call %s", pipe.Id, id)
+		}
+	}
+	if id := extractionCycle(pipe, moved); id != "" {
+		return nil, fmt.Errorf(
+			"call %s depends on and is depended on by the extracted calls, "+
+				"so %s would depend on itself",
+			id, newName)
+	}
+	addOutputs := func(bindings *syntax.BindStms) error {
+		if bindings == nil {
+			return nil
+		}
+		for _, binding := range bindings.List {
+			if binding.Exp == nil {
+				continue
+			}
+			for _, ref := range binding.Exp.FindRefs() {
+				if ref.Kind != syntax.KindCall || !moved.Contains(ref.Id) {
+					continue
+				}
+				if err := addParam(ref, outputs, usedOuts,
+					&edit.Outputs); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	for _, call := range pipe.Calls {
+		if moved.Contains(call.Id) {
+			continue
+		}
+		if err := addOutputs(call.Bindings); err != nil {
+			return nil, err
+		}
+		if err := addOutputs(call.Modifiers.Bindings); err != nil {
+			return nil, err
+		}
+	}
+	if pipe.Ret != nil {
+		if err := addOutputs(pipe.Ret.Bindings); err != nil {
+			return nil, err
+		}
+	}
+	return &edit, nil
+}
+
+// extractionCycle returns the ID of a call in the pipeline which is not in
+// the moved set, but which both depends, directly or indirectly, on a moved
+// call and is depended on by one, or the empty string if there is no such
+// call.
+func extractionCycle(pipe *syntax.Pipeline, moved StringSet) string {
+	deps := make(map[string]StringSet, len(pipe.Calls))
+	for _, call := range pipe.Calls {
+		d := make(StringSet)
+		for _, bindings := range [...]*syntax.BindStms{
+			call.Bindings, call.Modifiers.Bindings,
+		} {
+			if bindings == nil {
+				continue
+			}
+			for _, binding := range bindings.List {
+				if binding.Exp == nil {
+					continue
+				}
+				for _, ref := range binding.Exp.FindRefs() {
+					if ref.Kind == syntax.KindCall {
+						d.Add(ref.Id)
+					}
+				}
+			}
+		}
+		deps[call.Id] = d
+	}
+	// Calls which are not moved, but depend on a moved call.
+	downstream := make(StringSet)
+	var isDownstream func(id string) bool
+	isDownstream = func(id string) bool {
+		if downstream.Contains(id) {
+			return true
+		}
+		for dep := range deps[id] {
+			if moved.Contains(dep) || isDownstream(dep) {
+				downstream.Add(id)
+				return true
+			}
+		}
+		return false
+	}
+	// Calls which are not moved, but which a moved call depends on.
+	upstream := make(StringSet)
+	var addUpstream func(id string)
+	addUpstream = func(id string) {
+		for dep := range deps[id] {
+			if !moved.Contains(dep) && !upstream.Contains(dep) {
+				upstream.Add(dep)
+				addUpstream(dep)
+			}
+		}
+	}
+	for _, call := range pipe.Calls {
+		if moved.Contains(call.Id) {
+			addUpstream(call.Id)
+		}
+	}
+	for _, call := range pipe.Calls {
+		if upstream.Contains(call.Id) && isDownstream(call.Id) {
+			return call.Id
+		}
+	}
+	return ""
+}
+
+// uniqueName returns name, or name with a numeric suffix if name is already
+// in the used set, and adds the result to the set.
+func uniqueName(name string, used StringSet) string {
+	if used.Contains(name) {
+		base := name + "_"
+		for i := 2; used.Contains(name); i++ {
+			name = base + strconv.Itoa(i)
+		}
+	}
+	used.Add(name)
+	return name
+}
+
+type (
+	// A reference to a pipeline input or call output, without any struct
+	// field path.
+	extractedRef struct {
+		Kind syntax.ExpKind
+
+		// The call Id, for call references.
+		Id string
+
+		// The input parameter or output parameter name.  This is empty for
+		// references to the entire output of a call.
+		Root string
+	}
+
+	// An input or output parameter of an extracted pipeline.
+	extractedParam struct {
+		extractedRef
+		Param string
+		Tname syntax.TypeId
+	}
+
+	extractPipelineEdit struct {
+		Pipeline string
+		File     string
+		NewName  string
+		Calls    StringSet
+		Inputs   []*extractedParam
+		Outputs  []*extractedParam
+	}
+)
+
+// source returns a reference to the value bound to the parameter.
+func (p *extractedParam) source(node syntax.AstNode) *syntax.RefExp {
+	if p.Kind == syntax.KindSelf {
+		return &syntax.RefExp{Node: node, Kind: p.Kind, Id: p.Root}
+	}
+	return &syntax.RefExp{
+		Node:     node,
+		Kind:     p.Kind,
+		Id:       p.Id,
+		OutputId: p.Root,
+	}
+}
+
+func (e *extractPipelineEdit) Apply(ast *syntax.Ast) (int, error) {
+	pipe := findPipeline(ast, e.Pipeline, e.File)
+	if pipe == nil {
+		return 0, nil
+	}
+	var moved, kept []*syntax.CallStm
+	insertAt := -1
+	for _, call := range pipe.Calls {
+		if e.Calls.Contains(call.Id) {
+			if insertAt < 0 {
+				insertAt = len(kept)
+			}
+			moved = append(moved, call)
+		} else {
+			kept = append(kept, call)
+		}
+	}
+	if len(moved) == 0 {
+		return 0, nil
+	}
+	inputs := make(map[extractedRef]*extractedParam, len(e.Inputs))
+	for _, p := range e.Inputs {
+		inputs[p.extractedRef] = p
+	}
+	outputs := make(map[extractedRef]*extractedParam, len(e.Outputs))
+	for _, p := range e.Outputs {
+		outputs[p.extractedRef] = p
+	}
+	node := syntax.AstNode{Loc: pipe.Node.Loc}
+
+	// References from inside the new pipeline to anything outside of it
+	// become references to its inputs.
+	inner := func(ref *syntax.RefExp) (syntax.Exp, error) {
+		key := extractedRef{Kind: ref.Kind, Id: ref.Id}
+		var rest string
+		if ref.Kind == syntax.KindSelf {
+			key.Root, key.Id, rest = ref.Id, "", ref.OutputId
+		} else if e.Calls.Contains(ref.Id) {
+			return ref, nil
+		} else {
+			key.Root, rest = splitRefPath(ref.OutputId)
+		}
+		p := inputs[key]
+		if p == nil {
+			return ref, fmt.Errorf("no input for reference to %s.%s",
+				joinRefPath(key.Id, key.Root), rest)
+		}
+		return &syntax.RefExp{
+			Node:     ref.Node,
+			Kind:     syntax.KindSelf,
+			Id:       p.Param,
+			OutputId: rest,
+		}, nil
+	}
+	// References from outside the new pipeline to the moved calls become
+	// references to its outputs.
+	outer := func(ref *syntax.RefExp) (syntax.Exp, error) {
+		if ref.Kind != syntax.KindCall || !e.Calls.Contains(ref.Id) {
+			return ref, nil
+		}
+		root, rest := splitRefPath(ref.OutputId)
+		p := outputs[extractedRef{Kind: ref.Kind, Id: ref.Id, Root: root}]
+		if p == nil {
+			return ref, fmt.Errorf("no output for reference to %s.%s",
+				ref.Id, ref.OutputId)
+		}
+		return &syntax.RefExp{
+			Node:     ref.Node,
+			Kind:     syntax.KindCall,
+			Id:       e.NewName,
+			OutputId: joinRefPath(p.Param, rest),
+		}, nil
+	}
+
+	count := 1
+	for _, call := range moved {
+		if c, err := replaceBindingRefs(call.Bindings, inner); err != nil {
+			return count, err
+		} else {
+			count += c
+		}
+		if call.Modifiers != nil {
+			if c, err := replaceBindingRefs(call.Modifiers.Bindings, inner); err != nil {
+				return count, err
+			} else {
+				count += c
+			}
+		}
+	}
+	for _, call := range kept {
+		if c, err := replaceBindingRefs(call.Bindings, outer); err != nil {
+			return count, err
+		} else {
+			count += c
+		}
+		if call.Modifiers != nil {
+			if c, err := replaceBindingRefs(call.Modifiers.Bindings, outer); err != nil {
+				return count, err
+			} else {
+				count += c
+			}
+		}
+	}
+	if pipe.Ret != nil {
+		if c, err := replaceBindingRefs(pipe.Ret.Bindings, outer); err != nil {
+			return count, err
+		} else {
+			count += c
+		}
+	}
+
+	newPipe := &syntax.Pipeline{
+		Node: node,
+		Id:   e.NewName,
+		InParams: &syntax.InParams{
+			List:  make([]*syntax.InParam, 0, len(e.Inputs)),
+			Table: make(map[string]*syntax.InParam, len(e.Inputs)),
+		},
+		OutParams: &syntax.OutParams{
+			List:  make([]*syntax.OutParam, 0, len(e.Outputs)),
+			Table: make(map[string]*syntax.OutParam, len(e.Outputs)),
+		},
+		Calls: moved,
+		Ret: &syntax.ReturnStm{
+			Node: node,
+			Bindings: &syntax.BindStms{
+				Node:  node,
+				List:  make([]*syntax.BindStm, 0, len(e.Outputs)),
+				Table: make(map[string]*syntax.BindStm, len(e.Outputs)),
+			},
+		},
+	}
+	newCall := &syntax.CallStm{
+		Node:      node,
+		Modifiers: new(syntax.Modifiers),
+		Id:        e.NewName,
+		DecId:     e.NewName,
+		Bindings: &syntax.BindStms{
+			Node:  node,
+			List:  make([]*syntax.BindStm, 0, len(e.Inputs)),
+			Table: make(map[string]*syntax.BindStm, len(e.Inputs)),
+		},
+	}
+	for _, p := range e.Inputs {
+		param := &syntax.InParam{
+			Node:  node,
+			Tname: p.Tname,
+			Id:    p.Param,
+		}
+		newPipe.InParams.List = append(newPipe.InParams.List, param)
+		newPipe.InParams.Table[param.Id] = param
+		binding := &syntax.BindStm{
+			Node:  node,
+			Id:    p.Param,
+			Exp:   p.source(node),
+			Tname: p.Tname,
+		}
+		newCall.Bindings.List = append(newCall.Bindings.List, binding)
+		newCall.Bindings.Table[binding.Id] = binding
+	}
+	for _, p := range e.Outputs {
+		param := &syntax.OutParam{
+			StructMember: syntax.StructMember{
+				Node:  node,
+				Tname: p.Tname,
+				Id:    p.Param,
+			},
+		}
+		newPipe.OutParams.List = append(newPipe.OutParams.List, param)
+		newPipe.OutParams.Table[param.Id] = param
+		binding := &syntax.BindStm{
+			Node:  node,
+			Id:    p.Param,
+			Exp:   p.source(node),
+			Tname: p.Tname,
+		}
+		newPipe.Ret.Bindings.List = append(newPipe.Ret.Bindings.List, binding)
+		newPipe.Ret.Bindings.Table[binding.Id] = binding
+	}
+	if pipe.Retain != nil {
+		refs := pipe.Retain.Refs[:0:0]
+		for _, ref := range pipe.Retain.Refs {
+			if ref.Kind == syntax.KindCall && e.Calls.Contains(ref.Id) {
+				if newPipe.Retain == nil {
+					newPipe.Retain = &syntax.PipelineRetains{
+						Node: pipe.Retain.Node,
+					}
+				}
+				newPipe.Retain.Refs = append(newPipe.Retain.Refs, ref)
+				count++
+			} else {
+				refs = append(refs, ref)
+			}
+		}
+		if len(refs) == 0 {
+			pipe.Retain = nil
+		} else {
+			pipe.Retain.Refs = refs
+		}
+	}
+
+	pipe.Calls = make([]*syntax.CallStm, 0, len(kept)+1)
+	pipe.Calls = append(pipe.Calls, kept[:insertAt]...)
+	pipe.Calls = append(pipe.Calls, newCall)
+	pipe.Calls = append(pipe.Calls, kept[insertAt:]...)
+	if pipe.Callables != nil && pipe.Callables.Table != nil {
+		// Keep compiled ASTs consistent for subsequent edits.
+		newPipe.Callables = &syntax.Callables{
+			Table: make(map[string]syntax.Callable, len(moved)),
+		}
+		for _, call := range moved {
+			if c := pipe.Callables.Table[call.Id]; c != nil {
+				newPipe.Callables.List = append(newPipe.Callables.List, c)
+				newPipe.Callables.Table[call.Id] = c
+			}
+			delete(pipe.Callables.Table, call.Id)
+		}
+		pipe.Callables.Table[e.NewName] = newPipe
+	}
+
+	insertPipeline(ast, pipe, newPipe)
+	return count, nil
+}
+
+// insertPipeline adds a pipeline to the AST, immediately before the given
+// existing pipeline.
+func insertPipeline(ast *syntax.Ast, before, pipe *syntax.Pipeline) {
+	for i, c := range ast.Callables.List {
+		if c == before {
+			ast.Callables.List = append(ast.Callables.List[:i:i],
+				append([]syntax.Callable{pipe}, ast.Callables.List[i:]...)...)
+			break
+		}
+	}
+	if ast.Callables.Table != nil {
+		ast.Callables.Table[pipe.Id] = pipe
+	}
+	for i, p := range ast.Pipelines {
+		if p == before {
+			ast.Pipelines = append(ast.Pipelines[:i:i],
+				append([]*syntax.Pipeline{pipe}, ast.Pipelines[i:]...)...)
+			break
+		}
+	}
+}
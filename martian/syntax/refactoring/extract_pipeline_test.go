@@ -0,0 +1,220 @@
+// Copyright (c) 2020 10X Genomics, Inc. All rights reserved.
+
+package refactoring
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/martian-lang/martian/martian/syntax"
+)
+
+func TestExtractPipeline(t *testing.T) {
+	var parser syntax.Parser
+	_, file, _, _ := runtime.Caller(0)
+	const src = `
+stage FIRST(
+    in  int x,
+    out int y,
+    src comp "none",
+)
+
+stage SECOND(
+    in  int  y,
+    in  int  z,
+    out int  w,
+    out file f,
+    src comp "none",
+)
+
+stage THIRD(
+    in  int w,
+    out int v,
+    src comp "none",
+)
+
+pipeline PIPE(
+    in  int x,
+    in  int z,
+    out int v,
+    out int w,
+)
+{
+    call FIRST(
+        x = self.x,
+    )
+
+    call SECOND(
+        y = FIRST.y,
+        z = self.z,
+    )
+
+    call THIRD(
+        w = SECOND.w,
+    )
+
+    return (
+        v = THIRD.v,
+        w = SECOND.w,
+    )
+
+    retain (
+        SECOND.f,
+    )
+}
+`
+	srcBytes := []byte(src)
+	_, _, ast, err := parser.ParseSourceBytes(srcBytes, file, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	asts := []*syntax.Ast{ast}
+	if _, err := ExtractPipeline(ast.Pipelines[0],
+		[]string{"SECOND", "MISSING"}, "INNER", asts); err == nil {
+		t.Error("expected an error for a missing call")
+	}
+	if _, err := ExtractPipeline(ast.Pipelines[0],
+		[]string{"SECOND"}, "THIRD", asts); err == nil {
+		t.Error("expected an error for a name collision")
+	}
+	edit, err := ExtractPipeline(ast.Pipelines[0],
+		[]string{"SECOND", "THIRD"}, "INNER", asts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmtAst, err := parser.UncheckedParse(srcBytes, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c, err := edit.Apply(fmtAst); err != nil {
+		t.Fatal(err)
+	} else if c != 6 {
+		t.Errorf("%d != 6", c)
+	}
+	const expected = `stage FIRST(
+    in  int x,
+    out int y,
+    src comp "none",
+)
+
+stage SECOND(
+    in  int  y,
+    in  int  z,
+    out int  w,
+    out file f,
+    src comp "none",
+)
+
+stage THIRD(
+    in  int w,
+    out int v,
+    src comp "none",
+)
+
+pipeline INNER(
+    in  int first_y,
+    in  int z,
+    out int third_v,
+    out int second_w,
+)
+{
+    call SECOND(
+        y = self.first_y,
+        z = self.z,
+    )
+
+    call THIRD(
+        w = SECOND.w,
+    )
+
+    return (
+        third_v  = THIRD.v,
+        second_w = SECOND.w,
+    )
+
+    retain (
+        SECOND.f,
+    )
+}
+
+pipeline PIPE(
+    in  int x,
+    in  int z,
+    out int v,
+    out int w,
+)
+{
+    call FIRST(
+        x = self.x,
+    )
+
+    call INNER(
+        first_y = FIRST.y,
+        z       = self.z,
+    )
+
+    return (
+        v = INNER.third_v,
+        w = INNER.second_w,
+    )
+}
+`
+	s := fmtAst.Format()
+	if s != expected {
+		diff(t, expected, s)
+	}
+	if _, _, _, err := parser.ParseSourceBytes([]byte(s), file,
+		nil, false); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestExtractPipelineCycle(t *testing.T) {
+	var parser syntax.Parser
+	_, file, _, _ := runtime.Caller(0)
+	const src = `
+stage STEP(
+    in  int x,
+    out int y,
+    src comp "none",
+)
+
+pipeline P(
+    in  int x,
+    out int y,
+)
+{
+    call STEP as A(
+        x = self.x,
+    )
+
+    call STEP as B(
+        x = A.y,
+    )
+
+    call STEP as C(
+        x = B.y,
+    )
+
+    return (
+        y = C.y,
+    )
+}
+`
+	_, _, ast, err := parser.ParseSourceBytes([]byte(src), file, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	asts := []*syntax.Ast{ast}
+	if _, err := ExtractPipeline(ast.Pipelines[0],
+		[]string{"A", "C"}, "SUB", asts); err == nil {
+		t.Error("expected an error for an extraction which creates a cycle")
+	} else if !strings.Contains(err.Error(), "call B") {
+		t.Errorf("unexpected error %v", err)
+	}
+	if _, err := ExtractPipeline(ast.Pipelines[0],
+		[]string{"B", "C"}, "SUB", asts); err != nil {
+		t.Error(err)
+	}
+}
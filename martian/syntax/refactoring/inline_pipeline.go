@@ -0,0 +1,316 @@
+// Copyright (c) 2020 10X Genomics, Inc. All rights reserved.
+
+package refactoring
+
+import (
+	"fmt"
+
+	"github.com/martian-lang/martian/martian/syntax"
+)
+
+// InlineCall creates an edit which replaces a call to a sub-pipeline with
+// the calls made by that sub-pipeline.
+//
+// References to the sub-pipeline's inputs are replaced with the expressions
+// bound to them in the call, and references to the call's outputs are
+// replaced with the sub-pipeline's return bindings.  Calls in the
+// sub-pipeline are renamed, prefixed with the call Id, if their names conflict
+// with other calls in the enclosing pipeline.  References retained by the
+// sub-pipeline are retained by the enclosing pipeline.
+//
+// Mapped calls and calls with modifiers cannot be inlined.  The enclosing
+// pipeline's file may need additional includes for the stages and pipelines
+// called by the sub-pipeline.
+//
+// The pipeline must be fully compiled.
+func InlineCall(pipe *syntax.Pipeline, id string, asts []*syntax.Ast) (Edit, error) {
+	if pipe.Callables == nil {
+		panic("pipeline was not fully compiled")
+	}
+	var call *syntax.CallStm
+	for _, c := range pipe.Calls {
+		if c.Id == id {
+			call = c
+			break
+		}
+	}
+	if call == nil {
+		return nil, fmt.Errorf("pipeline %s has no call %s", pipe.Id, id)
+	}
+	callee, ok := pipe.Callables.Table[call.Id].(*syntax.Pipeline)
+	if !ok {
+		return nil, fmt.Errorf("call %s in pipeline %s is not a pipeline call",
+			id, pipe.Id)
+	}
+	if call.Mapping != nil {
+		return nil, fmt.Errorf("cannot inline mapped call %s in pipeline %s",
+			id, pipe.Id)
+	}
+	if mods := call.Modifiers; mods != nil && (mods.Local || mods.Preflight ||
+		mods.Volatile || mods.Bindings != nil && len(mods.Bindings.List) > 0) {
+		return nil, fmt.Errorf("cannot inline call %s in pipeline %s "+
+			"because it has modifiers", id, pipe.Id)
+	}
+	used := make(StringSet, len(pipe.Calls)+len(callee.Calls))
+	for _, c := range pipe.Calls {
+		if c != call {
+			used.Add(c.Id)
+		}
+	}
+	renames := make(map[string]string, len(callee.Calls))
+	for _, c := range callee.Calls {
+		if used.Contains(c.Id) {
+			renames[c.Id] = uniqueName(call.Id+"_"+c.Id, used)
+		} else {
+			renames[c.Id] = uniqueName(c.Id, used)
+		}
+	}
+	return &inlineCallEdit{
+		Pipeline: pipe.Id,
+		File:     syntax.DefiningFile(pipe),
+		Call:     call.Id,
+		Callee:   callee,
+		Renames:  renames,
+	}, nil
+}
+
+type inlineCallEdit struct {
+	Pipeline string
+	File     string
+	Call     string
+
+	// The compiled sub-pipeline being inlined.
+	Callee *syntax.Pipeline
+
+	// The new Id for each call in the sub-pipeline.
+	Renames map[string]string
+}
+
+// boundExp returns the expression bound to the given input parameter by the
+// call.
+func boundExp(call *syntax.CallStm, param string) (syntax.Exp, error) {
+	var wildcard *syntax.RefExp
+	if call.Bindings != nil {
+		for _, binding := range call.Bindings.List {
+			if binding.Id == param {
+				return binding.Exp, nil
+			} else if binding.Id == "*" {
+				wildcard, _ = binding.Exp.(*syntax.RefExp)
+			}
+		}
+	}
+	if wildcard != nil {
+		ref := *wildcard
+		if ref.Kind == syntax.KindSelf && ref.Id == "" {
+			ref.Id = param
+		} else {
+			ref.OutputId = joinRefPath(ref.OutputId, param)
+		}
+		return &ref, nil
+	}
+	return nil, fmt.Errorf("call %s does not bind %s", call.Id, param)
+}
+
+// fieldExp returns an expression for the value at the given path within the
+// value of exp.
+func fieldExp(exp syntax.Exp, path string) (syntax.Exp, error) {
+	if path == "" {
+		return exp, nil
+	}
+	switch exp := exp.(type) {
+	case *syntax.RefExp:
+		ref := *exp
+		ref.OutputId = joinRefPath(ref.OutputId, path)
+		return &ref, nil
+	case *syntax.MapExp:
+		root, rest := splitRefPath(path)
+		if v, ok := exp.Value[root]; ok {
+			return fieldExp(v, rest)
+		}
+	case *syntax.NullExp:
+		return exp, nil
+	}
+	return exp, fmt.Errorf("cannot inline reference to field %s of %s",
+		path, exp.GoString())
+}
+
+func (e *inlineCallEdit) Apply(ast *syntax.Ast) (int, error) {
+	pipe := findPipeline(ast, e.Pipeline, e.File)
+	if pipe == nil {
+		return 0, nil
+	}
+	index := -1
+	for i, c := range pipe.Calls {
+		if c.Id == e.Call {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return 0, nil
+	}
+	call := pipe.Calls[index]
+
+	// References within the sub-pipeline.
+	inner := func(ref *syntax.RefExp) (syntax.Exp, error) {
+		if ref.Kind == syntax.KindSelf {
+			exp, err := boundExp(call, ref.Id)
+			if err != nil {
+				return ref, err
+			}
+			return fieldExp(exp, ref.OutputId)
+		}
+		if id := e.Renames[ref.Id]; id != "" && id != ref.Id {
+			r := *ref
+			r.Id = id
+			return &r, nil
+		}
+		return ref, nil
+	}
+	copyBindings := func(bindings *syntax.BindStms) (*syntax.BindStms, error) {
+		if bindings == nil {
+			return nil, nil
+		}
+		result := &syntax.BindStms{
+			Node:  bindings.Node,
+			List:  make([]*syntax.BindStm, 0, len(bindings.List)),
+			Table: make(map[string]*syntax.BindStm, len(bindings.List)),
+		}
+		for _, binding := range bindings.List {
+			if binding.Id == "*" {
+				// The compiled bindings include the expanded wildcard.
+				continue
+			}
+			b := *binding
+			exp, err := replaceRefs(b.Exp, inner)
+			if err != nil {
+				return result, err
+			}
+			b.Exp = exp
+			result.List = append(result.List, &b)
+			result.Table[b.Id] = &b
+		}
+		return result, nil
+	}
+	// References from the enclosing pipeline to the inlined call.
+	outer := func(ref *syntax.RefExp) (syntax.Exp, error) {
+		if ref.Kind != syntax.KindCall || ref.Id != e.Call {
+			return ref, nil
+		}
+		root, rest := splitRefPath(ref.OutputId)
+		if root == "" {
+			value := make(map[string]syntax.Exp, len(e.Callee.Ret.Bindings.List))
+			for _, binding := range e.Callee.Ret.Bindings.List {
+				if binding.Id == "*" {
+					continue
+				}
+				exp, err := replaceRefs(binding.Exp, inner)
+				if err != nil {
+					return ref, err
+				}
+				value[binding.Id] = exp
+			}
+			return &syntax.MapExp{Kind: syntax.KindStruct, Value: value}, nil
+		}
+		for _, binding := range e.Callee.Ret.Bindings.List {
+			if binding.Id == root {
+				exp, err := replaceRefs(binding.Exp, inner)
+				if err != nil {
+					return ref, err
+				}
+				return fieldExp(exp, rest)
+			}
+		}
+		return ref, fmt.Errorf("%s has no output %s", e.Callee.Id, root)
+	}
+
+	calls := make([]*syntax.CallStm, 0, len(e.Callee.Calls))
+	for _, c := range e.Callee.Calls {
+		cc := *c
+		cc.Id = e.Renames[c.Id]
+		var err error
+		if cc.Bindings, err = copyBindings(c.Bindings); err != nil {
+			return 0, err
+		}
+		if c.Modifiers != nil {
+			mods := *c.Modifiers
+			if mods.Bindings, err = copyBindings(c.Modifiers.Bindings); err != nil {
+				return 0, err
+			}
+			cc.Modifiers = &mods
+		}
+		calls = append(calls, &cc)
+	}
+
+	count := 1
+	for _, c := range pipe.Calls {
+		if c == call {
+			continue
+		}
+		if n, err := replaceBindingRefs(c.Bindings, outer); err != nil {
+			return count, err
+		} else {
+			count += n
+		}
+		if c.Modifiers != nil {
+			if n, err := replaceBindingRefs(c.Modifiers.Bindings, outer); err != nil {
+				return count, err
+			} else {
+				count += n
+			}
+		}
+	}
+	if pipe.Ret != nil {
+		if n, err := replaceBindingRefs(pipe.Ret.Bindings, outer); err != nil {
+			return count, err
+		} else {
+			count += n
+		}
+	}
+	var retain []*syntax.RefExp
+	if pipe.Retain != nil {
+		for _, ref := range pipe.Retain.Refs {
+			exp, err := outer(ref)
+			if err != nil {
+				return count, err
+			}
+			if exp != ref {
+				count++
+			}
+			retain = append(retain, exp.FindRefs()...)
+		}
+	}
+	if e.Callee.Retain != nil {
+		for _, ref := range e.Callee.Retain.Refs {
+			exp, err := inner(ref)
+			if err != nil {
+				return count, err
+			}
+			retain = append(retain, exp.FindRefs()...)
+			count++
+		}
+	}
+	if len(retain) == 0 {
+		pipe.Retain = nil
+	} else {
+		if pipe.Retain == nil {
+			pipe.Retain = &syntax.PipelineRetains{
+				Node: syntax.AstNode{Loc: pipe.Node.Loc},
+			}
+		}
+		pipe.Retain.Refs = retain
+	}
+
+	pipe.Calls = append(pipe.Calls[:index:index],
+		append(calls, pipe.Calls[index+1:]...)...)
+	if pipe.Callables != nil && pipe.Callables.Table != nil {
+		// Keep compiled ASTs consistent for subsequent edits.
+		delete(pipe.Callables.Table, e.Call)
+		for _, c := range e.Callee.Calls {
+			if callable := e.Callee.Callables.Table[c.Id]; callable != nil {
+				pipe.Callables.Table[e.Renames[c.Id]] = callable
+			}
+		}
+	}
+	return count, nil
+}
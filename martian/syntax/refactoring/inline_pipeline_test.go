@@ -0,0 +1,185 @@
+// Copyright (c) 2020 10X Genomics, Inc. All rights reserved.
+
+package refactoring
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/martian-lang/martian/martian/syntax"
+)
+
+func TestInlineCall(t *testing.T) {
+	var parser syntax.Parser
+	_, file, _, _ := runtime.Caller(0)
+	const src = `
+stage FIRST(
+    in  int x,
+    out int y,
+    src comp "none",
+)
+
+stage SECOND(
+    in  int  y,
+    in  int  z,
+    out int  w,
+    out file f,
+    src comp "none",
+)
+
+pipeline INNER(
+    in  int y,
+    in  int z,
+    out int w,
+    out int y,
+)
+{
+    call FIRST(
+        x = self.y,
+    )
+
+    call SECOND(
+        * = self,
+    )
+
+    return (
+        w = SECOND.w,
+        y = FIRST.y,
+    )
+
+    retain (
+        SECOND.f,
+    )
+}
+
+pipeline PIPE(
+    in  int x,
+    out int v,
+    out int w,
+)
+{
+    call FIRST(
+        x = self.x,
+    )
+
+    call INNER(
+        y = FIRST.y,
+        z = 3,
+    )
+
+    call SECOND(
+        y = INNER.y,
+        z = INNER.w,
+    )
+
+    return (
+        v = SECOND.w,
+        w = INNER.w,
+    )
+}
+`
+	srcBytes := []byte(src)
+	_, _, ast, err := parser.ParseSourceBytes(srcBytes, file, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	asts := []*syntax.Ast{ast}
+	pipe := ast.Pipelines[1]
+	if _, err := InlineCall(pipe, "FIRST", asts); err == nil {
+		t.Error("expected an error inlining a stage")
+	}
+	edit, err := InlineCall(pipe, "INNER", asts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmtAst, err := parser.UncheckedParse(srcBytes, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c, err := edit.Apply(fmtAst); err != nil {
+		t.Fatal(err)
+	} else if c != 5 {
+		t.Errorf("%d != 5", c)
+	}
+	const expected = `stage FIRST(
+    in  int x,
+    out int y,
+    src comp "none",
+)
+
+stage SECOND(
+    in  int  y,
+    in  int  z,
+    out int  w,
+    out file f,
+    src comp "none",
+)
+
+pipeline INNER(
+    in  int y,
+    in  int z,
+    out int w,
+    out int y,
+)
+{
+    call FIRST(
+        x = self.y,
+    )
+
+    call SECOND(
+        * = self,
+    )
+
+    return (
+        w = SECOND.w,
+        y = FIRST.y,
+    )
+
+    retain (
+        SECOND.f,
+    )
+}
+
+pipeline PIPE(
+    in  int x,
+    out int v,
+    out int w,
+)
+{
+    call FIRST(
+        x = self.x,
+    )
+
+    call FIRST as INNER_FIRST(
+        x = FIRST.y,
+    )
+
+    call SECOND as INNER_SECOND(
+        y = FIRST.y,
+        z = 3,
+    )
+
+    call SECOND(
+        y = INNER_FIRST.y,
+        z = INNER_SECOND.w,
+    )
+
+    return (
+        v = SECOND.w,
+        w = INNER_SECOND.w,
+    )
+
+    retain (
+        INNER_SECOND.f,
+    )
+}
+`
+	s := fmtAst.Format()
+	if s != expected {
+		diff(t, expected, s)
+	}
+	if _, _, _, err := parser.ParseSourceBytes([]byte(s), file,
+		nil, false); err != nil {
+		t.Error(err)
+	}
+}
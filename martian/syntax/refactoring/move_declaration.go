@@ -0,0 +1,240 @@
+// Copyright (c) 2020 10X Genomics, Inc. All rights reserved.
+
+package refactoring
+
+import (
+	"fmt"
+
+	"github.com/martian-lang/martian/martian/syntax"
+)
+
+// MoveDeclaration moves the stage, pipeline, or struct type with the given
+// name, along with its comments, from one AST to another.
+//
+// Struct types declared in the same file which the moved declaration depends
+// on, directly or through other such struct types, are moved along with it if
+// none of the declarations remaining in the original file use them.
+//
+// The ASTs should not have had includes processed, as from
+// Parser.UncheckedParse.  Includes are not modified.  An error is returned,
+// and neither AST is modified, if the move would require each of the two files
+// to include the other.
+//
+// The returned set contains the names of all of the moved declarations.
+func MoveDeclaration(name string, from, to *syntax.Ast) (StringSet, error) {
+	decs := declarations(from)
+	if decs[name] == nil {
+		return nil, fmt.Errorf("%s is not declared in %s",
+			name, astFileName(from))
+	}
+	moved := StringSet{name: struct{}{}}
+
+	// Add struct types which are only used by moved declarations, until no
+	// further struct types can be moved.
+	for changed := true; changed; {
+		changed = false
+		for id := range moved {
+			for ref := range declRefs(decs[id]) {
+				if moved.Contains(ref) {
+					continue
+				}
+				if _, ok := decs[ref].(*syntax.StructType); !ok {
+					continue
+				}
+				used := false
+				for otherId, other := range decs {
+					if !moved.Contains(otherId) &&
+						declRefs(other).Contains(ref) {
+						used = true
+						break
+					}
+				}
+				if !used && (from.Call == nil || from.Call.DecId != ref) {
+					moved.Add(ref)
+					changed = true
+				}
+			}
+		}
+	}
+
+	// Check for include cycles.
+	var fromNeedsTo, toNeedsFrom bool
+	for id, dec := range decs {
+		refs := declRefs(dec)
+		for ref := range refs {
+			if decs[ref] == nil {
+				continue
+			}
+			if moved.Contains(id) && !moved.Contains(ref) {
+				toNeedsFrom = true
+			} else if !moved.Contains(id) && moved.Contains(ref) {
+				fromNeedsTo = true
+			}
+		}
+	}
+	if from.Call != nil && moved.Contains(from.Call.DecId) {
+		fromNeedsTo = true
+	}
+	if fromNeedsTo && toNeedsFrom {
+		return nil, fmt.Errorf(
+			"moving %s would require %s and %s to include each other",
+			name, astFileName(from), astFileName(to))
+	}
+
+	existing := declarations(to)
+	for id := range moved {
+		if existing[id] != nil {
+			return nil, fmt.Errorf("%s is already declared in %s",
+				id, astFileName(to))
+		}
+	}
+
+	// Collect the moved declarations in their original order before
+	// removing them.
+	var structs []*syntax.StructType
+	for _, st := range from.StructTypes {
+		if moved.Contains(st.Id) {
+			structs = append(structs, st)
+		}
+	}
+	var callables []syntax.Callable
+	for _, c := range from.Callables.List {
+		if moved.Contains(c.GetId()) {
+			callables = append(callables, c)
+		}
+	}
+	removeDeclarations(from, moved)
+	var toFile *syntax.SourceFile
+	for _, f := range to.Files {
+		toFile = f
+	}
+	if toFile != nil {
+		for _, st := range structs {
+			syntax.SetDefiningFile(st, toFile)
+		}
+		for _, c := range callables {
+			syntax.SetDefiningFile(c, toFile)
+		}
+	}
+	to.StructTypes = append(to.StructTypes, structs...)
+	for _, c := range callables {
+		to.Callables.List = append(to.Callables.List, c)
+		if to.Callables.Table != nil {
+			to.Callables.Table[c.GetId()] = c
+		}
+		switch c := c.(type) {
+		case *syntax.Stage:
+			to.Stages = append(to.Stages, c)
+		case *syntax.Pipeline:
+			to.Pipelines = append(to.Pipelines, c)
+		}
+	}
+	return moved, nil
+}
+
+// ReferencesAny returns true if any declaration or call in the AST uses any
+// of the given names as a type or callable.
+func ReferencesAny(ast *syntax.Ast, names StringSet) bool {
+	if ast.Call != nil && names.Contains(ast.Call.DecId) {
+		return true
+	}
+	for _, dec := range declarations(ast) {
+		for ref := range declRefs(dec) {
+			if names.Contains(ref) && !names.Contains(dec.GetId()) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func astFileName(ast *syntax.Ast) string {
+	for _, f := range ast.Files {
+		return f.FileName
+	}
+	return "source"
+}
+
+// declarations returns the stages, pipelines and struct types declared in an
+// AST, by name.
+func declarations(ast *syntax.Ast) map[string]syntax.NamedNode {
+	result := make(map[string]syntax.NamedNode,
+		len(ast.StructTypes)+len(ast.Callables.List))
+	for _, st := range ast.StructTypes {
+		result[st.Id] = st
+	}
+	for _, c := range ast.Callables.List {
+		result[c.GetId()] = c
+	}
+	return result
+}
+
+// declRefs returns the set of type and callable names used by a declaration.
+func declRefs(dec syntax.NamedNode) StringSet {
+	refs := make(StringSet)
+	addIns := func(params *syntax.InParams) {
+		if params != nil {
+			for _, p := range params.List {
+				refs.Add(p.Tname.Tname)
+			}
+		}
+	}
+	addOuts := func(params *syntax.OutParams) {
+		if params != nil {
+			for _, p := range params.List {
+				refs.Add(p.Tname.Tname)
+			}
+		}
+	}
+	switch dec := dec.(type) {
+	case *syntax.StructType:
+		for _, m := range dec.Members {
+			refs.Add(m.Tname.Tname)
+		}
+	case *syntax.Stage:
+		addIns(dec.InParams)
+		addOuts(dec.OutParams)
+		addIns(dec.ChunkIns)
+		addOuts(dec.ChunkOuts)
+	case *syntax.Pipeline:
+		addIns(dec.InParams)
+		addOuts(dec.OutParams)
+		for _, call := range dec.Calls {
+			refs.Add(call.DecId)
+		}
+	}
+	return refs
+}
+
+func removeDeclarations(ast *syntax.Ast, names StringSet) {
+	structs := ast.StructTypes[:0]
+	for _, st := range ast.StructTypes {
+		if !names.Contains(st.Id) {
+			structs = append(structs, st)
+		}
+	}
+	ast.StructTypes = structs
+	callables := ast.Callables.List[:0]
+	for _, c := range ast.Callables.List {
+		if !names.Contains(c.GetId()) {
+			callables = append(callables, c)
+		} else if ast.Callables.Table != nil {
+			delete(ast.Callables.Table, c.GetId())
+		}
+	}
+	ast.Callables.List = callables
+	stages := ast.Stages[:0]
+	for _, s := range ast.Stages {
+		if !names.Contains(s.Id) {
+			stages = append(stages, s)
+		}
+	}
+	ast.Stages = stages
+	pipelines := ast.Pipelines[:0]
+	for _, p := range ast.Pipelines {
+		if !names.Contains(p.Id) {
+			pipelines = append(pipelines, p)
+		}
+	}
+	ast.Pipelines = pipelines
+}
@@ -0,0 +1,107 @@
+// Copyright (c) 2020 10X Genomics, Inc. All rights reserved.
+
+package refactoring
+
+import (
+	"testing"
+
+	"github.com/martian-lang/martian/martian/syntax"
+)
+
+func TestMoveDeclaration(t *testing.T) {
+	var parser syntax.Parser
+	const src = `struct INNER(
+    int x,
+)
+
+# The outer struct.
+struct OUTER(
+    INNER inner,
+)
+
+struct SHARED(
+    int y,
+)
+
+stage USE_SHARED(
+    in  SHARED s,
+    src comp   "none",
+)
+
+stage USE_OUTER(
+    in  OUTER  o,
+    in  SHARED s,
+    src comp   "none",
+)
+
+pipeline PIPE(
+    in  OUTER  o,
+    in  SHARED s,
+)
+{
+    call USE_OUTER(
+        o = self.o,
+        s = self.s,
+    )
+
+    call USE_SHARED(
+        s = self.s,
+    )
+
+    return ()
+}
+`
+	parse := func() (*syntax.Ast, *syntax.Ast) {
+		t.Helper()
+		from, err := parser.UncheckedParse([]byte(src), "from.mro")
+		if err != nil {
+			t.Fatal(err)
+		}
+		to, err := parser.UncheckedParse([]byte(
+			"struct OTHER(\n    int z,\n)\n"), "to.mro")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return from, to
+	}
+	from, to := parse()
+	if _, err := MoveDeclaration("USE_OUTER", from, to); err == nil {
+		t.Error("expected an include cycle error")
+	}
+	if _, err := MoveDeclaration("MISSING", from, to); err == nil {
+		t.Error("expected an error for a missing declaration")
+	}
+	from, to = parse()
+	moved, err := MoveDeclaration("OUTER", from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(moved) != 2 || !moved.Contains("OUTER") || !moved.Contains("INNER") {
+		t.Errorf("expected OUTER and INNER to move, got %v", moved)
+	}
+	if !ReferencesAny(from, moved) {
+		t.Error("expected source file to reference moved types")
+	}
+	if ReferencesAny(to, moved) {
+		t.Error("expected destination file not to need an include")
+	}
+	const expectedTo = `struct OTHER(
+    int z,
+)
+
+struct INNER(
+    int x,
+)
+
+# The outer struct.
+struct OUTER(
+    INNER inner,
+)
+`
+	if s := to.Format(); s != expectedTo {
+		diff(t, expectedTo, s)
+	}
+	if s := from.Format(); s[:len("struct SHARED(")] != "struct SHARED(" {
+		t.Errorf("expected moved structs to be removed, got\n%s", s)
+	}
+}
@@ -4,12 +4,16 @@ package refactoring
 
 import (
 	"regexp"
+	"strings"
 
 	"github.com/martian-lang/martian/martian/syntax"
 )
 
 var keepRegexp = regexp.MustCompile(`(?i:\bkeep\b)|(?i:\brequired\b)`)
 
+// keepListRegexp matches a keep comment which lists the names it applies to.
+var keepListRegexp = regexp.MustCompile(`(?i)\bkeep\s*:\s*([\w-]+(?:\s*,\s*[\w-]+)*)`)
+
 func HasKeepComment(node syntax.AstNodable) bool {
 	for _, c := range syntax.GetComments(node) {
 		if keepRegexp.MatchString(c) {
@@ -18,3 +22,28 @@ func HasKeepComment(node syntax.AstNodable) bool {
 	}
 	return false
 }
+
+// KeepCommentNames returns the names listed in keep comments on the node, of
+// the form
+//
+//	# keep: naming-convention, nested-map
+//
+// and whether the node has a keep comment which does not list any names.
+func KeepCommentNames(node syntax.AstNodable) (StringSet, bool) {
+	var names StringSet
+	all := false
+	for _, c := range syntax.GetComments(node) {
+		m := keepListRegexp.FindStringSubmatch(c)
+		if m == nil {
+			all = all || keepRegexp.MatchString(c)
+			continue
+		}
+		if names == nil {
+			names = make(StringSet)
+		}
+		for _, name := range strings.Split(m[1], ",") {
+			names.Add(strings.TrimSpace(name))
+		}
+	}
+	return names, all
+}
@@ -35,6 +35,55 @@ type RenameParam struct {
 	NewName string
 }
 
+// Extraction specifies a set of calls to move into a new pipeline.
+type Extraction struct {
+	Pipeline string
+	Calls    []string
+	NewName  string
+}
+
+// PipelineCall identifies a call within a pipeline.
+type PipelineCall struct {
+	// The pipeline containing the call.  If empty, the call in every pipeline
+	// which has a call with the given Id is used.
+	Pipeline string
+	Call     string
+}
+
+// pipelinesCalling returns the distinct pipelines which contain the given
+// call.
+func pipelinesCalling(call PipelineCall,
+	asts []*syntax.Ast) ([]*syntax.Pipeline, error) {
+	if call.Pipeline != "" {
+		pipe, ok := getCallable(call.Pipeline, asts).(*syntax.Pipeline)
+		if !ok {
+			return nil, fmt.Errorf("pipeline %s not found", call.Pipeline)
+		}
+		return []*syntax.Pipeline{pipe}, nil
+	}
+	var result []*syntax.Pipeline
+	seen := make(map[decId]struct{})
+	for _, ast := range asts {
+		for _, pipe := range ast.Pipelines {
+			dec := makeDecId(pipe)
+			if _, ok := seen[dec]; ok {
+				continue
+			}
+			seen[dec] = struct{}{}
+			for _, c := range pipe.Calls {
+				if c.Id == call.Call {
+					result = append(result, pipe)
+					break
+				}
+			}
+		}
+	}
+	if len(result) == 0 {
+		return nil, fmt.Errorf("no pipeline has a call %s", call.Call)
+	}
+	return result, nil
+}
+
 // RefactorConfig contains options to be passed to Refactor.
 type RefactorConfig struct {
 	// If topCalls is non-empty, the RemoveUnusedOutputs will be applied repeatedly
@@ -60,6 +109,13 @@ type RefactorConfig struct {
 
 	// Rename the given output parameters.
 	RenameOutParam []RenameParam
+
+	// Move the given calls into new pipelines.
+	Extract []Extraction
+
+	// Replace the given calls to pipelines with the calls those pipelines
+	// make.
+	Inline []PipelineCall
 }
 
 // Refactor modifies a set of ASTs.
@@ -119,6 +175,42 @@ func Refactor(asts []*syntax.Ast,
 			}
 		}
 	}
+	for _, extract := range opt.Extract {
+		pipe, ok := getCallable(extract.Pipeline, asts).(*syntax.Pipeline)
+		if !ok {
+			return edits, fmt.Errorf("pipeline %s not found", extract.Pipeline)
+		}
+		edit, err := ExtractPipeline(pipe, extract.Calls, extract.NewName, asts)
+		if err != nil {
+			return edits, err
+		}
+		if edit != nil {
+			edits = append(edits, edit)
+			for _, ast := range asts {
+				if _, err := edit.Apply(ast); err != nil {
+					return edits, fmt.Errorf("applying edit: %w", err)
+				}
+			}
+		}
+	}
+	for _, inline := range opt.Inline {
+		pipes, err := pipelinesCalling(inline, asts)
+		if err != nil {
+			return edits, err
+		}
+		for _, pipe := range pipes {
+			edit, err := InlineCall(pipe, inline.Call, asts)
+			if err != nil {
+				return edits, err
+			}
+			edits = append(edits, edit)
+			for _, ast := range asts {
+				if _, err := edit.Apply(ast); err != nil {
+					return edits, fmt.Errorf("applying edit: %w", err)
+				}
+			}
+		}
+	}
 	for _, removeParam := range opt.RemoveInParams {
 		cname := removeParam.Callable
 		param := removeParam.Param
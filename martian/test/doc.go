@@ -1,2 +1,16 @@
-// Package test is a stub containing mro code used in some unit tests.
+// Package test contains mro code used in some unit tests, and a harness for
+// running pipelines in go tests with mocked stage code.
+//
+// A test registers mocks for the stages in the pipeline, and then runs it:
+//
+//	h := test.NewHarness(t, "path/to/mro")
+//	h.Mock("SUM_SQUARES", test.StageMock{Main: ...})
+//	h.MockOuts("REPORT", `{}`)
+//	result := h.Invoke("sum_squares.mro", "SUM_SQUARE_PIPELINE",
+//		map[string]interface{}{"values": []float64{1, 2, 3}})
+//	if err := result.Err(); err != nil {
+//		t.Fatal(err)
+//	}
+//
+// Stage jobs run in-process, so no stage code or job adapters are required.
 package test
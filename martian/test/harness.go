@@ -0,0 +1,421 @@
+// Copyright (c) 2020 10X Genomics, Inc. All rights reserved.
+
+// An in-process harness for running pipelines in go tests.
+
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/trace"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/martian-lang/martian/martian/adapter"
+	"github.com/martian-lang/martian/martian/core"
+	"github.com/martian-lang/martian/martian/syntax"
+)
+
+const (
+	// The pipestance ID used for pipelines run by a Harness.
+	harnessPsid = "test"
+
+	// The prefix of fully-qualified names before the call ID.
+	fqnamePrefix = "ID." + harnessPsid + "."
+
+	// The directory for the first (or only) fork of a call.
+	firstFork = "fork0"
+)
+
+// A StageMock is a go implementation of a stage, which a Harness runs in
+// place of the stage code.
+//
+// If Split is nil, a split stage runs a single chunk with no additional
+// arguments.  If Main or Join is nil, the outputs for that phase are left
+// null.  Mocks may be called concurrently for different jobs.
+type StageMock struct {
+	Split adapter.SplitFunc
+	Main  adapter.MainFunc
+	Join  adapter.MainFunc
+}
+
+// CannedOuts returns a StageMock which ignores its inputs and returns the
+// given JSON object as the stage outputs.
+func CannedOuts(outs string) StageMock {
+	f := func(*core.Metadata) (interface{}, error) {
+		return json.RawMessage(outs), nil
+	}
+	return StageMock{Main: f, Join: f}
+}
+
+// A Harness compiles and runs pipelines in-process, using the local job
+// manager, with mocks standing in for the stage code.
+type Harness struct {
+	t        testing.TB
+	mroPaths []string
+	mocks    map[string]StageMock
+}
+
+// NewHarness creates a harness which searches the given directories for
+// included mro files.
+func NewHarness(t testing.TB, mroPaths ...string) *Harness {
+	return &Harness{
+		t:        t,
+		mroPaths: mroPaths,
+		mocks:    make(map[string]StageMock),
+	}
+}
+
+// Mock registers the implementation for all calls to the given stage.
+func (h *Harness) Mock(stage string, mock StageMock) {
+	h.mocks[stage] = mock
+}
+
+// MockOuts registers canned outputs, as a JSON object, for all calls to the
+// given stage.
+func (h *Harness) MockOuts(stage, outs string) {
+	h.Mock(stage, CannedOuts(outs))
+}
+
+// Invoke runs a pipeline declared in the given mro file, with the given
+// arguments.  Arguments are converted to JSON before being bound to the
+// pipeline inputs.
+func (h *Harness) Invoke(include, pipeline string,
+	args map[string]interface{}) *Result {
+	h.t.Helper()
+	invocation := core.InvocationData{
+		Call:    pipeline,
+		Args:    make(core.LazyArgumentMap, len(args)),
+		Include: include,
+	}
+	for k, v := range args {
+		b, err := json.Marshal(v)
+		if err != nil {
+			h.t.Fatalf("Serializing argument %s: %v", k, err)
+		}
+		invocation.Args[k] = b
+	}
+	ast, err := invocation.BuildCallAst(h.mroPaths)
+	if err != nil {
+		h.t.Fatal("Building invocation:", err)
+	}
+	return h.Run(ast.Format(), "__invocation__.mro")
+}
+
+// Run runs the pipeline call in the given invocation source in a temporary
+// directory, and waits for it to complete or fail.
+//
+// Compilation errors are fatal to the test.  Errors from the pipeline are
+// reported by Result.Err.
+func (h *Harness) Run(src, srcPath string) *Result {
+	h.t.Helper()
+	_, _, ast, err := syntax.ParseSourceBytes([]byte(src), srcPath,
+		h.mroPaths, false)
+	if err != nil {
+		h.t.Fatal("Compiling pipeline:", err)
+	}
+	if ast.Call == nil {
+		h.t.Fatal("No call in invocation.")
+	}
+	graph, err := ast.MakePipelineCallGraph("", ast.Call)
+	if err != nil {
+		h.t.Fatal("Building call graph:", err)
+	}
+	run := pipelineRun{
+		mocks:  h.mocks,
+		stages: make(map[string]string),
+	}
+	run.addStages(graph)
+
+	opts := core.DefaultRuntimeOptions()
+	opts.VdrMode = core.VdrDisable
+	rt := opts.NewRuntimeWithJobConfig(&core.JobManagerJson{
+		JobSettings: &core.JobManagerSettings{
+			ThreadsPerJob: 1,
+			MemGBPerJob:   1,
+			ExtraVmemGB:   1,
+			ThreadEnvs:    []string{"GOMAXPROCS"},
+		},
+	})
+	rt.LocalJobManager.SetJobRunner(run.runJob)
+
+	psdir := filepath.Join(h.t.TempDir(), harnessPsid)
+	pipestance, err := rt.InvokePipeline(src, srcPath, harnessPsid, psdir,
+		h.mroPaths, "<none>", nil, nil)
+	if err != nil {
+		h.t.Fatal("Invoking pipeline:", err)
+	}
+	defer pipestance.Unlock()
+	state := run.wait(pipestance, rt.LocalJobManager.Done())
+	// Stop the runtime before returning, so that nothing is still writing
+	// to the pipestance when the test cleans it up.
+	defer pipestance.WaitForStorageCleanup()
+	defer rt.LocalJobManager.Wait()
+
+	run.mu.Lock()
+	result := Result{
+		Path:  psdir,
+		State: state,
+		call:  ast.Call.Id,
+		jobs:  run.jobs,
+		nodes: pipestance.SerializeState(),
+	}
+	run.mu.Unlock()
+	if state == core.Failed {
+		result.err = fatalError(pipestance)
+		h.t.Log(result.err)
+	} else {
+		pipestance.PostProcess()
+	}
+	sort.SliceStable(result.jobs, func(i, j int) bool {
+		a, b := &result.jobs[i], &result.jobs[j]
+		if a.Call != b.Call {
+			return a.Call < b.Call
+		}
+		return a.Fork < b.Fork
+	})
+	return &result
+}
+
+func fatalError(pipestance *core.Pipestance) error {
+	_, _, _, log, _, errPaths := pipestance.GetFatalError()
+	var buf strings.Builder
+	buf.WriteString(strings.TrimSpace(log))
+	for _, p := range errPaths {
+		buf.WriteString("\nErrors in ")
+		buf.WriteString(p)
+		if b, err := os.ReadFile(p); err == nil {
+			buf.WriteString(":\n")
+			buf.Write(b)
+		}
+	}
+	return errors.New(buf.String())
+}
+
+// A Job records a job which was run for a stage.
+type Job struct {
+	// The fully-qualified call ID, without the ID.psid. prefix, e.g.
+	// PIPELINE.STAGE.
+	Call string
+
+	// The name of the stage.
+	Stage string
+
+	// The fork ID, e.g. fork0.
+	Fork string
+
+	// The phase which was run: split, main, or join.
+	Phase string
+}
+
+// The state for a single pipeline run.
+type pipelineRun struct {
+	mocks map[string]StageMock
+
+	// Maps fully-qualified call IDs to stage names.
+	stages map[string]string
+
+	mu   sync.Mutex
+	jobs []Job
+}
+
+func (r *pipelineRun) addStages(node syntax.CallGraphNode) {
+	if node.Kind() == syntax.KindStage {
+		r.stages[node.GetFqid()] = node.Callable().GetId()
+	}
+	for _, child := range node.GetChildren() {
+		r.addStages(child)
+	}
+}
+
+// findJob gets the call ID, stage, and fork for a job.
+//
+// Job names are the call ID followed by the fork ID and, for chunks, the
+// chunk ID, all separated by '.'.  Fork IDs in job names never contain '.'.
+func (r *pipelineRun) findJob(fqname string) (call, stage, fork string) {
+	rel := strings.TrimPrefix(fqname, fqnamePrefix)
+	for end := len(rel); end > 0; {
+		end = strings.LastIndexByte(rel[:end], '.')
+		if end < 0 {
+			break
+		}
+		if stage, ok := r.stages[rel[:end]]; ok {
+			fork := rel[end+1:]
+			if i := strings.IndexByte(fork, '.'); i >= 0 {
+				fork = fork[:i]
+			}
+			return rel[:end], stage, fork
+		}
+	}
+	return rel, "", ""
+}
+
+func (r *pipelineRun) runJob(fqname, phase string,
+	metadata *core.Metadata) (err error) {
+	call, stage, fork := r.findJob(fqname)
+	r.mu.Lock()
+	r.jobs = append(r.jobs, Job{
+		Call:  call,
+		Stage: stage,
+		Fork:  fork,
+		Phase: phase,
+	})
+	r.mu.Unlock()
+	mock, ok := r.mocks[stage]
+	if !ok {
+		return fmt.Errorf("no mock registered for stage %q in job %s",
+			stage, fqname)
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			var buf [8000]byte
+			err = fmt.Errorf("stage mock panic: %v\n\n%s",
+				p, buf[:runtime.Stack(buf[:], false)])
+		}
+	}()
+	switch phase {
+	case "split":
+		if mock.Split == nil {
+			return metadata.WriteRaw(core.StageDefsFile, `{"chunks":[{}]}`)
+		}
+		defs, err := mock.Split(metadata)
+		if err != nil {
+			return err
+		} else if defs == nil {
+			return errors.New("split returned nil")
+		}
+		return metadata.Write(core.StageDefsFile, defs)
+	case "main", "join":
+		f := mock.Main
+		if phase == "join" {
+			f = mock.Join
+		}
+		if f == nil {
+			return nil
+		}
+		outs, err := f(metadata)
+		if err != nil || outs == nil {
+			return err
+		}
+		return metadata.Write(core.OutsFile, outs)
+	}
+	return fmt.Errorf("invalid run type %q", phase)
+}
+
+// wait steps the pipestance until it completes or fails.
+func (r *pipelineRun) wait(pipestance *core.Pipestance,
+	jobDone <-chan struct{}) core.MetadataState {
+	pipestance.LoadMetadata(context.Background())
+	ti := time.NewTimer(0)
+	if !ti.Stop() {
+		<-ti.C
+	}
+	for {
+		// Drain any notifications which arrived during the last step.
+		for len(jobDone) > 0 {
+			<-jobDone
+		}
+		ctx, task := trace.NewTask(context.Background(), "update")
+		pipestance.RefreshState(ctx)
+		state := pipestance.GetState(ctx)
+		if state == core.Complete || state == core.DisabledState ||
+			state == core.Failed {
+			task.End()
+			return state
+		}
+		pipestance.CheckHeartbeats(ctx)
+		progress := pipestance.StepNodes(ctx)
+		task.End()
+		if !progress {
+			ti.Reset(time.Second)
+			select {
+			case <-ti.C:
+			case <-jobDone:
+				if !ti.Stop() {
+					<-ti.C
+				}
+			}
+		}
+	}
+}
+
+// A Result is the outcome of running a pipeline with a Harness.
+type Result struct {
+	// The pipestance directory.
+	Path string
+
+	// The final state of the pipestance.
+	State core.MetadataState
+
+	call  string
+	jobs  []Job
+	nodes []*core.NodeInfo
+	err   error
+}
+
+// Err returns the pipeline's fatal error, if it failed.
+func (r *Result) Err() error {
+	return r.err
+}
+
+// Jobs returns the jobs which were run, sorted by call and fork.
+func (r *Result) Jobs() []Job {
+	return r.jobs
+}
+
+// Forks returns the IDs of the forks of the given call for which any jobs
+// were run.
+func (r *Result) Forks(call string) []string {
+	var forks []string
+	for _, job := range r.jobs {
+		if job.Call == call &&
+			(len(forks) == 0 || forks[len(forks)-1] != job.Fork) {
+			forks = append(forks, job.Fork)
+		}
+	}
+	return forks
+}
+
+// Disabled returns the IDs of the calls which had a disabled fork.
+func (r *Result) Disabled() []string {
+	var calls []string
+	for _, node := range r.nodes {
+		for _, fork := range node.Forks {
+			if fork.State == core.DisabledState {
+				calls = append(calls, strings.TrimPrefix(node.Fqname, fqnamePrefix))
+				break
+			}
+		}
+	}
+	sort.Strings(calls)
+	return calls
+}
+
+// Outs reads the pipeline outputs into v.
+func (r *Result) Outs(v interface{}) error {
+	return r.CallOuts(r.call, v)
+}
+
+// CallOuts reads the outputs of the first fork of the given call into v.
+func (r *Result) CallOuts(call string, v interface{}) error {
+	fqname := fqnamePrefix + call
+	for _, node := range r.nodes {
+		if node.Fqname == fqname {
+			b, err := os.ReadFile(filepath.Join(node.Path, firstFork,
+				core.OutsFile.FileName()))
+			if err != nil {
+				return err
+			}
+			return json.Unmarshal(b, v)
+		}
+	}
+	return fmt.Errorf("no call %s in pipeline", call)
+}
@@ -0,0 +1,158 @@
+// Copyright (c) 2020 10X Genomics, Inc. All rights reserved.
+
+package test
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/martian-lang/martian/martian/core"
+)
+
+func sumSquaresMock() StageMock {
+	return StageMock{
+		Split: func(metadata *core.Metadata) (*core.StageDefs, error) {
+			var args struct {
+				Values []float64 `json:"values"`
+			}
+			if err := metadata.ReadInto(core.ArgsFile, &args); err != nil {
+				return nil, err
+			}
+			defs := &core.StageDefs{
+				ChunkDefs: make([]*core.ChunkDef, 0, len(args.Values)),
+			}
+			for _, v := range args.Values {
+				b, err := json.Marshal(v)
+				if err != nil {
+					return nil, err
+				}
+				defs.ChunkDefs = append(defs.ChunkDefs, &core.ChunkDef{
+					Args: core.LazyArgumentMap{"value": b},
+				})
+			}
+			return defs, nil
+		},
+		Main: func(metadata *core.Metadata) (interface{}, error) {
+			var args struct {
+				Value float64 `json:"value"`
+			}
+			if err := metadata.ReadInto(core.ArgsFile, &args); err != nil {
+				return nil, err
+			}
+			return map[string]float64{"square": args.Value * args.Value}, nil
+		},
+		Join: func(metadata *core.Metadata) (interface{}, error) {
+			var chunkOuts []struct {
+				Square float64 `json:"square"`
+			}
+			if err := metadata.ReadInto(core.ChunkOutsFile, &chunkOuts); err != nil {
+				return nil, err
+			}
+			var sum float64
+			for _, out := range chunkOuts {
+				sum += out.Square
+			}
+			return map[string]float64{"sum": sum}, nil
+		},
+	}
+}
+
+func TestHarness(t *testing.T) {
+	h := NewHarness(t, ".")
+	h.Mock("SUM_SQUARES", sumSquaresMock())
+	h.MockOuts("REPORT", `{}`)
+	result := h.Invoke("sum_squares.mro", "SUM_SQUARE_PIPELINE",
+		map[string]interface{}{
+			"values":     []float64{1, 2, 3},
+			"disable_sq": false,
+		})
+	if err := result.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if result.State != core.Complete {
+		t.Errorf("state %v != complete", result.State)
+	}
+	var outs struct {
+		Sum float64 `json:"sum"`
+	}
+	if err := result.Outs(&outs); err != nil {
+		t.Error(err)
+	} else if outs.Sum != 14 {
+		t.Errorf("sum %g != 14", outs.Sum)
+	}
+	var phases []string
+	for _, job := range result.Jobs() {
+		if job.Call == "SUM_SQUARE_PIPELINE.SUM_SQUARES" {
+			phases = append(phases, job.Phase)
+		}
+	}
+	if expect := []string{
+		"split", "main", "main", "main", "join",
+	}; !reflect.DeepEqual(phases, expect) {
+		t.Errorf("phases %v != %v", phases, expect)
+	}
+	if forks := result.Forks("SUM_SQUARE_PIPELINE.REPORT"); !reflect.DeepEqual(
+		forks, []string{"fork0"}) {
+		t.Errorf("REPORT forks %v != [fork0]", forks)
+	}
+	if disabled := result.Disabled(); len(disabled) != 0 {
+		t.Errorf("unexpected disabled calls %v", disabled)
+	}
+}
+
+func TestHarnessDisabled(t *testing.T) {
+	h := NewHarness(t, ".")
+	h.Mock("SUM_SQUARES", sumSquaresMock())
+	h.MockOuts("REPORT", `{}`)
+	result := h.Invoke("sum_squares.mro", "SUM_SQUARE_PIPELINE",
+		map[string]interface{}{
+			"values":     []float64{1, 2, 3},
+			"disable_sq": true,
+		})
+	if err := result.Err(); err != nil {
+		t.Fatal(err)
+	}
+	var outs struct {
+		Sum *float64 `json:"sum"`
+	}
+	if err := result.Outs(&outs); err != nil {
+		t.Error(err)
+	} else if outs.Sum != nil {
+		t.Errorf("expected null sum, got %g", *outs.Sum)
+	}
+	if disabled := result.Disabled(); !reflect.DeepEqual(disabled,
+		[]string{"SUM_SQUARE_PIPELINE.SUM_SQUARES"}) {
+		t.Errorf("disabled %v", disabled)
+	}
+	if forks := result.Forks("SUM_SQUARE_PIPELINE.SUM_SQUARES"); len(forks) != 0 {
+		t.Errorf("disabled stage ran forks %v", forks)
+	}
+	if forks := result.Forks("SUM_SQUARE_PIPELINE.REPORT"); len(forks) != 1 {
+		t.Errorf("REPORT forks %v", forks)
+	}
+}
+
+func TestHarnessFailure(t *testing.T) {
+	h := NewHarness(t, ".")
+	h.Mock("SUM_SQUARES", StageMock{
+		Main: func(*core.Metadata) (interface{}, error) {
+			return nil, errors.New("chunk failed")
+		},
+	})
+	result := h.Invoke("sum_squares.mro", "SUM_SQUARE_PIPELINE",
+		map[string]interface{}{
+			"values":     []float64{1},
+			"disable_sq": false,
+		})
+	if result.State != core.Failed {
+		t.Errorf("state %v != failed", result.State)
+	}
+	if err := result.Err(); err == nil {
+		t.Error("expected an error")
+	} else if !strings.Contains(err.Error(), "chunk failed") {
+		t.Errorf("unexpected error %v", err)
+	}
+}